@@ -0,0 +1,121 @@
+package hatchery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// AdminWorkspace is a workspace as returned by the admin endpoints
+type AdminWorkspace struct {
+	WorkspaceInfo
+	ContainerName string           `json:"container_name"`
+	PayModel      *PayModel        `json:"pay_model"`
+	Status        *WorkspaceStatus `json:"status"`
+}
+
+// checkAdmin writes an error response and returns false if the current
+// user is not a hatchery admin
+func checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	userName := getCurrentUserName(r)
+	if userName == "" {
+		http.Error(w, "Please login", http.StatusUnauthorized)
+		return false
+	}
+	isAdmin, err := isUserHatcheryAdmin(userName, getBearerToken(r))
+	if err != nil {
+		getConfig().Logger.Printf("Unable to check if user %s is a hatchery admin. Assuming they are not. Details: %v", userName, err)
+	}
+	if err != nil || !isAdmin {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// `/admin/workspaces` => return all the active workspaces
+// `/admin/workspaces?user=abc` => return the active workspaces of the specified user
+func adminWorkspaces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAdmin(w, r) {
+		return
+	}
+	targetUser := r.URL.Query().Get("user")
+
+	workspaces, err := listActiveWorkspaces(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := []AdminWorkspace{}
+	for _, workspace := range workspaces {
+		if targetUser != "" && workspace.UserName != targetUser {
+			continue
+		}
+		adminWorkspace := AdminWorkspace{
+			WorkspaceInfo: workspace,
+			ContainerName: getConfig().ContainersMap[workspace.ContainerID].Name,
+		}
+		// the pay model the workspace was launched with, which is the one
+		// `/admin/terminate` uses, even if the user switched pay models since
+		adminWorkspace.PayModel, err = getWorkspacePayModel(workspace.UserName, workspace.PayModelID)
+		if err != nil {
+			getConfig().Logger.Printf("Unable to get the pay model of user %s: %v", workspace.UserName, err)
+		}
+		// without the user's token, the status does not include the last activity time
+		adminWorkspace.Status, err = getWorkspaceStatus(r.Context(), workspace.UserName, workspace.WorkspaceName, "")
+		if err != nil {
+			getConfig().Logger.Printf("Unable to get the status of workspace '%s' of user %s: %v", workspace.WorkspaceName, workspace.UserName, err)
+		}
+		result = append(result, adminWorkspace)
+	}
+
+	out, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, string(out))
+}
+
+// `/admin/terminate?user=abc&workspace=xyz` => terminate the specified
+// workspace of the specified user, releasing their licenses and Nextflow
+// resources like `/terminate` does
+func adminTerminate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAdmin(w, r) {
+		return
+	}
+	targetUser := r.URL.Query().Get("user")
+	if targetUser == "" {
+		http.Error(w, "Missing 'user' parameter", http.StatusBadRequest)
+		return
+	}
+	workspaceName, err := getWorkspaceName(r, targetUser)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	getConfig().Logger.Printf("Admin %s is terminating workspace '%s' of user %s", getCurrentUserName(r), workspaceName, targetUser)
+	// the admin's token can not be used to delete the user's API key:
+	// the workspace's API key is deleted with the key itself
+	ctx := withAuditActor(r.Context(), getCurrentUserName(r))
+	result, err := terminateWorkspace(ctx, targetUser, workspaceName, "")
+	if err != nil {
+		if errors.Is(err, errNamedWorkspaceOnEcs) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	fmt.Fprint(w, result)
+}
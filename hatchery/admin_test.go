@@ -0,0 +1,281 @@
+package hatchery
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdminWorkspaces(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	activeWorkspaces := []WorkspaceInfo{
+		{UserName: "user1", ContainerID: "rstudio", StartTime: time.Now()},
+		{UserName: "user1", WorkspaceName: "second", ContainerID: "rstudio", StartTime: time.Now(), PayModelID: "launch-pay-model"},
+		{UserName: "user2", ContainerID: "rstudio", StartTime: time.Now()},
+	}
+
+	testCases := []struct {
+		name           string
+		method         string
+		username       string
+		isAdmin        bool
+		targetUser     string
+		wantStatus     int
+		wantWorkspaces int
+	}{
+		{
+			name:       "NotLoggedIn",
+			method:     "GET",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "NotAdmin",
+			method:     "GET",
+			username:   "user1",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "WrongMethod",
+			method:     "POST",
+			username:   "admin",
+			isAdmin:    true,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "AllWorkspaces",
+			method:         "GET",
+			username:       "admin",
+			isAdmin:        true,
+			wantStatus:     http.StatusOK,
+			wantWorkspaces: 3,
+		},
+		{
+			name:           "UserWorkspaces",
+			method:         "GET",
+			username:       "admin",
+			isAdmin:        true,
+			targetUser:     "user1",
+			wantStatus:     http.StatusOK,
+			wantWorkspaces: 2,
+		},
+	}
+
+	original_config := getConfig()
+	original_isUserHatcheryAdmin := isUserHatcheryAdmin
+	original_listActiveWorkspaces := listActiveWorkspaces
+	original_getCurrentPayModel := getCurrentPayModel
+	original_getPayModelsForUser := getPayModelsForUser
+	original_getWorkspaceStatus := getWorkspaceStatus
+	defer func() {
+		// restore original functions
+		SetConfig(original_config)
+		isUserHatcheryAdmin = original_isUserHatcheryAdmin
+		listActiveWorkspaces = original_listActiveWorkspaces
+		getCurrentPayModel = original_getCurrentPayModel
+		getPayModelsForUser = original_getPayModelsForUser
+		getWorkspaceStatus = original_getWorkspaceStatus
+	}()
+
+	// other tests may leave a config without a logger behind
+	SetConfig(&FullHatcheryConfig{
+		Logger: log.New(io.Discard, "", log.LstdFlags),
+	})
+	getConfig().ContainersMap = map[string]Container{
+		"rstudio": {Name: "RStudio"},
+	}
+
+	for _, testcase := range testCases {
+		t.Logf("Testing /admin/workspaces when %s", testcase.name)
+
+		isUserHatcheryAdmin = func(string, string) (bool, error) {
+			return testcase.isAdmin, nil
+		}
+		listActiveWorkspaces = func(context.Context) ([]WorkspaceInfo, error) {
+			return activeWorkspaces, nil
+		}
+		getCurrentPayModel = func(string) (*PayModel, error) {
+			return &PayModel{Id: "current-pay-model", Name: "Direct Pay", Local: true}, nil
+		}
+		// the user switched pay models after launching a workspace
+		getPayModelsForUser = func(string) (*AllPayModels, error) {
+			return &AllPayModels{
+				CurrentPayModel: &PayModel{Id: "current-pay-model", Name: "Direct Pay", Local: true},
+				PayModels:       []PayModel{{Id: "launch-pay-model", Name: "Workspace Account", Local: true}},
+			}, nil
+		}
+		getWorkspaceStatus = func(ctx context.Context, userName string, workspaceName string, accessToken string) (*WorkspaceStatus, error) {
+			return &WorkspaceStatus{Status: "Running", WorkspaceName: workspaceName}, nil
+		}
+
+		url := "/admin/workspaces"
+		if testcase.targetUser != "" {
+			url += "?user=" + testcase.targetUser
+		}
+		req, err := http.NewRequest(testcase.method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if testcase.username != "" {
+			req.Header.Set("REMOTE_USER", testcase.username)
+		}
+		w := httptest.NewRecorder()
+		http.HandlerFunc(adminWorkspaces).ServeHTTP(w, req)
+
+		if w.Code != testcase.wantStatus {
+			t.Errorf("handler returned wrong status code:\ngot: '%v'\nwant: '%v'", w.Code, testcase.wantStatus)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var workspaces []AdminWorkspace
+		if err := json.Unmarshal(w.Body.Bytes(), &workspaces); err != nil {
+			t.Fatalf("unable to parse the response: %v", err)
+		}
+		if len(workspaces) != testcase.wantWorkspaces {
+			t.Errorf("expected %d workspaces, got %d", testcase.wantWorkspaces, len(workspaces))
+		}
+		for _, workspace := range workspaces {
+			if testcase.targetUser != "" && workspace.UserName != testcase.targetUser {
+				t.Errorf("unexpected workspace of user %s", workspace.UserName)
+			}
+			if workspace.ContainerName != "RStudio" || workspace.PayModel == nil || workspace.Status == nil {
+				t.Errorf("workspace details are missing: %+v", workspace)
+			}
+			wantPayModel := "current-pay-model"
+			if workspace.PayModelID != "" {
+				wantPayModel = workspace.PayModelID
+			}
+			if workspace.PayModel != nil && workspace.PayModel.Id != wantPayModel {
+				t.Errorf("expected the pay model the workspace was launched with, %s, got %s", wantPayModel, workspace.PayModel.Id)
+			}
+		}
+	}
+}
+
+func TestAdminTerminate(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	testCases := []struct {
+		name          string
+		method        string
+		username      string
+		isAdmin       bool
+		query         string
+		wantStatus    int
+		wantTerminate bool
+	}{
+		{
+			name:       "NotLoggedIn",
+			method:     "POST",
+			query:      "?user=user1",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "NotAdmin",
+			method:     "POST",
+			username:   "user1",
+			query:      "?user=user2",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "WrongMethod",
+			method:     "GET",
+			username:   "admin",
+			isAdmin:    true,
+			query:      "?user=user1",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "MissingUser",
+			method:     "POST",
+			username:   "admin",
+			isAdmin:    true,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "InvalidWorkspaceName",
+			method:     "POST",
+			username:   "admin",
+			isAdmin:    true,
+			query:      "?user=user1&workspace=Not_Valid",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:          "DefaultWorkspace",
+			method:        "POST",
+			username:      "admin",
+			isAdmin:       true,
+			query:         "?user=user1",
+			wantStatus:    http.StatusOK,
+			wantTerminate: true,
+		},
+		{
+			name:          "NamedWorkspace",
+			method:        "POST",
+			username:      "admin",
+			isAdmin:       true,
+			query:         "?user=user1&workspace=second",
+			wantStatus:    http.StatusOK,
+			wantTerminate: true,
+		},
+	}
+
+	original_config := getConfig()
+	original_isUserHatcheryAdmin := isUserHatcheryAdmin
+	original_terminateWorkspace := terminateWorkspace
+	defer func() {
+		// restore original functions
+		SetConfig(original_config)
+		isUserHatcheryAdmin = original_isUserHatcheryAdmin
+		terminateWorkspace = original_terminateWorkspace
+	}()
+
+	// other tests may leave a config without a logger behind
+	SetConfig(&FullHatcheryConfig{
+		Logger: log.New(io.Discard, "", log.LstdFlags),
+	})
+
+	for _, testcase := range testCases {
+		t.Logf("Testing /admin/terminate when %s", testcase.name)
+
+		isUserHatcheryAdmin = func(string, string) (bool, error) {
+			return testcase.isAdmin, nil
+		}
+		var terminated []WorkspaceInfo
+		terminateWorkspace = func(ctx context.Context, userName string, workspaceName string, accessToken string) (string, error) {
+			terminated = append(terminated, WorkspaceInfo{UserName: userName, WorkspaceName: workspaceName})
+			return "Terminated workspace", nil
+		}
+
+		req, err := http.NewRequest(testcase.method, "/admin/terminate"+testcase.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if testcase.username != "" {
+			req.Header.Set("REMOTE_USER", testcase.username)
+		}
+		w := httptest.NewRecorder()
+		http.HandlerFunc(adminTerminate).ServeHTTP(w, req)
+
+		if w.Code != testcase.wantStatus {
+			t.Errorf("handler returned wrong status code:\ngot: '%v'\nwant: '%v'", w.Code, testcase.wantStatus)
+		}
+		if !testcase.wantTerminate {
+			if len(terminated) != 0 {
+				t.Errorf("expected no workspace to be terminated, got %+v", terminated)
+			}
+			continue
+		}
+		wantWorkspace := req.URL.Query().Get("workspace")
+		if len(terminated) != 1 || terminated[0].UserName != req.URL.Query().Get("user") || terminated[0].WorkspaceName != wantWorkspace {
+			t.Errorf("expected workspace '%s' of the target user to be terminated, got %+v", wantWorkspace, terminated)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package hatchery
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/google/uuid"
+)
+
+// Audited actions
+const (
+	auditLaunch         = "launch"
+	auditTerminate      = "terminate"
+	auditStop           = "stop"
+	auditResume         = "resume"
+	auditSetPayModel    = "setpaymodel"
+	auditResetPayModels = "resetpaymodels"
+	auditLicense        = "license"
+)
+
+// Outcomes of audited actions
+const (
+	auditSucceeded = "succeeded"
+	auditFailed    = "failed"
+)
+
+// AuditRecord records who did what to which workspace, and how it went
+type AuditRecord struct {
+	// sorts chronologically
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	UserName string    `json:"user"`
+	// who performed the action, when it is not the user themselves (an
+	// admin or a background job)
+	Actor         string            `json:"actor,omitempty"`
+	WorkspaceName string            `json:"workspace,omitempty"`
+	ContainerID   string            `json:"container_id,omitempty"`
+	ContainerName string            `json:"container_name,omitempty"`
+	Image         string            `json:"image,omitempty"`
+	PayModelID    string            `json:"pay_model_id,omitempty"`
+	Outcome       string            `json:"outcome"`
+	Error         string            `json:"error,omitempty"`
+	Details       map[string]string `json:"details,omitempty"`
+}
+
+// AuditStore is an append-only store of audit records
+type AuditStore interface {
+	Append(record AuditRecord) error
+	// ListForUser returns the user's most recent records first
+	ListForUser(userName string, limit int) ([]AuditRecord, error)
+}
+
+// validate checks the settings without creating the audit store
+func (config AuditLogConfig) validate() error {
+	switch config.Type {
+	case "":
+	case "jsonl":
+		if config.FilePath == "" {
+			return fmt.Errorf("'audit-log' of type 'jsonl' requires a 'file-path'")
+		}
+	case "dynamodb":
+		if config.DynamodbTable == "" {
+			return fmt.Errorf("'audit-log' of type 'dynamodb' requires a 'dynamodb-table'")
+		}
+	default:
+		return fmt.Errorf("unknown 'audit-log' type '%s'", config.Type)
+	}
+	return nil
+}
+
+// newAuditStore returns the audit store described by the configuration, or
+// nil if the audit log is not enabled
+func newAuditStore(config AuditLogConfig) (AuditStore, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	switch config.Type {
+	case "jsonl":
+		return &jsonlAuditStore{filePath: config.FilePath}, nil
+	case "dynamodb":
+		return &dynamodbAuditStore{tableName: config.DynamodbTable, db: initializeDbConfig().DynamoDb}, nil
+	}
+	return nil, nil
+}
+
+// newAuditRecord returns a record of an action on the specified workspace.
+// The actor is read from the context.
+func newAuditRecord(ctx context.Context, action string, userName string, workspaceName string, containerID string) AuditRecord {
+	now := time.Now().UTC()
+	record := AuditRecord{
+		ID:            now.Format("20060102T150405.000000000Z") + "-" + uuid.New().String(),
+		Time:          now,
+		Action:        action,
+		UserName:      userName,
+		WorkspaceName: workspaceName,
+		ContainerID:   containerID,
+	}
+	if actor := auditActorFromContext(ctx); actor != userName {
+		record.Actor = actor
+	}
+	if container, ok := configFromContext(ctx).ContainersMap[containerID]; ok {
+		record.ContainerName = container.Name
+		record.Image = container.Image
+	}
+	return record
+}
+
+// setOutcome sets the outcome of the action from the error it returned
+func (record *AuditRecord) setOutcome(err error) {
+	if err != nil {
+		record.Outcome = auditFailed
+		record.Error = err.Error()
+	} else {
+		record.Outcome = auditSucceeded
+	}
+}
+
+// recordAudit appends the record to the configured audit store, if any.
+// Failing to record an action does not fail the action: the error is
+// logged.
+var recordAudit = func(record AuditRecord) {
+	config := getConfig()
+	if config == nil || config.AuditStore == nil {
+		return
+	}
+	err := config.AuditStore.Append(record)
+	if err != nil {
+		config.Logger.Printf("Unable to record audit record %+v: %v", record, err)
+	}
+}
+
+type auditActorContextKey struct{}
+
+// withAuditActor returns a copy of ctx that records who performs the
+// actions, when it is not the user who owns the workspace
+func withAuditActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, auditActorContextKey{}, actor)
+}
+
+func auditActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(auditActorContextKey{}).(string)
+	return actor
+}
+
+// jsonlAuditStore appends the records to a local file, one JSON object per
+// line. It is meant for single-replica deployments and development.
+type jsonlAuditStore struct {
+	filePath string
+	mu       sync.Mutex
+}
+
+func (store *jsonlAuditStore) Append(record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	file, err := os.OpenFile(store.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (store *jsonlAuditStore) ListForUser(userName string, limit int) ([]AuditRecord, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	records := []AuditRecord{}
+	file, err := os.Open(store.filePath)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// skip lines truncated by a crash
+			continue
+		}
+		if record.UserName == userName {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// the file is in chronological order
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	return records, nil
+}
+
+// dynamodbAuditStore stores the records in a DynamoDB table with partition
+// key `user` and sort key `id` (both strings)
+type dynamodbAuditStore struct {
+	tableName string
+	db        dynamodbiface.DynamoDBAPI
+}
+
+func (store *dynamodbAuditStore) Append(record AuditRecord) error {
+	item, err := dynamodbattribute.MarshalMap(record)
+	if err != nil {
+		return err
+	}
+	// never overwrite an existing record
+	expr, err := expression.NewBuilder().WithCondition(expression.AttributeNotExists(expression.Name("id"))).Build()
+	if err != nil {
+		return err
+	}
+	_, err = store.db.PutItem(&dynamodb.PutItemInput{
+		TableName:                aws.String(store.tableName),
+		Item:                     item,
+		ConditionExpression:      expr.Condition(),
+		ExpressionAttributeNames: expr.Names(),
+	})
+	recordDependencyError(dependencyDynamoDB, err)
+	return err
+}
+
+func (store *dynamodbAuditStore) ListForUser(userName string, limit int) ([]AuditRecord, error) {
+	keyCondition := expression.Key("user").Equal(expression.Value(userName))
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCondition).Build()
+	if err != nil {
+		return nil, err
+	}
+	output, err := store.db.Query(&dynamodb.QueryInput{
+		TableName:                 aws.String(store.tableName),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		KeyConditionExpression:    expr.KeyCondition(),
+		// most recent first
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(int64(limit)),
+	})
+	recordDependencyError(dependencyDynamoDB, err)
+	if err != nil {
+		return nil, err
+	}
+	records := []AuditRecord{}
+	err = dynamodbattribute.UnmarshalListOfMaps(output.Items, &records)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// `/audit` => return the current user's most recent audit records
+// `/audit?limit=10` => return at most 10 records
+func auditEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userName := getCurrentUserName(r)
+	if userName == "" {
+		http.Error(w, "Please login", http.StatusUnauthorized)
+		return
+	}
+	if getConfig().AuditStore == nil {
+		http.Error(w, "The audit log is not enabled", http.StatusNotFound)
+		return
+	}
+
+	limit := defaultAuditLimit
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 || limit > maxAuditLimit {
+			http.Error(w, fmt.Sprintf("Invalid 'limit' parameter '%s': expected a number between 1 and %d", limitParam, maxAuditLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := getConfig().AuditStore.ListForUser(userName, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out, err := json.Marshal(records)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, string(out))
+}
@@ -0,0 +1,228 @@
+package hatchery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+func TestNewAuditStore(t *testing.T) {
+	testCases := []struct {
+		name       string
+		config     AuditLogConfig
+		wantStore  bool
+		wantErrors bool
+	}{
+		{name: "Disabled", config: AuditLogConfig{}},
+		{name: "Jsonl", config: AuditLogConfig{Type: "jsonl", FilePath: "/tmp/audit.jsonl"}, wantStore: true},
+		{name: "JsonlWithoutFilePath", config: AuditLogConfig{Type: "jsonl"}, wantErrors: true},
+		{name: "Dynamodb", config: AuditLogConfig{Type: "dynamodb", DynamodbTable: "audit"}, wantStore: true},
+		{name: "DynamodbWithoutTable", config: AuditLogConfig{Type: "dynamodb"}, wantErrors: true},
+		{name: "UnknownType", config: AuditLogConfig{Type: "stone-tablet"}, wantErrors: true},
+	}
+	for _, testcase := range testCases {
+		store, err := newAuditStore(testcase.config)
+		if testcase.wantErrors != (err != nil) {
+			t.Errorf("unexpected error when %s: %v", testcase.name, err)
+		}
+		if testcase.wantStore != (store != nil) {
+			t.Errorf("unexpected store when %s: %v", testcase.name, store)
+		}
+	}
+}
+
+func TestNewAuditRecord(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_containersMap := getConfig().ContainersMap
+	defer func() {
+		getConfig().ContainersMap = original_containersMap
+	}()
+	getConfig().ContainersMap = map[string]Container{
+		"hash": {Name: "RStudio", Image: "quay.io/cdis/rstudio:latest"},
+	}
+
+	record := newAuditRecord(context.Background(), auditLaunch, "testUser", "rstudio", "hash")
+	if record.ContainerName != "RStudio" || record.Image != "quay.io/cdis/rstudio:latest" || record.Actor != "" {
+		t.Errorf("unexpected audit record: %+v", record)
+	}
+	record.setOutcome(errors.New("launch failed"))
+	if record.Outcome != auditFailed || record.Error != "launch failed" {
+		t.Errorf("unexpected outcome: %+v", record)
+	}
+
+	record = newAuditRecord(withAuditActor(context.Background(), "idle-reaper"), auditTerminate, "testUser", "", "unknown")
+	if record.Actor != "idle-reaper" || record.ContainerName != "" {
+		t.Errorf("unexpected audit record: %+v", record)
+	}
+	record.setOutcome(nil)
+	if record.Outcome != auditSucceeded || record.Error != "" {
+		t.Errorf("unexpected outcome: %+v", record)
+	}
+
+	// users are not recorded as the actor of their own actions
+	record = newAuditRecord(withAuditActor(context.Background(), "testUser"), auditTerminate, "testUser", "", "")
+	if record.Actor != "" {
+		t.Errorf("unexpected actor: %+v", record)
+	}
+}
+
+func TestJsonlAuditStore(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	store := &jsonlAuditStore{filePath: filepath.Join(t.TempDir(), "audit.jsonl")}
+
+	records, err := store.ListForUser("user1", 10)
+	if err != nil || len(records) != 0 {
+		t.Errorf("expected no records before the file exists, got %v, %v", records, err)
+	}
+
+	for _, action := range []string{auditSetPayModel, auditLaunch, auditTerminate} {
+		for _, userName := range []string{"user1", "user2"} {
+			if err := store.Append(newAuditRecord(context.Background(), action, userName, "", "")); err != nil {
+				t.Fatalf("unable to append audit record: %v", err)
+			}
+		}
+	}
+
+	records, err = store.ListForUser("user1", 10)
+	if err != nil {
+		t.Fatalf("unable to list audit records: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+	for i, action := range []string{auditTerminate, auditLaunch, auditSetPayModel} {
+		if records[i].UserName != "user1" || records[i].Action != action {
+			t.Errorf("expected record %d to be a %s by user1, got %+v", i, action, records[i])
+		}
+	}
+
+	records, err = store.ListForUser("user1", 1)
+	if err != nil || len(records) != 1 || records[0].Action != auditTerminate {
+		t.Errorf("expected only the most recent record, got %v, %v", records, err)
+	}
+}
+
+type auditDynamodbMockClient struct {
+	dynamodbiface.DynamoDBAPI
+	putItemInput *dynamodb.PutItemInput
+	queryInput   *dynamodb.QueryInput
+	items        []map[string]*dynamodb.AttributeValue
+}
+
+func (m *auditDynamodbMockClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	m.putItemInput = input
+	m.items = append(m.items, input.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *auditDynamodbMockClient) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	m.queryInput = input
+	return &dynamodb.QueryOutput{Items: m.items}, nil
+}
+
+func TestDynamodbAuditStore(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	mockClient := &auditDynamodbMockClient{}
+	store := &dynamodbAuditStore{tableName: "audit", db: mockClient}
+
+	record := newAuditRecord(context.Background(), auditSetPayModel, "user1", "", "")
+	record.PayModelID = "pay-model-id"
+	record.setOutcome(nil)
+	if err := store.Append(record); err != nil {
+		t.Fatalf("unable to append audit record: %v", err)
+	}
+	if aws.StringValue(mockClient.putItemInput.TableName) != "audit" || mockClient.putItemInput.ConditionExpression == nil {
+		t.Errorf("records should be put in the audit table without overwriting existing items: %v", mockClient.putItemInput)
+	}
+	var stored AuditRecord
+	if err := dynamodbattribute.UnmarshalMap(mockClient.putItemInput.Item, &stored); err != nil || stored.ID != record.ID || stored.PayModelID != "pay-model-id" {
+		t.Errorf("unexpected stored item %v: %v", mockClient.putItemInput.Item, err)
+	}
+
+	records, err := store.ListForUser("user1", 5)
+	if err != nil || len(records) != 1 || records[0].ID != record.ID {
+		t.Errorf("unexpected records %v: %v", records, err)
+	}
+	if aws.BoolValue(mockClient.queryInput.ScanIndexForward) || aws.Int64Value(mockClient.queryInput.Limit) != 5 {
+		t.Errorf("expected a query for the 5 most recent records, got %v", mockClient.queryInput)
+	}
+}
+
+func TestAuditEndpoint(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	defer func() {
+		SetConfig(original_config)
+	}()
+
+	store := &jsonlAuditStore{filePath: filepath.Join(t.TempDir(), "audit.jsonl")}
+	for _, userName := range []string{"user1", "user1", "user2"} {
+		_ = store.Append(newAuditRecord(context.Background(), auditLaunch, userName, "", ""))
+	}
+
+	testCases := []struct {
+		name        string
+		username    string
+		query       string
+		store       AuditStore
+		wantStatus  int
+		wantRecords int
+	}{
+		{name: "NotLoggedIn", store: store, wantStatus: http.StatusUnauthorized},
+		{name: "AuditLogDisabled", username: "user1", wantStatus: http.StatusNotFound},
+		{name: "InvalidLimit", username: "user1", query: "?limit=0", store: store, wantStatus: http.StatusBadRequest},
+		{name: "OwnRecords", username: "user1", store: store, wantStatus: http.StatusOK, wantRecords: 2},
+		{name: "Limit", username: "user1", query: "?limit=1", store: store, wantStatus: http.StatusOK, wantRecords: 1},
+	}
+
+	for _, testcase := range testCases {
+		SetConfig(&FullHatcheryConfig{
+			Logger:     log.New(io.Discard, "", log.LstdFlags),
+			AuditStore: testcase.store,
+		})
+		req, err := http.NewRequest("GET", "/audit"+testcase.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if testcase.username != "" {
+			req.Header.Set("REMOTE_USER", testcase.username)
+		}
+		w := httptest.NewRecorder()
+		http.HandlerFunc(auditEndpoint).ServeHTTP(w, req)
+
+		if w.Code != testcase.wantStatus {
+			t.Errorf("handler returned wrong status code when %s:\ngot: '%v'\nwant: '%v'", testcase.name, w.Code, testcase.wantStatus)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var records []AuditRecord
+		if err := json.Unmarshal(w.Body.Bytes(), &records); err != nil {
+			t.Fatalf("unable to parse the response: %v", err)
+		}
+		if len(records) != testcase.wantRecords {
+			t.Errorf("expected %d records when %s, got %d", testcase.wantRecords, testcase.name, len(records))
+		}
+		for _, record := range records {
+			if record.UserName != testcase.username {
+				t.Errorf("returned a record of another user: %+v", record)
+			}
+		}
+	}
+}
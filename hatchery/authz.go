@@ -116,16 +116,26 @@ func validateAuthzConfigVersion_0_1(authzConfig AuthzVersion_0_1) error {
 */
 
 var isUserAuthorizedForContainer = func(userName string, accessToken string, container Container) (bool, error) {
-	if container.Authz.Version == 0 { // default int value "0" is interpreted as "no authz config"
+	return isUserAuthorizedForAuthzConfig(userName, accessToken, container.Name, container.Authz)
+}
+
+// isUserAuthorizedForResourceProfile checks the profile's own `authz`. The
+// user must also be authorized for the container.
+var isUserAuthorizedForResourceProfile = func(userName string, accessToken string, container Container, profile ResourceProfile) (bool, error) {
+	return isUserAuthorizedForAuthzConfig(userName, accessToken, fmt.Sprintf("%s (%s)", container.Name, profile.Name), profile.Authz)
+}
+
+func isUserAuthorizedForAuthzConfig(userName string, accessToken string, containerName string, authzConfig AuthzConfig) (bool, error) {
+	if authzConfig.Version == 0 { // default int value "0" is interpreted as "no authz config"
 		return true, nil
 	}
 
-	Config.Logger.Printf("DEBUG: Checking user '%s' access to container '%s'", userName, container.Name)
-	if container.Authz.Version == 0.1 {
-		return isUserAuthorizedForContainerVersion_0_1(userName, accessToken, container.Name, container.Authz.AuthzVersion_0_1)
+	getConfig().Logger.Printf("DEBUG: Checking user '%s' access to container '%s'", userName, containerName)
+	if authzConfig.Version == 0.1 {
+		return isUserAuthorizedForContainerVersion_0_1(userName, accessToken, containerName, authzConfig.AuthzVersion_0_1)
 	} else {
 		// this should never happen, it would get caught by `ValidateAuthzConfig`
-		return false, fmt.Errorf("Container authz config version '%v' is not valid", container.Authz.Version)
+		return false, fmt.Errorf("Container authz config version '%v' is not valid", authzConfig.Version)
 	}
 }
 
@@ -177,7 +187,7 @@ func isUserAuthorizedForContainerVersion_0_1(userName string, accessToken string
 	if !userIsAuthorized {
 		logPartial = "not "
 	}
-	Config.Logger.Printf("INFO: User '%s' is %sauthorized to run container '%s'", userName, logPartial, containerName)
+	getConfig().Logger.Printf("INFO: User '%s' is %sauthorized to run container '%s'", userName, logPartial, containerName)
 	return userIsAuthorized, nil
 }
 
@@ -198,7 +208,7 @@ var isUserAuthorizedForPayModels = func(userName string, allowedPayModels []stri
 		If the user is using any of the pay models specified in `allowedPayModels`, return true.
 		Otherwise, return false.
 	*/
-	Config.Logger.Printf("DEBUG: Checking user '%s' pay model against allowed pay models %v", userName, allowedPayModels)
+	getConfig().Logger.Printf("DEBUG: Checking user '%s' pay model against allowed pay models %v", userName, allowedPayModels)
 
 	if len(allowedPayModels) == 0 {
 		// no pay models are allowed => everyone is denied access (although we should never reach this block
@@ -207,12 +217,12 @@ var isUserAuthorizedForPayModels = func(userName string, allowedPayModels []stri
 	}
 
 	if userName == "" {
-		Config.Logger.Print("User is not logged in, assume they are not allowed to run container")
+		getConfig().Logger.Print("User is not logged in, assume they are not allowed to run container")
 		return false, nil
 	}
 	currentPayModel, err := getCurrentPayModel(userName)
 	if err != nil {
-		Config.Logger.Printf(fmt.Sprintf("Failed to get current pay model for user '%s', unable to check if user is authorized to launch container. Error: %v", userName, err))
+		getConfig().Logger.Printf(fmt.Sprintf("Failed to get current pay model for user '%s', unable to check if user is authorized to launch container. Error: %v", userName, err))
 		return false, nil
 	}
 
@@ -223,7 +233,7 @@ var isUserAuthorizedForPayModels = func(userName string, allowedPayModels []stri
 	}
 
 	if !stringArrayContains(allowedPayModels, currentPayModelName) {
-		Config.Logger.Printf("DEBUG: Pay model '%s' is not allowed for container", currentPayModelName)
+		getConfig().Logger.Printf("DEBUG: Pay model '%s' is not allowed for container", currentPayModelName)
 		return false, nil // do not return this pay model as an option
 	}
 
@@ -231,7 +241,7 @@ var isUserAuthorizedForPayModels = func(userName string, allowedPayModels []stri
 }
 
 var isUserAuthorizedForResourcePaths = func(userName string, accessToken string, resourcePaths []string) (bool, error) {
-	Config.Logger.Printf("DEBUG: Checking user '%s' access to resource paths %v (service 'jupyterhub', method 'launch')", userName, resourcePaths)
+	getConfig().Logger.Printf("DEBUG: Checking user '%s' access to resource paths %v (service 'jupyterhub', method 'launch')", userName, resourcePaths)
 
 	body := "{ \"requests\": ["
 	for _, resource := range resourcePaths {
@@ -242,14 +252,29 @@ var isUserAuthorizedForResourcePaths = func(userName string, accessToken string,
 
 	authorized, err := arboristAuthRequest(accessToken, body)
 	if err != nil {
-		Config.Logger.Printf("something went wrong when making a call to arborist's `/auth/request` endpoint. Denying access. Details: %v", err.Error())
+		getConfig().Logger.Printf("something went wrong when making a call to arborist's `/auth/request` endpoint. Denying access. Details: %v", err.Error())
 		return false, nil
 	}
 
 	return authorized, nil
 }
 
-var arboristAuthRequest = func(accessToken string, body string) (bool, error) {
+// isUserHatcheryAdmin checks that the user can use the admin endpoints,
+// ie that they have access to method `admin` of service `hatchery` on the
+// configured `admin-resource-path`
+var isUserHatcheryAdmin = func(userName string, accessToken string) (bool, error) {
+	resourcePath := getConfig().Config.AdminResourcePath
+	if userName == "" || accessToken == "" || resourcePath == "" {
+		return false, nil
+	}
+	getConfig().Logger.Printf("DEBUG: Checking user '%s' access to resource path %s (service 'hatchery', method 'admin')", userName, resourcePath)
+	body := fmt.Sprintf("{ \"requests\": [{\"resource\": \"%s\", \"action\": {\"service\": \"hatchery\", \"method\": \"admin\"}}]}", resourcePath)
+	return arboristAuthRequest(accessToken, body)
+}
+
+var arboristAuthRequest = func(accessToken string, body string) (authorized bool, err error) {
+	defer func() { recordDependencyError(dependencyArborist, err) }()
+
 	arboristUrl := "http://arborist-service/auth/request"
 	req, err := http.NewRequest("POST", arboristUrl, bytes.NewBufferString(body))
 	if err != nil {
@@ -148,7 +148,7 @@ func TestValidateAuthzConfigVersion0_1(t *testing.T) {
 			t.Errorf("failed to load authz config: %v", err)
 			return
 		}
-		err = ValidateAuthzConfig(Config.Logger, config)
+		err = ValidateAuthzConfig(getConfig().Logger, config)
 		if testCase.valid && nil != err {
 			t.Errorf("config is valid but the validation did not accept it: %v", err)
 			return
@@ -0,0 +1,214 @@
+package hatchery
+
+import (
+	"context"
+	"errors"
+
+	k8sv1 "k8s.io/api/core/v1"
+)
+
+// The pay model types, which are the names of the backends their
+// workspaces run on
+const (
+	backendLocalK8s    = "local"
+	backendExternalK8s = "eks"
+	backendEcs         = "ecs"
+)
+
+// WorkspaceBackend runs workspaces on one kind of compute. The backends
+// hold no state: the user's current pay model, nil in commons without pay
+// models, is passed to each call.
+type WorkspaceBackend interface {
+	// CheckPayModel returns an error if the pay model can not be used to
+	// launch workspaces on this backend
+	CheckPayModel(payModel *PayModel) error
+	// SupportsNamedWorkspaces is false for the backends that only run the
+	// user's default workspace
+	SupportsNamedWorkspaces() bool
+	// Launch creates the workspace's compute. It runs in the background
+	// launch operation, which it reports the outcome of.
+	Launch(ctx context.Context, launch WorkspaceLaunch) error
+	// Render returns what Launch would create, without creating anything
+	// or minting API keys
+	Render(ctx context.Context, launch WorkspaceLaunch) (*WorkspaceSpec, error)
+	// Status returns the status of the workspace's compute, "Not Found"
+	// when it is not running
+	Status(ctx context.Context, userName string, workspaceName string, accessToken string, payModel *PayModel) (*WorkspaceStatus, error)
+	// Terminate deletes the workspace's compute, but not the user volume,
+	// and returns a message for the user
+	Terminate(ctx context.Context, userName string, workspaceName string, accessToken string, payModel *PayModel) (string, error)
+	// List returns the names of the user's running workspaces, sorted. The
+	// default workspace is listed as "".
+	List(ctx context.Context, userName string, accessToken string, payModel *PayModel) ([]string, error)
+}
+
+// WorkspaceLaunch holds the parameters of a launch
+type WorkspaceLaunch struct {
+	UserName      string
+	WorkspaceName string
+	// the resolved ID of the container to launch
+	ContainerID string
+	AccessToken string
+	PayModel    *PayModel
+	// the extra environment variables of the workspace, in the format of
+	// the k8s and of the ECS backends
+	EnvVars    []k8sv1.EnvVar
+	EcsEnvVars []EnvVar
+}
+
+// workspaceBackends holds the backend of each pay model type, as returned
+// by `backendForPayModel`
+var workspaceBackends = map[string]WorkspaceBackend{
+	backendLocalK8s:    localK8sBackend{},
+	backendExternalK8s: externalK8sBackend{},
+	backendEcs:         ecsBackend{},
+}
+
+// backendForPayModel returns the type of the pay model, which is the name
+// of the backend its workspaces run on
+func backendForPayModel(payModel *PayModel) string {
+	if payModel == nil || payModel.Local {
+		return backendLocalK8s
+	}
+	if payModel.Ecs {
+		return backendEcs
+	}
+	return backendExternalK8s
+}
+
+// getWorkspaceBackend returns the name and the implementation of the
+// backend the workspaces of the pay model run on
+func getWorkspaceBackend(payModel *PayModel) (string, WorkspaceBackend) {
+	name := backendForPayModel(payModel)
+	return name, workspaceBackends[name]
+}
+
+var errPayModelNotActive = errors.New("Paymodel is not active")
+
+// k8sBackend holds what the local and the external k8s backends share: they
+// only differ in the cluster the pay model points to
+type k8sBackend struct{}
+
+func (k8sBackend) CheckPayModel(payModel *PayModel) error {
+	return nil
+}
+
+func (k8sBackend) SupportsNamedWorkspaces() bool {
+	return true
+}
+
+func (k8sBackend) Status(ctx context.Context, userName string, workspaceName string, accessToken string, payModel *PayModel) (*WorkspaceStatus, error) {
+	return statusK8sPod(ctx, userName, workspaceName, accessToken, payModel)
+}
+
+func (k8sBackend) Terminate(ctx context.Context, userName string, workspaceName string, accessToken string, payModel *PayModel) (string, error) {
+	err := deleteK8sPod(ctx, userName, workspaceName, accessToken, payModel)
+	if err != nil {
+		return "", err
+	}
+	getConfig().Logger.Printf("Terminated workspace '%s' for user %s", workspaceName, userName)
+	return "Terminated workspace", nil
+}
+
+func (k8sBackend) List(ctx context.Context, userName string, accessToken string, payModel *PayModel) ([]string, error) {
+	return listK8sWorkspaceNames(ctx, userName, payModel)
+}
+
+// followK8sLaunch reports the outcome of the creation of a workspace pod to
+// the launch operation: the failure, or else the startup of the pod
+func followK8sLaunch(ctx context.Context, err error) error {
+	op := operationFromContext(ctx)
+	if op == nil {
+		return err
+	}
+	if err != nil {
+		publishLaunchFailed(op, err)
+		return err
+	}
+	watchWorkspaceStartup(op)
+	return nil
+}
+
+// localK8sBackend runs the workspaces in the cluster hatchery runs in
+type localK8sBackend struct {
+	k8sBackend
+}
+
+func (localK8sBackend) Launch(ctx context.Context, launch WorkspaceLaunch) error {
+	err := createLocalK8sPod(ctx, launch.ContainerID, launch.UserName, launch.WorkspaceName, launch.AccessToken, launch.EnvVars)
+	return followK8sLaunch(ctx, err)
+}
+
+func (localK8sBackend) Render(ctx context.Context, launch WorkspaceLaunch) (*WorkspaceSpec, error) {
+	return renderLocalK8sWorkspace(ctx, launch)
+}
+
+// externalK8sBackend runs the workspaces in the EKS cluster of the pay
+// model's AWS account
+type externalK8sBackend struct {
+	k8sBackend
+}
+
+func (externalK8sBackend) Launch(ctx context.Context, launch WorkspaceLaunch) error {
+	err := createExternalK8sPod(ctx, launch.ContainerID, launch.UserName, launch.WorkspaceName, launch.AccessToken, *launch.PayModel, launch.EnvVars)
+	return followK8sLaunch(ctx, err)
+}
+
+func (externalK8sBackend) Render(ctx context.Context, launch WorkspaceLaunch) (*WorkspaceSpec, error) {
+	return renderExternalK8sWorkspace(ctx, launch)
+}
+
+// ecsBackend runs the user's default workspace as an ECS service in the pay
+// model's AWS account
+type ecsBackend struct{}
+
+func (ecsBackend) CheckPayModel(payModel *PayModel) error {
+	if payModel.Status != "active" {
+		return errPayModelNotActive
+	}
+	return nil
+}
+
+func (ecsBackend) SupportsNamedWorkspaces() bool {
+	return false
+}
+
+func (ecsBackend) Launch(ctx context.Context, launch WorkspaceLaunch) error {
+	// publishes its own events
+	return launchEcsWorkspaceWrapper(ctx, launch.UserName, launch.ContainerID, launch.AccessToken, *launch.PayModel, launch.EcsEnvVars)
+}
+
+func (ecsBackend) Render(ctx context.Context, launch WorkspaceLaunch) (*WorkspaceSpec, error) {
+	return renderEcsWorkspace(ctx, launch)
+}
+
+func (ecsBackend) Status(ctx context.Context, userName string, workspaceName string, accessToken string, payModel *PayModel) (*WorkspaceStatus, error) {
+	if workspaceName != "" {
+		return &WorkspaceStatus{Status: "Not Found", WorkspaceType: "ECS", WorkspaceName: workspaceName}, nil
+	}
+	status, err := statusEcs(ctx, userName, accessToken, payModel.AWSAccountId)
+	if err == nil && status.Status != "Not Found" {
+		setEcsSessionTimes(ctx, status, userName, payModel)
+	}
+	return status, err
+}
+
+func (ecsBackend) Terminate(ctx context.Context, userName string, workspaceName string, accessToken string, payModel *PayModel) (string, error) {
+	_, err := terminateEcsWorkspace(ctx, userName, accessToken, payModel.AWSAccountId)
+	if err != nil {
+		return "", err
+	}
+	getConfig().Logger.Printf("Succesfully terminated all resources related to ECS workspace for user %s", userName)
+	return "Terminated ECS workspace", nil
+}
+
+func (ecsBackend) List(ctx context.Context, userName string, accessToken string, payModel *PayModel) ([]string, error) {
+	status, err := statusEcs(ctx, userName, accessToken, payModel.AWSAccountId)
+	if err != nil {
+		return nil, err
+	}
+	if status.Status == "Not Found" {
+		return []string{}, nil
+	}
+	return []string{""}, nil
+}
@@ -0,0 +1,243 @@
+package hatchery
+
+import (
+	"context"
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// fakeWorkspaceBackend keeps the running workspaces in memory
+type fakeWorkspaceBackend struct {
+	// user name => names of the user's running workspaces
+	workspaces map[string]map[string]bool
+}
+
+func newFakeWorkspaceBackend() *fakeWorkspaceBackend {
+	return &fakeWorkspaceBackend{workspaces: make(map[string]map[string]bool)}
+}
+
+func (backend *fakeWorkspaceBackend) CheckPayModel(payModel *PayModel) error {
+	return nil
+}
+
+func (backend *fakeWorkspaceBackend) SupportsNamedWorkspaces() bool {
+	return true
+}
+
+func (backend *fakeWorkspaceBackend) Launch(ctx context.Context, launch WorkspaceLaunch) error {
+	if backend.workspaces[launch.UserName] == nil {
+		backend.workspaces[launch.UserName] = make(map[string]bool)
+	}
+	backend.workspaces[launch.UserName][launch.WorkspaceName] = true
+	return nil
+}
+
+func (backend *fakeWorkspaceBackend) Render(ctx context.Context, launch WorkspaceLaunch) (*WorkspaceSpec, error) {
+	return &WorkspaceSpec{AmbassadorMapping: launch.UserName + "/" + launch.WorkspaceName}, nil
+}
+
+func (backend *fakeWorkspaceBackend) Status(ctx context.Context, userName string, workspaceName string, accessToken string, payModel *PayModel) (*WorkspaceStatus, error) {
+	status := &WorkspaceStatus{Status: "Not Found", WorkspaceType: "Fake", WorkspaceName: workspaceName}
+	if backend.workspaces[userName][workspaceName] {
+		status.Status = "Running"
+	}
+	return status, nil
+}
+
+func (backend *fakeWorkspaceBackend) Terminate(ctx context.Context, userName string, workspaceName string, accessToken string, payModel *PayModel) (string, error) {
+	delete(backend.workspaces[userName], workspaceName)
+	return "Terminated fake workspace", nil
+}
+
+func (backend *fakeWorkspaceBackend) List(ctx context.Context, userName string, accessToken string, payModel *PayModel) ([]string, error) {
+	workspaceNames := []string{}
+	for name := range backend.workspaces[userName] {
+		workspaceNames = append(workspaceNames, name)
+	}
+	sort.Strings(workspaceNames)
+	return workspaceNames, nil
+}
+
+func TestWorkspaceBackendRegistry(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_workspaceBackend := workspaceBackends[backendEcs]
+	original_getPayModelsForUser := getPayModelsForUser
+	original_getCurrentPayModel := getCurrentPayModel
+	defer func() {
+		workspaceBackends[backendEcs] = original_workspaceBackend
+		getPayModelsForUser = original_getPayModelsForUser
+		getCurrentPayModel = original_getCurrentPayModel
+	}()
+
+	payModel := &PayModel{Id: "ecs-pay-model", Ecs: true, Status: "active"}
+	getPayModelsForUser = func(string) (*AllPayModels, error) {
+		return &AllPayModels{CurrentPayModel: payModel}, nil
+	}
+	getCurrentPayModel = func(string) (*PayModel, error) {
+		return payModel, nil
+	}
+	backend := newFakeWorkspaceBackend()
+	workspaceBackends[backendEcs] = backend
+
+	ctx := context.Background()
+	name, selected := getWorkspaceBackend(payModel)
+	if name != backendEcs || selected != backend {
+		t.Fatalf("expected the fake backend to be selected for an ECS pay model, got '%s'", name)
+	}
+	err := selected.Launch(ctx, WorkspaceLaunch{UserName: "testUser", WorkspaceName: "abc", PayModel: payModel})
+	if err != nil {
+		t.Fatalf("unexpected launch error: %v", err)
+	}
+
+	status, err := getRunningWorkspaceStatus(ctx, "testUser", "abc", "")
+	if err != nil || status.Status != "Running" || status.WorkspaceType != "Fake" {
+		t.Errorf("expected the status of the fake backend, got %+v, %v", status, err)
+	}
+	workspaceNames, err := getRunningWorkspaceNames(ctx, "testUser", "")
+	if err != nil || !reflect.DeepEqual(workspaceNames, []string{"abc"}) {
+		t.Errorf("expected the workspaces of the fake backend, got %v, %v", workspaceNames, err)
+	}
+	result, err := deleteWorkspaceCompute(ctx, "testUser", "abc", "", payModel)
+	if err != nil || result != "Terminated fake workspace" {
+		t.Errorf("expected the fake backend to terminate the workspace, got '%s', %v", result, err)
+	}
+	status, _ = getRunningWorkspaceStatus(ctx, "testUser", "abc", "")
+	if status.Status != "Not Found" {
+		t.Errorf("expected the workspace to be terminated, got %+v", status)
+	}
+}
+
+func TestLocalK8sBackend(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_getLocalPodClient := getLocalPodClient
+	original_getAPIKeyWithContext := getAPIKeyWithContext
+	defer func() {
+		SetConfig(original_config)
+		getLocalPodClient = original_getLocalPodClient
+		getAPIKeyWithContext = original_getAPIKeyWithContext
+	}()
+
+	SetConfig(&FullHatcheryConfig{
+		Logger: log.New(io.Discard, "", log.LstdFlags),
+		Config: HatcheryConfig{
+			UserNamespace:  "jupyter-pods",
+			UserVolumeSize: "10Gi",
+			Sidecar:        SidecarContainer{CPULimit: "0.1", MemoryLimit: "64Mi"},
+		},
+		ContainersMap: map[string]Container{
+			"test-container": {Name: "Test", CPULimit: "1", MemoryLimit: "1Gi", Image: "quay.io/cdis/jupyter", UserVolumeLocation: "/home/jovyan/pd"},
+		},
+	})
+	clientset := fake.NewSimpleClientset()
+	getLocalPodClient = func() corev1.CoreV1Interface {
+		return clientset.CoreV1()
+	}
+	getAPIKeyWithContext = func(context.Context, string) (*APIKeyStruct, error) {
+		return &APIKeyStruct{APIKey: "key", KeyID: "key-id"}, nil
+	}
+
+	ctx := context.Background()
+	_, backend := getWorkspaceBackend(nil)
+	err := backend.Launch(ctx, WorkspaceLaunch{UserName: "testUser", WorkspaceName: "abc", ContainerID: "test-container", AccessToken: "token"})
+	if err != nil {
+		t.Fatalf("unexpected launch error: %v", err)
+	}
+	claims, _ := clientset.CoreV1().PersistentVolumeClaims("jupyter-pods").List(ctx, metav1.ListOptions{})
+	services, _ := clientset.CoreV1().Services("jupyter-pods").List(ctx, metav1.ListOptions{})
+	if len(claims.Items) != 1 || len(services.Items) != 1 {
+		t.Errorf("expected a user volume claim and a service, got %d and %d", len(claims.Items), len(services.Items))
+	}
+
+	workspaceNames, err := backend.List(ctx, "testUser", "token", nil)
+	if err != nil || !reflect.DeepEqual(workspaceNames, []string{"abc"}) {
+		t.Errorf("unexpected workspaces: %v, %v", workspaceNames, err)
+	}
+	workspaceNames, _ = backend.List(ctx, "otherUser", "token", nil)
+	if len(workspaceNames) != 0 {
+		t.Errorf("expected no workspaces for another user, got %v", workspaceNames)
+	}
+
+	podName := workspaceToResourceName("testUser", "abc", "pod")
+	pod, err := clientset.CoreV1().Pods("jupyter-pods").Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the workspace pod to be created: %v", err)
+	}
+	pod.Status.Phase = k8sv1.PodRunning
+	pod.Status.Conditions = []k8sv1.PodCondition{{Type: k8sv1.PodReady, Status: k8sv1.ConditionTrue}}
+	_, err = clientset.CoreV1().Pods("jupyter-pods").UpdateStatus(ctx, pod, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := backend.Status(ctx, "testUser", "abc", "token", nil)
+	if err != nil || status.Status != "Running" {
+		t.Errorf("expected the workspace to be running, got %+v, %v", status, err)
+	}
+
+	result, err := backend.Terminate(ctx, "testUser", "abc", "", nil)
+	if err != nil || result != "Terminated workspace" {
+		t.Errorf("unexpected termination result: '%s', %v", result, err)
+	}
+	status, _ = backend.Status(ctx, "testUser", "abc", "token", nil)
+	if status.Status != "Not Found" {
+		t.Errorf("expected the workspace to be terminated, got %+v", status)
+	}
+	claims, _ = clientset.CoreV1().PersistentVolumeClaims("jupyter-pods").List(ctx, metav1.ListOptions{})
+	if len(claims.Items) != 1 {
+		t.Errorf("expected the user volume claim to be kept")
+	}
+}
+
+func TestEcsBackend(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_statusEcs := statusEcs
+	defer func() {
+		statusEcs = original_statusEcs
+	}()
+	running := false
+	statusEcs = func(context.Context, string, string, string) (*WorkspaceStatus, error) {
+		if running {
+			return &WorkspaceStatus{Status: "Launching", WorkspaceType: "ECS"}, nil
+		}
+		return &WorkspaceStatus{Status: "Not Found", WorkspaceType: "ECS"}, nil
+	}
+
+	ctx := context.Background()
+	payModel := &PayModel{Ecs: true, Status: "inactive"}
+	_, backend := getWorkspaceBackend(payModel)
+	if backend.SupportsNamedWorkspaces() {
+		t.Errorf("expected the ECS backend not to support named workspaces")
+	}
+	if err := backend.CheckPayModel(payModel); err != errPayModelNotActive {
+		t.Errorf("expected an inactive pay model to be rejected, got %v", err)
+	}
+	payModel.Status = "active"
+	if err := backend.CheckPayModel(payModel); err != nil {
+		t.Errorf("expected an active pay model to be accepted, got %v", err)
+	}
+
+	workspaceNames, err := backend.List(ctx, "testUser", "token", payModel)
+	if err != nil || len(workspaceNames) != 0 {
+		t.Errorf("expected no workspaces, got %v, %v", workspaceNames, err)
+	}
+	running = true
+	workspaceNames, _ = backend.List(ctx, "testUser", "token", payModel)
+	if !reflect.DeepEqual(workspaceNames, []string{""}) {
+		t.Errorf("expected the default workspace, got %v", workspaceNames)
+	}
+	status, _ := backend.Status(ctx, "testUser", "abc", "token", payModel)
+	if status.Status != "Not Found" {
+		t.Errorf("expected named workspaces to be reported as not found, got %+v", status)
+	}
+}
@@ -0,0 +1,374 @@
+package hatchery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	k8sv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// `app` label of the config maps that hold the slots of the containers with
+// a `max-concurrent` limit
+const containerCapacityApp = "hatchery-capacity"
+
+// A slot whose workspace is not running is only reclaimed after this long,
+// so that workspaces that are still launching keep their slot
+const capacitySlotGracePeriod = workspaceStartupTimeout
+
+var errCapacityFull = errors.New("Capacity full")
+
+// capacitySlot is held by a workspace of a container with a `max-concurrent`
+// limit, from launch until it is stopped or terminated
+type capacitySlot struct {
+	UserName      string    `json:"user"`
+	WorkspaceName string    `json:"workspace,omitempty"`
+	AcquiredAt    time.Time `json:"acquired_at"`
+}
+
+// The slots of a container are the entries of a config map in the local
+// cluster, keyed by the workspace's pod name. The config map is updated
+// with optimistic concurrency, so that hatchery replicas launching at the
+// same time can not exceed the limit. It is named after the container's ID,
+// its `id` or else the hash of its configuration, which is the same for
+// every replica and every load of an unchanged configuration.
+func containerCapacityName(containerID string) string {
+	return fmt.Sprintf("%s-%s", containerCapacityApp, containerID)
+}
+
+func capacitySlotKey(userName string, workspaceName string) string {
+	return workspaceToResourceName(userName, workspaceName, "pod")
+}
+
+// isSlotLive returns false for slots held by workspaces that were not
+// released properly, eg deleted outside of hatchery. `active` maps the pod
+// names of the active workspaces to their container ID.
+func isSlotLive(slot capacitySlot, containerID string, active map[string]string, now time.Time) bool {
+	if now.Sub(slot.AcquiredAt) < capacitySlotGracePeriod {
+		return true
+	}
+	return active[capacitySlotKey(slot.UserName, slot.WorkspaceName)] == containerID
+}
+
+func getActiveWorkspaceContainers(ctx context.Context) (map[string]string, error) {
+	workspaces, err := listActiveWorkspaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	active := make(map[string]string)
+	for _, workspace := range workspaces {
+		active[capacitySlotKey(workspace.UserName, workspace.WorkspaceName)] = workspace.ContainerID
+	}
+	return active, nil
+}
+
+// acquireContainerSlot reserves a slot for the workspace if the container
+// has a `max-concurrent` limit, or returns an error wrapping
+// `errCapacityFull` if all the slots are taken
+var acquireContainerSlot = func(ctx context.Context, containerID string, userName string, workspaceName string) error {
+	container := configFromContext(ctx).ContainersMap[containerID]
+	if container.MaxConcurrent <= 0 {
+		return nil
+	}
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	configMaps := podClient.ConfigMaps(getConfig().Config.UserNamespace)
+	key := capacitySlotKey(userName, workspaceName)
+
+	retriable := func(err error) bool {
+		return k8serrors.IsConflict(err) || k8serrors.IsAlreadyExists(err)
+	}
+	return retry.OnError(retry.DefaultRetry, retriable, func() error {
+		now := time.Now().UTC()
+		slot, err := json.Marshal(capacitySlot{UserName: userName, WorkspaceName: workspaceName, AcquiredAt: now})
+		if err != nil {
+			return err
+		}
+
+		configMap, err := configMaps.Get(ctx, containerCapacityName(containerID), metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			configMap = &k8sv1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        containerCapacityName(containerID),
+					Namespace:   getConfig().Config.UserNamespace,
+					Labels:      map[string]string{"app": containerCapacityApp},
+					Annotations: map[string]string{containerIDAnnotation: containerID},
+				},
+				Data: map[string]string{key: string(slot)},
+			}
+			_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+
+		// a workspace that was not released properly may still hold its
+		// slot: it is taken over
+		_, holdsSlot := configMap.Data[key]
+		if !holdsSlot && len(configMap.Data) >= container.MaxConcurrent {
+			active, err := getActiveWorkspaceContainers(ctx)
+			if err != nil {
+				return err
+			}
+			for slotKey, value := range configMap.Data {
+				var held capacitySlot
+				if err := json.Unmarshal([]byte(value), &held); err != nil || !isSlotLive(held, containerID, active, now) {
+					getConfig().Logger.Printf("Reclaiming the '%s' slot of workspace %s", container.Name, slotKey)
+					delete(configMap.Data, slotKey)
+				}
+			}
+			if len(configMap.Data) >= container.MaxConcurrent {
+				return fmt.Errorf("%w: the maximum number of concurrent '%s' workspaces (%d) are running. Try again later", errCapacityFull, container.Name, container.MaxConcurrent)
+			}
+		}
+		configMap.Data[key] = string(slot)
+		// fails with a conflict if another replica updated the config map
+		// since it was read
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// releaseContainerSlot frees the workspace's slot, if the container has a
+// `max-concurrent` limit
+var releaseContainerSlot = func(ctx context.Context, containerID string, userName string, workspaceName string) error {
+	if configFromContext(ctx).ContainersMap[containerID].MaxConcurrent <= 0 {
+		return nil
+	}
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	configMaps := podClient.ConfigMaps(getConfig().Config.UserNamespace)
+	key := capacitySlotKey(userName, workspaceName)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := configMaps.Get(ctx, containerCapacityName(containerID), metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := configMap.Data[key]; !ok {
+			return nil
+		}
+		delete(configMap.Data, key)
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// releaseSlot is like releaseContainerSlot, but only logs errors: the slot
+// is reclaimed once the workspace is gone anyway
+func releaseSlot(ctx context.Context, containerID string, userName string, workspaceName string) {
+	err := releaseContainerSlot(ctx, containerID, userName, workspaceName)
+	if err != nil {
+		getConfig().Logger.Printf("Unable to release the '%s' slot of workspace '%s' of user %s: %v", getConfig().ContainersMap[containerID].Name, workspaceName, userName, err)
+	}
+}
+
+// `app` label of the config maps that hold the workspaces each user is
+// launching
+const workspaceReservationsApp = "hatchery-workspaces"
+
+var (
+	errWorkspaceNameTaken = errors.New("A workspace with this name is already running")
+	errTooManyWorkspaces  = errors.New("Maximum number of workspaces per user reached")
+)
+
+// The workspaces a user is launching are reserved in a config map in the
+// local cluster, keyed by the workspace's pod name, the same way as the
+// slots of the containers with a `max-concurrent` limit. The workspace
+// lists are read from the status cache, which lags behind the launches:
+// the reservations keep two launches from getting the same name, or from
+// exceeding `max-workspaces-per-user`, even on different replicas.
+func workspaceReservationsName(userName string) string {
+	return userToResourceName(userName, workspaceReservationsApp)
+}
+
+// reserveWorkspace reserves the workspace name for a launch, or returns an
+// error wrapping `errWorkspaceNameTaken` if the workspace is running or
+// being launched, or `errTooManyWorkspaces` if the user can not launch
+// another workspace. `running` are the names of the user's running
+// workspaces. A reservation expires after the same grace period as a
+// capacity slot, when the workspace is running by then.
+var reserveWorkspace = func(ctx context.Context, userName string, workspaceName string, running []string) error {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	maxWorkspaces := configFromContext(ctx).Config.MaxWorkspacesPerUser
+	configMaps := podClient.ConfigMaps(getConfig().Config.UserNamespace)
+	key := capacitySlotKey(userName, workspaceName)
+
+	retriable := func(err error) bool {
+		return k8serrors.IsConflict(err) || k8serrors.IsAlreadyExists(err)
+	}
+	return retry.OnError(retry.DefaultRetry, retriable, func() error {
+		now := time.Now().UTC()
+		reservation, err := json.Marshal(capacitySlot{UserName: userName, WorkspaceName: workspaceName, AcquiredAt: now})
+		if err != nil {
+			return err
+		}
+
+		configMap, err := configMaps.Get(ctx, workspaceReservationsName(userName), metav1.GetOptions{})
+		create := k8serrors.IsNotFound(err)
+		if create {
+			configMap = &k8sv1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        workspaceReservationsName(userName),
+					Namespace:   getConfig().Config.UserNamespace,
+					Labels:      map[string]string{"app": workspaceReservationsApp},
+					Annotations: map[string]string{userNameAnnotation: userName},
+				},
+			}
+		} else if err != nil {
+			return err
+		}
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+
+		names := make(map[string]bool)
+		for _, name := range running {
+			names[name] = true
+		}
+		if names[workspaceName] {
+			return errWorkspaceNameTaken
+		}
+		for reservationKey, value := range configMap.Data {
+			var held capacitySlot
+			if err := json.Unmarshal([]byte(value), &held); err != nil || now.Sub(held.AcquiredAt) >= capacitySlotGracePeriod {
+				// the workspace is running by now, or its launch failed
+				delete(configMap.Data, reservationKey)
+				continue
+			}
+			if reservationKey == key {
+				return errWorkspaceNameTaken
+			}
+			names[held.WorkspaceName] = true
+		}
+		if len(names) >= maxWorkspaces {
+			return fmt.Errorf("%w (%d). Launch forbidden", errTooManyWorkspaces, maxWorkspaces)
+		}
+
+		configMap.Data[key] = string(reservation)
+		if create {
+			_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+			return err
+		}
+		// fails with a conflict if another replica updated the config map
+		// since it was read
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// releaseWorkspaceReservation frees the workspace name, when its launch
+// failed or when the workspace is stopped or terminated. Errors are only
+// logged: the reservation expires anyway.
+var releaseWorkspaceReservation = func(ctx context.Context, userName string, workspaceName string) {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		getConfig().Logger.Printf("Unable to release the reservation of workspace '%s' of user %s: unable to get a client for the local k8s cluster", workspaceName, userName)
+		return
+	}
+	configMaps := podClient.ConfigMaps(getConfig().Config.UserNamespace)
+	key := capacitySlotKey(userName, workspaceName)
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := configMaps.Get(ctx, workspaceReservationsName(userName), metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := configMap.Data[key]; !ok {
+			return nil
+		}
+		delete(configMap.Data, key)
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		getConfig().Logger.Printf("Unable to release the reservation of workspace '%s' of user %s: %v", workspaceName, userName, err)
+	}
+}
+
+// getContainerSlotsInUse returns the number of slots in use for each
+// container with a `max-concurrent` limit
+var getContainerSlotsInUse = func(ctx context.Context) (map[string]int, error) {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return nil, fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	configMaps, err := podClient.ConfigMaps(getConfig().Config.UserNamespace).List(ctx, metav1.ListOptions{LabelSelector: "app=" + containerCapacityApp})
+	if err != nil {
+		return nil, err
+	}
+	active, err := getActiveWorkspaceContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	inUse := make(map[string]int)
+	for _, configMap := range configMaps.Items {
+		containerID := resolveContainerID(configMap.Annotations[containerIDAnnotation])
+		for _, value := range configMap.Data {
+			var held capacitySlot
+			if err := json.Unmarshal([]byte(value), &held); err == nil && isSlotLive(held, containerID, active, now) {
+				inUse[containerID]++
+			}
+		}
+	}
+	return inUse, nil
+}
+
+// setRemainingCapacity sets the remaining capacity of the options with a
+// `max-concurrent` limit. It is left unset if the slots in use can not be
+// counted.
+func setRemainingCapacity(ctx context.Context, options []containerOption) {
+	limited := false
+	for _, option := range options {
+		if option.MaxConcurrent > 0 {
+			limited = true
+		}
+	}
+	if !limited {
+		return
+	}
+	inUse, err := getContainerSlotsInUse(ctx)
+	if err != nil {
+		getConfig().Logger.Printf("Unable to count the workspaces of the containers with a 'max-concurrent' limit: %v", err)
+		return
+	}
+	for i := range options {
+		if options[i].MaxConcurrent > 0 {
+			remaining := getRemainingCapacity(options[i].MaxConcurrent, inUse[options[i].ID])
+			options[i].RemainingCapacity = &remaining
+		}
+	}
+}
+
+// getRemainingCapacity returns the number of workspaces of a container that
+// can still be launched
+func getRemainingCapacity(maxConcurrent int, inUse int) int {
+	remaining := maxConcurrent - inUse
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
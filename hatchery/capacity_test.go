@@ -0,0 +1,359 @@
+package hatchery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+func TestAcquireContainerSlot(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_getLocalPodClient := getLocalPodClient
+	original_listActiveWorkspaces := listActiveWorkspaces
+	defer func() {
+		SetConfig(original_config)
+		getLocalPodClient = original_getLocalPodClient
+		listActiveWorkspaces = original_listActiveWorkspaces
+	}()
+
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags)})
+	getConfig().ContainersMap = map[string]Container{
+		"gpu":       {Name: "GPU container", MaxConcurrent: 2},
+		"unlimited": {Name: "Unlimited container"},
+	}
+	podClient := fake.NewSimpleClientset().CoreV1()
+	getLocalPodClient = func() corev1.CoreV1Interface {
+		return podClient
+	}
+	activeWorkspaces := []WorkspaceInfo{}
+	listActiveWorkspaces = func(context.Context) ([]WorkspaceInfo, error) {
+		return activeWorkspaces, nil
+	}
+	ctx := context.Background()
+
+	// containers without a limit do not use slots
+	if err := acquireContainerSlot(ctx, "unlimited", "user1", ""); err != nil {
+		t.Errorf("unexpected error for a container without a limit: %v", err)
+	}
+
+	for _, userName := range []string{"user1", "user2"} {
+		if err := acquireContainerSlot(ctx, "gpu", userName, ""); err != nil {
+			t.Fatalf("unable to acquire a slot for %s: %v", userName, err)
+		}
+	}
+	// relaunching a workspace that already holds a slot
+	if err := acquireContainerSlot(ctx, "gpu", "user1", ""); err != nil {
+		t.Errorf("unexpected error when the workspace already holds a slot: %v", err)
+	}
+	err := acquireContainerSlot(ctx, "gpu", "user3", "")
+	if !errors.Is(err, errCapacityFull) {
+		t.Errorf("expected a capacity full error, got %v", err)
+	}
+
+	if err := releaseContainerSlot(ctx, "gpu", "user2", ""); err != nil {
+		t.Fatalf("unable to release the slot: %v", err)
+	}
+	if err := acquireContainerSlot(ctx, "gpu", "user3", ""); err != nil {
+		t.Errorf("unable to acquire a released slot: %v", err)
+	}
+
+	// the slot of a workspace that is gone is reclaimed after the grace
+	// period, unlike the slot of a running workspace
+	configMap, err := podClient.ConfigMaps("").Get(ctx, containerCapacityName("gpu"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unable to get the slots: %v", err)
+	}
+	for key, value := range configMap.Data {
+		var slot capacitySlot
+		_ = json.Unmarshal([]byte(value), &slot)
+		slot.AcquiredAt = slot.AcquiredAt.Add(-2 * capacitySlotGracePeriod)
+		updated, _ := json.Marshal(slot)
+		configMap.Data[key] = string(updated)
+	}
+	if _, err := podClient.ConfigMaps("").Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unable to update the slots: %v", err)
+	}
+	activeWorkspaces = []WorkspaceInfo{{UserName: "user1", ContainerID: "gpu"}}
+	if err := acquireContainerSlot(ctx, "gpu", "user4", ""); err != nil {
+		t.Errorf("expected the slot of user3 to be reclaimed, got %v", err)
+	}
+	inUse, err := getContainerSlotsInUse(ctx)
+	if err != nil || inUse["gpu"] != 2 {
+		t.Errorf("expected 2 slots in use, got %v, %v", inUse, err)
+	}
+}
+
+// Replicas, and a replica that reloads an unchanged configuration, must use
+// the same config maps for the slots of a container
+func TestContainerSlotsAcrossConfigLoads(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_getLocalPodClient := getLocalPodClient
+	original_listActiveWorkspaces := listActiveWorkspaces
+	defer func() {
+		SetConfig(original_config)
+		getLocalPodClient = original_getLocalPodClient
+		listActiveWorkspaces = original_listActiveWorkspaces
+	}()
+
+	composePath, err := filepath.Abs("../testData/dockstore/docker-compose.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	content := fmt.Sprintf(`{
+		"user-namespace": "jupyter-pods",
+		"sidecar": {"image": "quay.io/cdis/gen3fuse-sidecar:master"},
+		"containers": [
+			{"name": "GPU", "image": "quay.io/cdis/gpu:master", "cpu-limit": "1.0", "memory-limit": "1Gi", "max-concurrent": 1},
+			{"id": "gpu-with-id", "name": "GPU with an ID", "image": "quay.io/cdis/gpu:master", "cpu-limit": "2.0", "memory-limit": "1Gi", "max-concurrent": 1}
+		],
+		"more-configs": [{"type": "dockstore-compose:1.0.0", "path": %q, "name": "Dockstore"}]
+	}`, composePath)
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New(io.Discard, "", log.LstdFlags)
+	first, err := ParseConfig(configPath, logger)
+	if err != nil {
+		t.Fatalf("failed to load config, got: %v", err)
+	}
+	second, err := ParseConfig(configPath, logger)
+	if err != nil {
+		t.Fatalf("failed to load config, got: %v", err)
+	}
+	for id := range first.ContainersMap {
+		if _, ok := second.ContainersMap[id]; !ok {
+			t.Errorf("container '%s' has another ID when the configuration is loaded again", id)
+		}
+	}
+
+	podClient := fake.NewSimpleClientset().CoreV1()
+	getLocalPodClient = func() corev1.CoreV1Interface {
+		return podClient
+	}
+	listActiveWorkspaces = func(context.Context) ([]WorkspaceInfo, error) {
+		return []WorkspaceInfo{}, nil
+	}
+	for id, container := range first.ContainersMap {
+		if container.MaxConcurrent == 0 {
+			continue
+		}
+		if err := acquireContainerSlot(withConfig(context.Background(), first), id, "user1", ""); err != nil {
+			t.Fatalf("unable to acquire a '%s' slot: %v", container.Name, err)
+		}
+		// the only slot is taken, whichever configuration is used
+		err := acquireContainerSlot(withConfig(context.Background(), second), id, "user2", "")
+		if !errors.Is(err, errCapacityFull) {
+			t.Errorf("expected a capacity full error for '%s' with the configuration loaded again, got %v", container.Name, err)
+		}
+	}
+}
+
+func TestReserveWorkspace(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_getLocalPodClient := getLocalPodClient
+	defer func() {
+		SetConfig(original_config)
+		getLocalPodClient = original_getLocalPodClient
+	}()
+
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags)})
+	getConfig().Config.MaxWorkspacesPerUser = 3
+	podClient := fake.NewSimpleClientset().CoreV1()
+	getLocalPodClient = func() corev1.CoreV1Interface {
+		return podClient
+	}
+	ctx := context.Background()
+
+	// the first launch is not in the list of running workspaces yet when
+	// the second one checks it
+	if err := reserveWorkspace(ctx, "user1", "rstudio", []string{}); err != nil {
+		t.Fatalf("unable to reserve the workspace: %v", err)
+	}
+	if err := reserveWorkspace(ctx, "user1", "rstudio", []string{}); !errors.Is(err, errWorkspaceNameTaken) {
+		t.Errorf("expected the name to be taken, got %v", err)
+	}
+	if err := reserveWorkspace(ctx, "user1", "", []string{"jupyter"}); err != nil {
+		t.Fatalf("unable to reserve the workspace: %v", err)
+	}
+	if err := reserveWorkspace(ctx, "user1", "jupyter", []string{"jupyter"}); !errors.Is(err, errWorkspaceNameTaken) {
+		t.Errorf("expected the name of a running workspace to be taken, got %v", err)
+	}
+	// "rstudio" and "" are being launched and "jupyter" is running
+	if err := reserveWorkspace(ctx, "user1", "stata", []string{"jupyter"}); !errors.Is(err, errTooManyWorkspaces) {
+		t.Errorf("expected too many workspaces, got %v", err)
+	}
+	// other users have their own reservations
+	if err := reserveWorkspace(ctx, "user2", "rstudio", []string{}); err != nil {
+		t.Errorf("unable to reserve the workspace of another user: %v", err)
+	}
+
+	releaseWorkspaceReservation(ctx, "user1", "rstudio")
+	if err := reserveWorkspace(ctx, "user1", "stata", []string{"jupyter"}); err != nil {
+		t.Errorf("unable to reserve a workspace after a release: %v", err)
+	}
+
+	// the reservations expire after the grace period: by then, the
+	// workspaces are in the list of running workspaces
+	configMap, err := podClient.ConfigMaps("").Get(ctx, workspaceReservationsName("user1"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unable to get the reservations: %v", err)
+	}
+	for key, value := range configMap.Data {
+		var reservation capacitySlot
+		_ = json.Unmarshal([]byte(value), &reservation)
+		reservation.AcquiredAt = reservation.AcquiredAt.Add(-2 * capacitySlotGracePeriod)
+		updated, _ := json.Marshal(reservation)
+		configMap.Data[key] = string(updated)
+	}
+	if _, err := podClient.ConfigMaps("").Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unable to update the reservations: %v", err)
+	}
+	if err := reserveWorkspace(ctx, "user1", "rstudio", []string{"", "stata"}); err != nil {
+		t.Errorf("expected the expired reservations to be dropped, got %v", err)
+	}
+}
+
+func TestOptionsRemainingCapacity(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_getContainerSlotsInUse := getContainerSlotsInUse
+	original_isUserAuthorizedForContainer := isUserAuthorizedForContainer
+	defer func() {
+		SetConfig(original_config)
+		getContainerSlotsInUse = original_getContainerSlotsInUse
+		isUserAuthorizedForContainer = original_isUserAuthorizedForContainer
+	}()
+
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags)})
+	getConfig().ContainersMap = map[string]Container{
+		"gpu":       {Name: "GPU container", MaxConcurrent: 2},
+		"unlimited": {Name: "Unlimited container"},
+	}
+	isUserAuthorizedForContainer = func(string, string, Container) (bool, error) {
+		return true, nil
+	}
+
+	testCases := []struct {
+		name          string
+		inUse         map[string]int
+		countError    error
+		wantRemaining *int
+	}{
+		{name: "FreeSlots", inUse: map[string]int{"gpu": 1}, wantRemaining: intPointer(1)},
+		{name: "Full", inUse: map[string]int{"gpu": 3}, wantRemaining: intPointer(0)},
+		{name: "UnableToCount", countError: errors.New("unable to list config maps")},
+	}
+	for _, testcase := range testCases {
+		getContainerSlotsInUse = func(context.Context) (map[string]int, error) {
+			return testcase.inUse, testcase.countError
+		}
+		req, err := http.NewRequest("GET", "/options", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		http.HandlerFunc(options).ServeHTTP(w, req)
+
+		var got []containerOption
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("unable to parse the response when %s: %v", testcase.name, w.Body.String())
+		}
+		for _, option := range got {
+			if option.ID == "unlimited" && option.RemainingCapacity != nil {
+				t.Errorf("unexpected remaining capacity for a container without a limit when %s", testcase.name)
+			}
+			if option.ID != "gpu" {
+				continue
+			}
+			if option.MaxConcurrent != 2 {
+				t.Errorf("unexpected max-concurrent when %s: %d", testcase.name, option.MaxConcurrent)
+			}
+			if (testcase.wantRemaining == nil) != (option.RemainingCapacity == nil) ||
+				(testcase.wantRemaining != nil && *testcase.wantRemaining != *option.RemainingCapacity) {
+				t.Errorf("unexpected remaining capacity when %s: got %v, want %v", testcase.name, option.RemainingCapacity, testcase.wantRemaining)
+			}
+		}
+	}
+}
+
+func intPointer(i int) *int {
+	return &i
+}
+
+func TestLaunchCapacityFull(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_acquireContainerSlot := acquireContainerSlot
+	original_isUserAuthorizedForContainer := isUserAuthorizedForContainer
+	original_getPayModelsForUser := getPayModelsForUser
+	original_listK8sWorkspaceNames := listK8sWorkspaceNames
+	original_reserveWorkspace := reserveWorkspace
+	original_listStoppedWorkspaceNames := listStoppedWorkspaceNames
+	defer func() {
+		SetConfig(original_config)
+		acquireContainerSlot = original_acquireContainerSlot
+		isUserAuthorizedForContainer = original_isUserAuthorizedForContainer
+		getPayModelsForUser = original_getPayModelsForUser
+		listK8sWorkspaceNames = original_listK8sWorkspaceNames
+		reserveWorkspace = original_reserveWorkspace
+		listStoppedWorkspaceNames = original_listStoppedWorkspaceNames
+	}()
+	reserveWorkspace = func(context.Context, string, string, []string) error {
+		return nil
+	}
+
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags)})
+	getConfig().Config.MaxWorkspacesPerUser = 1
+	getConfig().ContainersMap = map[string]Container{
+		"gpu": {Name: "GPU container", MaxConcurrent: 1},
+	}
+	isUserAuthorizedForContainer = func(string, string, Container) (bool, error) {
+		return true, nil
+	}
+	getPayModelsForUser = func(string) (*AllPayModels, error) {
+		return nil, nil
+	}
+	listK8sWorkspaceNames = func(context.Context, string, *PayModel) ([]string, error) {
+		return []string{}, nil
+	}
+	listStoppedWorkspaceNames = func(context.Context, string) ([]string, error) {
+		return []string{}, nil
+	}
+	acquireContainerSlot = func(context.Context, string, string, string) error {
+		return fmt.Errorf("%w: all the slots are taken", errCapacityFull)
+	}
+
+	req, err := http.NewRequest("POST", "/launch?id=gpu", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("REMOTE_USER", "testUser")
+	w := httptest.NewRecorder()
+	http.HandlerFunc(launch).ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict || !strings.HasPrefix(w.Body.String(), "Capacity full") {
+		t.Errorf("expected a capacity full error, got %d '%s'", w.Code, w.Body.String())
+	}
+}
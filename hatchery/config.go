@@ -10,6 +10,9 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"reflect"
+	"regexp"
+	"time"
 )
 
 // Configuration specific to Nextflow containers
@@ -37,12 +40,30 @@ type LicenseInfo struct {
 
 // Container Struct to hold the configuration for Pod Container
 type Container struct {
-	Name               string            `json:"name"`
-	CPULimit           string            `json:"cpu-limit"`
-	MemoryLimit        string            `json:"memory-limit"`
-	Image              string            `json:"image"`
-	PullPolicy         string            `json:"pull_policy"`
-	Env                map[string]string `json:"env"`
+	// stable ID passed to `/launch?id=`. Defaults to a hash of the
+	// configuration, which changes whenever the configuration does.
+	ID string `json:"id,omitempty"`
+	// older IDs of the container that still resolve to it, eg its hash
+	// before it had an explicit ID
+	Aliases     []string `json:"aliases,omitempty"`
+	Name        string   `json:"name"`
+	CPULimit    string   `json:"cpu-limit"`
+	MemoryLimit string   `json:"memory-limit"`
+	// the resources reserved for the workspace container, lower than the
+	// limits so that it can burst. Default to the limits divided by the
+	// global `overcommit-ratio`.
+	CPURequest    string `json:"cpu-request,omitempty"`
+	MemoryRequest string `json:"memory-request,omitempty"`
+	// extended resources of the workspace container, eg
+	// `{"nvidia.com/gpu": "1"}`, and its ephemeral storage
+	ExtendedResources     map[string]string `json:"extended-resources,omitempty"`
+	EphemeralStorageLimit string            `json:"ephemeral-storage-limit,omitempty"`
+	Image                 string            `json:"image"`
+	PullPolicy            string            `json:"pull_policy"`
+	Env                   map[string]string `json:"env"`
+	// environment variables read from Secrets and ConfigMaps, so that
+	// credentials are not stored in the configuration
+	EnvValueFrom       []EnvVarValueFrom `json:"env-value-from,omitempty"`
 	TargetPort         int32             `json:"target-port"`
 	Args               []string          `json:"args"`
 	Command            []string          `json:"command"`
@@ -58,17 +79,81 @@ type Container struct {
 	Gen3VolumeLocation string            `json:"gen3-volume-location"`
 	UseSharedMemory    string            `json:"use-shared-memory"`
 	Friends            []k8sv1.Container `json:"friends"`
-	NextflowConfig     NextflowConfig    `json:"nextflow"`
-	License            LicenseInfo       `json:"license"`
-	Authz              AuthzConfig       `json:"authz"`
+	// containers that run to completion before the workspace starts, eg
+	// to seed notebooks, and volumes added to the pod and mounted in the
+	// workspace container, in the Kubernetes format
+	InitContainers     []k8sv1.Container   `json:"init-containers,omitempty"`
+	ExtraVolumes       []k8sv1.Volume      `json:"extra-volumes,omitempty"`
+	ExtraVolumeMounts  []k8sv1.VolumeMount `json:"extra-volume-mounts,omitempty"`
+	NextflowConfig     NextflowConfig      `json:"nextflow"`
+	License            LicenseInfo         `json:"license"`
+	Authz              AuthzConfig         `json:"authz"`
+	MaxSessionDuration int                 `json:"max-session-duration,omitempty"`
+	MaxConcurrent      int                 `json:"max-concurrent,omitempty"`
+	// catalog metadata, returned by `/options`
+	Description      string   `json:"description,omitempty"`
+	Category         string   `json:"category,omitempty"`
+	Tags             []string `json:"tags,omitempty"`
+	IconURL          string   `json:"icon-url,omitempty"`
+	DocumentationURL string   `json:"documentation-url,omitempty"`
+	// estimated cost of running the workspace for an hour, in USD
+	HourlyCost float64 `json:"hourly-cost,omitempty"`
+	GPUCount   int     `json:"gpu-count,omitempty"`
+	// sizes users can pick at launch, instead of `cpu-limit` and
+	// `memory-limit`
+	ResourceProfiles       []ResourceProfile `json:"resource-profiles,omitempty"`
+	DefaultResourceProfile string            `json:"default-resource-profile,omitempty"`
+	// `node-selector`, `tolerations`, `affinity` and
+	// `priority-class-name`, replacing the global ones
+	SchedulingConfig
+}
+
+// ResourceProfile is a named size of a container, selected with
+// `/launch?profile=`
+type ResourceProfile struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	CPULimit    string `json:"cpu-limit"`
+	MemoryLimit string `json:"memory-limit"`
+	// replace the container's requests, like the limits
+	CPURequest    string `json:"cpu-request,omitempty"`
+	MemoryRequest string `json:"memory-request,omitempty"`
+	// overrides the container's Nextflow compute environment settings
+	Nextflow ResourceProfileNextflowConfig `json:"nextflow"`
+	// restricts which users can pick the profile, in addition to the
+	// container's `authz`
+	Authz AuthzConfig `json:"authz"`
+}
+
+// ResourceProfileNextflowConfig holds the Nextflow settings a resource
+// profile can override. Unset values are not overridden.
+type ResourceProfileNextflowConfig struct {
+	InstanceType     string `json:"instance-type,omitempty"`
+	InstanceMinVCpus int32  `json:"instance-min-vcpus,omitempty"`
+	InstanceMaxVCpus int32  `json:"instance-max-vcpus,omitempty"`
+}
+
+// EnvVarValueFrom is an environment variable whose value is stored outside
+// of the configuration
+type EnvVarValueFrom struct {
+	Name string `json:"name"`
+	// a `secretKeyRef` or a `configMapKeyRef` in the user namespace, in
+	// the Kubernetes format
+	ValueFrom *k8sv1.EnvVarSource `json:"value-from,omitempty"`
+	// the ARN of the Secrets Manager secret or SSM parameter holding the
+	// value of ECS workspaces
+	EcsValueFrom string `json:"ecs-value-from,omitempty"`
 }
 
 // SidecarContainer holds fuse sidecar configuration
 type SidecarContainer struct {
 	CPULimit         string            `json:"cpu-limit"`
 	MemoryLimit      string            `json:"memory-limit"`
+	CPURequest       string            `json:"cpu-request"`
+	MemoryRequest    string            `json:"memory-request"`
 	Image            string            `json:"image"`
 	Env              map[string]string `json:"env"`
+	EnvValueFrom     []EnvVarValueFrom `json:"env-value-from"`
 	Args             []string          `json:"args"`
 	Command          []string          `json:"command"`
 	LifecyclePreStop []string          `json:"lifecycle-pre-stop"`
@@ -79,6 +164,8 @@ type AppConfigInfo struct {
 	AppType string `json:"type"`
 	Path    string
 	Name    string
+	ID      string   `json:"id"`
+	Aliases []string `json:"aliases"`
 }
 
 // TODO remove PayModel from config once DynamoDB contains all necessary data
@@ -96,6 +183,8 @@ type PayModel struct {
 	SoftLimit       float32 `json:"soft-limit"`
 	TotalUsage      float32 `json:"total-usage"`
 	CurrentPayModel bool    `json:"current_pay_model"`
+	// in seconds, 0 means no limit
+	MaxSessionDuration int `json:"max-session-duration,omitempty"`
 }
 
 type AllPayModels struct {
@@ -109,20 +198,97 @@ type DbConfig struct {
 
 // HatcheryConfig is the root of all the configuration
 type HatcheryConfig struct {
-	UserNamespace          string           `json:"user-namespace"`
-	DefaultPayModel        PayModel         `json:"default-pay-model"`
-	DisableLocalWS         bool             `json:"disable-local-ws"`
-	PayModels              []PayModel       `json:"pay-models"`
-	PayModelsDynamodbTable string           `json:"pay-models-dynamodb-table"`
-	LicenseUserMapsTable   string           `json:"license-user-maps-dynamodb-table"`
-	LicenseUserMapsGSI     string           `json:"license-user-maps-global-secondary-index"`
-	License                LicenseInfo      `json:"license"`
-	SubDir                 string           `json:"sub-dir"`
-	Containers             []Container      `json:"containers"`
-	UserVolumeSize         string           `json:"user-volume-size"`
-	Sidecar                SidecarContainer `json:"sidecar"`
-	MoreConfigs            []AppConfigInfo  `json:"more-configs"`
-	PrismaConfig           PrismaConfig     `json:"prisma"`
+	UserNamespace          string                  `json:"user-namespace"`
+	DefaultPayModel        PayModel                `json:"default-pay-model"`
+	DisableLocalWS         bool                    `json:"disable-local-ws"`
+	PayModels              []PayModel              `json:"pay-models"`
+	PayModelsDynamodbTable string                  `json:"pay-models-dynamodb-table"`
+	LicenseUserMapsTable   string                  `json:"license-user-maps-dynamodb-table"`
+	LicenseUserMapsGSI     string                  `json:"license-user-maps-global-secondary-index"`
+	License                LicenseInfo             `json:"license"`
+	SubDir                 string                  `json:"sub-dir"`
+	Containers             []Container             `json:"containers"`
+	UserVolumeSize         string                  `json:"user-volume-size"`
+	Sidecar                SidecarContainer        `json:"sidecar"`
+	MoreConfigs            []AppConfigInfo         `json:"more-configs"`
+	PrismaConfig           PrismaConfig            `json:"prisma"`
+	MaxWorkspacesPerUser   int                     `json:"max-workspaces-per-user"`
+	IdleReaper             ReaperConfig            `json:"idle-reaper"`
+	SessionSweeper         ReaperConfig            `json:"session-sweeper"`
+	AdminResourcePath      string                  `json:"admin-resource-path"`
+	EventSink              EventSinkConfig         `json:"event-sink"`
+	AuditLog               AuditLogConfig          `json:"audit-log"`
+	ConfigReload           ReaperConfig            `json:"config-reload"`
+	Server                 ServerConfig            `json:"server"`
+	PendingOperations      PendingOperationsConfig `json:"pending-operations"`
+	Operations             OperationsConfig        `json:"operations"`
+	// the CPU and memory requests of the containers that do not set them
+	// are their limits divided by this ratio. Defaults to 1: requests
+	// equal to limits.
+	OvercommitRatio float64 `json:"overcommit-ratio"`
+	// default `node-selector`, `tolerations`, `affinity` and
+	// `priority-class-name` of the workspace pods
+	SchedulingConfig
+}
+
+// ReaperConfig configures a background job that runs at a fixed interval,
+// eg to terminate workspaces
+type ReaperConfig struct {
+	Enabled         bool `json:"enabled"`
+	IntervalSeconds int  `json:"interval-seconds"`
+}
+
+// ServerConfig configures how hatchery serves its API and shuts down
+type ServerConfig struct {
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	// the API is served over HTTPS when both files are set
+	TLSCertFile string `json:"tls-cert-file"`
+	TLSKeyFile  string `json:"tls-key-file"`
+	// how often the certificate files are checked for changes
+	TLSReloadIntervalSeconds int `json:"tls-reload-interval-seconds"`
+	// how long to wait for the running requests and background operations
+	// on shutdown
+	ShutdownTimeoutSeconds int `json:"shutdown-timeout-seconds"`
+}
+
+// EventSinkConfig configures where workspace lifecycle events are published
+type EventSinkConfig struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+	// name of the environment variable that holds the webhook's HMAC secret,
+	// so that the secret is not in the configuration file
+	SecretEnvVar   string   `json:"secret-env-var"`
+	MaxRetries     int      `json:"max-retries"`
+	TimeoutSeconds int      `json:"timeout-seconds"`
+	EventTypes     []string `json:"event-types"`
+}
+
+// AuditLogConfig configures where the audit records of workspace actions
+// are stored
+type AuditLogConfig struct {
+	Type          string `json:"type"`
+	DynamodbTable string `json:"dynamodb-table"`
+	FilePath      string `json:"file-path"`
+}
+
+// PendingOperationsConfig configures where the background operations that
+// must complete even if hatchery restarts are persisted, and how long they
+// are retried for
+type PendingOperationsConfig struct {
+	Type          string `json:"type"`
+	DynamodbTable string `json:"dynamodb-table"`
+	// how long an operation is retried for before giving up
+	DeadlineSeconds int `json:"deadline-seconds"`
+	// how often to look for operations to resume
+	ResumeIntervalSeconds int `json:"resume-interval-seconds"`
+}
+
+// OperationsConfig configures where the operations returned by
+// `/operations` are saved, so that any replica can serve them
+type OperationsConfig struct {
+	Type          string `json:"type"`
+	DynamodbTable string `json:"dynamodb-table"`
 }
 
 // Config to allow for Prisma Agents
@@ -136,12 +302,40 @@ type PrismaConfig struct {
 type FullHatcheryConfig struct {
 	Config        HatcheryConfig
 	ContainersMap map[string]Container
-	PayModelMap   map[string]PayModel
-	Logger        *log.Logger
+	// maps the aliases of the containers to their ID
+	ContainerAliases map[string]string
+	PayModelMap      map[string]PayModel
+	EventSink        EventSink
+	AuditStore       AuditStore
+	// nil when pending operations are not persisted
+	PendingOperationStore PendingOperationStore
+	// nil when operations are only kept in the memory of the replica that
+	// runs them
+	OperationStore OperationStore
+	Logger         *log.Logger
+	// identifies the content of the configuration file and of the
+	// `more-configs` files it was loaded from
+	Version  string
+	LoadedAt time.Time
 }
 
 // LoadConfig from a json file
 func LoadConfig(configFilePath string, loggerIn *log.Logger) (config *FullHatcheryConfig, err error) {
+	data, err := ParseConfig(configFilePath, loggerIn)
+	if err != nil {
+		return data, err
+	}
+	err = data.buildStores(nil)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ParseConfig reads and validates a json file and fills in the defaults,
+// without creating the event sink and the stores, for the commands that
+// only read the configuration
+func ParseConfig(configFilePath string, loggerIn *log.Logger) (config *FullHatcheryConfig, err error) {
 	logger := loggerIn
 	if nil == loggerIn {
 		logger = log.New(os.Stdout, "", log.LstdFlags)
@@ -159,74 +353,97 @@ func LoadConfig(configFilePath string, loggerIn *log.Logger) (config *FullHatche
 	}
 	data.Logger.Printf("loaded config: %v", string(plan))
 	data.ContainersMap = make(map[string]Container)
+	data.ContainerAliases = make(map[string]string)
 	data.PayModelMap = make(map[string]PayModel)
 	err = json.Unmarshal(plan, &data.Config)
 	if nil != err {
 		data.Logger.Printf("Unable to unmarshal configuration: %v", err)
 		return nil, err
 	}
-	if nil != data.Config.MoreConfigs && 0 < len(data.Config.MoreConfigs) {
-		for _, info := range data.Config.MoreConfigs {
-			if info.AppType == "dockstore-compose:1.0.0" {
-				if info.Name == "" {
-					return nil, fmt.Errorf("empty name for more-configs app at: %v", info.Path)
-				}
-				data.Logger.Printf("loading config from %v", info.Path)
-				composeModel, err := DockstoreComposeFromFile(info.Path)
-				if nil != err {
-					data.Logger.Printf("failed to load config from %v, got: %v", info.Path, err)
-					return nil, err
-				}
-				data.Logger.Printf("%v", composeModel)
-				hatchApp, err := composeModel.BuildHatchApp()
-				hatchApp.Name = info.Name
-				if nil != err {
-					data.Logger.Printf("failed to translate app, got: %v", err)
-					return nil, err
-				}
-				data.Config.Containers = append(data.Config.Containers, *hatchApp)
-			} else {
-				data.Logger.Printf("ignoring config of unsupported type: %v", info.AppType)
-			}
-		}
+	data.Version, err = configVersion(plan, data.Config.MoreConfigs)
+	if err != nil {
+		data.Logger.Printf("Unable to read configuration: %v", err)
+		return nil, err
 	}
-
-	for _, container := range data.Config.Containers {
-		err = ValidateAuthzConfig(data.Logger, container.Authz)
-		if nil != err {
-			data.Logger.Printf("Container '%s' has an invalid 'authz' configuration: %v", container.Name, err)
+	data.LoadedAt = time.Now().UTC()
+	for _, info := range data.Config.MoreConfigs {
+		hatchApp, err := loadMoreConfig(data.Logger, info)
+		if err != nil {
 			return nil, err
 		}
-		jsonBytes, _ := json.Marshal(container)
-		hash := fmt.Sprintf("%x", md5.Sum([]byte(jsonBytes)))
-		data.ContainersMap[hash] = container
+		if hatchApp != nil {
+			data.Config.Containers = append(data.Config.Containers, *hatchApp)
+		}
 	}
 
-	if data.Config.LicenseUserMapsTable == "" {
-		data.Logger.Printf("Warning: no 'license-user-maps-dynamodb-table' in configuration: will be unable to store license-user-map data in DynamoDB")
-	} else if data.Config.LicenseUserMapsGSI == "" {
-		err = fmt.Errorf("'license-user-maps-dynamodb-table' is present but missing 'license-user-maps-global-secondary-index'")
-		data.Logger.Printf("Error in config: %v", err)
+	err = validateSchedulingConfig(data.Config.SchedulingConfig)
+	if err != nil {
+		data.Logger.Printf("Error in configuration: %v", err)
+		return nil, err
+	}
+	if data.Config.OvercommitRatio == 0 {
+		data.Config.OvercommitRatio = 1
+	} else if data.Config.OvercommitRatio < 1 {
+		err = fmt.Errorf("'overcommit-ratio' must be at least 1, got %v", data.Config.OvercommitRatio)
+		data.Logger.Printf("Error in configuration: %v", err)
+		return nil, err
+	}
+	err = validateEnvValueFrom(data.Config.Sidecar.Env, data.Config.Sidecar.EnvValueFrom)
+	if err != nil {
+		err = fmt.Errorf("the sidecar has an invalid 'env-value-from': %v", err)
+		data.Logger.Printf("Error in configuration: %v", err)
+		return nil, err
+	}
+	err = validateResourceRequests(data.Config.Sidecar.CPULimit, data.Config.Sidecar.CPURequest, data.Config.Sidecar.MemoryLimit, data.Config.Sidecar.MemoryRequest)
+	if err != nil {
+		err = fmt.Errorf("the sidecar has invalid resources: %v", err)
+		data.Logger.Printf("Error in configuration: %v", err)
 		return nil, err
 	}
-
 	for _, container := range data.Config.Containers {
-		data.Logger.Printf("Checking license config info for container %s", container.Name)
-		if container.License.Enabled {
-			if data.Config.LicenseUserMapsTable == "" {
-				err = fmt.Errorf("no 'license-user-maps-dynamodb-table' in configuration but license is configured for container %s", container.Name)
+		if errs := validateContainer(data.Logger, data.Config, container); len(errs) > 0 {
+			data.Logger.Printf("Error in configuration: %v", errs[0])
+			return nil, errs[0]
+		}
+		hash := containerHash(container)
+		id := hash
+		aliases := container.Aliases
+		if container.ID != "" {
+			if _, exists := data.ContainersMap[container.ID]; exists {
+				err = fmt.Errorf("container '%s' has the same 'id' '%s' as another container", container.Name, container.ID)
 				data.Logger.Printf("Error in configuration: %v", err)
 				return nil, err
 			}
-			err := validateContainerLicenseInfo(container.Name, container.License)
-			if err != nil {
-				err = fmt.Errorf("container '%s' has an invalid 'license' configuration", container.Name)
+			id = container.ID
+			// links to the container from before it had an ID keep working
+			aliases = append([]string{hash}, aliases...)
+		}
+		data.ContainersMap[id] = container
+		for _, alias := range aliases {
+			if other, exists := data.ContainerAliases[alias]; exists && other != id {
+				err = fmt.Errorf("container '%s' has the alias '%s' of another container", container.Name, alias)
 				data.Logger.Printf("Error in configuration: %v", err)
 				return nil, err
 			}
+			data.ContainerAliases[alias] = id
+		}
+	}
+	for alias := range data.ContainerAliases {
+		if _, exists := data.ContainersMap[alias]; exists {
+			err = fmt.Errorf("the container alias '%s' is the ID of another container", alias)
+			data.Logger.Printf("Error in configuration: %v", err)
+			return nil, err
 		}
 	}
 
+	if data.Config.LicenseUserMapsTable == "" {
+		data.Logger.Printf("Warning: no 'license-user-maps-dynamodb-table' in configuration: will be unable to store license-user-map data in DynamoDB")
+	} else if data.Config.LicenseUserMapsGSI == "" {
+		err = fmt.Errorf("'license-user-maps-dynamodb-table' is present but missing 'license-user-maps-global-secondary-index'")
+		data.Logger.Printf("Error in config: %v", err)
+		return nil, err
+	}
+
 	if data.Config.PayModelsDynamodbTable == "" {
 		data.Logger.Printf("Warning: no 'pay-models-dynamodb-table' in configuration: will be unable to query pay model data in DynamoDB")
 	}
@@ -236,6 +453,43 @@ func LoadConfig(configFilePath string, loggerIn *log.Logger) (config *FullHatche
 		data.PayModelMap[user] = payModel
 	}
 
+	if data.Config.MaxWorkspacesPerUser == 0 {
+		data.Config.MaxWorkspacesPerUser = 1
+	} else if data.Config.MaxWorkspacesPerUser < 0 {
+		err = fmt.Errorf("'max-workspaces-per-user' must be positive, got %d", data.Config.MaxWorkspacesPerUser)
+		data.Logger.Printf("Error in configuration: %v", err)
+		return nil, err
+	}
+
+	if data.Config.IdleReaper.IntervalSeconds <= 0 {
+		data.Config.IdleReaper.IntervalSeconds = 300
+	}
+	if data.Config.SessionSweeper.IntervalSeconds <= 0 {
+		data.Config.SessionSweeper.IntervalSeconds = 60
+	}
+	if data.Config.ConfigReload.IntervalSeconds <= 0 {
+		data.Config.ConfigReload.IntervalSeconds = 30
+	}
+
+	err = setServerDefaults(&data.Config.Server)
+	if err != nil {
+		data.Logger.Printf("Error in configuration: %v", err)
+		return nil, err
+	}
+
+	if data.Config.EventSink.MaxRetries == 0 {
+		data.Config.EventSink.MaxRetries = 3
+	}
+	if data.Config.EventSink.TimeoutSeconds <= 0 {
+		data.Config.EventSink.TimeoutSeconds = 10
+	}
+	if data.Config.PendingOperations.DeadlineSeconds <= 0 {
+		data.Config.PendingOperations.DeadlineSeconds = 3600
+	}
+	if data.Config.PendingOperations.ResumeIntervalSeconds <= 0 {
+		data.Config.PendingOperations.ResumeIntervalSeconds = 60
+	}
+
 	// Set default prisma console version
 	if data.Config.PrismaConfig.ConsoleVersion == "" {
 		data.Config.PrismaConfig.ConsoleVersion = "v32.02"
@@ -243,3 +497,175 @@ func LoadConfig(configFilePath string, loggerIn *log.Logger) (config *FullHatche
 
 	return data, nil
 }
+
+// buildStores creates the event sink and the stores of the configuration.
+// Those of `previous` are kept when their settings did not change, so that
+// reloading the configuration does not drop them.
+func (data *FullHatcheryConfig) buildStores(previous *FullHatcheryConfig) (err error) {
+	if previous != nil && reflect.DeepEqual(previous.Config.EventSink, data.Config.EventSink) {
+		data.EventSink = previous.EventSink
+	} else if data.EventSink, err = newEventSink(data.Config.EventSink); err != nil {
+		data.Logger.Printf("Error in configuration: %v", err)
+		return err
+	}
+
+	if previous != nil && reflect.DeepEqual(previous.Config.AuditLog, data.Config.AuditLog) {
+		data.AuditStore = previous.AuditStore
+	} else if data.AuditStore, err = newAuditStore(data.Config.AuditLog); err != nil {
+		data.Logger.Printf("Error in configuration: %v", err)
+		return err
+	}
+
+	if previous != nil && reflect.DeepEqual(previous.Config.PendingOperations, data.Config.PendingOperations) {
+		data.PendingOperationStore = previous.PendingOperationStore
+	} else if data.PendingOperationStore, err = newPendingOperationStore(data.Config.PendingOperations); err != nil {
+		data.Logger.Printf("Error in configuration: %v", err)
+		return err
+	}
+
+	if previous != nil && reflect.DeepEqual(previous.Config.Operations, data.Config.Operations) {
+		data.OperationStore = previous.OperationStore
+	} else if data.OperationStore, err = newOperationStore(data.Config.Operations); err != nil {
+		data.Logger.Printf("Error in configuration: %v", err)
+		return err
+	}
+	return nil
+}
+
+// setServerDefaults checks the `server` settings and fills in the defaults
+func setServerDefaults(server *ServerConfig) error {
+	if server.Address == "" {
+		server.Address = "0.0.0.0"
+	}
+	if server.Port == 0 {
+		server.Port = 8000
+	} else if server.Port < 0 || server.Port > 65535 {
+		return fmt.Errorf("'server.port' must be between 1 and 65535, got %d", server.Port)
+	}
+	if (server.TLSCertFile == "") != (server.TLSKeyFile == "") {
+		return fmt.Errorf("'server.tls-cert-file' and 'server.tls-key-file' must be set together")
+	}
+	if server.TLSReloadIntervalSeconds <= 0 {
+		server.TLSReloadIntervalSeconds = 60
+	}
+	if server.ShutdownTimeoutSeconds == 0 {
+		server.ShutdownTimeoutSeconds = 25
+	} else if server.ShutdownTimeoutSeconds < 0 {
+		return fmt.Errorf("'server.shutdown-timeout-seconds' must be positive, got %d", server.ShutdownTimeoutSeconds)
+	}
+	return nil
+}
+
+// loadMoreConfig returns the container of a `more-configs` app, or nil if
+// the type of app is not supported
+func loadMoreConfig(logger *log.Logger, info AppConfigInfo) (*Container, error) {
+	if info.AppType != "dockstore-compose:1.0.0" {
+		logger.Printf("ignoring config of unsupported type: %v", info.AppType)
+		return nil, nil
+	}
+	if info.Name == "" {
+		return nil, fmt.Errorf("empty name for more-configs app at: %v", info.Path)
+	}
+	logger.Printf("loading config from %v", info.Path)
+	composeModel, err := DockstoreComposeFromFile(info.Path)
+	if nil != err {
+		logger.Printf("failed to load config from %v, got: %v", info.Path, err)
+		return nil, fmt.Errorf("unable to load more-configs app '%s' from %v: %v", info.Name, info.Path, err)
+	}
+	logger.Printf("%v", composeModel)
+	hatchApp, err := composeModel.BuildHatchApp()
+	if nil != err {
+		logger.Printf("failed to translate app, got: %v", err)
+		return nil, fmt.Errorf("unable to translate more-configs app '%s' from %v: %v", info.Name, info.Path, err)
+	}
+	hatchApp.Name = info.Name
+	hatchApp.ID = info.ID
+	hatchApp.Aliases = info.Aliases
+	return hatchApp, nil
+}
+
+// validateContainer returns all the errors in the configuration of the
+// container. The checks that involve other containers, such as duplicate
+// IDs, are done by `LoadConfig`.
+func validateContainer(logger *log.Logger, config HatcheryConfig, container Container) []error {
+	var errs []error
+	if err := ValidateAuthzConfig(logger, container.Authz); err != nil {
+		errs = append(errs, fmt.Errorf("container '%s' has an invalid 'authz' configuration: %v", container.Name, err))
+	}
+	if container.MaxSessionDuration < 0 {
+		errs = append(errs, fmt.Errorf("container '%s' has a negative 'max-session-duration'", container.Name))
+	}
+	if container.MaxConcurrent < 0 {
+		errs = append(errs, fmt.Errorf("container '%s' has a negative 'max-concurrent'", container.Name))
+	}
+	if container.HourlyCost < 0 || container.GPUCount < 0 {
+		errs = append(errs, fmt.Errorf("container '%s' has a negative 'hourly-cost' or 'gpu-count'", container.Name))
+	}
+	if err := validateResourceProfiles(logger, container); err != nil {
+		errs = append(errs, err)
+	}
+	err := validateResourceRequests(container.CPULimit, container.CPURequest, container.MemoryLimit, container.MemoryRequest)
+	if err == nil {
+		err = validateContainerResources(container)
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("container '%s' has invalid resources: %v", container.Name, err))
+	}
+	if err := validateSchedulingConfig(container.SchedulingConfig); err != nil {
+		errs = append(errs, fmt.Errorf("container '%s' has an invalid scheduling configuration: %v", container.Name, err))
+	}
+	if err := validateEnvValueFrom(container.Env, container.EnvValueFrom); err != nil {
+		errs = append(errs, fmt.Errorf("container '%s' has an invalid 'env-value-from': %v", container.Name, err))
+	}
+	if err := validatePodExtensions(container); err != nil {
+		errs = append(errs, fmt.Errorf("container '%s' has invalid init containers or volumes: %v", container.Name, err))
+	}
+	if container.ID != "" && !containerIDRegex.MatchString(container.ID) {
+		errs = append(errs, fmt.Errorf("container '%s' has an invalid 'id' '%s': expected up to 63 lowercase letters, digits or '-', starting and ending with a letter or digit", container.Name, container.ID))
+	}
+	if container.License.Enabled {
+		if config.LicenseUserMapsTable == "" {
+			errs = append(errs, fmt.Errorf("no 'license-user-maps-dynamodb-table' in configuration but license is configured for container %s", container.Name))
+		} else if err := validateContainerLicenseInfo(container.Name, container.License); err != nil {
+			errs = append(errs, fmt.Errorf("container '%s' has an invalid 'license' configuration", container.Name))
+		}
+	}
+	return errs
+}
+
+var containerIDRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// containerHash returns the historical ID of a container: a hash of its
+// configuration, without its explicit ID and aliases so that adding them
+// does not change it. The running workspaces are annotated with it, so the
+// fields added to `Container` must be `omitempty`: an unset field then
+// leaves the hash of the existing containers unchanged.
+func containerHash(container Container) string {
+	container.ID = ""
+	container.Aliases = nil
+	jsonBytes, _ := json.Marshal(container)
+	return fmt.Sprintf("%x", md5.Sum([]byte(jsonBytes)))
+}
+
+// resolveContainerID returns the ID of the container that `id` is the ID or
+// an alias of, or `id` itself if it is unknown
+func resolveContainerID(id string) string {
+	if _, ok := getConfig().ContainersMap[id]; ok {
+		return id
+	}
+	if resolved, ok := getConfig().ContainerAliases[id]; ok {
+		return resolved
+	}
+	return id
+}
+
+// resolveRequestedContainerID is like resolveContainerID, for the IDs that
+// users request: requests with an alias are logged, so that the links and
+// automation that still use it can be found before it is removed
+func resolveRequestedContainerID(id string) string {
+	resolved := resolveContainerID(id)
+	if resolved != id {
+		getConfig().Logger.Printf("Container ID '%s' is deprecated, use '%s' instead", id, resolved)
+	}
+	return resolved
+}
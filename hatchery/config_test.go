@@ -2,6 +2,10 @@ package hatchery
 
 import (
 	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
 	"testing"
 )
 
@@ -34,3 +38,108 @@ func TestLoadConfig(t *testing.T) {
 	}
 	config.Logger.Printf("config_test marshalled config: %v", string(jsBytes))
 }
+
+func TestLoadConfigContainerIDs(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	defer func() {
+		SetConfig(original_config)
+	}()
+
+	logger := log.New(io.Discard, "", log.LstdFlags)
+	configPath := filepath.Join(t.TempDir(), "hatchery.json")
+	loadConfig := func(content string) (*FullHatcheryConfig, error) {
+		if err := ioutil.WriteFile(configPath, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return LoadConfig(configPath, logger)
+	}
+
+	rstudio := Container{Name: "RStudio", Image: "quay.io/cdis/rstudio:latest"}
+	rstudioHash := containerHash(rstudio)
+
+	config, err := loadConfig(`{"containers": [
+		{"name": "RStudio", "image": "quay.io/cdis/rstudio:latest", "id": "rstudio", "aliases": ["old-rstudio-id"]},
+		{"name": "Jupyter", "image": "quay.io/cdis/jupyter:latest"}
+	], "more-configs": [
+		{"type": "dockstore-compose:1.0.0", "path": "../testData/dockstore/firefox-app.yml", "name": "Firefox", "id": "firefox"}
+	]}`)
+	if err != nil {
+		t.Fatalf("unable to load the configuration: %v", err)
+	}
+	SetConfig(config)
+	jupyterHash := containerHash(Container{Name: "Jupyter", Image: "quay.io/cdis/jupyter:latest"})
+	for _, id := range []string{"rstudio", "firefox", jupyterHash} {
+		if _, ok := getConfig().ContainersMap[id]; !ok {
+			t.Errorf("expected a container with ID '%s', got %v", id, getConfig().ContainersMap)
+		}
+	}
+	if len(getConfig().ContainersMap) != 3 {
+		t.Errorf("expected 3 containers, got %d", len(getConfig().ContainersMap))
+	}
+	for alias, want := range map[string]string{
+		// the hash of the container before it had an explicit ID
+		rstudioHash:      "rstudio",
+		"old-rstudio-id": "rstudio",
+		"rstudio":        "rstudio",
+		jupyterHash:      jupyterHash,
+		"unknown":        "unknown",
+	} {
+		if got := resolveContainerID(alias); got != want {
+			t.Errorf("'%s' resolved to '%s', want '%s'", alias, got, want)
+		}
+	}
+
+	for name, content := range map[string]string{
+		"InvalidID":        `{"containers": [{"name": "RStudio", "id": "R Studio"}]}`,
+		"DuplicateID":      `{"containers": [{"name": "RStudio", "id": "rstudio"}, {"name": "Jupyter", "id": "rstudio"}]}`,
+		"DuplicateAlias":   `{"containers": [{"name": "RStudio", "id": "rstudio", "aliases": ["old"]}, {"name": "Jupyter", "id": "jupyter", "aliases": ["old"]}]}`,
+		"AliasIsAnotherID": `{"containers": [{"name": "RStudio", "id": "rstudio", "aliases": ["jupyter"]}, {"name": "Jupyter", "id": "jupyter"}]}`,
+	} {
+		if _, err := loadConfig(content); err == nil {
+			t.Errorf("expected an error when %s", name)
+		}
+	}
+}
+
+// The hash of a container is its ID when it has none, and is stored in the
+// annotations of the running workspaces: adding a field to `Container` must
+// not change the hash of the containers that do not set it.
+func TestContainerHashUnchanged(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	config, err := LoadConfig("../testData/testConfig.json", log.New(io.Discard, "", log.LstdFlags))
+	if err != nil {
+		t.Fatalf("failed to load config, got: %v", err)
+	}
+
+	// the hashes before any field was added. The Dockstore apps are left
+	// out: they had no stable hash until their services were sorted.
+	expectedHashes := map[string]string{
+		"(Generic, Limited Gen3-licensed) Stata Notebook": "ab70afb01e56488b43142e45b36ad0ff",
+		"R Studio":                    "0f53d07bf643c18db1313c4121c2415a",
+		"Jupyter - Python/R":          "ca46a9f7c170cff403f0c07794690c24",
+		"Jupyter - Ariba and Mykrobe": "2749dd9442312eaf9f92541fa2c875e7",
+		"Test MultiContainer App":     "b764302c2489a02924683990ef15ad0b",
+		"TEST R Studio thru VNC":      "a1af1d8d474b31b20b67f2d8c1503c68",
+	}
+	for _, container := range config.Config.Containers {
+		expected, ok := expectedHashes[container.Name]
+		if !ok {
+			continue
+		}
+		if hash := containerHash(container); hash != expected {
+			t.Errorf("the hash of container '%s' changed: got '%s', want '%s'", container.Name, hash, expected)
+		}
+		delete(expectedHashes, container.Name)
+	}
+	for name := range expectedHashes {
+		t.Errorf("container '%s' is missing from the test configuration", name)
+	}
+
+	jupyter := Container{Name: "Jupyter", Image: "quay.io/cdis/jupyter:latest"}
+	if hash := containerHash(jupyter); hash != "db8688220f59b3c65d144eb8431f30a1" {
+		t.Errorf("the hash of a minimal container changed: got '%s'", hash)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -18,6 +19,21 @@ import (
 type ComposeResourceSpec struct {
 	Memory string
 	CPU    string `yaml:"cpus,omitempty"`
+	// not part of the compose spec, like `extended_resources`
+	EphemeralStorage string `yaml:"ephemeral_storage,omitempty"`
+	// eg `nvidia.com/gpu: 1`
+	ExtendedResources map[string]string `yaml:"extended_resources,omitempty"`
+	// the compose way of reserving GPUs
+	Devices []ComposeDeviceRequest `yaml:"devices,omitempty"`
+}
+
+// ComposeDeviceRequest is a device reservation, eg
+// `{capabilities: [gpu], driver: nvidia, count: 1}`
+type ComposeDeviceRequest struct {
+	Capabilities []string
+	Driver       string
+	// a number, or "all" which k8s does not support
+	Count string
 }
 
 // ComposeResources holds the resource requests and limits
@@ -59,12 +75,25 @@ type ComposeService struct {
 	Healthcheck     ComposeHealthCheck
 }
 
+// ComposeCatalogInfo holds the catalog metadata of the app,
+// in the `x-hatchery` extension block of docker-compose
+type ComposeCatalogInfo struct {
+	Description      string
+	Category         string
+	Tags             []string
+	IconURL          string  `yaml:"icon_url"`
+	DocumentationURL string  `yaml:"documentation_url"`
+	HourlyCost       float64 `yaml:"hourly_cost"`
+	GPUCount         int     `yaml:"gpu_count"`
+}
+
 // ComposeFull holds all the data harvested from
 // a docker-compose.yaml file
 type ComposeFull struct {
 	// name of the root service mapped to the magic port
 	RootService string `yaml:"-"`
 	Services    map[string]ComposeService
+	Catalog     ComposeCatalogInfo `yaml:"x-hatchery"`
 }
 
 var dslog = log.New(os.Stdout, "hatchery/dockstore", log.LstdFlags)
@@ -103,10 +132,23 @@ func DockstoreComposeFromBytes(yamlBytes []byte) (model *ComposeFull, err error)
 	return model, model.Sanitize()
 }
 
+// serviceNames returns the names of the services in a stable order, so that
+// the root service and the friends built from them, and so the container's
+// hash, do not depend on the iteration order of the map
+func (model *ComposeFull) serviceNames() []string {
+	names := make([]string, 0, len(model.Services))
+	for name := range model.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Sanitize scans, validates, and decorates a given ComposeFull model
 func (model *ComposeFull) Sanitize() error {
 	cleanServices := make(map[string]ComposeService, len(model.Services))
-	for key, service := range model.Services {
+	for _, key := range model.serviceNames() {
+		service := model.Services[key]
 		// k8s wants DNS-safe container names - let's just do that here
 		service.Name = strings.ToLower(key)
 		for _, badChar := range [...]string{"_", "/", " "} {
@@ -133,6 +175,11 @@ func (model *ComposeFull) Sanitize() error {
 				rspec.CPU = fmt.Sprintf("%v", float32(i+1)*0.8)
 			}
 		}
+		for _, rspec := range []ComposeResourceSpec{service.Deploy.Resources.Requests, service.Deploy.Resources.Limits} {
+			if err := rspec.validate(); err != nil {
+				return fmt.Errorf("invalid resources for service %v: %v", key, err)
+			}
+		}
 		for _, envEntry := range service.Environment {
 			kvSlice := strings.SplitN(envEntry, "=", 2)
 			if len(kvSlice) != 2 {
@@ -167,6 +214,55 @@ func (model *ComposeFull) Sanitize() error {
 	return nil
 }
 
+// validate checks that BuildK8sResource can parse the spec
+func (rspec *ComposeResourceSpec) validate() error {
+	for name, value := range map[string]string{"cpus": rspec.CPU, "memory": rspec.Memory, "ephemeral_storage": rspec.EphemeralStorage} {
+		if value == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(value); err != nil {
+			return fmt.Errorf("invalid '%s' '%s': %v", name, value, err)
+		}
+	}
+	extendedResources, err := rspec.getExtendedResources()
+	if err != nil {
+		return err
+	}
+	return validateExtendedResources(extendedResources)
+}
+
+// getExtendedResources returns the extended resources of the spec,
+// including the GPUs reserved as devices
+func (rspec *ComposeResourceSpec) getExtendedResources() (map[string]string, error) {
+	result := make(map[string]string)
+	for name, value := range rspec.ExtendedResources {
+		result[name] = value
+	}
+	for _, device := range rspec.Devices {
+		if !stringArrayContains(device.Capabilities, "gpu") {
+			return nil, fmt.Errorf("unsupported device capabilities %v: only 'gpu' devices are supported", device.Capabilities)
+		}
+		count, err := strconv.Atoi(device.Count)
+		if device.Count == "" {
+			count, err = 1, nil
+		}
+		if err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid device count '%s': expected a positive number", device.Count)
+		}
+		driver := device.Driver
+		if driver == "" {
+			driver = "nvidia"
+		}
+		name := driver + ".com/gpu"
+		if existing, ok := result[name]; ok {
+			existingCount, _ := strconv.Atoi(existing)
+			count += existingCount
+		}
+		result[name] = strconv.Itoa(count)
+	}
+	return result, nil
+}
+
 // BuildK8sResource from a compose resource spec
 func (rspec *ComposeResourceSpec) BuildK8sResource() map[k8sv1.ResourceName]resource.Quantity {
 	result := make(map[k8sv1.ResourceName]resource.Quantity)
@@ -176,9 +272,39 @@ func (rspec *ComposeResourceSpec) BuildK8sResource() map[k8sv1.ResourceName]reso
 	if rspec.Memory != "" {
 		result[k8sv1.ResourceMemory] = resource.MustParse(rspec.Memory)
 	}
+	if rspec.EphemeralStorage != "" {
+		result[k8sv1.ResourceEphemeralStorage] = resource.MustParse(rspec.EphemeralStorage)
+	}
+	// validated by Sanitize
+	extendedResources, _ := rspec.getExtendedResources()
+	for name, value := range extendedResources {
+		result[k8sv1.ResourceName(name)] = resource.MustParse(value)
+	}
 	return result
 }
 
+// buildK8sResourceRequirements returns the requests and limits of the
+// service. k8s does not overcommit extended resources: their requests must
+// equal their limits, so compose reservations of extended resources (eg
+// GPU devices) are also used as limits.
+func (resources *ComposeResources) buildK8sResourceRequirements() k8sv1.ResourceRequirements {
+	requirements := k8sv1.ResourceRequirements{
+		Limits:   resources.Limits.BuildK8sResource(),
+		Requests: resources.Requests.BuildK8sResource(),
+	}
+	for name, quantity := range requirements.Requests {
+		if _, ok := requirements.Limits[name]; isExtendedResource(name) && !ok {
+			requirements.Limits[name] = quantity
+		}
+	}
+	for name, quantity := range requirements.Limits {
+		if isExtendedResource(name) {
+			requirements.Requests[name] = quantity
+		}
+	}
+	return requirements
+}
+
 // ToK8sContainer copies data from the given service to the container friend
 // Returns true if this container mounts the user volume.  We try to avoid
 // mounting that thing if possible while it's still EBS based.
@@ -277,8 +403,7 @@ func (service *ComposeService) ToK8sContainer(friend *k8sv1.Container) (mountUse
 		copy(friend.Args, service.Command)
 	}
 
-	friend.Resources.Limits = service.Deploy.Resources.Limits.BuildK8sResource()
-	friend.Resources.Requests = service.Deploy.Resources.Requests.BuildK8sResource()
+	friend.Resources = service.Deploy.Resources.buildK8sResourceRequirements()
 
 	if 1 < len(service.Healthcheck.Test) && service.Healthcheck.Test[0] == "CMD" {
 		friend.ReadinessProbe = &k8sv1.Probe{
@@ -306,10 +431,29 @@ func (model *ComposeFull) BuildHatchApp() (*Container, error) {
 	hatchApp.Name = service.Name
 	hatchApp.CPULimit = service.Deploy.Resources.Limits.CPU
 	hatchApp.MemoryLimit = service.Deploy.Resources.Limits.Memory
+	// informative, for `/options`: the root service is one of the friends
+	resources := service.Deploy.Resources.buildK8sResourceRequirements()
+	for name, quantity := range resources.Limits {
+		if name == k8sv1.ResourceEphemeralStorage {
+			hatchApp.EphemeralStorageLimit = quantity.String()
+		} else if isExtendedResource(name) {
+			if hatchApp.ExtendedResources == nil {
+				hatchApp.ExtendedResources = make(map[string]string)
+			}
+			hatchApp.ExtendedResources[string(name)] = quantity.String()
+		}
+	}
 	hatchApp.UserUID = service.UserUID
 	hatchApp.GroupUID = service.GroupUID
 	hatchApp.FSGID = service.FSGID
 	hatchApp.Image = ""
+	hatchApp.Description = model.Catalog.Description
+	hatchApp.Category = model.Catalog.Category
+	hatchApp.Tags = model.Catalog.Tags
+	hatchApp.IconURL = model.Catalog.IconURL
+	hatchApp.DocumentationURL = model.Catalog.DocumentationURL
+	hatchApp.HourlyCost = model.Catalog.HourlyCost
+	hatchApp.GPUCount = model.Catalog.GPUCount
 
 	for _, portEntry := range service.Ports {
 		portSlice := strings.SplitN(portEntry, ":", 2)
@@ -342,7 +486,8 @@ func (model *ComposeFull) BuildHatchApp() (*Container, error) {
 	friendIndex := 0
 	mountUserVolume := false // does this app mount the user volume?
 	mountSharedMemory := false
-	for _, service := range model.Services {
+	for _, name := range model.serviceNames() {
+		service := model.Services[name]
 		usesUserVolume, useSharedMemory, err := service.ToK8sContainer(&hatchApp.Friends[friendIndex])
 		if nil != err {
 			return nil, err
@@ -1,10 +1,13 @@
 package hatchery
 
 import (
+	"io"
+	"log"
 	"strings"
 	"testing"
 
 	"gopkg.in/yaml.v2"
+	k8sv1 "k8s.io/api/core/v1"
 )
 
 func TestDockstoreComposeLoad(t *testing.T) {
@@ -83,10 +86,39 @@ func TestDockstoreComposeTranslate(t *testing.T) {
 		t.Error("dockstore hatchApp should set UserVolumeLocation property")
 		return
 	}
+	if hatchApp.Category != "Imaging" || len(hatchApp.Tags) != 2 || hatchApp.HourlyCost != 0.25 || hatchApp.DocumentationURL != "https://docs.ohif.org" {
+		t.Errorf("dockstore hatchApp should have the catalog metadata of the x-hatchery block, got %+v", hatchApp)
+	}
 	hatchAppBytes, _ := yaml.Marshal(hatchApp)
 	dslog.Printf("translated hatchery app: %v", string(hatchAppBytes))
 }
 
+func TestDockstoreComposeHash(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	logger := log.New(io.Discard, "", log.LstdFlags)
+	info := AppConfigInfo{AppType: "dockstore-compose:1.0.0", Path: "../testData/dockstore/docker-compose.yml", Name: "Dockstore app"}
+	first, err := loadMoreConfig(logger, info)
+	if err != nil {
+		t.Fatalf("failed to load config from %v, got: %v", info.Path, err)
+	}
+	// the services are iterated in a different order each time
+	for i := 0; i < 10; i++ {
+		again, err := loadMoreConfig(logger, info)
+		if err != nil {
+			t.Fatalf("failed to load config from %v, got: %v", info.Path, err)
+		}
+		if containerHash(*again) != containerHash(*first) {
+			t.Fatalf("expected the same compose file to have the same hash, got %s and %s", containerHash(*first), containerHash(*again))
+		}
+	}
+	for i := 1; i < len(first.Friends); i++ {
+		if first.Friends[i-1].Name > first.Friends[i].Name {
+			t.Errorf("expected the friends to be sorted by service name, got %s before %s", first.Friends[i-1].Name, first.Friends[i].Name)
+		}
+	}
+}
+
 func TestFirefoxAppTranslate(t *testing.T) {
 	defer SetupAndTeardownTest()()
 
@@ -112,3 +144,76 @@ func TestFirefoxAppTranslate(t *testing.T) {
 	hatchAppBytes, _ := yaml.Marshal(hatchApp)
 	dslog.Printf("translated hatchery app: %v", string(hatchAppBytes))
 }
+
+func TestDockstoreComposeGPU(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	composeModel, err := DockstoreComposeFromStr(`
+services:
+  notebook:
+    image: quay.io/cdis/jupyter-gpu:latest
+    ports:
+      - "${SERVICE_PORT}:8888"
+    deploy:
+      resources:
+        limits:
+          cpus: '4'
+          memory: 16G
+          ephemeral_storage: 50Gi
+        reservations:
+          devices:
+            - capabilities: [gpu]
+              count: 2
+          extended_resources:
+            example.com/fpga: 1
+`)
+	if err != nil {
+		t.Fatalf("failed to load the compose app, got: %v", err)
+	}
+	hatchApp, err := composeModel.BuildHatchApp()
+	if err != nil {
+		t.Fatalf("failed to translate app, got: %v", err)
+	}
+	if hatchApp.EphemeralStorageLimit != "50Gi" || hatchApp.ExtendedResources["nvidia.com/gpu"] != "2" || hatchApp.ExtendedResources["example.com/fpga"] != "1" {
+		t.Errorf("unexpected resources: '%s', %v", hatchApp.EphemeralStorageLimit, hatchApp.ExtendedResources)
+	}
+	if getGPUCount(*hatchApp) != 2 {
+		t.Errorf("expected 2 GPUs, got %d", getGPUCount(*hatchApp))
+	}
+
+	// the reserved devices are also limits, as k8s requires
+	resources := hatchApp.Friends[0].Resources
+	for _, resources := range []k8sv1.ResourceList{resources.Limits, resources.Requests} {
+		gpus := resources[k8sv1.ResourceName("nvidia.com/gpu")]
+		if gpus.Value() != 2 {
+			t.Errorf("expected 2 GPUs in %v", resources)
+		}
+	}
+	storage := resources.Limits[k8sv1.ResourceEphemeralStorage]
+	if storage.String() != "50Gi" {
+		t.Errorf("unexpected ephemeral storage limit %v", resources.Limits)
+	}
+
+	for name, compose := range map[string]string{
+		"AllDevices":          "devices: [{capabilities: [gpu], count: all}]",
+		"NotGPU":              "devices: [{capabilities: [tpu], count: 1}]",
+		"FractionalGPU":       "extended_resources: {nvidia.com/gpu: 0.5}",
+		"UnprefixedResource":  "extended_resources: {gpu: 1}",
+		"InvalidStorageLimit": "ephemeral_storage: lots",
+	} {
+		_, err := DockstoreComposeFromStr(`
+services:
+  notebook:
+    image: quay.io/cdis/jupyter-gpu:latest
+    ports:
+      - "${SERVICE_PORT}:8888"
+    deploy:
+      resources:
+        reservations:
+          ` + compose + `
+`)
+		if err == nil {
+			t.Errorf("expected an error when %s", name)
+		}
+	}
+}
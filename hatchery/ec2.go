@@ -109,7 +109,7 @@ func (creds *CREDS) describeWorkspaceNetwork(userName string) (*NetworkInfo, err
 		if err != nil {
 			return nil, err
 		}
-		Config.Logger.Printf("Create Security Group: %s", *newSecurityGroup.GroupId)
+		getConfig().Logger.Printf("Create Security Group: %s", *newSecurityGroup.GroupId)
 
 		ingressRules := ec2.AuthorizeSecurityGroupIngressInput{
 			GroupId: newSecurityGroup.GroupId,
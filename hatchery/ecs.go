@@ -31,6 +31,13 @@ type CreateTaskDefinitionInput struct {
 	EntryPoint       []string
 	Args             []string
 	SidecarContainer ecs.ContainerDefinition
+	// GPUs of the workspace container
+	ResourceRequirements []*ecs.ResourceRequirement
+	// nil for the default ephemeral storage
+	EphemeralStorage *ecs.EphemeralStorage
+	// environment variables of the workspace container read from Secrets
+	// Manager or SSM by the task execution role
+	Secrets []*ecs.Secret
 }
 
 type EnvVar struct {
@@ -128,13 +135,13 @@ func (sess *CREDS) findEcsCluster() (*ecs.Cluster, error) {
 		} else {
 			// Print the error, cast err to awserr.Error to get the Code and
 			// Message from an error.
-			Config.Logger.Println(err.Error())
+			getConfig().Logger.Println(err.Error())
 		}
 	}
 	if len(describeClusterResult.Failures) > 0 {
 		for _, failure := range describeClusterResult.Failures {
 			if *failure.Reason == "MISSING" {
-				Config.Logger.Printf("ECS cluster named %s not found, trying to create this ECS cluster", clusterName)
+				getConfig().Logger.Printf("ECS cluster named %s not found, trying to create this ECS cluster", clusterName)
 				input := &ecs.CreateClusterInput{
 					ClusterName: aws.String(clusterName),
 					Tags: []*ecs.Tag{
@@ -166,7 +173,7 @@ func (sess *CREDS) findEcsCluster() (*ecs.Cluster, error) {
 				return describeClusterResult.Clusters[0], nil
 			}
 		}
-		Config.Logger.Printf("ECS cluster named %s cannot be described", clusterName)
+		getConfig().Logger.Printf("ECS cluster named %s cannot be described", clusterName)
 		return nil, fmt.Errorf("ECS cluster named %s cannot be described", clusterName)
 	} else {
 		return describeClusterResult.Clusters[0], nil
@@ -209,7 +216,7 @@ func (sess *CREDS) statusEcsWorkspace(ctx context.Context, userName string, acce
 		if statusMessage == "ACTIVE" && (*service.Services[0].RunningCount == *service.Services[0].DesiredCount) {
 			taskDefName = *service.Services[0].TaskDefinition
 			if taskDefName == "" {
-				Config.Logger.Printf("No task definition found for user %s", userName)
+				getConfig().Logger.Printf("No task definition found for user %s", userName)
 			} else {
 				desTaskDefOutput, err := sess.svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
 					TaskDefinition: &taskDefName,
@@ -225,25 +232,25 @@ func (sess *CREDS) statusEcsWorkspace(ctx context.Context, userName string, acce
 									idleTimeLimit, err := strconv.Atoi(argSplit[len(argSplit)-1])
 									if err == nil {
 										status.IdleTimeLimit = idleTimeLimit * 1000
-										lastActivityTime, err := getKernelIdleTimeWithContext(ctx, accessToken)
+										lastActivityTime, err := getKernelIdleTimeWithContext(ctx, "", accessToken)
 										status.LastActivityTime = lastActivityTime
 										if err != nil {
-											Config.Logger.Println(err.Error())
+											getConfig().Logger.Println(err.Error())
 										}
 									} else {
-										Config.Logger.Println(err.Error())
+										getConfig().Logger.Println(err.Error())
 									}
 									break
 								}
 								if i == len(args)-1 {
-									Config.Logger.Printf("Unable to find kernel idle shutdown time in args\n")
+									getConfig().Logger.Printf("Unable to find kernel idle shutdown time in args\n")
 								}
 							}
 						} else {
-							Config.Logger.Printf("No env vars found for task definition %s\n", taskDefName)
+							getConfig().Logger.Printf("No env vars found for task definition %s\n", taskDefName)
 						}
 					} else {
-						Config.Logger.Printf("No container definition found for task definition %s\n", taskDefName)
+						getConfig().Logger.Printf("No container definition found for task definition %s\n", taskDefName)
 					}
 				}
 			}
@@ -262,7 +269,7 @@ func (sess *CREDS) statusEcsWorkspace(ctx context.Context, userName string, acce
 // Terminate workspace running in ECS
 // TODO: Make this terminate ALB as well.
 var terminateEcsWorkspace = func(ctx context.Context, userName string, accessToken string, awsAcctID string) (string, error) {
-	Config.Logger.Printf("Terminating ECS workspace for user %s", userName)
+	getConfig().Logger.Printf("Terminating ECS workspace for user %s", userName)
 	roleARN := "arn:aws:iam::" + awsAcctID + ":role/csoc_adminvm"
 	sess := session.Must(session.NewSession(&aws.Config{
 		// TODO: Make this configurable
@@ -290,7 +297,7 @@ var terminateEcsWorkspace = func(ctx context.Context, userName string, accessTok
 		return "", errors.New("No service found for " + userName)
 	}
 	if taskDefName == "" {
-		Config.Logger.Printf("No task definition found for user %s, skipping API key deletion", userName)
+		getConfig().Logger.Printf("No task definition found for user %s, skipping API key deletion", userName)
 	} else {
 		desTaskDefOutput, err := svc.svc.DescribeTaskDefinition(&ecs.DescribeTaskDefinitionInput{
 			TaskDefinition: &taskDefName,
@@ -302,28 +309,33 @@ var terminateEcsWorkspace = func(ctx context.Context, userName string, accessTok
 		if len(containerDefs) > 0 {
 			envVars := containerDefs[0].Environment
 			if len(envVars) > 0 {
-				for i, ev := range envVars {
-					if *ev.Name == "API_KEY_ID" {
-						Config.Logger.Printf("Found mounted API key. Attempting to delete API Key with ID %s for user %s\n", *ev.Value, userName)
-						err := deleteAPIKeyWithContext(ctx, accessToken, *ev.Value)
-						if err != nil {
-							Config.Logger.Printf("Error occurred when deleting API Key with ID %s for user %s: %s\n", *ev.Value, userName, err.Error())
-						}
-						break
+				var apiKey, apiKeyID string
+				for _, ev := range envVars {
+					switch aws.StringValue(ev.Name) {
+					case "API_KEY":
+						apiKey = aws.StringValue(ev.Value)
+					case "API_KEY_ID":
+						apiKeyID = aws.StringValue(ev.Value)
 					}
-					if i == len(envVars)-1 {
-						Config.Logger.Printf("Unable to find API Key ID in env vars for user %s\n", userName)
+				}
+				if apiKeyID != "" {
+					getConfig().Logger.Printf("Found mounted API key. Attempting to delete API Key with ID %s for user %s\n", apiKeyID, userName)
+					err := deleteWorkspaceAPIKey(ctx, accessToken, apiKey, apiKeyID)
+					if err != nil {
+						getConfig().Logger.Printf("Error occurred when deleting API Key with ID %s for user %s: %s\n", apiKeyID, userName, err.Error())
 					}
+				} else {
+					getConfig().Logger.Printf("Unable to find API Key ID in env vars for user %s\n", userName)
 				}
 			} else {
-				Config.Logger.Printf("No env vars found for task definition %s, skipping API key deletion\n", taskDefName)
+				getConfig().Logger.Printf("No env vars found for task definition %s, skipping API key deletion\n", taskDefName)
 			}
 		} else {
-			Config.Logger.Printf("No container definition found for task definition %s, skipping API key deletion\n", taskDefName)
+			getConfig().Logger.Printf("No container definition found for task definition %s, skipping API key deletion\n", taskDefName)
 		}
 	}
 	// Terminate ECS service
-	Config.Logger.Printf("Terminating ECS service %s for user %s\n", svcName, userName)
+	getConfig().Logger.Printf("Terminating ECS service %s for user %s\n", svcName, userName)
 	delServiceOutput, err := svc.svc.DeleteService(&ecs.DeleteServiceInput{
 		Cluster: cluster.ClusterName,
 		Force:   aws.Bool(true),
@@ -334,29 +346,28 @@ var terminateEcsWorkspace = func(ctx context.Context, userName string, accessTok
 	}
 
 	// Terminate load balancer
-	Config.Logger.Printf("Terminating load balancer for user %s\n", userName)
+	getConfig().Logger.Printf("Terminating load balancer for user %s\n", userName)
 	err = svc.terminateLoadBalancer(userName)
 	if err != nil {
-		Config.Logger.Printf("Error occurred when terminating load balancer for user %s: %s\n", userName, err.Error())
+		getConfig().Logger.Printf("Error occurred when terminating load balancer for user %s: %s\n", userName, err.Error())
 	}
 
 	// Terminate target group
 	err = svc.terminateLoadBalancerTargetGroup(userName)
 	if err != nil {
-		Config.Logger.Printf("Error occurred when terminating load balancer target group for user %s: %s\n", userName, err.Error())
+		getConfig().Logger.Printf("Error occurred when terminating load balancer target group for user %s: %s\n", userName, err.Error())
 	}
 
 	// Terminate transit gateway
 	err = teardownTransitGateway(userName)
 	if err != nil {
-		Config.Logger.Printf("Error occurred when terminating transit gateway resources for user %s: %s\n", userName, err.Error())
+		getConfig().Logger.Printf("Error occurred when terminating transit gateway resources for user %s: %s\n", userName, err.Error())
 	}
 	return fmt.Sprintf("Service '%s' is in status: %s", userToResourceName(userName, "pod"), *delServiceOutput.Service.Status), nil
 }
 
-func launchEcsWorkspace(userName string, hash string, accessToken string, payModel PayModel, envVars []EnvVar) error {
-	// Set up background context, as this runs in a goroutine
-	ctx := context.Background()
+func launchEcsWorkspace(ctx context.Context, userName string, hash string, accessToken string, payModel PayModel, envVars []EnvVar) error {
+	op := operationFromContext(ctx)
 
 	roleARN := "arn:aws:iam::" + payModel.AWSAccountId + ":role/csoc_adminvm"
 	sess := session.Must(session.NewSession(&aws.Config{
@@ -364,36 +375,120 @@ func launchEcsWorkspace(userName string, hash string, accessToken string, payMod
 		Region: aws.String("us-east-1"),
 	}))
 	svc := NewSVC(sess, roleARN)
-	hatchApp := Config.ContainersMap[hash]
+	hatchApp, err := getLaunchContainer(ctx, hash)
+	if err != nil {
+		return err
+	}
 	mem, err := mem(hatchApp.MemoryLimit)
 	if err != nil {
 		// Log error and return without launching workspace
-		Config.Logger.Printf("Failed to launch ECS workspace for user %v, Error: %v", userName, err)
+		getConfig().Logger.Printf("Failed to launch ECS workspace for user %v, Error: %v", userName, err)
 		return err
 	}
 	cpu, err := cpu(hatchApp.CPULimit)
 	if err != nil {
 		// Log error and return without launching workspace
-		Config.Logger.Printf("Failed to launch ECS workspace for user %v, Error: %v", userName, err)
+		getConfig().Logger.Printf("Failed to launch ECS workspace for user %v, Error: %v", userName, err)
 	}
 
 	// Make sure ECS cluster exists
+	op.startPhase(phaseEcsCluster)
 	_, err = svc.launchEcsCluster(userName)
+	op.endPhase(phaseEcsCluster, err)
 	if err != nil {
-		Config.Logger.Printf("Failed to launch ECS cluster for user %v, Error: %v", userName, err)
+		getConfig().Logger.Printf("Failed to launch ECS cluster for user %v, Error: %v", userName, err)
 		return err
 	}
 
 	// Get Gen3 API key to be used in workspace
-	Config.Logger.Printf("Creating API key for user %s", userName)
+	getConfig().Logger.Printf("Creating API key for user %s", userName)
+	op.startPhase(phaseAPIKey)
 	apiKey, err := getAPIKeyWithContext(ctx, accessToken)
 	if err != nil {
-		Config.Logger.Printf("Failed to create API key for user %v, Error: %v. Moving on but workspace won't have API key", userName, err)
+		getConfig().Logger.Printf("Failed to create API key for user %v, Error: %v. Moving on but workspace won't have API key", userName, err)
 		apiKey = &APIKeyStruct{}
 	} else {
-		Config.Logger.Printf("Created API key for user %v, key ID: %v", userName, apiKey.KeyID)
+		getConfig().Logger.Printf("Created API key for user %v, key ID: %v", userName, apiKey.KeyID)
+	}
+	// not fatal: the workspace is launched without an API key
+	op.endPhase(phaseAPIKey, err)
+
+	envVars = getEcsEnvVars(hatchApp, envVars, apiKey, accessToken)
+
+	getConfig().Logger.Printf("Settign up EFS for user %s", userName)
+	op.startPhase(phaseEfs)
+	volumes, err := svc.EFSFileSystem(userName)
+	op.endPhase(phaseEfs, err)
+	if err != nil {
+		getConfig().Logger.Printf("Failed to set up EFS for user %v, Error: %v", userName, err)
+		return err
+	}
+
+	op.startPhase(phaseTaskDefinition)
+	getConfig().Logger.Printf("Setting up task role for user %s", userName)
+	taskRole, err := svc.taskRole(userName)
+	if err != nil {
+		// Log the error
+		getConfig().Logger.Printf("Failed to set up task role for user %v, Error: %v", userName, err)
+		op.endPhase(phaseTaskDefinition, err)
+		return err
 	}
 
+	getConfig().Logger.Printf("Setting up execution role for user %s", userName)
+	_, err = svc.CreateEcsTaskExecutionRole()
+	if err != nil {
+		// Log the error
+		getConfig().Logger.Printf("Failed to set up execution role for user %v, Error: %v", userName, err)
+		op.endPhase(phaseTaskDefinition, err)
+		return err
+	}
+
+	getConfig().Logger.Printf("Setting up ECS task definition for user %s", userName)
+	taskDef := buildEcsTaskDefinitionInput(hatchApp, userName, cpu, mem, *taskRole, volumes, envVars, payModel)
+	taskDefResult, err := svc.CreateTaskDefinition(&taskDef, userName, hash, payModel.AWSAccountId)
+	op.endPhase(phaseTaskDefinition, err)
+	if err != nil {
+		// Log the error
+		getConfig().Logger.Printf("Failed to set up task definition for user %v, Error: %v", userName, err)
+		aerr := deleteAPIKeyWithContext(ctx, accessToken, apiKey.KeyID)
+		if aerr != nil {
+			getConfig().Logger.Printf("Error occurred when deleting API Key with ID %s for user %s: %s\n", apiKey.KeyID, userName, err.Error())
+		}
+		return err
+	}
+
+	getConfig().Logger.Printf("Launching ECS workspace service for user %s", userName)
+	op.startPhase(phaseEcsService)
+	launchTask, err := svc.launchService(ctx, taskDefResult, userName, hash, payModel)
+	op.endPhase(phaseEcsService, err)
+	if err != nil {
+		// Log the error
+		getConfig().Logger.Printf("Failed to launch ECS workspace service for user %v, Error: %v", userName, err)
+		aerr := deleteAPIKeyWithContext(ctx, accessToken, apiKey.KeyID)
+		if aerr != nil {
+			getConfig().Logger.Printf("Error occurred when deleting API Key with ID %s for user %s: %s\n", apiKey.KeyID, userName, err.Error())
+		}
+		return err
+	}
+
+	getConfig().Logger.Printf("Setting up Transit Gateway for user %s", userName)
+	op.startPhase(phaseTransitGateway)
+	err = setupTransitGateway(userName)
+	op.endPhase(phaseTransitGateway, err)
+	if err != nil {
+		// Log the error
+		getConfig().Logger.Printf("Failed to set up Transit Gateway for user %v, Error: %v", userName, err)
+		return err
+	}
+
+	getConfig().Logger.Printf("Launched ECS workspace service at %s for user %s\n", launchTask, userName)
+	return nil
+}
+
+// getEcsEnvVars returns the environment variables of an ECS workspace: the
+// container's `env`, the extra variables of the launch, and the credentials
+// and endpoint of the commons
+func getEcsEnvVars(hatchApp Container, envVars []EnvVar, apiKey *APIKeyStruct, accessToken string) []EnvVar {
 	for k, v := range hatchApp.Env {
 		envVars = append(envVars, EnvVar{
 			Key:   k,
@@ -417,48 +512,29 @@ func launchEcsWorkspace(userName string, hash string, accessToken string, payMod
 		Key:   "GEN3_ENDPOINT",
 		Value: os.Getenv("GEN3_ENDPOINT"),
 	})
+	return envVars
+}
 
-	Config.Logger.Printf("Settign up EFS for user %s", userName)
-	volumes, err := svc.EFSFileSystem(userName)
-	if err != nil {
-		Config.Logger.Printf("Failed to set up EFS for user %v, Error: %v", userName, err)
-		return err
-	}
-
-	Config.Logger.Printf("Setting up task role for user %s", userName)
-	taskRole, err := svc.taskRole(userName)
-	if err != nil {
-		// Log the error
-		Config.Logger.Printf("Failed to set up task role for user %v, Error: %v", userName, err)
-		return err
-	}
-
-	Config.Logger.Printf("Setting up execution role for user %s", userName)
-	_, err = svc.CreateEcsTaskExecutionRole()
-	if err != nil {
-		// Log the error
-		Config.Logger.Printf("Failed to set up execution role for user %v, Error: %v", userName, err)
-		return err
-	}
-
-	Config.Logger.Printf("Setting up ECS task definition for user %s", userName)
-	taskDef := CreateTaskDefinitionInput{
+// buildEcsTaskDefinitionInput returns the task definition of the user's
+// workspace, with the user's EFS volume and task role
+func buildEcsTaskDefinitionInput(hatchApp Container, userName string, cpu string, mem string, taskRole string, volumes *EFS, envVars []EnvVar, payModel PayModel) CreateTaskDefinitionInput {
+	return CreateTaskDefinitionInput{
 		Image:      hatchApp.Image,
 		Cpu:        cpu,
 		Memory:     mem,
 		Name:       userToResourceName(userName, "pod"),
 		Type:       "ws",
-		TaskRole:   *taskRole,
+		TaskRole:   taskRole,
 		EntryPoint: hatchApp.Command,
 		Volumes: []*ecs.Volume{
 			{
 				Name: aws.String("pd"),
 				EfsVolumeConfiguration: &ecs.EFSVolumeConfiguration{
 					AuthorizationConfig: &ecs.EFSAuthorizationConfig{
-						AccessPointId: &volumes.AccessPointId,
+						AccessPointId: aws.String(volumes.AccessPointId),
 						Iam:           aws.String("ENABLED"),
 					},
-					FileSystemId:      &volumes.FileSystemId,
+					FileSystemId:      aws.String(volumes.FileSystemId),
 					RootDirectory:     aws.String("/"),
 					TransitEncryption: aws.String("ENABLED"),
 				},
@@ -488,16 +564,20 @@ func launchEcsWorkspace(userName string, hash string, accessToken string, payMod
 				ReadOnly:      aws.Bool(false),
 			},
 		},
-		Args:             hatchApp.Args,
-		EnvVars:          envVars,
-		Port:             int64(hatchApp.TargetPort),
-		ExecutionRoleArn: fmt.Sprintf("arn:aws:iam::%s:role/ecsTaskExecutionRole", payModel.AWSAccountId), // TODO: Make this configurable?
+		Args:                 hatchApp.Args,
+		EnvVars:              envVars,
+		Secrets:              getEcsSecrets(hatchApp.Name, hatchApp.EnvValueFrom),
+		Port:                 int64(hatchApp.TargetPort),
+		ExecutionRoleArn:     fmt.Sprintf("arn:aws:iam::%s:role/ecsTaskExecutionRole", payModel.AWSAccountId), // TODO: Make this configurable?
+		ResourceRequirements: getEcsResourceRequirements(hatchApp),
+		EphemeralStorage:     getEcsEphemeralStorage(hatchApp),
 		SidecarContainer: ecs.ContainerDefinition{
-			Image: &Config.Config.Sidecar.Image,
+			Image: &getConfig().Config.Sidecar.Image,
 			Name:  aws.String("sidecar-container"),
 			// 2 seconds is the smallest value allowed.
 			StopTimeout: aws.Int64(2),
 			Essential:   aws.Bool(false),
+			Secrets:     getEcsSecrets("sidecar", getConfig().Config.Sidecar.EnvValueFrom),
 			MountPoints: []*ecs.MountPoint{
 				{
 					ContainerPath: aws.String("/data"),
@@ -510,45 +590,15 @@ func launchEcsWorkspace(userName string, hash string, accessToken string, payMod
 			},
 		},
 	}
-	taskDefResult, err := svc.CreateTaskDefinition(&taskDef, userName, hash, payModel.AWSAccountId)
-	if err != nil {
-		// Log the error
-		Config.Logger.Printf("Failed to set up task definition for user %v, Error: %v", userName, err)
-		aerr := deleteAPIKeyWithContext(ctx, accessToken, apiKey.KeyID)
-		if aerr != nil {
-			Config.Logger.Printf("Error occurred when deleting API Key with ID %s for user %s: %s\n", apiKey.KeyID, userName, err.Error())
-		}
-		return err
-	}
-
-	Config.Logger.Printf("Launching ECS workspace service for user %s", userName)
-	launchTask, err := svc.launchService(ctx, taskDefResult, userName, hash, payModel)
-	if err != nil {
-		// Log the error
-		Config.Logger.Printf("Failed to launch ECS workspace service for user %v, Error: %v", userName, err)
-		aerr := deleteAPIKeyWithContext(ctx, accessToken, apiKey.KeyID)
-		if aerr != nil {
-			Config.Logger.Printf("Error occurred when deleting API Key with ID %s for user %s: %s\n", apiKey.KeyID, userName, err.Error())
-		}
-		return err
-	}
-
-	Config.Logger.Printf("Setting up Transit Gateway for user %s", userName)
-	err = setupTransitGateway(userName)
-	if err != nil {
-		// Log the error
-		Config.Logger.Printf("Failed to set up Transit Gateway for user %v, Error: %v", userName, err)
-		return err
-	}
-
-	Config.Logger.Printf("Launched ECS workspace service at %s for user %s\n", launchTask, userName)
-	return nil
 }
 
 // Launch ECS service for task definition + LB for routing
 func (sess *CREDS) launchService(ctx context.Context, taskDefArn string, userName string, hash string, payModel PayModel) (string, error) {
 	svc := sess.svc
-	hatchApp := Config.ContainersMap[hash]
+	hatchApp, err := getLaunchContainer(ctx, hash)
+	if err != nil {
+		return "", err
+	}
 	cluster, err := sess.findEcsCluster()
 	if err != nil {
 		return "", err
@@ -590,42 +640,31 @@ func (sess *CREDS) launchService(ctx context.Context, taskDefArn string, userNam
 			switch aerr.Code() {
 			case ecs.ErrCodeInvalidParameterException:
 				if aerr.Error() == "InvalidParameterException: Creation of service was not idempotent." {
-					Config.Logger.Print("Service already exists.. ")
+					getConfig().Logger.Print("Service already exists.. ")
 					return "", nil
 				}
 			}
 		}
-		Config.Logger.Println(err.Error())
+		getConfig().Logger.Println(err.Error())
 		return "", err
 	}
-	Config.Logger.Printf("Service launched: %s", *result.Service.ClusterArn)
-	err = createLocalService(ctx, userName, hash, *loadBalancer.LoadBalancers[0].DNSName, payModel)
+	getConfig().Logger.Printf("Service launched: %s", *result.Service.ClusterArn)
+	err = createLocalService(ctx, userName, "", hash, *loadBalancer.LoadBalancers[0].DNSName, payModel)
 	if err != nil {
 		return "", err
 	}
 	return *loadBalancer.LoadBalancers[0].DNSName, nil
 }
 
-// Create/Update Task Definition in ECS
-func (sess *CREDS) CreateTaskDefinition(input *CreateTaskDefinitionInput, userName string, hash string, awsAcctID string) (string, error) {
-	creds := sess.creds
-	LogGroup, err := sess.CreateLogGroup(fmt.Sprintf("/hatchery/%s/", awsAcctID), creds)
-	if err != nil {
-		Config.Logger.Printf("Failed to create/get LogGroup. Error: %s", err)
-		return "", err
-	}
-	svc := ecs.New(session.Must(session.NewSession(&aws.Config{
-		Credentials: creds,
-		Region:      aws.String("us-east-1"),
-	})))
-
-	Config.Logger.Printf("Creating ECS task definition")
-
+// buildRegisterTaskDefinitionInput returns the registration of the task
+// definition, with the logs of its containers sent to the log group. It does
+// not include the Prisma defender, which `CreateTaskDefinition` adds.
+func (input *CreateTaskDefinitionInput) buildRegisterTaskDefinitionInput(userName string, logGroup string) *ecs.RegisterTaskDefinitionInput {
 	logConfiguration := &ecs.LogConfiguration{
 		LogDriver: aws.String(ecs.LogDriverAwslogs),
 		Options: map[string]*string{
 			"awslogs-region":        aws.String("us-east-1"),
-			"awslogs-group":         aws.String(LogGroup),
+			"awslogs-group":         aws.String(logGroup),
 			"awslogs-stream-prefix": aws.String(userName),
 		},
 	}
@@ -641,6 +680,12 @@ func (sess *CREDS) CreateTaskDefinition(input *CreateTaskDefinitionInput, userNa
 		EntryPoint:       aws.StringSlice(input.EntryPoint),
 		Command:          aws.StringSlice(input.Args),
 	}
+	if len(input.ResourceRequirements) > 0 {
+		containerDefinition.ResourceRequirements = input.ResourceRequirements
+	}
+	if len(input.Secrets) > 0 {
+		containerDefinition.Secrets = input.Secrets
+	}
 
 	sidecarContainerDefinition := input.SidecarContainer
 	sidecarContainerDefinition.LogConfiguration = logConfiguration
@@ -661,16 +706,48 @@ func (sess *CREDS) CreateTaskDefinition(input *CreateTaskDefinitionInput, userNa
 		&sidecarContainerDefinition,
 	}
 
-	if Config.Config.PrismaConfig.Enable {
+	return &ecs.RegisterTaskDefinitionInput{
+		ContainerDefinitions:    containerDefinitions,
+		Cpu:                     aws.String(input.Cpu),
+		ExecutionRoleArn:        aws.String(input.ExecutionRoleArn),
+		Family:                  aws.String(fmt.Sprintf("%s_%s", input.Type, input.Name)),
+		Memory:                  aws.String(input.Memory),
+		NetworkMode:             aws.String(ecs.NetworkModeAwsvpc),
+		RequiresCompatibilities: aws.StringSlice([]string{ecs.CompatibilityFargate}),
+		TaskRoleArn:             aws.String(input.TaskRole),
+		Volumes:                 input.Volumes,
+		EphemeralStorage:        input.EphemeralStorage,
+	}
+}
+
+// Create/Update Task Definition in ECS
+func (sess *CREDS) CreateTaskDefinition(input *CreateTaskDefinitionInput, userName string, hash string, awsAcctID string) (string, error) {
+	creds := sess.creds
+	LogGroup, err := sess.CreateLogGroup(fmt.Sprintf("/hatchery/%s/", awsAcctID), creds)
+	if err != nil {
+		getConfig().Logger.Printf("Failed to create/get LogGroup. Error: %s", err)
+		return "", err
+	}
+	svc := ecs.New(session.Must(session.NewSession(&aws.Config{
+		Credentials: creds,
+		Region:      aws.String("us-east-1"),
+	})))
+
+	getConfig().Logger.Printf("Creating ECS task definition")
+
+	taskDefinition := input.buildRegisterTaskDefinitionInput(userName, LogGroup)
+	logConfiguration := taskDefinition.ContainerDefinitions[0].LogConfiguration
+
+	if getConfig().Config.PrismaConfig.Enable {
 		installBundle, err := getInstallBundle()
 		if err != nil {
-			Config.Logger.Print(err, " error getting prisma install bundle")
+			getConfig().Logger.Print(err, " error getting prisma install bundle")
 			return "", err
 		}
 
 		image, err := getPrismaImage()
 		if err != nil {
-			Config.Logger.Print(err, " error getting prisma image")
+			getConfig().Logger.Print(err, " error getting prisma image")
 			return "", err
 		}
 
@@ -720,31 +797,18 @@ func (sess *CREDS) CreateTaskDefinition(input *CreateTaskDefinitionInput, userNa
 			LogConfiguration: logConfiguration,
 		}
 
-		containerDefinitions = append(containerDefinitions, &paloAltoContainerDefinition)
+		taskDefinition.ContainerDefinitions = append(taskDefinition.ContainerDefinitions, &paloAltoContainerDefinition)
 	}
 
-	resp, err := svc.RegisterTaskDefinition(
-		&ecs.RegisterTaskDefinitionInput{
-			ContainerDefinitions:    containerDefinitions,
-			Cpu:                     aws.String(input.Cpu),
-			ExecutionRoleArn:        aws.String(input.ExecutionRoleArn),
-			Family:                  aws.String(fmt.Sprintf("%s_%s", input.Type, input.Name)),
-			Memory:                  aws.String(input.Memory),
-			NetworkMode:             aws.String(ecs.NetworkModeAwsvpc),
-			RequiresCompatibilities: aws.StringSlice([]string{ecs.CompatibilityFargate}),
-			TaskRoleArn:             aws.String(input.TaskRole),
-			Volumes:                 input.Volumes,
-		},
-	)
-
+	resp, err := svc.RegisterTaskDefinition(taskDefinition)
 	if err != nil {
-		Config.Logger.Print(err, " Couldn't register ECS task definition")
+		getConfig().Logger.Print(err, " Couldn't register ECS task definition")
 		return "", err
 	}
 
 	td := resp.TaskDefinition
 
-	Config.Logger.Printf("Created ECS task definition [%s:%d]", aws.StringValue(td.Family), aws.Int64Value(td.Revision))
+	getConfig().Logger.Printf("Created ECS task definition [%s:%d]", aws.StringValue(td.Family), aws.Int64Value(td.Revision))
 
 	return aws.StringValue(td.TaskDefinitionArn), nil
 }
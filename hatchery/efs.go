@@ -134,7 +134,7 @@ func (creds *CREDS) EFSFileSystem(userName string) (*EFS, error) {
 
 		exisitingFS, _ = creds.getEFSFileSystem(userName, svc)
 		for *exisitingFS.FileSystems[0].LifeCycleState != "available" {
-			Config.Logger.Printf("EFS filesystem is in state: %s ...  Waiting for 2 seconds", *exisitingFS.FileSystems[0].LifeCycleState)
+			getConfig().Logger.Printf("EFS filesystem is in state: %s ...  Waiting for 2 seconds", *exisitingFS.FileSystems[0].LifeCycleState)
 			// sleep for 2 sec
 			time.Sleep(2 * time.Second)
 			exisitingFS, _ = creds.getEFSFileSystem(userName, svc)
@@ -145,12 +145,12 @@ func (creds *CREDS) EFSFileSystem(userName string) (*EFS, error) {
 		if err != nil {
 			return nil, fmt.Errorf("Failed to create EFS MountTarget: %s", err)
 		}
-		Config.Logger.Printf("MountTarget created: %s", *mountTarget.MountTargetId)
+		getConfig().Logger.Printf("MountTarget created: %s", *mountTarget.MountTargetId)
 		accessPoint, err := creds.createAccessPoint(*result.FileSystemId, userName, svc)
 		if err != nil {
 			return nil, fmt.Errorf("Failed to create EFS AccessPoint: %s", err)
 		}
-		Config.Logger.Printf("AccessPoint created: %s", *accessPoint)
+		getConfig().Logger.Printf("AccessPoint created: %s", *accessPoint)
 
 		return &EFS{
 			EFSArn:        *result.FileSystemArn,
@@ -187,7 +187,7 @@ func (creds *CREDS) EFSFileSystem(userName string) (*EFS, error) {
 			if err != nil {
 				return nil, fmt.Errorf("Failed to create EFS MountTarget: %s", err)
 			}
-			Config.Logger.Printf("MountTarget created: %s", *mountTarget.MountTargetId)
+			getConfig().Logger.Printf("MountTarget created: %s", *mountTarget.MountTargetId)
 		}
 
 		return &EFS{
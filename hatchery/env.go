@@ -0,0 +1,81 @@
+package hatchery
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// validateEnvValueFrom checks the `env-value-from` of a container: the
+// names must be unique and not already set in `env`, and each variable
+// must reference a single Secret or ConfigMap key, or an ECS secret
+func validateEnvValueFrom(env map[string]string, envValueFrom []EnvVarValueFrom) error {
+	names := make(map[string]bool)
+	for _, envVar := range envValueFrom {
+		if errs := validation.IsEnvVarName(envVar.Name); len(errs) > 0 {
+			return fmt.Errorf("invalid name '%s': %v", envVar.Name, errs)
+		}
+		if _, exists := env[envVar.Name]; exists || names[envVar.Name] {
+			return fmt.Errorf("'%s' is set more than once", envVar.Name)
+		}
+		names[envVar.Name] = true
+		if envVar.ValueFrom == nil && envVar.EcsValueFrom == "" {
+			return fmt.Errorf("'%s' has neither a 'value-from' nor an 'ecs-value-from'", envVar.Name)
+		}
+		if source := envVar.ValueFrom; source != nil {
+			if source.FieldRef != nil || source.ResourceFieldRef != nil || (source.SecretKeyRef == nil) == (source.ConfigMapKeyRef == nil) {
+				return fmt.Errorf("the 'value-from' of '%s' must have either a 'secretKeyRef' or a 'configMapKeyRef'", envVar.Name)
+			}
+			if ref := source.SecretKeyRef; ref != nil && (ref.Name == "" || ref.Key == "") {
+				return fmt.Errorf("the 'secretKeyRef' of '%s' must have a 'name' and a 'key'", envVar.Name)
+			}
+			if ref := source.ConfigMapKeyRef; ref != nil && (ref.Name == "" || ref.Key == "") {
+				return fmt.Errorf("the 'configMapKeyRef' of '%s' must have a 'name' and a 'key'", envVar.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// getK8sEnvValueFrom returns the environment variables of a pod's container
+// that are read from Secrets and ConfigMaps. The variables that are only
+// set for ECS are left out.
+func getK8sEnvValueFrom(envValueFrom []EnvVarValueFrom) []k8sv1.EnvVar {
+	var envVars []k8sv1.EnvVar
+	for _, envVar := range envValueFrom {
+		if envVar.ValueFrom != nil {
+			envVars = append(envVars, k8sv1.EnvVar{
+				Name:      envVar.Name,
+				ValueFrom: envVar.ValueFrom.DeepCopy(),
+			})
+		}
+	}
+	return envVars
+}
+
+// getEcsSecrets returns the `secrets` of an ECS container definition. The
+// variables without an `ecs-value-from` are logged and left out. The task
+// execution role must be allowed to read the secrets.
+func getEcsSecrets(containerName string, envValueFrom []EnvVarValueFrom) []*ecs.Secret {
+	var secrets []*ecs.Secret
+	var ignored []string
+	for _, envVar := range envValueFrom {
+		if envVar.EcsValueFrom == "" {
+			ignored = append(ignored, envVar.Name)
+			continue
+		}
+		secrets = append(secrets, &ecs.Secret{
+			Name:      aws.String(envVar.Name),
+			ValueFrom: aws.String(envVar.EcsValueFrom),
+		})
+	}
+	if len(ignored) > 0 {
+		sort.Strings(ignored)
+		getConfig().Logger.Printf("Warning: environment variables %v of container '%s' have no 'ecs-value-from' and are not set on ECS", ignored, containerName)
+	}
+	return secrets
+}
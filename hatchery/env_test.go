@@ -0,0 +1,112 @@
+package hatchery
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	k8sv1 "k8s.io/api/core/v1"
+)
+
+func TestValidateEnvValueFrom(t *testing.T) {
+	secretRef := &k8sv1.EnvVarSource{SecretKeyRef: &k8sv1.SecretKeySelector{LocalObjectReference: k8sv1.LocalObjectReference{Name: "db"}, Key: "password"}}
+	configMapRef := &k8sv1.EnvVarSource{ConfigMapKeyRef: &k8sv1.ConfigMapKeySelector{LocalObjectReference: k8sv1.LocalObjectReference{Name: "settings"}, Key: "url"}}
+	testCases := []struct {
+		name         string
+		env          map[string]string
+		envValueFrom []EnvVarValueFrom
+		wantError    bool
+	}{
+		{name: "NoVariables"},
+		{name: "Valid", envValueFrom: []EnvVarValueFrom{{Name: "DB_PASSWORD", ValueFrom: secretRef, EcsValueFrom: "arn:aws:ssm:us-east-1:123456789012:parameter/db-password"}, {Name: "SETTINGS_URL", ValueFrom: configMapRef}}},
+		{name: "EcsOnly", envValueFrom: []EnvVarValueFrom{{Name: "TOKEN", EcsValueFrom: "arn:aws:secretsmanager:us-east-1:123456789012:secret:token"}}},
+		{name: "NoSource", envValueFrom: []EnvVarValueFrom{{Name: "TOKEN"}}, wantError: true},
+		{name: "InvalidName", envValueFrom: []EnvVarValueFrom{{Name: "1TOKEN", ValueFrom: secretRef}}, wantError: true},
+		{name: "DuplicateName", envValueFrom: []EnvVarValueFrom{{Name: "TOKEN", ValueFrom: secretRef}, {Name: "TOKEN", ValueFrom: configMapRef}}, wantError: true},
+		{name: "NameInEnv", env: map[string]string{"TOKEN": "cleartext"}, envValueFrom: []EnvVarValueFrom{{Name: "TOKEN", ValueFrom: secretRef}}, wantError: true},
+		{name: "FieldRef", envValueFrom: []EnvVarValueFrom{{Name: "POD_IP", ValueFrom: &k8sv1.EnvVarSource{FieldRef: &k8sv1.ObjectFieldSelector{FieldPath: "status.podIP"}}}}, wantError: true},
+		{name: "TwoRefs", envValueFrom: []EnvVarValueFrom{{Name: "TOKEN", ValueFrom: &k8sv1.EnvVarSource{SecretKeyRef: secretRef.SecretKeyRef, ConfigMapKeyRef: configMapRef.ConfigMapKeyRef}}}, wantError: true},
+		{name: "NoKey", envValueFrom: []EnvVarValueFrom{{Name: "TOKEN", ValueFrom: &k8sv1.EnvVarSource{SecretKeyRef: &k8sv1.SecretKeySelector{LocalObjectReference: k8sv1.LocalObjectReference{Name: "db"}}}}}, wantError: true},
+	}
+	for _, testcase := range testCases {
+		err := validateEnvValueFrom(testcase.env, testcase.envValueFrom)
+		if testcase.wantError != (err != nil) {
+			t.Errorf("unexpected validation result when %s: %v", testcase.name, err)
+		}
+	}
+}
+
+func TestBuildPodEnvValueFrom(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	configPath := filepath.Join(t.TempDir(), "hatchery.json")
+	err := ioutil.WriteFile(configPath, []byte(`{
+		"sidecar": {
+			"cpu-limit": "0.1", "memory-limit": "64Mi",
+			"env-value-from": [{"name": "FUSE_TOKEN", "value-from": {"secretKeyRef": {"name": "fuse", "key": "token"}}}]
+		},
+		"containers": [
+			{
+				"name": "Jupyter", "cpu-limit": "1", "memory-limit": "1Gi", "image": "quay.io/cdis/jupyter",
+				"env": {"FRICKJACK": "1"},
+				"env-value-from": [
+					{"name": "DB_PASSWORD", "value-from": {"secretKeyRef": {"name": "db", "key": "password"}}},
+					{"name": "SETTINGS_URL", "value-from": {"configMapKeyRef": {"name": "settings", "key": "url"}}},
+					{"name": "ECS_TOKEN", "ecs-value-from": "arn:aws:secretsmanager:us-east-1:123456789012:secret:token"}
+				]
+			}
+		]
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(configPath, log.New(io.Discard, "", log.LstdFlags))
+	if err != nil {
+		t.Fatalf("failed to load config, got: %v", err)
+	}
+
+	pod, err := buildPod(config, &config.Config.Containers[0], "frickjack", "", nil)
+	if err != nil {
+		t.Fatalf("failed to build a pod - %v", err)
+	}
+	getEnv := func(container k8sv1.Container) map[string]k8sv1.EnvVar {
+		env := make(map[string]k8sv1.EnvVar)
+		for _, envVar := range container.Env {
+			env[envVar.Name] = envVar
+		}
+		return env
+	}
+	sidecarEnv := getEnv(pod.Spec.Containers[0])
+	if sidecarEnv["FUSE_TOKEN"].ValueFrom == nil || sidecarEnv["FUSE_TOKEN"].ValueFrom.SecretKeyRef.Name != "fuse" {
+		t.Errorf("expected the sidecar to read FUSE_TOKEN from a secret, got %v", pod.Spec.Containers[0].Env)
+	}
+	env := getEnv(pod.Spec.Containers[1])
+	if env["FRICKJACK"].Value != "1" || env["DB_PASSWORD"].ValueFrom == nil || env["DB_PASSWORD"].ValueFrom.SecretKeyRef.Key != "password" || env["SETTINGS_URL"].ValueFrom == nil || env["SETTINGS_URL"].ValueFrom.ConfigMapKeyRef.Name != "settings" {
+		t.Errorf("unexpected workspace container env: %v", pod.Spec.Containers[1].Env)
+	}
+	if _, exists := env["ECS_TOKEN"]; exists {
+		t.Errorf("expected the ECS-only variable to be left out, got %v", pod.Spec.Containers[1].Env)
+	}
+}
+
+func TestGetEcsSecrets(t *testing.T) {
+	original_config := getConfig()
+	defer func() {
+		SetConfig(original_config)
+	}()
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags)})
+
+	secrets := getEcsSecrets("test", []EnvVarValueFrom{
+		{Name: "DB_PASSWORD", ValueFrom: &k8sv1.EnvVarSource{SecretKeyRef: &k8sv1.SecretKeySelector{Key: "password"}}, EcsValueFrom: "arn:aws:ssm:us-east-1:123456789012:parameter/db-password"},
+		{Name: "K8S_ONLY", ValueFrom: &k8sv1.EnvVarSource{SecretKeyRef: &k8sv1.SecretKeySelector{Key: "token"}}},
+	})
+	if len(secrets) != 1 || aws.StringValue(secrets[0].Name) != "DB_PASSWORD" || aws.StringValue(secrets[0].ValueFrom) != "arn:aws:ssm:us-east-1:123456789012:parameter/db-password" {
+		t.Errorf("unexpected ECS secrets: %v", secrets)
+	}
+	if secrets := getEcsSecrets("test", nil); secrets != nil {
+		t.Errorf("expected no ECS secrets, got %v", secrets)
+	}
+}
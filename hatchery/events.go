@@ -0,0 +1,296 @@
+package hatchery
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Workspace lifecycle event types
+const (
+	eventLaunchRequested     = "workspace.launch.requested"
+	eventWorkspaceRunning    = "workspace.running"
+	eventWorkspaceFailed     = "workspace.failed"
+	eventWorkspaceTerminated = "workspace.terminated"
+	eventLicenseAssigned     = "license.assigned"
+)
+
+var eventTypes = []string{
+	eventLaunchRequested,
+	eventWorkspaceRunning,
+	eventWorkspaceFailed,
+	eventWorkspaceTerminated,
+	eventLicenseAssigned,
+}
+
+// Event is a workspace lifecycle event, published to the configured
+// `event-sink` so other systems (billing, notifications, audits) can react
+type Event struct {
+	ID            string            `json:"id"`
+	Type          string            `json:"type"`
+	Time          time.Time         `json:"time"`
+	UserName      string            `json:"user"`
+	WorkspaceName string            `json:"workspace,omitempty"`
+	ContainerID   string            `json:"container_id,omitempty"`
+	ContainerName string            `json:"container_name,omitempty"`
+	Backend       string            `json:"backend,omitempty"`
+	OperationID   string            `json:"operation_id,omitempty"`
+	Details       map[string]string `json:"details,omitempty"`
+}
+
+// EventSink delivers events to an external system
+type EventSink interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// eventSinkFactories creates the event sinks by `type`. To add a new kind
+// of sink (eg SQS or Kafka), implement `EventSink` and register it here.
+var eventSinkFactories = map[string]func(EventSinkConfig) (EventSink, error){
+	"webhook": newWebhookSink,
+}
+
+// newEventSink returns the event sink described by the configuration, or
+// nil if event publishing is not enabled
+func newEventSink(config EventSinkConfig) (EventSink, error) {
+	if config.Type == "" {
+		return nil, nil
+	}
+	factory, ok := eventSinkFactories[config.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown 'event-sink' type '%s'", config.Type)
+	}
+	for _, eventType := range config.EventTypes {
+		if !stringArrayContains(eventTypes, eventType) {
+			return nil, fmt.Errorf("unknown 'event-sink' event type '%s', expected one of %v", eventType, eventTypes)
+		}
+	}
+	if config.MaxRetries < 0 {
+		return nil, fmt.Errorf("'event-sink' 'max-retries' must be positive, got %d", config.MaxRetries)
+	}
+	return factory(config)
+}
+
+// newEvent returns an event about the specified workspace
+func newEvent(eventType string, userName string, workspaceName string, containerID string) Event {
+	return Event{
+		ID:            uuid.New().String(),
+		Type:          eventType,
+		Time:          time.Now().UTC(),
+		UserName:      userName,
+		WorkspaceName: workspaceName,
+		ContainerID:   containerID,
+		ContainerName: getConfig().ContainersMap[containerID].Name,
+	}
+}
+
+// newOperationEvent returns an event about the workspace an operation
+// acts on
+func newOperationEvent(eventType string, op *Operation) Event {
+	event := newEvent(eventType, op.UserName, op.WorkspaceName, op.ContainerID)
+	event.Backend = op.Backend
+	event.OperationID = op.ID
+	return event
+}
+
+// publishEvent sends the event to the configured event sink in the
+// background, so that slow or unavailable sinks do not hold up workspaces.
+// Hatchery waits for the events being sent on shutdown.
+// Events that can not be delivered are logged and dropped.
+var publishEvent = func(event Event) {
+	config := getConfig()
+	if config == nil || config.EventSink == nil {
+		return
+	}
+	allowedTypes := config.Config.EventSink.EventTypes
+	if len(allowedTypes) > 0 && !stringArrayContains(allowedTypes, event.Type) {
+		return
+	}
+	sink := config.EventSink
+	goBackground(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		err := sink.Publish(ctx, event)
+		if err != nil {
+			config.Logger.Printf("Unable to publish event %s (%s) for user %s: %v", event.ID, event.Type, event.UserName, err)
+		}
+	})
+}
+
+// How long to wait for a launched workspace to be running before
+// publishing a `workspace.failed` event
+const workspaceStartupTimeout = 30 * time.Minute
+
+var workspaceStartupPollInterval = 10 * time.Second
+
+// watchWorkspaceStartup follows the status of a launched workspace in the
+// background and publishes a `workspace.running` event once it is ready,
+// or a `workspace.failed` event if it never gets there. Nothing is watched
+// when no event sink is configured. The watch is recorded as a pending
+// operation, so that it is resumed after a restart.
+var watchWorkspaceStartup = func(op *Operation) {
+	if getConfig().EventSink == nil {
+		return
+	}
+	pending := newPendingOperation(pendingStartupWatch, op.UserName, op.WorkspaceName)
+	pending.ContainerID = op.ContainerID
+	pending.OperationID = op.ID
+	pending.Backend = op.Backend
+	pending.Deadline = pending.CreatedAt.Add(workspaceStartupTimeout)
+	startPendingOperation(pending)
+}
+
+// runStartupWatch polls the status of the workspace until it is running,
+// failed or gone, or until the watch's deadline. On shutdown, the watch is
+// left to be resumed after the restart rather than waited for.
+func runStartupWatch(ctx context.Context, pending *PendingOperation) {
+	for time.Now().Before(pending.Deadline) {
+		select {
+		case <-backgroundWork.stopped():
+			if getConfig().PendingOperationStore != nil {
+				getConfig().Logger.Printf("Shutting down: the startup of workspace '%s' of user %s will be watched after the restart", pending.WorkspaceName, pending.UserName)
+			}
+			pendingOperations.finish(pending, true)
+			return
+		case <-time.After(workspaceStartupPollInterval):
+		}
+		status, err := getWorkspaceStatus(ctx, pending.UserName, pending.WorkspaceName, "")
+		if err != nil || status == nil {
+			getConfig().Logger.Printf("Unable to get the status of workspace '%s' of user %s while waiting for it to start: %v", pending.WorkspaceName, pending.UserName, err)
+			continue
+		}
+		switch status.Status {
+		case "Running":
+			publishEvent(newStartupWatchEvent(eventWorkspaceRunning, pending))
+			pendingOperations.finish(pending, false)
+			return
+		case "Stopped":
+			event := newStartupWatchEvent(eventWorkspaceFailed, pending)
+			event.Details = map[string]string{"error": "the workspace failed to start"}
+			publishEvent(event)
+			pendingOperations.finish(pending, false)
+			return
+		case "Not Found", "Terminating", workspaceStoppedStatus:
+			// terminated before it was ready
+			pendingOperations.finish(pending, false)
+			return
+		}
+	}
+	event := newStartupWatchEvent(eventWorkspaceFailed, pending)
+	event.Details = map[string]string{"error": fmt.Sprintf("the workspace was not running after %v", workspaceStartupTimeout)}
+	publishEvent(event)
+	pendingOperations.finish(pending, false)
+}
+
+// newStartupWatchEvent returns an event about the workspace whose startup
+// is watched, for the launch operation that started it
+func newStartupWatchEvent(eventType string, pending *PendingOperation) Event {
+	event := newEvent(eventType, pending.UserName, pending.WorkspaceName, pending.ContainerID)
+	event.Backend = pending.Backend
+	event.OperationID = pending.OperationID
+	return event
+}
+
+// publishLaunchFailed publishes a `workspace.failed` event for a launch
+// that returned an error
+func publishLaunchFailed(op *Operation, err error) {
+	event := newOperationEvent(eventWorkspaceFailed, op)
+	event.Details = map[string]string{"error": err.Error()}
+	publishEvent(event)
+}
+
+// webhookSink POSTs the events as JSON to a URL. When a secret is
+// configured, the body is signed with HMAC-SHA256 and the signature is sent
+// in the `X-Hatchery-Signature` header as `sha256=<hex digest>`.
+type webhookSink struct {
+	url        string
+	secret     []byte
+	maxRetries int
+	retryDelay time.Duration
+	client     *http.Client
+}
+
+func newWebhookSink(config EventSinkConfig) (EventSink, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("'event-sink' of type 'webhook' requires a 'url'")
+	}
+	sink := &webhookSink{
+		url:        config.URL,
+		maxRetries: config.MaxRetries,
+		retryDelay: time.Second,
+		client:     &http.Client{Timeout: time.Duration(config.TimeoutSeconds) * time.Second},
+	}
+	if config.SecretEnvVar != "" {
+		secret := os.Getenv(config.SecretEnvVar)
+		if secret == "" {
+			return nil, fmt.Errorf("'event-sink' secret environment variable '%s' is not set", config.SecretEnvVar)
+		}
+		sink.secret = []byte(secret)
+	}
+	return sink, nil
+}
+
+// signature returns the hex encoded HMAC-SHA256 of the body
+func (sink *webhookSink) signature(body []byte) string {
+	mac := hmac.New(sha256.New, sink.secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Publish delivers the event, retrying with an exponential backoff when
+// the webhook can not be reached or returns a 429 or 5xx status code
+func (sink *webhookSink) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	delay := sink.retryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := sink.send(ctx, event, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= sink.maxRetries {
+			return fmt.Errorf("webhook delivery failed after %d attempt(s): %v", attempt+1, err)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("webhook delivery failed after %d attempt(s): %v", attempt+1, err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// send makes a single delivery attempt and returns whether it is worth
+// retrying if it failed
+func (sink *webhookSink) send(ctx context.Context, event Event, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", sink.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Hatchery-Event", event.Type)
+	req.Header.Set("X-Hatchery-Delivery", event.ID)
+	if len(sink.secret) > 0 {
+		req.Header.Set("X-Hatchery-Signature", "sha256="+sink.signature(body))
+	}
+	resp, err := sink.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook returned status code %d", resp.StatusCode)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
@@ -0,0 +1,270 @@
+package hatchery
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewEventSink(t *testing.T) {
+	os.Setenv("HATCHERY_TEST_WEBHOOK_SECRET", "secret")
+	defer os.Unsetenv("HATCHERY_TEST_WEBHOOK_SECRET")
+
+	testCases := []struct {
+		name       string
+		config     EventSinkConfig
+		wantSink   bool
+		wantErrors bool
+	}{
+		{
+			name:   "Disabled",
+			config: EventSinkConfig{},
+		},
+		{
+			name:     "Webhook",
+			config:   EventSinkConfig{Type: "webhook", URL: "http://example.com", SecretEnvVar: "HATCHERY_TEST_WEBHOOK_SECRET", EventTypes: []string{eventWorkspaceRunning}},
+			wantSink: true,
+		},
+		{
+			name:       "UnknownType",
+			config:     EventSinkConfig{Type: "carrier-pigeon", URL: "http://example.com"},
+			wantErrors: true,
+		},
+		{
+			name:       "UnknownEventType",
+			config:     EventSinkConfig{Type: "webhook", URL: "http://example.com", EventTypes: []string{"workspace.exploded"}},
+			wantErrors: true,
+		},
+		{
+			name:       "WebhookWithoutURL",
+			config:     EventSinkConfig{Type: "webhook"},
+			wantErrors: true,
+		},
+		{
+			name:       "MissingSecret",
+			config:     EventSinkConfig{Type: "webhook", URL: "http://example.com", SecretEnvVar: "HATCHERY_TEST_UNSET_SECRET"},
+			wantErrors: true,
+		},
+	}
+	for _, testcase := range testCases {
+		sink, err := newEventSink(testcase.config)
+		if testcase.wantErrors != (err != nil) {
+			t.Errorf("unexpected error when %s: %v", testcase.name, err)
+		}
+		if testcase.wantSink != (sink != nil) {
+			t.Errorf("unexpected sink when %s: %v", testcase.name, sink)
+		}
+	}
+}
+
+func TestWebhookSinkPublish(t *testing.T) {
+	testCases := []struct {
+		name         string
+		statusCodes  []int
+		wantAttempts int
+		wantErrors   bool
+	}{
+		{
+			name:         "Delivered",
+			statusCodes:  []int{http.StatusOK},
+			wantAttempts: 1,
+		},
+		{
+			name:         "DeliveredAfterRetries",
+			statusCodes:  []int{http.StatusBadGateway, http.StatusTooManyRequests, http.StatusNoContent},
+			wantAttempts: 3,
+		},
+		{
+			name:         "ClientErrorIsNotRetried",
+			statusCodes:  []int{http.StatusBadRequest},
+			wantAttempts: 1,
+			wantErrors:   true,
+		},
+		{
+			name:         "TooManyFailures",
+			statusCodes:  []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError},
+			wantAttempts: 3,
+			wantErrors:   true,
+		},
+	}
+
+	event := Event{ID: "event-id", Type: eventWorkspaceRunning, UserName: "testUser"}
+
+	for _, testcase := range testCases {
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			body, _ := ioutil.ReadAll(r.Body)
+
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write(body)
+			wantSignature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+			if r.Header.Get("X-Hatchery-Signature") != wantSignature {
+				t.Errorf("wrong signature when %s: got '%s', want '%s'", testcase.name, r.Header.Get("X-Hatchery-Signature"), wantSignature)
+			}
+			if r.Header.Get("X-Hatchery-Event") != event.Type || r.Header.Get("X-Hatchery-Delivery") != event.ID {
+				t.Errorf("wrong event headers when %s: %v", testcase.name, r.Header)
+			}
+			var got Event
+			if err := json.Unmarshal(body, &got); err != nil || got.ID != event.ID || got.UserName != event.UserName {
+				t.Errorf("wrong body when %s: %s", testcase.name, string(body))
+			}
+
+			statusCode := http.StatusInternalServerError
+			if attempts <= len(testcase.statusCodes) {
+				statusCode = testcase.statusCodes[attempts-1]
+			}
+			w.WriteHeader(statusCode)
+		}))
+
+		sink := &webhookSink{
+			url:        server.URL,
+			secret:     []byte("secret"),
+			maxRetries: 2,
+			retryDelay: time.Millisecond,
+			client:     server.Client(),
+		}
+		err := sink.Publish(context.Background(), event)
+		server.Close()
+
+		if testcase.wantErrors != (err != nil) {
+			t.Errorf("unexpected error when %s: %v", testcase.name, err)
+		}
+		if attempts != testcase.wantAttempts {
+			t.Errorf("unexpected number of attempts when %s: got %d, want %d", testcase.name, attempts, testcase.wantAttempts)
+		}
+	}
+}
+
+type channelEventSink chan Event
+
+func (sink channelEventSink) Publish(ctx context.Context, event Event) error {
+	sink <- event
+	return nil
+}
+
+func TestPublishEvent(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	defer func() {
+		SetConfig(original_config)
+	}()
+
+	sink := make(channelEventSink, 10)
+	SetConfig(&FullHatcheryConfig{
+		Logger:    log.New(io.Discard, "", log.LstdFlags),
+		EventSink: sink,
+	})
+	getConfig().Config.EventSink.EventTypes = []string{eventWorkspaceTerminated}
+
+	// filtered out
+	publishEvent(newEvent(eventLaunchRequested, "testUser", "", ""))
+	publishEvent(newEvent(eventWorkspaceTerminated, "testUser", "rstudio", ""))
+
+	select {
+	case event := <-sink:
+		if event.Type != eventWorkspaceTerminated || event.WorkspaceName != "rstudio" {
+			t.Errorf("unexpected event published: %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event was not published")
+	}
+	select {
+	case event := <-sink:
+		t.Errorf("unexpected event published: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWatchWorkspaceStartup(t *testing.T) {
+	defer SetupAndTeardownTest()()
+	defer setupPendingOperationsTest(t)()
+
+	original_getWorkspaceStatus := getWorkspaceStatus
+	original_workspaceStartupPollInterval := workspaceStartupPollInterval
+	defer func() {
+		getWorkspaceStatus = original_getWorkspaceStatus
+		workspaceStartupPollInterval = original_workspaceStartupPollInterval
+	}()
+
+	sink := make(channelEventSink, 10)
+	getConfig().EventSink = sink
+	workspaceStartupPollInterval = time.Millisecond
+	var status atomic.Value
+	status.Store("Launching")
+	getWorkspaceStatus = func(ctx context.Context, userName string, workspaceName string, accessToken string) (*WorkspaceStatus, error) {
+		return &WorkspaceStatus{Status: status.Load().(string)}, nil
+	}
+	store := getConfig().PendingOperationStore
+	waitForEvent := func(when string) Event {
+		select {
+		case event := <-sink:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event was published when %s", when)
+		}
+		return Event{}
+	}
+	// the watch is deleted from the store once it is done
+	waitForWatchDeleted := func() {
+		for i := 0; i < 100; i++ {
+			if stored, _ := store.List(); len(stored) == 0 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Errorf("expected the watch to be deleted once the event is published")
+	}
+
+	op := &Operation{ID: "operation-id", UserName: "testUser", WorkspaceName: "rstudio", ContainerID: "rstudio", Backend: backendLocalK8s}
+	watchWorkspaceStartup(op)
+	time.Sleep(20 * time.Millisecond)
+	stored, err := store.List()
+	if err != nil || len(stored) != 1 || stored[0].Kind != pendingStartupWatch || stored[0].OperationID != op.ID {
+		t.Fatalf("expected the watch to be recorded while the workspace starts, got %v, %v", stored, err)
+	}
+	status.Store("Running")
+	event := waitForEvent("the workspace is running")
+	if event.Type != eventWorkspaceRunning || event.OperationID != op.ID || event.Backend != backendLocalK8s || event.WorkspaceName != "rstudio" {
+		t.Errorf("unexpected event published: %+v", event)
+	}
+	waitForWatchDeleted()
+
+	// the watch is interrupted by a shutdown, and resumed after the restart
+	status.Store("Launching")
+	watchWorkspaceStartup(op)
+	time.Sleep(20 * time.Millisecond)
+	_ = backgroundWork.drain(context.Background())
+	time.Sleep(20 * time.Millisecond)
+	stored, err = store.List()
+	if err != nil || len(stored) != 1 || pendingOperations.isRunning(stored[0].ID) {
+		t.Fatalf("expected the interrupted watch to be kept in the store, got %v, %v", stored, err)
+	}
+	interrupted := stored[0]
+	interrupted.HeartbeatAt = time.Now().Add(-time.Hour)
+	if err := store.Save(interrupted); err != nil {
+		t.Fatal(err)
+	}
+	backgroundWork = &backgroundTracker{}
+	status.Store("Stopped")
+	if count := resumePendingOperations(context.Background()); count != 1 {
+		t.Fatalf("expected the watch to be resumed, got %d operations", count)
+	}
+	event = waitForEvent("the resumed workspace failed to start")
+	if event.Type != eventWorkspaceFailed || event.OperationID != op.ID {
+		t.Errorf("unexpected event published: %+v", event)
+	}
+	waitForWatchDeleted()
+}
@@ -28,6 +28,7 @@ type Gen3LicenseUserMap struct {
 	LicenseType        string `json:"licenseType"`
 	IsActive           string `json:"isActive"`
 	UserId             string `json:"userId"`
+	WorkspaceName      string `json:"workspaceName,omitempty"`
 	LicenseId          int    `json:"licenseId"`
 	FirstUsedTimestamp int    `json:"firstUsedTimestamp"`
 	LastUsedTimestamp  int    `json:"lastUsedTimestamp"`
@@ -89,6 +90,7 @@ var validateContainerLicenseInfo = func(containerName string, licenseInfo Licens
 func getItemsFromQuery(dbconfig *DbConfig, queryInput *dynamodb.QueryInput) ([]map[string]*dynamodb.AttributeValue, error) {
 	// Get items from a db query
 	queryOutput, err := dbconfig.DynamoDb.Query(queryInput)
+	recordDependencyError(dependencyDynamoDB, err)
 	if err != nil {
 		return nil, err
 	}
@@ -97,6 +99,7 @@ func getItemsFromQuery(dbconfig *DbConfig, queryInput *dynamodb.QueryInput) ([]m
 	for queryOutput.LastEvaluatedKey != nil {
 		queryInput.ExclusiveStartKey = queryOutput.LastEvaluatedKey
 		queryOutput, err = dbconfig.DynamoDb.Query(queryInput)
+		recordDependencyError(dependencyDynamoDB, err)
 		if err != nil {
 			return nil, err
 		}
@@ -112,11 +115,11 @@ var getActiveGen3LicenseUserMaps = func(dbconfig *DbConfig, container Container)
 	targetEnvironment := os.Getenv("GEN3_ENDPOINT")
 	err = validateContainerLicenseInfo(container.Name, container.License)
 	if err != nil {
-		Config.Logger.Printf("Gen3License table info for container is not configured or is misconfigured.")
+		getConfig().Logger.Printf("Gen3License table info for container is not configured or is misconfigured.")
 		return emptyList, nil
 	}
-	if Config.Config.LicenseUserMapsTable == "" || Config.Config.LicenseUserMapsGSI == "" {
-		Config.Logger.Printf("Gen3License table info is not configured.")
+	if getConfig().Config.LicenseUserMapsTable == "" || getConfig().Config.LicenseUserMapsGSI == "" {
+		getConfig().Logger.Printf("Gen3License table info is not configured.")
 		return emptyList, nil
 	}
 
@@ -126,12 +129,12 @@ var getActiveGen3LicenseUserMaps = func(dbconfig *DbConfig, container Container)
 	filt := expression.Name("licenseType").Equal(expression.Value(container.License.LicenseType))
 	expr, err := expression.NewBuilder().WithKeyCondition(expression.KeyAnd(keyEx1, keyEx2)).WithFilter(filt).Build()
 	if err != nil {
-		Config.Logger.Printf("Error in building expression for query: %s", err)
+		getConfig().Logger.Printf("Error in building expression for query: %s", err)
 		return emptyList, err
 	}
 	queryUserMapsInput := &dynamodb.QueryInput{
-		TableName:                 aws.String(Config.Config.LicenseUserMapsTable),
-		IndexName:                 aws.String(Config.Config.LicenseUserMapsGSI),
+		TableName:                 aws.String(getConfig().Config.LicenseUserMapsTable),
+		IndexName:                 aws.String(getConfig().Config.LicenseUserMapsGSI),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		KeyConditionExpression:    expr.KeyCondition(),
@@ -139,7 +142,7 @@ var getActiveGen3LicenseUserMaps = func(dbconfig *DbConfig, container Container)
 	}
 	licenseUserMapItems, err := getItemsFromQuery(dbconfig, queryUserMapsInput)
 	if err != nil {
-		Config.Logger.Printf("Error in active user query: %s", err)
+		getConfig().Logger.Printf("Error in active user query: %s", err)
 		return emptyList, err
 	}
 
@@ -147,10 +150,10 @@ var getActiveGen3LicenseUserMaps = func(dbconfig *DbConfig, container Container)
 	var gen3LicenseUsers []Gen3LicenseUserMap
 	err = dynamodbattribute.UnmarshalListOfMaps(licenseUserMapItems, &gen3LicenseUsers)
 	if err != nil {
-		Config.Logger.Printf("Error in unmarshalling active gen3 license user maps: %s", err)
+		getConfig().Logger.Printf("Error in unmarshalling active gen3 license user maps: %s", err)
 		return emptyList, err
 	}
-	Config.Logger.Printf("Debug: active gen3 license user maps %v", gen3LicenseUsers)
+	getConfig().Logger.Printf("Debug: active gen3 license user maps %v", gen3LicenseUsers)
 	return gen3LicenseUsers, nil
 }
 
@@ -159,8 +162,8 @@ var getLicenseUserMapsForUser = func(dbconfig *DbConfig, userId string) (gen3Lic
 	emptyList := []Gen3LicenseUserMap{}
 
 	targetEnvironment := os.Getenv("GEN3_ENDPOINT")
-	if Config.Config.LicenseUserMapsTable == "" || Config.Config.LicenseUserMapsGSI == "" {
-		Config.Logger.Printf("Gen3License table info is not configured.")
+	if getConfig().Config.LicenseUserMapsTable == "" || getConfig().Config.LicenseUserMapsGSI == "" {
+		getConfig().Logger.Printf("Gen3License table info is not configured.")
 		return emptyList, nil
 	}
 
@@ -170,12 +173,12 @@ var getLicenseUserMapsForUser = func(dbconfig *DbConfig, userId string) (gen3Lic
 	filt := expression.Name("userId").Equal(expression.Value(userId))
 	expr, err := expression.NewBuilder().WithKeyCondition(expression.KeyAnd(keyEx1, keyEx2)).WithFilter(filt).Build()
 	if err != nil {
-		Config.Logger.Printf("Error in building expression for query: %s", err)
+		getConfig().Logger.Printf("Error in building expression for query: %s", err)
 		return emptyList, err
 	}
 	queryUserMapsInput := &dynamodb.QueryInput{
-		TableName:                 aws.String(Config.Config.LicenseUserMapsTable),
-		IndexName:                 aws.String(Config.Config.LicenseUserMapsGSI),
+		TableName:                 aws.String(getConfig().Config.LicenseUserMapsTable),
+		IndexName:                 aws.String(getConfig().Config.LicenseUserMapsGSI),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		KeyConditionExpression:    expr.KeyCondition(),
@@ -183,7 +186,7 @@ var getLicenseUserMapsForUser = func(dbconfig *DbConfig, userId string) (gen3Lic
 	}
 	licenseUserMapItems, err := getItemsFromQuery(dbconfig, queryUserMapsInput)
 	if err != nil {
-		Config.Logger.Printf("Error in items for user query: %s", err)
+		getConfig().Logger.Printf("Error in items for user query: %s", err)
 		return emptyList, err
 	}
 
@@ -191,10 +194,10 @@ var getLicenseUserMapsForUser = func(dbconfig *DbConfig, userId string) (gen3Lic
 	var gen3LicenseUsers []Gen3LicenseUserMap
 	err = dynamodbattribute.UnmarshalListOfMaps(licenseUserMapItems, &gen3LicenseUsers)
 	if err != nil {
-		Config.Logger.Printf("Error in unmarshalling gen3 license user maps for user: %s", err)
+		getConfig().Logger.Printf("Error in unmarshalling gen3 license user maps for user: %s", err)
 		return emptyList, err
 	}
-	Config.Logger.Printf("Debug: gen3 license user maps for user %v", gen3LicenseUsers)
+	getConfig().Logger.Printf("Debug: gen3 license user maps for user %v", gen3LicenseUsers)
 	return gen3LicenseUsers, nil
 }
 
@@ -213,7 +216,7 @@ func getNextLicenseId(activeGen3LicenseUserMaps []Gen3LicenseUserMap, maxLicense
 			}
 		}
 		if !idInUsedIds {
-			Config.Logger.Printf("Next available license id: %d", i)
+			getConfig().Logger.Printf("Next available license id: %d", i)
 			return i
 		}
 	}
@@ -221,7 +224,7 @@ func getNextLicenseId(activeGen3LicenseUserMaps []Gen3LicenseUserMap, maxLicense
 	return 0
 }
 
-var createGen3LicenseUserMap = func(dbconfig *DbConfig, userId string, licenseId int, container Container) (gen3LicenseUserMap Gen3LicenseUserMap, err error) {
+var createGen3LicenseUserMap = func(dbconfig *DbConfig, userId string, workspaceName string, licenseId int, container Container) (gen3LicenseUserMap Gen3LicenseUserMap, err error) {
 	// Create a new user-license object and put in table
 
 	targetEnvironment := os.Getenv("GEN3_ENDPOINT")
@@ -236,6 +239,7 @@ var createGen3LicenseUserMap = func(dbconfig *DbConfig, userId string, licenseId
 	newItem.ItemId = itemId
 	newItem.Environment = targetEnvironment
 	newItem.UserId = userId
+	newItem.WorkspaceName = workspaceName
 	newItem.LicenseId = licenseId
 	newItem.IsActive = "True"
 	newItem.FirstUsedTimestamp = currentUnixTime
@@ -244,16 +248,17 @@ var createGen3LicenseUserMap = func(dbconfig *DbConfig, userId string, licenseId
 	// marshall Gen3LicenseUserMap into dynamodb item
 	item, err := dynamodbattribute.MarshalMap(newItem)
 	if err != nil {
-		Config.Logger.Printf("Error: could not marshal new item: %s", err)
+		getConfig().Logger.Printf("Error: could not marshal new item: %s", err)
 		return newItem, err
 	}
 	// put item
 	_, err = dbconfig.DynamoDb.PutItem(&dynamodb.PutItemInput{
-		TableName: aws.String(Config.Config.LicenseUserMapsTable),
+		TableName: aws.String(getConfig().Config.LicenseUserMapsTable),
 		Item:      item,
 	})
+	recordDependencyError(dependencyDynamoDB, err)
 	if err != nil {
-		Config.Logger.Printf("Error: could not add item to table: %s", err)
+		getConfig().Logger.Printf("Error: could not add item to table: %s", err)
 		return newItem, err
 	}
 	// Return the new gen3-user-license item that we created; DynamoDB:putItem does not return new items.
@@ -278,7 +283,7 @@ var setGen3LicenseUserInactive = func(dbconfig *DbConfig, itemId string) (Gen3Li
 				N: aws.String(strconv.Itoa(currentUnixTime)),
 			},
 		},
-		TableName: aws.String(Config.Config.LicenseUserMapsTable),
+		TableName: aws.String(getConfig().Config.LicenseUserMapsTable),
 		// Use the composite primary key: itemId, environment
 		Key: map[string]*dynamodb.AttributeValue{
 			"itemId": {
@@ -295,15 +300,16 @@ var setGen3LicenseUserInactive = func(dbconfig *DbConfig, itemId string) (Gen3Li
 	}
 
 	res, err := dbconfig.DynamoDb.UpdateItem(input)
+	recordDependencyError(dependencyDynamoDB, err)
 	if err != nil {
-		Config.Logger.Printf("Error: could not update item in table: %s", err)
+		getConfig().Logger.Printf("Error: could not update item in table: %s", err)
 		return Gen3LicenseUserMap{}, err
 	}
 
 	var updatedItem Gen3LicenseUserMap
 	err = dynamodbattribute.UnmarshalMap(res.Attributes, &updatedItem)
 	if err != nil {
-		Config.Logger.Printf("Error: could not unmarshal updated item: %s", err)
+		getConfig().Logger.Printf("Error: could not unmarshal updated item: %s", err)
 		return Gen3LicenseUserMap{}, err
 	}
 
@@ -316,7 +322,7 @@ func getLicenceFilePathConfigs() ([]LicenseInfo, error) {
 	var config LicenseInfo
 	var filePathConfigs []LicenseInfo
 
-	for _, v := range Config.ContainersMap {
+	for _, v := range getConfig().ContainersMap {
 		if v.License.Enabled {
 			err := validateContainerLicenseInfo(v.Name, v.License)
 			if err != nil {
@@ -357,24 +363,24 @@ var getKubeClientSet = func() (clientset kubernetes.Interface, err error) {
 		// out of cluster, eg local
 		config, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
 		if err != nil {
-			Config.Logger.Printf("Error: Could not build config for out of cluster client, %s", err)
+			getConfig().Logger.Printf("Error: Could not build config for out of cluster client, %s", err)
 			return nil, err
 		}
 		clientset, err = kubernetes.NewForConfig(config)
 		if err != nil {
-			Config.Logger.Printf("Error: Could not create clientset for out of cluster client, %s", err)
+			getConfig().Logger.Printf("Error: Could not create clientset for out of cluster client, %s", err)
 			return nil, err
 		}
 	} else {
 		// in cluster
 		config, err := rest.InClusterConfig()
 		if err != nil {
-			Config.Logger.Printf("Error: Could not build config for in cluster client, %s", err)
+			getConfig().Logger.Printf("Error: Could not build config for in cluster client, %s", err)
 			return nil, err
 		}
 		clientset, err = kubernetes.NewForConfig(config)
 		if err != nil {
-			Config.Logger.Printf("Error: Could not create clientset for in cluster client, %s", err)
+			getConfig().Logger.Printf("Error: Could not create clientset for in cluster client, %s", err)
 			return nil, err
 		}
 	}
@@ -388,10 +394,10 @@ var getLicenseFromKubernetes = func(clientset kubernetes.Interface, g3autoName s
 	var namespace string
 	var ok bool
 
-	if namespace, ok = Config.Config.Sidecar.Env["NAMESPACE"]; ok {
-		Config.Logger.Printf("Searching configured namespace for g3auto secret: %s", namespace)
+	if namespace, ok = getConfig().Config.Sidecar.Env["NAMESPACE"]; ok {
+		getConfig().Logger.Printf("Searching configured namespace for g3auto secret: %s", namespace)
 	} else {
-		Config.Logger.Printf("Error: namespace is not configured. Will try 'default'")
+		getConfig().Logger.Printf("Error: namespace is not configured. Will try 'default'")
 		namespace = "default"
 	}
 
@@ -399,7 +405,7 @@ var getLicenseFromKubernetes = func(clientset kubernetes.Interface, g3autoName s
 	secretsClient := clientset.CoreV1().Secrets(namespace)
 	secret, err := secretsClient.Get(context.TODO(), g3autoName, metaV1.GetOptions{})
 	if err != nil {
-		Config.Logger.Printf("Error: could not get secret from kubernetes: %s", err)
+		getConfig().Logger.Printf("Error: could not get secret from kubernetes: %s", err)
 		return "", err
 	}
 	licenseString = string(secret.Data[g3autoKey])
@@ -165,8 +165,8 @@ func Test_GetActiveGen3LicenseUserMaps(t *testing.T) {
 		Name:    "container-name",
 		License: licenseInfo,
 	}
-	Config.Config.LicenseUserMapsTable = "test_license_user_maps"
-	Config.Config.LicenseUserMapsGSI = "test_gsi"
+	getConfig().Config.LicenseUserMapsTable = "test_license_user_maps"
+	getConfig().Config.LicenseUserMapsGSI = "test_gsi"
 
 	// getActiveGen3LicenseUserMaps
 	for _, testcase := range testCases {
@@ -255,7 +255,7 @@ func Test_CreateGen3LicenseUserMap(t *testing.T) {
 	t.Logf("Testing CreateGen3LicenseUserMap")
 
 	/* Act */
-	newItem, err := createGen3LicenseUserMap(dbconfig, itemId, licenseId, mockContainer)
+	newItem, err := createGen3LicenseUserMap(dbconfig, itemId, "", licenseId, mockContainer)
 	if nil != err {
 		t.Errorf("failed to put item, got: %v", err)
 	}
@@ -3,6 +3,7 @@ package hatchery
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
 	"io"
@@ -10,23 +11,50 @@ import (
 	"strconv"
 	"strings"
 	"text/template"
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
 	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
-// Config package-global shared hatchery config
-var Config *FullHatcheryConfig
-
 type containerOption struct {
+	Name        string `json:"name"`
+	CPULimit    string `json:"cpu-limit"`
+	MemoryLimit string `json:"memory-limit"`
+	// the resources reserved for the workspace, at most the limits
+	CPURequest    string `json:"cpu-request"`
+	MemoryRequest string `json:"memory-request"`
+	// eg `{"nvidia.com/gpu": "1"}`
+	ExtendedResources     map[string]string `json:"extended-resources,omitempty"`
+	EphemeralStorageLimit string            `json:"ephemeral-storage-limit,omitempty"`
+	ID                    string            `json:"id"`
+	IdleTimeLimit         int               `json:"idle-time-limit"`
+	MaxConcurrent         int               `json:"max-concurrent,omitempty"`
+	// only set for containers with a `max-concurrent` limit
+	RemainingCapacity *int     `json:"remaining-capacity,omitempty"`
+	Description       string   `json:"description,omitempty"`
+	Category          string   `json:"category,omitempty"`
+	Tags              []string `json:"tags,omitempty"`
+	IconURL           string   `json:"icon-url,omitempty"`
+	DocumentationURL  string   `json:"documentation-url,omitempty"`
+	HourlyCost        float64  `json:"hourly-cost,omitempty"`
+	GPUCount          int      `json:"gpu-count,omitempty"`
+	Licensed          bool     `json:"licensed"`
+	Nextflow          bool     `json:"nextflow"`
+	// only the profiles the user is allowed to pick
+	ResourceProfiles       []resourceProfileOption `json:"resource-profiles,omitempty"`
+	DefaultResourceProfile string                  `json:"default-resource-profile,omitempty"`
+}
+
+type resourceProfileOption struct {
 	Name          string `json:"name"`
+	Description   string `json:"description,omitempty"`
 	CPULimit      string `json:"cpu-limit"`
 	MemoryLimit   string `json:"memory-limit"`
-	ID            string `json:"id"`
-	IdleTimeLimit int    `json:"idle-time-limit"`
+	CPURequest    string `json:"cpu-request"`
+	MemoryRequest string `json:"memory-request"`
 }
 
 type TextOutput struct {
@@ -40,13 +68,23 @@ func RegisterHatchery(mux *httptrace.ServeMux) {
 	mux.HandleFunc("/", home)
 	mux.HandleFunc("/launch", launch)
 	mux.HandleFunc("/terminate", terminate)
+	mux.HandleFunc("/stop", stop)
+	mux.HandleFunc("/resume", resume)
+	mux.HandleFunc("/operations", operationsEndpoint)
 	mux.HandleFunc("/status", status)
+	mux.HandleFunc("/status/stream", statusStream)
 	mux.HandleFunc("/options", options)
 	mux.HandleFunc("/mount-files", mountFiles)
 	mux.HandleFunc("/paymodels", paymodels)
 	mux.HandleFunc("/setpaymodel", setpaymodel)
 	mux.HandleFunc("/resetpaymodels", resetPaymodels)
 	mux.HandleFunc("/allpaymodels", allpaymodels)
+	mux.HandleFunc("/audit", auditEndpoint)
+
+	// Admin functions, only available to users with access to the
+	// `admin-resource-path` in arborist
+	mux.HandleFunc("/admin/workspaces", adminWorkspaces)
+	mux.HandleFunc("/admin/terminate", adminTerminate)
 
 	// ECS functions
 	mux.HandleFunc("/create-ecs-cluster", createECSCluster)
@@ -58,8 +96,8 @@ func home(w http.ResponseWriter, r *http.Request) {
 	<body>`
 	fmt.Fprintln(w, htmlHeader)
 
-	for k, v := range Config.ContainersMap {
-		fmt.Fprintf(w, "<h1><a href=\"%s/launch?hash=%s\">Launch %s - %s CPU - %s Memory</a></h1>", Config.Config.SubDir, k, v.Name, v.CPULimit, v.MemoryLimit)
+	for k, v := range getConfig().ContainersMap {
+		fmt.Fprintf(w, "<h1><a href=\"%s/launch?hash=%s\">Launch %s - %s CPU - %s Memory</a></h1>", getConfig().Config.SubDir, k, v.Name, v.CPULimit, v.MemoryLimit)
 	}
 
 	htmlFooter := `</body>
@@ -70,7 +108,7 @@ func home(w http.ResponseWriter, r *http.Request) {
 func getCurrentUserName(r *http.Request) (userName string) {
 	user := r.Header.Get("REMOTE_USER")
 	if user == "" {
-		Config.Logger.Print("Warning: No username in header REMOTE_USER!")
+		getConfig().Logger.Print("Warning: No username in header REMOTE_USER!")
 	}
 
 	// escape username to sanitize input from http header
@@ -80,22 +118,28 @@ func getCurrentUserName(r *http.Request) (userName string) {
 	return user
 }
 
-var getWorkspaceStatus = func(ctx context.Context, userName string, accessToken string) (*WorkspaceStatus, error) {
+var getWorkspaceStatus = func(ctx context.Context, userName string, workspaceName string, accessToken string) (*WorkspaceStatus, error) {
+	status, err := getRunningWorkspaceStatus(ctx, userName, workspaceName, accessToken)
+	if err == nil && status != nil && status.Status == "Not Found" {
+		setStoppedStatus(ctx, status, userName, workspaceName)
+	}
+	return status, err
+}
+
+// getRunningWorkspaceStatus returns the status of the workspace's compute,
+// which is "Not Found" for stopped workspaces
+func getRunningWorkspaceStatus(ctx context.Context, userName string, workspaceName string, accessToken string) (*WorkspaceStatus, error) {
 	allpaymodels, err := getPayModelsForUser(userName)
 	if err != nil {
 		return nil, err
 	}
 
-	if allpaymodels == nil {
-		return statusK8sPod(ctx, userName, accessToken, nil)
-	}
-
-	payModel := allpaymodels.CurrentPayModel
-	if payModel != nil && payModel.Ecs {
-		return statusEcs(ctx, userName, accessToken, payModel.AWSAccountId)
-	} else {
-		return statusK8sPod(ctx, userName, accessToken, payModel)
+	var payModel *PayModel
+	if allpaymodels != nil {
+		payModel = allpaymodels.CurrentPayModel
 	}
+	_, backend := getWorkspaceBackend(payModel)
+	return backend.Status(ctx, userName, workspaceName, accessToken, payModel)
 }
 
 func paymodels(w http.ResponseWriter, r *http.Request) {
@@ -158,7 +202,7 @@ func setpaymodel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	currentStatus, err := getWorkspaceStatus(r.Context(), userName, getBearerToken(r))
+	currentStatus, err := getWorkspaceStatus(r.Context(), userName, "", getBearerToken(r))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -169,8 +213,21 @@ func setpaymodel(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Can not update paymodel when workspace is running", http.StatusInternalServerError)
 		return
 	}
+	workspaceNames, err := getUserWorkspaceNames(r.Context(), userName, getBearerToken(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(workspaceNames) > 0 {
+		http.Error(w, "Can not update paymodel when workspace is running", http.StatusInternalServerError)
+		return
+	}
 
 	pm, err := setCurrentPaymodel(userName, id)
+	record := newAuditRecord(r.Context(), auditSetPayModel, userName, "", "")
+	record.PayModelID = id
+	record.setOutcome(err)
+	recordAudit(record)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -183,14 +240,41 @@ func setpaymodel(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, string(out))
 }
 
+// `/status?workspace=abc` => return the status of the specified workspace
+// `/status?all=true` => return the status of all the user's workspaces
+// `/status` => return the status of the user's default workspace
 func status(w http.ResponseWriter, r *http.Request) {
 	userName := getCurrentUserName(r)
 	accessToken := getBearerToken(r)
 
-	result, err := getWorkspaceStatus(r.Context(), userName, accessToken)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	var result interface{}
+	if r.URL.Query().Get("all") == "true" {
+		workspaceNames, err := getUserWorkspaceNames(r.Context(), userName, accessToken)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		statuses := []*WorkspaceStatus{}
+		for _, workspaceName := range workspaceNames {
+			workspaceStatus, err := getWorkspaceStatus(r.Context(), userName, workspaceName, accessToken)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			statuses = append(statuses, workspaceStatus)
+		}
+		result = statuses
+	} else {
+		workspaceName, err := getWorkspaceName(r, userName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err = getWorkspaceStatus(r.Context(), userName, workspaceName, accessToken)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 
 	out, err := json.Marshal(result)
@@ -209,7 +293,7 @@ func resetPaymodels(w http.ResponseWriter, r *http.Request) {
 	}
 	userName := getCurrentUserName(r)
 
-	currentStatus, err := getWorkspaceStatus(r.Context(), userName, getBearerToken(r))
+	currentStatus, err := getWorkspaceStatus(r.Context(), userName, "", getBearerToken(r))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -220,8 +304,20 @@ func resetPaymodels(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Can not reset paymodels when workspace is running", http.StatusInternalServerError)
 		return
 	}
+	workspaceNames, err := getUserWorkspaceNames(r.Context(), userName, getBearerToken(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(workspaceNames) > 0 {
+		http.Error(w, "Can not reset paymodels when workspace is running", http.StatusInternalServerError)
+		return
+	}
 
 	err = resetCurrentPaymodel(userName)
+	record := newAuditRecord(r.Context(), auditResetPayModels, userName, "", "")
+	record.setOutcome(err)
+	recordAudit(record)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -231,25 +327,81 @@ func resetPaymodels(w http.ResponseWriter, r *http.Request) {
 }
 
 func getOptionOutputForContainer(containerId string, containerSettings Container) containerOption {
+	// the limits of a launch without `profile`
+	defaultProfile, _ := getResourceProfile(containerSettings, "")
+	containerSettings = applyResourceProfile(containerSettings, defaultProfile)
+	cpuRequest, memoryRequest := getOptionResourceRequests(containerSettings.CPULimit, containerSettings.CPURequest, containerSettings.MemoryLimit, containerSettings.MemoryRequest)
 	c := containerOption{
-		Name:        containerSettings.Name,
-		CPULimit:    containerSettings.CPULimit,
-		MemoryLimit: containerSettings.MemoryLimit,
-		ID:          containerId,
+		Name:          containerSettings.Name,
+		CPULimit:      containerSettings.CPULimit,
+		MemoryLimit:   containerSettings.MemoryLimit,
+		CPURequest:    cpuRequest,
+		MemoryRequest: memoryRequest,
+		ID:            containerId,
+	}
+	c.IdleTimeLimit = getIdleTimeLimit(containerSettings)
+	c.MaxConcurrent = containerSettings.MaxConcurrent
+	c.Description = containerSettings.Description
+	c.Category = containerSettings.Category
+	c.Tags = containerSettings.Tags
+	c.IconURL = containerSettings.IconURL
+	c.DocumentationURL = containerSettings.DocumentationURL
+	c.HourlyCost = containerSettings.HourlyCost
+	c.GPUCount = getGPUCount(containerSettings)
+	c.ExtendedResources = containerSettings.ExtendedResources
+	c.EphemeralStorageLimit = containerSettings.EphemeralStorageLimit
+	c.Licensed = containerSettings.License.Enabled
+	c.Nextflow = containerSettings.NextflowConfig.Enabled
+	c.DefaultResourceProfile = containerSettings.DefaultResourceProfile
+	for _, profile := range containerSettings.ResourceProfiles {
+		cpuRequest, memoryRequest := getOptionResourceRequests(profile.CPULimit, profile.CPURequest, profile.MemoryLimit, profile.MemoryRequest)
+		c.ResourceProfiles = append(c.ResourceProfiles, resourceProfileOption{
+			Name:          profile.Name,
+			Description:   profile.Description,
+			CPULimit:      profile.CPULimit,
+			MemoryLimit:   profile.MemoryLimit,
+			CPURequest:    cpuRequest,
+			MemoryRequest: memoryRequest,
+		})
 	}
-	c.IdleTimeLimit = -1
+
+	return c
+}
+
+// getOptionResourceRequests returns the CPU and memory requests of a
+// workspace, as `buildPod` would set them. Limits that do not parse are
+// returned as is.
+func getOptionResourceRequests(cpuLimit string, cpuRequest string, memoryLimit string, memoryRequest string) (string, string) {
+	ratio := getConfig().Config.OvercommitRatio
+	if _, err := resource.ParseQuantity(cpuLimit); err == nil {
+		quantity := getResourceRequest(k8sv1.ResourceCPU, cpuLimit, cpuRequest, ratio)
+		cpuRequest = quantity.String()
+	} else if cpuRequest == "" {
+		cpuRequest = cpuLimit
+	}
+	if _, err := resource.ParseQuantity(memoryLimit); err == nil {
+		quantity := getResourceRequest(k8sv1.ResourceMemory, memoryLimit, memoryRequest, ratio)
+		memoryRequest = quantity.String()
+	} else if memoryRequest == "" {
+		memoryRequest = memoryLimit
+	}
+	return cpuRequest, memoryRequest
+}
+
+// getIdleTimeLimit returns the container's idle timeout in milliseconds,
+// as set by its `shutdown_no_activity_timeout=` arg, or -1
+func getIdleTimeLimit(containerSettings Container) int {
 	for _, arg := range containerSettings.Args {
 		if strings.Contains(arg, "shutdown_no_activity_timeout=") {
 			argSplit := strings.Split(arg, "=")
 			idleTimeLimit, err := strconv.Atoi(argSplit[len(argSplit)-1])
 			if err == nil {
-				c.IdleTimeLimit = idleTimeLimit * 1000
+				return idleTimeLimit * 1000
 			}
 			break
 		}
 	}
-
-	return c
+	return -1
 }
 
 func options(w http.ResponseWriter, r *http.Request) {
@@ -257,16 +409,16 @@ func options(w http.ResponseWriter, r *http.Request) {
 	accessToken := getBearerToken(r)
 
 	// handle `/options?id=abc` => return the specified option
-	hash := r.URL.Query().Get("id")
+	hash := resolveRequestedContainerID(r.URL.Query().Get("id"))
 	if hash != "" {
-		containerSettings, ok := Config.ContainersMap[hash]
+		containerSettings, ok := getConfig().ContainersMap[hash]
 		if !ok {
 			http.Error(w, fmt.Sprintf("Invalid 'id' parameter '%s'", hash), http.StatusBadRequest)
 			return
 		}
-		allowed, err := isUserAuthorizedForContainer(userName, accessToken, Config.ContainersMap[hash])
+		allowed, err := isUserAuthorizedForContainer(userName, accessToken, getConfig().ContainersMap[hash])
 		if err != nil {
-			Config.Logger.Printf("Unable to check if user is authorized to launch this container. Assuming unthorized. Details: %v", err)
+			getConfig().Logger.Printf("Unable to check if user is authorized to launch this container. Assuming unthorized. Details: %v", err)
 		}
 		if err != nil || !allowed {
 			// return the same as for an unknown id
@@ -274,7 +426,14 @@ func options(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		out, err := json.Marshal(getOptionOutputForContainer(hash, containerSettings))
+		option := []containerOption{getOptionOutputForContainer(hash, containerSettings)}
+		err = filterResourceProfileOptions(userName, accessToken, containerSettings, &option[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		setRemainingCapacity(r.Context(), option)
+		out, err := json.Marshal(option[0])
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -285,8 +444,15 @@ func options(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// handle `/options` without `id` parameter => return all available options
-	var options []containerOption
-	for k, v := range Config.ContainersMap {
+	// `/options?category=abc&tag=def&tag=ghi` => only return the options in
+	// the category that have all the tags
+	category := r.URL.Query().Get("category")
+	tags := r.URL.Query()["tag"]
+	options := []containerOption{}
+	for k, v := range getConfig().ContainersMap {
+		if !containerMatchesFilters(v, category, tags) {
+			continue
+		}
 		// filter out workspace options that the user is not allowed to run
 		allowed, err := isUserAuthorizedForContainer(userName, accessToken, v)
 		if err != nil {
@@ -298,8 +464,14 @@ func options(w http.ResponseWriter, r *http.Request) {
 		}
 
 		c := getOptionOutputForContainer(k, v)
+		err = filterResourceProfileOptions(userName, accessToken, v, &c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		options = append(options, c)
 	}
+	setRemainingCapacity(r.Context(), options)
 
 	out, err := json.Marshal(options)
 	if err != nil {
@@ -310,6 +482,28 @@ func options(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, string(out))
 }
 
+// containerMatchesFilters returns true if the container is in the category
+// and has all the tags. Empty filters match all containers. Matching is
+// case-insensitive.
+func containerMatchesFilters(container Container, category string, tags []string) bool {
+	if category != "" && !strings.EqualFold(container.Category, category) {
+		return false
+	}
+	for _, tag := range tags {
+		found := false
+		for _, containerTag := range container.Tags {
+			if strings.EqualFold(containerTag, tag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 func getWorkspaceFlavor(container Container) string {
 	if container.NextflowConfig.Enabled {
 		return "nextflow"
@@ -327,14 +521,18 @@ func launch(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	accessToken := getBearerToken(r)
 
-	hash := r.URL.Query().Get("id")
+	// the whole launch reads the same configuration, even if it is
+	// reloaded in the meantime
+	config := getConfig()
+	r = r.WithContext(withConfig(r.Context(), config))
+
+	hash := resolveRequestedContainerID(r.URL.Query().Get("id"))
 	if hash == "" {
 		http.Error(w, "Missing 'id' parameter", http.StatusBadRequest)
 		return
 	}
-	_, ok := Config.ContainersMap[hash]
+	_, ok := config.ContainersMap[hash]
 	if !ok {
 		http.Error(w, fmt.Sprintf("Invalid 'id' parameter '%s'", hash), http.StatusBadRequest)
 		return
@@ -346,9 +544,38 @@ func launch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	allowed, err := isUserAuthorizedForContainer(userName, accessToken, Config.ContainersMap[hash])
+	workspaceName, err := getWorkspaceName(r, userName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dry-run") == "true"
+	if dryRun {
+		// the rendered workspace includes the configuration of the commons
+		if !checkAdmin(w, r) {
+			return
+		}
+		if format := r.URL.Query().Get("format"); format != "" && format != "json" && format != "yaml" {
+			http.Error(w, fmt.Sprintf("Invalid 'format' parameter '%s': expected 'json' or 'yaml'", format), http.StatusBadRequest)
+			return
+		}
+	}
+
+	launchWorkspace(w, r, userName, workspaceName, hash, r.URL.Query().Get("profile"), nil, dryRun)
+}
+
+// launchWorkspace checks that the user can launch the container with the
+// resource profile and starts the launch operation. `stopped` is the
+// workspace being resumed, if any. A dry run returns the rendered workspace
+// instead of launching it.
+func launchWorkspace(w http.ResponseWriter, r *http.Request, userName string, workspaceName string, hash string, profileName string, stopped *StoppedWorkspace, dryRun bool) {
+	config := configFromContext(r.Context())
+	accessToken := getBearerToken(r)
+
+	allowed, err := isUserAuthorizedForContainer(userName, accessToken, config.ContainersMap[hash])
 	if err != nil {
-		Config.Logger.Printf("Unable to check if user is authorized to launch this container. Assuming unthorized. Details: %v", err)
+		config.Logger.Printf("Unable to check if user is authorized to launch this container. Assuming unthorized. Details: %v", err)
 	}
 	if err != nil || !allowed {
 		// return the same as for an unknown id
@@ -356,10 +583,247 @@ func launch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	profile, err := getResourceProfile(config.ContainersMap[hash], profileName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if profile != nil {
+		allowed, err := isUserAuthorizedForResourceProfile(userName, accessToken, config.ContainersMap[hash], *profile)
+		if err != nil {
+			config.Logger.Printf("Unable to check if user is authorized to use this resource profile. Assuming unthorized. Details: %v", err)
+		}
+		if err != nil || !allowed {
+			// return the same as for an unknown profile
+			http.Error(w, fmt.Sprintf("Invalid 'profile' parameter '%s'", profile.Name), http.StatusBadRequest)
+			return
+		}
+		// the default profile is recorded too
+		profileName = profile.Name
+	}
+
+	allpaymodels, err := getPayModelsForUser(userName)
+	if err != nil {
+		config.Logger.Printf(err.Error())
+	}
+	var payModel *PayModel
+	if allpaymodels != nil { // nil for commons with no concept of paymodels
+		payModel = allpaymodels.CurrentPayModel
+		if payModel == nil {
+			config.Logger.Printf("Current Paymodel is not set. Launch forbidden for user %s", userName)
+			http.Error(w, "Current Paymodel is not set. Launch forbidden", http.StatusInternalServerError)
+			return
+		}
+	}
+	backendName, backend := getWorkspaceBackend(payModel)
+	if err := backend.CheckPayModel(payModel); err != nil {
+		// send 500 response.
+		// TODO: 403 is the correct code, but it triggers a 302 to the default 403 page in revproxy instead of showing error message.
+		config.Logger.Printf("%v. Launch forbidden for user %s", err, userName)
+		http.Error(w, fmt.Sprintf("%v. Launch forbidden", err), http.StatusInternalServerError)
+		return
+	}
+
+	if !backend.SupportsNamedWorkspaces() && workspaceName != "" {
+		http.Error(w, errNamedWorkspaceOnEcs.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if dryRun {
+		renderWorkspace(w, r, userName, workspaceName, hash, profileName, payModel, backendName, backend)
+		return
+	}
+
+	if stopped != nil {
+		payModelID := ""
+		if payModel != nil {
+			payModelID = payModel.Id
+		}
+		if payModelID != stopped.PayModelID {
+			http.Error(w, "The workspace was stopped with another pay model. Set that pay model as current to resume it, or terminate it", http.StatusConflict)
+			return
+		}
+	} else {
+		stoppedNames, err := listStoppedWorkspaceNames(r.Context(), userName)
+		if err != nil {
+			config.Logger.Printf("Unable to list the stopped workspaces of user %s: %v", userName, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if stringArrayContains(stoppedNames, workspaceName) {
+			http.Error(w, "A stopped workspace with this name exists. Resume or terminate it first", http.StatusConflict)
+			return
+		}
+	}
+
+	if backend.SupportsNamedWorkspaces() {
+		workspaceNames, err := backend.List(r.Context(), userName, accessToken, payModel)
+		if err != nil {
+			config.Logger.Printf("Unable to list the workspaces of user %s: %v", userName, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if stringArrayContains(workspaceNames, workspaceName) {
+			http.Error(w, "A workspace with this name is already running", http.StatusConflict)
+			return
+		}
+		if len(workspaceNames) >= config.Config.MaxWorkspacesPerUser {
+			http.Error(w, fmt.Sprintf("Maximum number of workspaces per user (%d) reached. Launch forbidden", config.Config.MaxWorkspacesPerUser), http.StatusConflict)
+			return
+		}
+		// the list may not include the workspaces launched a moment ago:
+		// the name is reserved so that concurrent launches can not both
+		// pass the checks above
+		err = reserveWorkspace(r.Context(), userName, workspaceName, workspaceNames)
+		if errors.Is(err, errWorkspaceNameTaken) || errors.Is(err, errTooManyWorkspaces) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			config.Logger.Printf("Unable to reserve workspace '%s' of user %s: %v", workspaceName, userName, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = acquireContainerSlot(r.Context(), hash, userName, workspaceName)
+	if err != nil {
+		releaseWorkspaceReservation(r.Context(), userName, workspaceName)
+	}
+	if errors.Is(err, errCapacityFull) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		config.Logger.Printf("Unable to reserve a '%s' slot for workspace '%s' of user %s: %v", config.ContainersMap[hash].Name, workspaceName, userName, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	auditAction := auditLaunch
+	if stopped != nil {
+		auditAction = auditResume
+	}
+
+	// The launch itself runs in the background. The caller can follow its
+	// progress at `/operations?id=<operation id>`.
+	op := newOperation("launch", userName, hash, config.ContainersMap[hash].Name)
+	op.Backend = backendName
+	op.WorkspaceName = workspaceName
+	op.ResourceProfile = profileName
+	config.Logger.Printf("Launching workspace '%s' for user %s, backend %s, resource profile '%s', operation %s", workspaceName, userName, backendName, profileName, op.ID)
+	publishEvent(newOperationEvent(eventLaunchRequested, op))
+	runOperation(op, func(ctx context.Context) (err error) {
+		ctx = withConfig(ctx, config)
+		ctx = withResourceProfile(ctx, profileName)
+		ctx = withLaunchPayModel(ctx, payModel)
+		defer func() {
+			if err != nil {
+				releaseSlot(ctx, hash, userName, workspaceName)
+				releaseWorkspaceReservation(ctx, userName, workspaceName)
+				// a failed launch must not keep holding a license seat or
+				// the Nextflow resources it created
+				if container := config.ContainersMap[hash]; container.License.Enabled || container.NextflowConfig.Enabled {
+					releaseWorkspaceResources(ctx, userName, workspaceName, accessToken)
+				}
+			}
+			record := newAuditRecord(ctx, auditAction, userName, workspaceName, hash)
+			if payModel != nil {
+				record.PayModelID = payModel.Id
+			}
+			if profileName != "" {
+				record.Details = map[string]string{"resource_profile": profileName}
+			}
+			record.setOutcome(err)
+			recordAudit(record)
+		}()
+		envVars, envVarsEcs, err := prepareLaunchEnvironment(ctx, userName, workspaceName, hash, false)
+		if err != nil {
+			publishLaunchFailed(op, err)
+			return err
+		}
+		err = backend.Launch(ctx, WorkspaceLaunch{
+			UserName:      userName,
+			WorkspaceName: workspaceName,
+			ContainerID:   hash,
+			AccessToken:   accessToken,
+			PayModel:      payModel,
+			EnvVars:       envVars,
+			EcsEnvVars:    envVarsEcs,
+		})
+		if err != nil || stopped == nil {
+			return err
+		}
+		// the workspace is running again: it can not be resumed anymore.
+		// If the launch failed, the record is kept so the user can retry.
+		if derr := deleteStoppedWorkspace(ctx, userName, workspaceName); derr != nil {
+			config.Logger.Printf("Unable to delete the stopped workspace record of workspace '%s' for user %s: %v", workspaceName, userName, derr)
+		}
+		return nil
+	})
+
+	out, err := json.Marshal(op.snapshot())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, string(out))
+}
+
+// renderWorkspace writes the resources the launch would create, as JSON or
+// as YAML (`format=yaml`). Capacity and the user's running workspaces are
+// not checked, and nothing is created.
+func renderWorkspace(w http.ResponseWriter, r *http.Request, userName string, workspaceName string, hash string, profileName string, payModel *PayModel, backendName string, backend WorkspaceBackend) {
+	ctx := withResourceProfile(r.Context(), profileName)
+	getConfig().Logger.Printf("Rendering workspace '%s' for user %s, backend %s, resource profile '%s'", workspaceName, userName, backendName, profileName)
+	envVars, envVarsEcs, err := prepareLaunchEnvironment(ctx, userName, workspaceName, hash, true)
+	if errors.Is(err, errNoAvailableLicense) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	spec, err := backend.Render(ctx, WorkspaceLaunch{
+		UserName:      userName,
+		WorkspaceName: workspaceName,
+		ContainerID:   hash,
+		AccessToken:   getBearerToken(r),
+		PayModel:      payModel,
+		EnvVars:       envVars,
+		EcsEnvVars:    envVarsEcs,
+	})
+	if err != nil {
+		getConfig().Logger.Printf("Unable to render workspace '%s' of user %s: %v", workspaceName, userName, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	spec.Backend = backendName
+
+	format := r.URL.Query().Get("format")
+	out, err := marshalWorkspaceSpec(spec, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if format == "yaml" {
+		w.Header().Set("Content-Type", "application/yaml")
+	}
+	fmt.Fprint(w, string(out))
+}
+
+// prepareLaunchEnvironment creates the Nextflow resources and assigns a license
+// if the container needs them, and returns the extra environment variables to
+// set in the workspace (as k8s and ECS env vars). A dry run only checks that a
+// license is available and uses placeholders for the Nextflow credentials.
+func prepareLaunchEnvironment(ctx context.Context, userName string, workspaceName string, hash string, dryRun bool) ([]k8sv1.EnvVar, []EnvVar, error) {
+	op := operationFromContext(ctx)
 	var envVars []k8sv1.EnvVar
 	var envVarsEcs []EnvVar
 
-	workspaceFlavor := getWorkspaceFlavor(Config.ContainersMap[hash])
+	container, err := getLaunchContainer(ctx, hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	workspaceFlavor := getWorkspaceFlavor(container)
 	envVars = append(
 		envVars,
 		k8sv1.EnvVar{
@@ -375,13 +839,23 @@ func launch(w http.ResponseWriter, r *http.Request) {
 		},
 	)
 
-	if Config.ContainersMap[hash].NextflowConfig.Enabled {
-		Config.Logger.Printf("Info: Nextflow is enabled: creating Nextflow resources in AWS...")
-		nextflowKeyId, nextflowKeySecret, err := createNextflowResources(userName, Config.ContainersMap[hash].NextflowConfig)
-		if err != nil {
-			Config.Logger.Printf("Error creating Nextflow AWS resources in AWS for user '%s': %v", userName, err)
-			http.Error(w, "Unable to create AWS resources for Nextflow", http.StatusInternalServerError)
-			return
+	if container.NextflowConfig.Enabled {
+		var nextflowKeyId, nextflowKeySecret string
+		if dryRun {
+			// the Nextflow resources are only created when the workspace is launched
+			nextflowKeyId = dryRunPlaceholder("Nextflow access key ID")
+			nextflowKeySecret = dryRunPlaceholder("Nextflow secret access key")
+		} else {
+			getConfig().Logger.Printf("Info: Nextflow is enabled: creating Nextflow resources in AWS...")
+			op.startPhase(phaseNextflowResources)
+			nextflowKeyId, nextflowKeySecret, err = createNextflowResources(userName, container.NextflowConfig)
+			if err != nil {
+				getConfig().Logger.Printf("Error creating Nextflow AWS resources in AWS for user '%s': %v", userName, err)
+				err = fmt.Errorf("unable to create AWS resources for Nextflow: %v", err)
+				op.endPhase(phaseNextflowResources, err)
+				return nil, nil, err
+			}
+			op.endPhase(phaseNextflowResources, nil)
 		}
 		envVars = append(
 			envVars,
@@ -407,72 +881,56 @@ func launch(w http.ResponseWriter, r *http.Request) {
 		)
 		// TODO do we need to set AWS_DEFAULT_REGION too?
 	} else {
-		Config.Logger.Printf("Debug: Nextflow is not enabled: skipping Nextflow resources creation")
+		getConfig().Logger.Printf("Debug: Nextflow is not enabled: skipping Nextflow resources creation")
 	}
 
-	if Config.ContainersMap[hash].License.Enabled {
-		Config.Logger.Printf(
-			"Info: Running licensed workspace: %s", Config.ContainersMap[hash].License.WorkspaceFlavor)
+	if container.License.Enabled {
+		getConfig().Logger.Printf(
+			"Info: Running licensed workspace: %s", container.License.WorkspaceFlavor)
+		op.startPhase(phaseLicense)
 		dbconfig := initializeDbConfig()
-		activeGen3LicenseUsers, err := getActiveGen3LicenseUserMaps(dbconfig, Config.ContainersMap[hash])
+		activeGen3LicenseUsers, err := getActiveGen3LicenseUserMaps(dbconfig, container)
 		if err != nil {
-			Config.Logger.Printf(err.Error())
+			getConfig().Logger.Printf(err.Error())
 		}
 		// Check for config max
-		nextLicenseId := getNextLicenseId(activeGen3LicenseUsers, Config.ContainersMap[hash].License.MaxLicenseIds)
+		nextLicenseId := getNextLicenseId(activeGen3LicenseUsers, container.License.MaxLicenseIds)
 		if nextLicenseId == 0 {
-			Config.Logger.Printf("Error: no available license ids")
-			return
+			getConfig().Logger.Printf("Error: no available license ids")
+			err = errNoAvailableLicense
+			op.endPhase(phaseLicense, err)
+			return nil, nil, err
 		}
-		newItem, err := createGen3LicenseUserMap(dbconfig, userName, nextLicenseId, Config.ContainersMap[hash])
-		if err != nil {
-			Config.Logger.Printf(err.Error())
+		if dryRun {
+			// the license is only assigned when the workspace is launched
+			return envVars, envVarsEcs, nil
 		}
-		Config.Logger.Printf("Created new license-user-map item: %v", newItem)
-
-	}
-
-	allpaymodels, err := getPayModelsForUser(userName)
-	if err != nil {
-		Config.Logger.Printf(err.Error())
-	}
-	if allpaymodels == nil { // Commons with no concept of paymodels
-		err = createLocalK8sPod(r.Context(), hash, userName, accessToken, envVars)
-	} else {
-		payModel := allpaymodels.CurrentPayModel
-		if payModel == nil {
-			Config.Logger.Printf("Current Paymodel is not set. Launch forbidden for user %s", userName)
-			http.Error(w, "Current Paymodel is not set. Launch forbidden", http.StatusInternalServerError)
-			return
-		} else if payModel.Local {
-			err = createLocalK8sPod(r.Context(), hash, userName, accessToken, envVars)
-		} else if payModel.Ecs {
-
-			if payModel.Status != "active" {
-				// send 500 response.
-				// TODO: 403 is the correct code, but it triggers a 302 to the default 403 page in revproxy instead of showing error message.
-				Config.Logger.Printf("Paymodel is not active. Launch forbidden for user %s", userName)
-				http.Error(w, "Paymodel is not active. Launch forbidden", http.StatusInternalServerError)
-				return
-			}
-
-			Config.Logger.Printf("Launching ECS workspace for user %s", userName)
-			// Sending a 200 response straight away, but starting the launch in a goroutine
-			// TODO: Do more sanity checks before returning 200.
-			w.WriteHeader(http.StatusOK)
-			go launchEcsWorkspaceWrapper(userName, hash, accessToken, *payModel, envVarsEcs)
-			fmt.Fprintf(w, "Launch accepted")
-			return
+		newItem, err := createGen3LicenseUserMap(dbconfig, userName, workspaceName, nextLicenseId, container)
+		record := newAuditRecord(ctx, auditLicense, userName, workspaceName, hash)
+		record.Details = map[string]string{
+			"license_type": container.License.LicenseType,
+			"license_id":   strconv.Itoa(nextLicenseId),
+		}
+		record.setOutcome(err)
+		recordAudit(record)
+		if err != nil {
+			getConfig().Logger.Printf(err.Error())
 		} else {
-			err = createExternalK8sPod(r.Context(), hash, userName, accessToken, *payModel, envVars)
+			event := newEvent(eventLicenseAssigned, userName, workspaceName, hash)
+			if op != nil {
+				event.OperationID = op.ID
+			}
+			event.Details = map[string]string{
+				"license_type": newItem.LicenseType,
+				"license_id":   strconv.Itoa(newItem.LicenseId),
+			}
+			publishEvent(event)
 		}
+		getConfig().Logger.Printf("Created new license-user-map item: %v", newItem)
+		op.endPhase(phaseLicense, nil)
 	}
-	if err != nil {
-		Config.Logger.Printf("error during launch: %-v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	fmt.Fprintf(w, "Success")
+
+	return envVars, envVarsEcs, nil
 }
 
 func terminate(w http.ResponseWriter, r *http.Request) {
@@ -486,79 +944,164 @@ func terminate(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "No username found. Unable to terminate", http.StatusBadRequest)
 		return
 	}
-	Config.Logger.Printf("Terminating workspace for user %s", userName)
+	workspaceName, err := getWorkspaceName(r, userName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := terminateWorkspace(r.Context(), userName, workspaceName, accessToken)
+	if err != nil {
+		if errors.Is(err, errNamedWorkspaceOnEcs) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	fmt.Fprint(w, result)
+}
 
-	// mark any gen3-licensed sessions as inactive
-	Config.Logger.Printf("Checking for gen3 license items for user: %s", userName)
+var errNamedWorkspaceOnEcs = errors.New("Named workspaces are not supported with ECS pay models")
+
+var errNoAvailableLicense = errors.New("no available license ids")
+
+// releaseWorkspaceLicenses marks the workspace's gen3-licensed sessions as
+// inactive
+func releaseWorkspaceLicenses(userName string, workspaceName string) {
+	getConfig().Logger.Printf("Checking for gen3 license items for user: %s", userName)
 	dbconfig := initializeDbConfig()
 	activeGen3LicenseUsers, userlicerr := getLicenseUserMapsForUser(dbconfig, userName)
 	if userlicerr != nil {
-		Config.Logger.Printf(userlicerr.Error())
+		getConfig().Logger.Printf(userlicerr.Error())
 	}
-	Config.Logger.Printf("Debug: Active gen3 license user maps %v", activeGen3LicenseUsers)
+	getConfig().Logger.Printf("Debug: Active gen3 license user maps %v", activeGen3LicenseUsers)
 	if len(activeGen3LicenseUsers) == 0 {
-		Config.Logger.Printf("No active gen3 license sessions for user: %s", userName)
-	} else {
-		for _, v := range activeGen3LicenseUsers {
-			if v.UserId == userName {
-				Config.Logger.Printf("Debug: updating gen3 license user map as inactive for itemId %s", v.ItemId)
-				_, err := setGen3LicenseUserInactive(dbconfig, v.ItemId)
-				if err != nil {
-					Config.Logger.Printf(err.Error())
-				}
+		getConfig().Logger.Printf("No active gen3 license sessions for user: %s", userName)
+		return
+	}
+	for _, v := range activeGen3LicenseUsers {
+		// items created before named workspaces existed have no
+		// workspace name: they belong to the default workspace
+		if v.UserId == userName && v.WorkspaceName == workspaceName {
+			getConfig().Logger.Printf("Debug: updating gen3 license user map as inactive for itemId %s", v.ItemId)
+			_, err := setGen3LicenseUserInactive(dbconfig, v.ItemId)
+			if err != nil {
+				getConfig().Logger.Printf(err.Error())
 			}
 		}
 	}
+}
+
+// releaseWorkspaceResources releases the workspace's licenses and, if the
+// user has no other workspace, deletes the user's Nextflow resources. It
+// returns the names of the user's other workspaces. It is used when a
+// workspace is terminated and when its launch fails.
+var releaseWorkspaceResources = func(ctx context.Context, userName string, workspaceName string, accessToken string) []string {
+	releaseWorkspaceLicenses(userName, workspaceName)
+
+	// The Nextflow resources are shared by all the user's workspaces: only
+	// clean them up along with the last workspace
+	otherWorkspaces := []string{}
+	workspaceNames, err := getUserWorkspaceNames(ctx, userName, accessToken)
+	if err != nil {
+		getConfig().Logger.Printf("Unable to list the workspaces of user %s, assuming there are no others: %v", userName, err)
+	}
+	for _, name := range workspaceNames {
+		if name != workspaceName {
+			otherWorkspaces = append(otherWorkspaces, name)
+		}
+	}
 
 	// delete nextflow resources. There is no way to know if the actual workspace being
 	// terminated is a nextflow workspace or not, so always attempt to delete
-	Config.Logger.Printf("Info: Deleting Nextflow resources in AWS...")
-	err := cleanUpNextflowResources(userName)
-	if err != nil {
-		Config.Logger.Printf("Unable to delete AWS resources for Nextflow... continuing anyway")
+	if len(otherWorkspaces) == 0 {
+		getConfig().Logger.Printf("Info: Deleting Nextflow resources in AWS...")
+		err = cleanUpNextflowResources(userName)
+		if err != nil {
+			getConfig().Logger.Printf("Unable to delete AWS resources for Nextflow... continuing anyway")
+		}
+	} else {
+		getConfig().Logger.Printf("Info: User %s has other running workspaces %v: not deleting Nextflow resources", userName, otherWorkspaces)
 	}
+	return otherWorkspaces
+}
 
-	payModel, err := getCurrentPayModel(userName)
+// deleteWorkspaceCompute deletes the pod and service, or the ECS service,
+// the workspace runs on. The user volume is kept.
+func deleteWorkspaceCompute(ctx context.Context, userName string, workspaceName string, accessToken string, payModel *PayModel) (string, error) {
+	_, backend := getWorkspaceBackend(payModel)
+	return backend.Terminate(ctx, userName, workspaceName, accessToken, payModel)
+}
+
+// terminateWorkspace releases the workspace's licenses, deletes the Nextflow
+// resources if this is the user's last workspace, and terminates the
+// workspace with the pay model it was launched with. It is used by the
+// `/terminate` endpoint and by the background jobs that terminate
+// workspaces on the user's behalf, in which case `accessToken` is empty and
+// the workspace's API key is deleted with the key itself.
+var terminateWorkspace = func(ctx context.Context, userName string, workspaceName string, accessToken string) (result string, err error) {
+	getConfig().Logger.Printf("Terminating workspace '%s' for user %s", workspaceName, userName)
+
+	payModel, err := getWorkspacePayModel(userName, getWorkspacePayModelID(ctx, userName, workspaceName))
 	if err != nil {
-		Config.Logger.Printf(err.Error())
+		getConfig().Logger.Printf(err.Error())
 	}
-	if payModel != nil && payModel.Ecs {
-		_, err = terminateEcsWorkspace(r.Context(), userName, accessToken, payModel.AWSAccountId)
+	// read before the workspace's resources are deleted
+	containerID := getWorkspaceContainerID(ctx, userName, workspaceName)
+	// stopped workspaces have no compute left, only their record
+	stopped, err := getStoppedWorkspace(ctx, userName, workspaceName)
+	if err != nil {
+		getConfig().Logger.Printf("Unable to check if workspace '%s' of user %s is stopped, assuming it is not: %v", workspaceName, userName, err)
+	}
+	if stopped != nil {
+		containerID = resolveContainerID(stopped.ContainerID)
+	}
+
+	defer func() {
+		record := newAuditRecord(ctx, auditTerminate, userName, workspaceName, containerID)
+		if payModel != nil {
+			record.PayModelID = payModel.Id
+		}
+		record.setOutcome(err)
+		recordAudit(record)
+	}()
+	if _, backend := getWorkspaceBackend(payModel); workspaceName != "" && !backend.SupportsNamedWorkspaces() {
+		return "", errNamedWorkspaceOnEcs
+	}
+
+	// The current paymodel is shared by all the user's workspaces too: only
+	// reset it along with the last workspace
+	otherWorkspaces := releaseWorkspaceResources(ctx, userName, workspaceName, accessToken)
+
+	if stopped != nil {
+		err = deleteStoppedWorkspace(ctx, userName, workspaceName)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		} else {
-			Config.Logger.Printf("Succesfully terminated all resources related to ECS workspace for user %s", userName)
-			fmt.Fprintf(w, "Terminated ECS workspace")
+			return "", err
 		}
+		getConfig().Logger.Printf("Terminated stopped workspace '%s' for user %s", workspaceName, userName)
+		result = "Terminated stopped workspace"
 	} else {
-		err := deleteK8sPod(r.Context(), userName, accessToken, payModel)
+		result, err = deleteWorkspaceCompute(ctx, userName, workspaceName, accessToken, payModel)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return "", err
 		}
-		Config.Logger.Printf("Terminated workspace for user %s", userName)
-		fmt.Fprintf(w, "Terminated workspace")
+		releaseSlot(ctx, containerID, userName, workspaceName)
+		releaseWorkspaceReservation(ctx, userName, workspaceName)
 	}
+	recordTermination(containerID, backendForPayModel(payModel))
+	event := newEvent(eventWorkspaceTerminated, userName, workspaceName, containerID)
+	event.Backend = backendForPayModel(payModel)
+	publishEvent(event)
 
+	if len(otherWorkspaces) > 0 {
+		return result, nil
+	}
 	// Need to reset pay model only after workspace termination is completed.
-	go func() {
-		// Periodically poll for status, until it is set as "Not Found"
-		for {
-			status, err := getWorkspaceStatus(r.Context(), userName, accessToken)
-			if err != nil {
-				Config.Logger.Printf("error fetching workspace status for user %s\n err: %s", userName, err)
-			}
-			if status.Status == "Not Found" {
-				break
-			}
-			time.Sleep(5 * time.Second)
-		}
-		err = resetCurrentPaymodel(userName)
-		if err != nil {
-			Config.Logger.Printf("unable to reset current paymodel for current user %s\nerr: %s", userName, err)
-		}
-	}()
+	// This is a pending operation, so that it is resumed if hatchery
+	// restarts before the workspace is gone.
+	startPendingOperation(newPendingOperation(pendingPayModelReset, userName, workspaceName))
+	return result, nil
 }
 
 func getBearerToken(r *http.Request) string {
@@ -599,13 +1142,13 @@ func createECSCluster(w http.ResponseWriter, r *http.Request) {
 	var reader *strings.Reader
 	if err != nil {
 		reader = strings.NewReader(err.Error())
-		Config.Logger.Printf("Error: %s", err)
+		getConfig().Logger.Printf("Error: %s", err)
 	} else {
 		reader = strings.NewReader(result.String())
 	}
 	_, err = io.Copy(w, reader)
 	if err != nil {
-		Config.Logger.Printf("Error: %s", err)
+		getConfig().Logger.Printf("Error: %s", err)
 	}
 }
 
@@ -619,24 +1162,55 @@ var statusEcs = func(ctx context.Context, userName string, accessToken string, a
 	svc := NewSVC(sess, roleARN)
 	result, err := svc.statusEcsWorkspace(ctx, userName, accessToken)
 	if err != nil {
-		Config.Logger.Printf("Error: %s", err)
+		getConfig().Logger.Printf("Error: %s", err)
 		return nil, err
 	}
 	return result, nil
 }
 
-// Wrapper function to launch ECS workspace in a goroutine.
+// Wrapper function to launch ECS workspace in the background.
 // Terminates workspace if launch fails for whatever reason
-var launchEcsWorkspaceWrapper = func(userName string, hash string, accessToken string, payModel PayModel, envVars []EnvVar) {
-	err := launchEcsWorkspace(userName, hash, accessToken, payModel, envVars)
+var launchEcsWorkspaceWrapper = func(ctx context.Context, userName string, hash string, accessToken string, payModel PayModel, envVars []EnvVar) error {
+	op := operationFromContext(ctx)
+	// recorded until the launch is over, so that a launch interrupted by a
+	// restart is rolled back
+	pending := newPendingOperation(pendingEcsLaunch, userName, "")
+	pending.ContainerID = hash
+	pending.AWSAccountId = payModel.AWSAccountId
+	if op != nil {
+		pending.ID = op.ID
+	}
+	pendingOperations.start(pending)
+	err := launchEcsWorkspace(ctx, userName, hash, accessToken, payModel, envVars)
 	if err != nil {
-		Config.Logger.Printf("Error: %s", err)
+		getConfig().Logger.Printf("Error: %s", err)
+		// rolled back, rather than checked, if it is resumed after a restart
+		pendingOperations.update(pending, func(pending *PendingOperation) {
+			pending.RollBack = true
+		})
 		// Terminate ECS workspace if launch fails.
-		_, err = terminateEcsWorkspace(context.Background(), userName, accessToken, payModel.AWSAccountId)
-		if err != nil {
-			Config.Logger.Printf("Error: %s", err)
+		op.startPhase(phaseCleanup)
+		_, terr := terminateEcsWorkspace(ctx, userName, accessToken, payModel.AWSAccountId)
+		if terr != nil {
+			getConfig().Logger.Printf("Error: %s. Retrying the cleanup in the background", terr)
+			goBackground(func() {
+				_ = runPendingOperation(context.Background(), pending)
+			})
+		} else {
+			pendingOperations.finish(pending, false)
 		}
+		op.endPhase(phaseCleanup, terr)
+		err = fmt.Errorf("ECS workspace launch failed and the workspace was cleaned up: %v", err)
+		if op != nil {
+			publishLaunchFailed(op, err)
+		}
+		return err
+	}
+	pendingOperations.finish(pending, false)
+	if op != nil {
+		watchWorkspaceStartup(op)
 	}
+	return nil
 }
 
 // The files returned by this endpoint are mounted to the `/data` dir by the `ecs-ws-sidecar`
@@ -674,7 +1248,7 @@ func mountFiles(w http.ResponseWriter, r *http.Request) {
 		WorkspaceFlavor: "nextflow",
 	})
 	// Look for any `license` configs in containers
-	for _, v := range Config.ContainersMap {
+	for _, v := range getConfig().ContainersMap {
 		if v.License.Enabled {
 			fileList = append(fileList, file{
 				FilePath:        v.License.FilePath,
@@ -695,14 +1269,14 @@ func mountFiles(w http.ResponseWriter, r *http.Request) {
 func getMountFileContents(fileId string, userName string) (string, error) {
 	filePathConfigs, err := getLicenceFilePathConfigs()
 	if err != nil {
-		Config.Logger.Printf("unable to get filepaths from config: %v", err)
+		getConfig().Logger.Printf("unable to get filepaths from config: %v", err)
 		return "", err
 	}
 
 	if fileId == "sample-nextflow-config.txt" {
 		out, err := generateNextflowConfig(userName)
 		if err != nil {
-			Config.Logger.Printf("unable to generate Nextflow config: %v", err)
+			getConfig().Logger.Printf("unable to generate Nextflow config: %v", err)
 		}
 		return out, nil
 	} else if filePathInLicenseConfigs(fileId, filePathConfigs) {
@@ -713,11 +1287,11 @@ func getMountFileContents(fileId string, userName string) (string, error) {
 		}
 		clientset, err := getKubeClientSet()
 		if err != nil {
-			Config.Logger.Printf("unable to get kube client set: %v", err)
+			getConfig().Logger.Printf("unable to get kube client set: %v", err)
 		}
 		out, err := getLicenseFromKubernetes(clientset, g3autoName, g3autoKey)
 		if err != nil {
-			Config.Logger.Printf("unable to get license from kubernetes: %v", err)
+			getConfig().Logger.Printf("unable to get license from kubernetes: %v", err)
 		}
 		return out, nil
 	} else {
@@ -2,11 +2,13 @@ package hatchery
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
@@ -106,7 +108,7 @@ func Test_GetWorkspaceStatus(t *testing.T) {
 		t.Logf("Testing GetWorkspaceStatus when %s", testcase.name)
 		/* Setup */
 
-		statusK8sPod = func(context.Context, string, string, *PayModel) (*WorkspaceStatus, error) {
+		statusK8sPod = func(context.Context, string, string, string, *PayModel) (*WorkspaceStatus, error) {
 			return mockStatusK8sPod, nil
 		}
 
@@ -118,7 +120,7 @@ func Test_GetWorkspaceStatus(t *testing.T) {
 		}
 		/* Act */
 		ctx := context.Background()
-		got, err := getWorkspaceStatus(ctx, "testUser", "access_token")
+		got, err := getWorkspaceStatus(ctx, "testUser", "", "access_token")
 		if nil != err {
 			t.Errorf("failed to load workspace status, got: %v", err)
 			return
@@ -153,6 +155,7 @@ func Test_SetpaymodelEndpoint(t *testing.T) {
 		wantStatus          int
 		mockRequest         *RequestBody
 		currentStatus       *WorkspaceStatus
+		workspaceNames      []string
 	}{
 		{
 			name:       "MethodIsNotPost",
@@ -182,6 +185,17 @@ func Test_SetpaymodelEndpoint(t *testing.T) {
 			},
 			currentStatus: &WorkspaceStatus{Status: "Running"},
 		},
+		{
+			name:       "NamedWorkspaceRunning",
+			want:       "Can not update paymodel when workspace is running",
+			wantStatus: http.StatusInternalServerError,
+			mockRequest: &RequestBody{
+				Method: "POST",
+				id:     "random_id",
+			},
+			currentStatus:  &WorkspaceStatus{Status: "Not Found"},
+			workspaceNames: []string{"rstudio"},
+		},
 		{
 			name:       "StatusAsNotFound",
 			want:       "{\"bmh_workspace_id\":\"mock_current_paymodel\",\"workspace_type\":\"\",\"user_id\":\"\",\"account_id\":\"\",\"request_status\":\"\",\"local\":false,\"region\":\"\",\"ecs\":false,\"subnet\":0,\"hard-limit\":0,\"soft-limit\":0,\"total-usage\":0,\"current_pay_model\":true}",
@@ -196,10 +210,12 @@ func Test_SetpaymodelEndpoint(t *testing.T) {
 
 	// Backing up original functions before mocking
 	original_getWorkspaceStatus := getWorkspaceStatus
+	original_getUserWorkspaceNames := getUserWorkspaceNames
 	original_setCurrentPaymodel := setCurrentPaymodel
 	defer func() {
 		// restore original functions
 		getWorkspaceStatus = original_getWorkspaceStatus
+		getUserWorkspaceNames = original_getUserWorkspaceNames
 		setCurrentPaymodel = original_setCurrentPaymodel
 	}()
 
@@ -207,9 +223,12 @@ func Test_SetpaymodelEndpoint(t *testing.T) {
 		t.Logf("Testing SetPaymodels when %s", testcase.name)
 
 		/* Setup */
-		getWorkspaceStatus = func(context.Context, string, string) (*WorkspaceStatus, error) {
+		getWorkspaceStatus = func(context.Context, string, string, string) (*WorkspaceStatus, error) {
 			return testcase.currentStatus, nil
 		}
+		getUserWorkspaceNames = func(context.Context, string, string) ([]string, error) {
+			return testcase.workspaceNames, nil
+		}
 		setCurrentPaymodel = func(string, string) (*PayModel, error) {
 			return &PayModel{
 				Id:              "mock_current_paymodel",
@@ -268,6 +287,7 @@ func Test_ResetpaymodelsEndpoint(t *testing.T) {
 		mockRequest         *RequestBody
 		throwError          bool
 		currentStatus       *WorkspaceStatus
+		workspaceNames      []string
 	}{
 		{
 			name:       "MethodIsNotPost",
@@ -288,6 +308,16 @@ func Test_ResetpaymodelsEndpoint(t *testing.T) {
 			},
 			currentStatus: &WorkspaceStatus{Status: "Running"},
 		},
+		{
+			name:       "NamedWorkspaceRunning",
+			want:       "Can not reset paymodels when workspace is running",
+			wantStatus: http.StatusInternalServerError,
+			mockRequest: &RequestBody{
+				Method: "POST",
+			},
+			currentStatus:  &WorkspaceStatus{Status: "Not Found"},
+			workspaceNames: []string{"rstudio"},
+		},
 		{
 			name:       "StatusAsNotFound",
 			want:       "Current Paymodel has been reset",
@@ -311,10 +341,12 @@ func Test_ResetpaymodelsEndpoint(t *testing.T) {
 
 	// Backing up original functions before mocking
 	original_getWorkspaceStatus := getWorkspaceStatus
+	original_getUserWorkspaceNames := getUserWorkspaceNames
 	original_resetCurrentPaymodel := resetCurrentPaymodel
 	defer func() {
 		// restore original functions
 		getWorkspaceStatus = original_getWorkspaceStatus
+		getUserWorkspaceNames = original_getUserWorkspaceNames
 		resetCurrentPaymodel = original_resetCurrentPaymodel
 	}()
 
@@ -322,9 +354,12 @@ func Test_ResetpaymodelsEndpoint(t *testing.T) {
 		t.Logf("Testing ResetPaymodels when %s", testcase.name)
 
 		/* Setup */
-		getWorkspaceStatus = func(ctx context.Context, userName string, accessToken string) (*WorkspaceStatus, error) {
+		getWorkspaceStatus = func(ctx context.Context, userName string, workspaceName string, accessToken string) (*WorkspaceStatus, error) {
 			return testcase.currentStatus, nil
 		}
+		getUserWorkspaceNames = func(context.Context, string, string) ([]string, error) {
+			return testcase.workspaceNames, nil
+		}
 		resetCurrentPaymodel = func(string) error {
 			if testcase.throwError {
 				return errors.New("unable to set paymodel")
@@ -364,6 +399,7 @@ func Test_ResetpaymodelsEndpoint(t *testing.T) {
 		* r.Method not being post must return an error
 		* id being empty should return in an error
 	* mock getPayModelsForUser
+	* successful requests return an operation, and the launch runs in the background
 		* allPayModels = nil, createLocalK8sPod must be called once
 		* allPayModels.CurrentPayModel = nil, InternalServerError is thrown
 		* allPayModels.CurrentPayModel.Local = true, createLocalK8sPod must be called once
@@ -375,9 +411,10 @@ func Test_LaunchEndpoint(t *testing.T) {
 	defer SetupAndTeardownTest()()
 
 	type RequestBody struct {
-		Method   string
-		id       string
-		username string
+		Method    string
+		id        string
+		username  string
+		workspace string
 	}
 	testCases := []struct {
 		name                string
@@ -387,7 +424,10 @@ func Test_LaunchEndpoint(t *testing.T) {
 		mockRequest         *RequestBody
 		throwError          bool
 		payModelsForUser    *AllPayModels
+		workspaceNames      []string
 		calledFunctionName  string
+		wantOperationStatus string
+		wantOperationError  string
 	}{
 		{
 			name:       "MethodIsNotPost",
@@ -416,9 +456,9 @@ func Test_LaunchEndpoint(t *testing.T) {
 			},
 		},
 		{
-			name:       "NoPayModelsForUser",
-			want:       "Success",
-			wantStatus: http.StatusOK,
+			name:                "NoPayModelsForUser",
+			wantStatus:          http.StatusOK,
+			wantOperationStatus: operationSucceeded,
 			mockRequest: &RequestBody{
 				Method:   "POST",
 				id:       "random_id",
@@ -441,9 +481,9 @@ func Test_LaunchEndpoint(t *testing.T) {
 			},
 		},
 		{
-			name:       "LocalCurrentPayModelExists",
-			want:       "Success",
-			wantStatus: http.StatusOK,
+			name:                "LocalCurrentPayModelExists",
+			wantStatus:          http.StatusOK,
+			wantOperationStatus: operationSucceeded,
 			mockRequest: &RequestBody{
 				Method:   "POST",
 				id:       "random_id",
@@ -468,9 +508,9 @@ func Test_LaunchEndpoint(t *testing.T) {
 			},
 		},
 		{
-			name:       "ActiveEcsCurrentPayModelExists",
-			want:       "Launch accepted",
-			wantStatus: http.StatusOK,
+			name:                "ActiveEcsCurrentPayModelExists",
+			wantStatus:          http.StatusOK,
+			wantOperationStatus: operationSucceeded,
 			mockRequest: &RequestBody{
 				Method:   "POST",
 				id:       "random_id",
@@ -482,9 +522,9 @@ func Test_LaunchEndpoint(t *testing.T) {
 			calledFunctionName: "launchEcsWorkspaceWrapper",
 		},
 		{
-			name:       "NeitherLocalNorEcsPaymodelExists",
-			want:       "Success",
-			wantStatus: http.StatusOK,
+			name:                "NeitherLocalNorEcsPaymodelExists",
+			wantStatus:          http.StatusOK,
+			wantOperationStatus: operationSucceeded,
 			mockRequest: &RequestBody{
 				Method:   "POST",
 				id:       "random_id",
@@ -496,9 +536,10 @@ func Test_LaunchEndpoint(t *testing.T) {
 			calledFunctionName: "createExternalK8sPod",
 		},
 		{
-			name:       "createLocalK8sPodFailure",
-			want:       "error creating local k8s pod",
-			wantStatus: http.StatusInternalServerError,
+			name:                "createLocalK8sPodFailure",
+			wantStatus:          http.StatusOK,
+			wantOperationStatus: operationFailed,
+			wantOperationError:  "error creating local k8s pod",
 			mockRequest: &RequestBody{
 				Method:   "POST",
 				id:       "random_id",
@@ -509,9 +550,10 @@ func Test_LaunchEndpoint(t *testing.T) {
 			calledFunctionName: "createLocalK8sPod",
 		},
 		{
-			name:       "createExternalK8sPodFailure",
-			want:       "error creating external k8s pod",
-			wantStatus: http.StatusInternalServerError,
+			name:                "createExternalK8sPodFailure",
+			wantStatus:          http.StatusOK,
+			wantOperationStatus: operationFailed,
+			wantOperationError:  "error creating external k8s pod",
 			mockRequest: &RequestBody{
 				Method:   "POST",
 				id:       "random_id",
@@ -523,6 +565,68 @@ func Test_LaunchEndpoint(t *testing.T) {
 			},
 			calledFunctionName: "createExternalK8sPod",
 		},
+		{
+			name:       "InvalidWorkspaceName",
+			want:       "invalid 'workspace' parameter 'My_Workspace': workspace names must be 1 to 20 lowercase letters, digits or '-', and start and end with a letter or digit",
+			wantStatus: http.StatusBadRequest,
+			mockRequest: &RequestBody{
+				Method:    "POST",
+				id:        "random_id",
+				username:  "testUser",
+				workspace: "My_Workspace",
+			},
+		},
+		{
+			name:                "NamedWorkspace",
+			wantStatus:          http.StatusOK,
+			wantOperationStatus: operationSucceeded,
+			mockRequest: &RequestBody{
+				Method:    "POST",
+				id:        "random_id",
+				username:  "testUser",
+				workspace: "rstudio",
+			},
+			workspaceNames:     []string{""},
+			calledFunctionName: "createLocalK8sPod",
+		},
+		{
+			name:       "WorkspaceAlreadyRunning",
+			want:       "A workspace with this name is already running",
+			wantStatus: http.StatusConflict,
+			mockRequest: &RequestBody{
+				Method:    "POST",
+				id:        "random_id",
+				username:  "testUser",
+				workspace: "rstudio",
+			},
+			workspaceNames: []string{"rstudio"},
+		},
+		{
+			name:       "MaxWorkspacesReached",
+			want:       "Maximum number of workspaces per user (2) reached. Launch forbidden",
+			wantStatus: http.StatusConflict,
+			mockRequest: &RequestBody{
+				Method:    "POST",
+				id:        "random_id",
+				username:  "testUser",
+				workspace: "jupyter",
+			},
+			workspaceNames: []string{"", "rstudio"},
+		},
+		{
+			name:       "NamedWorkspaceWithEcsPayModel",
+			want:       "Named workspaces are not supported with ECS pay models",
+			wantStatus: http.StatusBadRequest,
+			mockRequest: &RequestBody{
+				Method:    "POST",
+				id:        "random_id",
+				username:  "testUser",
+				workspace: "rstudio",
+			},
+			payModelsForUser: &AllPayModels{
+				CurrentPayModel: &PayModel{Ecs: true, Status: "active"},
+			},
+		},
 	}
 
 	// Backing up original functions before patching
@@ -530,15 +634,31 @@ func Test_LaunchEndpoint(t *testing.T) {
 	original_launchEcsWorkspaceWrapper := launchEcsWorkspaceWrapper
 	original_createExternalK8sPod := createExternalK8sPod
 	original_getPayModelsForUser := getPayModelsForUser
+	original_listK8sWorkspaceNames := listK8sWorkspaceNames
+	original_reserveWorkspace := reserveWorkspace
+	original_listStoppedWorkspaceNames := listStoppedWorkspaceNames
+	original_maxWorkspacesPerUser := getConfig().Config.MaxWorkspacesPerUser
 	defer func() {
 		// restore original functions
 		createLocalK8sPod = original_createLocalK8sPod
 		launchEcsWorkspaceWrapper = original_launchEcsWorkspaceWrapper
 		createExternalK8sPod = original_createExternalK8sPod
 		getPayModelsForUser = original_getPayModelsForUser
+		listK8sWorkspaceNames = original_listK8sWorkspaceNames
+		reserveWorkspace = original_reserveWorkspace
+		listStoppedWorkspaceNames = original_listStoppedWorkspaceNames
+		getConfig().Config.MaxWorkspacesPerUser = original_maxWorkspacesPerUser
 	}()
+	reserveWorkspace = func(context.Context, string, string, []string) error {
+		return nil
+	}
+
+	getConfig().Config.MaxWorkspacesPerUser = 2
+	listStoppedWorkspaceNames = func(context.Context, string) ([]string, error) {
+		return []string{}, nil
+	}
 
-	Config.ContainersMap = map[string]Container{
+	getConfig().ContainersMap = map[string]Container{
 		"random_id": {
 			Name: "Hatchery test container",
 		},
@@ -547,27 +667,27 @@ func Test_LaunchEndpoint(t *testing.T) {
 	for _, testcase := range testCases {
 		t.Logf("Testing Launch Endpoint when %s", testcase.name)
 
-		// waitGroup is needed since one of the mocked methods is called as a go routine internally
-		var waitGroup sync.WaitGroup
-
 		FuncCounter := map[string]int{
 			"createLocalK8sPod":         0,
 			"launchEcsWorkspaceWrapper": 0,
 			"createExternalK8sPod":      0,
 		}
 
-		createLocalK8sPod = func(ctx context.Context, hash, userName, accessToken string, envVars []k8sv1.EnvVar) error {
+		createLocalK8sPod = func(ctx context.Context, hash, userName, workspaceName, accessToken string, envVars []k8sv1.EnvVar) error {
 			FuncCounter["createLocalK8sPod"] += 1
 			if testcase.throwError {
 				return errors.New("error creating local k8s pod")
 			}
+			if workspaceName != testcase.mockRequest.workspace {
+				return fmt.Errorf("unexpected workspace name '%s'", workspaceName)
+			}
 			return nil
 		}
-		launchEcsWorkspaceWrapper = func(userName, hash, accessToken string, payModel PayModel, envVars []EnvVar) {
+		launchEcsWorkspaceWrapper = func(ctx context.Context, userName, hash, accessToken string, payModel PayModel, envVars []EnvVar) error {
 			FuncCounter["launchEcsWorkspaceWrapper"] += 1
-			waitGroup.Done() // Assertions are blocked until this line is completed
+			return nil
 		}
-		createExternalK8sPod = func(ctx context.Context, hash, userName, accessToken string, payModel PayModel, envVars []k8sv1.EnvVar) error {
+		createExternalK8sPod = func(ctx context.Context, hash, userName, workspaceName, accessToken string, payModel PayModel, envVars []k8sv1.EnvVar) error {
 			FuncCounter["createExternalK8sPod"] += 1
 			if testcase.throwError {
 				return errors.New("error creating external k8s pod")
@@ -578,9 +698,15 @@ func Test_LaunchEndpoint(t *testing.T) {
 		getPayModelsForUser = func(userName string) (result *AllPayModels, err error) {
 			return testcase.payModelsForUser, nil
 		}
+		listK8sWorkspaceNames = func(context.Context, string, *PayModel) ([]string, error) {
+			return testcase.workspaceNames, nil
+		}
 		url := "/launch"
 		if testcase.mockRequest.id != "" {
 			url = "/launch?id=" + testcase.mockRequest.id
+			if testcase.mockRequest.workspace != "" {
+				url += "&workspace=" + testcase.mockRequest.workspace
+			}
 		}
 		req, err := http.NewRequest(testcase.mockRequest.Method, url, nil)
 		if testcase.mockRequest.username != "" {
@@ -593,24 +719,37 @@ func Test_LaunchEndpoint(t *testing.T) {
 		w := httptest.NewRecorder()
 
 		/* Act */
-		if testcase.calledFunctionName == "launchEcsWorkspaceWrapper" {
-			// Since launchEcsWorkspaceWrapper is called as a go routine internally, we say
-			// we say there is, in this test there is one goroutine that we'd like to wait for during execution
-			waitGroup.Add(1)
-		}
 		handler := http.HandlerFunc(launch)
 		handler.ServeHTTP(w, req)
 
 		/* Assert */
-		waitGroup.Wait() // we wait for any go routines to finish before making assertions
 		if testcase.wantStatus != w.Code {
 			t.Errorf("handler returned wrong status code:\ngot: '%v'\nwant: '%v'",
 				w.Code, testcase.wantStatus)
 		}
 
-		if testcase.want != strings.TrimSpace(w.Body.String()) {
-			t.Errorf("handler returned wrong response:\ngot: '%v'\nwant: '%v'",
-				w.Body.String(), testcase.want)
+		if testcase.wantOperationStatus == "" {
+			if testcase.want != strings.TrimSpace(w.Body.String()) {
+				t.Errorf("handler returned wrong response:\ngot: '%v'\nwant: '%v'",
+					w.Body.String(), testcase.want)
+			}
+		} else {
+			// the launch runs in the background: wait for the operation to finish
+			var returnedOp Operation
+			err = json.Unmarshal(w.Body.Bytes(), &returnedOp)
+			if err != nil {
+				t.Fatalf("handler did not return an operation: '%v'", w.Body.String())
+			}
+			op, ok := operations.get(returnedOp.ID)
+			if !ok {
+				t.Fatalf("operation '%s' was not registered", returnedOp.ID)
+			}
+			op.wait()
+			got := op.snapshot()
+			if got.Status != testcase.wantOperationStatus || got.Error != testcase.wantOperationError {
+				t.Errorf("operation has the wrong outcome:\ngot: '%v' '%v'\nwant: '%v' '%v'",
+					got.Status, got.Error, testcase.wantOperationStatus, testcase.wantOperationError)
+			}
 		}
 
 		for functionName, functionCallCounter := range FuncCounter {
@@ -626,10 +765,129 @@ func Test_LaunchEndpoint(t *testing.T) {
 	}
 }
 
+func TestLaunchFailureReleasesLicense(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_isUserAuthorizedForContainer := isUserAuthorizedForContainer
+	original_getPayModelsForUser := getPayModelsForUser
+	original_listK8sWorkspaceNames := listK8sWorkspaceNames
+	original_reserveWorkspace := reserveWorkspace
+	original_createLocalK8sPod := createLocalK8sPod
+	original_initializeDbConfig := initializeDbConfig
+	original_getActiveGen3LicenseUserMaps := getActiveGen3LicenseUserMaps
+	original_createGen3LicenseUserMap := createGen3LicenseUserMap
+	original_getLicenseUserMapsForUser := getLicenseUserMapsForUser
+	original_setGen3LicenseUserInactive := setGen3LicenseUserInactive
+	original_getUserWorkspaceNames := getUserWorkspaceNames
+	original_listStoppedWorkspaceNames := listStoppedWorkspaceNames
+	defer func() {
+		SetConfig(original_config)
+		isUserAuthorizedForContainer = original_isUserAuthorizedForContainer
+		getPayModelsForUser = original_getPayModelsForUser
+		listStoppedWorkspaceNames = original_listStoppedWorkspaceNames
+		listK8sWorkspaceNames = original_listK8sWorkspaceNames
+		reserveWorkspace = original_reserveWorkspace
+		createLocalK8sPod = original_createLocalK8sPod
+		initializeDbConfig = original_initializeDbConfig
+		getActiveGen3LicenseUserMaps = original_getActiveGen3LicenseUserMaps
+		createGen3LicenseUserMap = original_createGen3LicenseUserMap
+		getLicenseUserMapsForUser = original_getLicenseUserMapsForUser
+		setGen3LicenseUserInactive = original_setGen3LicenseUserInactive
+		getUserWorkspaceNames = original_getUserWorkspaceNames
+	}()
+	reserveWorkspace = func(context.Context, string, string, []string) error {
+		return nil
+	}
+
+	SetConfig(&FullHatcheryConfig{Logger: getConfig().Logger})
+	getConfig().ContainersMap = map[string]Container{
+		"stata": {Name: "Stata", License: LicenseInfo{Enabled: true, LicenseType: "STATA", MaxLicenseIds: 2}},
+	}
+	getConfig().Config.MaxWorkspacesPerUser = 2
+	isUserAuthorizedForContainer = func(string, string, Container) (bool, error) {
+		return true, nil
+	}
+	getPayModelsForUser = func(string) (*AllPayModels, error) {
+		return nil, nil
+	}
+	listStoppedWorkspaceNames = func(context.Context, string) ([]string, error) {
+		return []string{}, nil
+	}
+	listK8sWorkspaceNames = func(context.Context, string, *PayModel) ([]string, error) {
+		return []string{}, nil
+	}
+	initializeDbConfig = func() *DbConfig {
+		return &DbConfig{}
+	}
+	getActiveGen3LicenseUserMaps = func(*DbConfig, Container) ([]Gen3LicenseUserMap, error) {
+		return []Gen3LicenseUserMap{}, nil
+	}
+	assigned := Gen3LicenseUserMap{ItemId: "item-1", UserId: "testUser", WorkspaceName: "stata", LicenseType: "STATA", LicenseId: 1, IsActive: "True"}
+	createGen3LicenseUserMap = func(*DbConfig, string, string, int, Container) (Gen3LicenseUserMap, error) {
+		return assigned, nil
+	}
+	getLicenseUserMapsForUser = func(*DbConfig, string) ([]Gen3LicenseUserMap, error) {
+		return []Gen3LicenseUserMap{assigned}, nil
+	}
+	// another workspace is running: the Nextflow resources are kept
+	getUserWorkspaceNames = func(context.Context, string, string) ([]string, error) {
+		return []string{"", "stata"}, nil
+	}
+
+	testCases := []struct {
+		name         string
+		launchErr    error
+		wantReleased bool
+	}{
+		{name: "LaunchSucceeded"},
+		{name: "LaunchFailed", launchErr: errors.New("unable to create the pod"), wantReleased: true},
+	}
+	for _, testcase := range testCases {
+		released := []string{}
+		setGen3LicenseUserInactive = func(dbconfig *DbConfig, itemId string) (Gen3LicenseUserMap, error) {
+			released = append(released, itemId)
+			return Gen3LicenseUserMap{}, nil
+		}
+		createLocalK8sPod = func(ctx context.Context, hash, userName, workspaceName, accessToken string, envVars []k8sv1.EnvVar) error {
+			return testcase.launchErr
+		}
+
+		req, err := http.NewRequest("POST", "/launch?id=stata&workspace=stata", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("REMOTE_USER", "testUser")
+		w := httptest.NewRecorder()
+		http.HandlerFunc(launch).ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code when %s: got %v: %v", testcase.name, w.Code, w.Body.String())
+		}
+		var returnedOp Operation
+		if err := json.Unmarshal(w.Body.Bytes(), &returnedOp); err != nil {
+			t.Fatalf("handler did not return an operation: '%v'", w.Body.String())
+		}
+		op, ok := operations.get(returnedOp.ID)
+		if !ok {
+			t.Fatalf("operation '%s' was not registered", returnedOp.ID)
+		}
+		op.wait()
+
+		// the license seat of a failed launch is given back
+		wantReleased := []string{}
+		if testcase.wantReleased {
+			wantReleased = []string{assigned.ItemId}
+		}
+		if !reflect.DeepEqual(released, wantReleased) {
+			t.Errorf("wrong licenses released when %s:\ngot: %v\nwant: %v", testcase.name, released, wantReleased)
+		}
+	}
+}
+
 func TestLaunchEndpointAuthorization(t *testing.T) {
 	defer SetupAndTeardownTest()()
 
-	Config.ContainersMap = map[string]Container{
+	getConfig().ContainersMap = map[string]Container{
 		"container_a": {
 			Name: "Container without authz (accessible by default)",
 		},
@@ -663,16 +921,34 @@ func TestLaunchEndpointAuthorization(t *testing.T) {
 	}
 	// mock the pod launch
 	originalCreateLocalK8sPod := createLocalK8sPod
-	createLocalK8sPod = func(ctx context.Context, hash, userName, accessToken string, envVars []k8sv1.EnvVar) error {
+	createLocalK8sPod = func(ctx context.Context, hash, userName, workspaceName, accessToken string, envVars []k8sv1.EnvVar) error {
+		return nil
+	}
+	originalListK8sWorkspaceNames := listK8sWorkspaceNames
+	listK8sWorkspaceNames = func(context.Context, string, *PayModel) ([]string, error) {
+		return []string{}, nil
+	}
+	originalReserveWorkspace := reserveWorkspace
+	reserveWorkspace = func(context.Context, string, string, []string) error {
 		return nil
 	}
+	originalListStoppedWorkspaceNames := listStoppedWorkspaceNames
+	listStoppedWorkspaceNames = func(context.Context, string) ([]string, error) {
+		return []string{}, nil
+	}
+	originalMaxWorkspacesPerUser := getConfig().Config.MaxWorkspacesPerUser
+	getConfig().Config.MaxWorkspacesPerUser = 1
 	defer func() {
 		// restore original functions
 		isUserAuthorizedForContainer = originalIsUserAuthorizedForContainer
 		createLocalK8sPod = originalCreateLocalK8sPod
+		listK8sWorkspaceNames = originalListK8sWorkspaceNames
+		reserveWorkspace = originalReserveWorkspace
+		listStoppedWorkspaceNames = originalListStoppedWorkspaceNames
+		getConfig().Config.MaxWorkspacesPerUser = originalMaxWorkspacesPerUser
 	}()
 
-	for containerId, container := range Config.ContainersMap {
+	for containerId, container := range getConfig().ContainersMap {
 		t.Logf("Running test case: '%s'", container.Name)
 
 		url := fmt.Sprintf("/launch?id=%s", containerId)
@@ -686,7 +962,7 @@ func TestLaunchEndpointAuthorization(t *testing.T) {
 		handler := http.HandlerFunc(launch)
 		handler.ServeHTTP(w, req)
 
-		if !strings.Contains(container.Name, "cannot") && (w.Code != 200 || w.Body.String() != "Success") {
+		if !strings.Contains(container.Name, "cannot") && (w.Code != 200 || !strings.Contains(w.Body.String(), "\"type\":\"launch\"")) {
 			t.Errorf("The /launch endpoint should have allowed launching an authorized container, but it didn't: %v %v", w.Code, w.Body)
 			return
 		}
@@ -694,6 +970,15 @@ func TestLaunchEndpointAuthorization(t *testing.T) {
 			t.Errorf("The /launch endpoint should not have allowed launching an unauthorized container, but it did: %v %v", w.Code, w.Body)
 			return
 		}
+		if w.Code == 200 {
+			// wait for the background launch to finish before restoring the mocks
+			var returnedOp Operation
+			if err := json.Unmarshal(w.Body.Bytes(), &returnedOp); err == nil {
+				if op, ok := operations.get(returnedOp.ID); ok {
+					op.wait()
+				}
+			}
+		}
 	}
 }
 
@@ -718,8 +1003,9 @@ func Test_TerminateEndpoint(t *testing.T) {
 	defer SetupAndTeardownTest()()
 
 	type RequestBody struct {
-		Method   string
-		username string
+		Method    string
+		username  string
+		workspace string
 	}
 	testCases := []struct {
 		name                string
@@ -727,6 +1013,7 @@ func Test_TerminateEndpoint(t *testing.T) {
 		wantStatus          int
 		mockRequest         *RequestBody
 		mockCurrentPayModel *PayModel
+		workspaceNames      []string
 		waitToTerminate     bool
 		throwError          bool
 		calledFunctionName  string
@@ -828,6 +1115,30 @@ func Test_TerminateEndpoint(t *testing.T) {
 			waitToTerminate:     true,
 			calledFunctionName:  "terminateEcsWorkspace",
 		},
+		{
+			name:       "NamedWorkspaceWithOtherWorkspacesRunning",
+			want:       "Terminated workspace",
+			wantStatus: http.StatusOK,
+			mockRequest: &RequestBody{
+				Method:    "POST",
+				username:  "testUser",
+				workspace: "rstudio",
+			},
+			mockCurrentPayModel: &PayModel{Ecs: false},
+			workspaceNames:      []string{"", "rstudio"},
+			calledFunctionName:  "deleteK8sPod",
+		},
+		{
+			name:       "NamedWorkspaceWithEcsPayModel",
+			want:       "Named workspaces are not supported with ECS pay models",
+			wantStatus: http.StatusBadRequest,
+			mockRequest: &RequestBody{
+				Method:    "POST",
+				username:  "testUser",
+				workspace: "rstudio",
+			},
+			mockCurrentPayModel: &PayModel{Ecs: true},
+		},
 	}
 
 	// Backing up original functions before patching
@@ -836,6 +1147,7 @@ func Test_TerminateEndpoint(t *testing.T) {
 	original_getCurrentPayModel := getCurrentPayModel
 	original_getLicenseUserMapsForUser := getLicenseUserMapsForUser
 	original_getWorkspaceStatus := getWorkspaceStatus
+	original_getUserWorkspaceNames := getUserWorkspaceNames
 	original_resetCurrentPaymodel := resetCurrentPaymodel
 	defer func() {
 		// restore original functions
@@ -844,6 +1156,7 @@ func Test_TerminateEndpoint(t *testing.T) {
 		getCurrentPayModel = original_getCurrentPayModel
 		getLicenseUserMapsForUser = original_getLicenseUserMapsForUser
 		getWorkspaceStatus = original_getWorkspaceStatus
+		getUserWorkspaceNames = original_getUserWorkspaceNames
 		resetCurrentPaymodel = original_resetCurrentPaymodel
 	}()
 
@@ -853,7 +1166,8 @@ func Test_TerminateEndpoint(t *testing.T) {
 		/* Setup */
 		workspaceTerminationPending := testcase.waitToTerminate
 		workspaceStatusCallCounter := 0
-		goRoutineCalled := testcase.calledFunctionName != "" && !testcase.throwError
+		// the paymodel is only reset when the user's last workspace is terminated
+		goRoutineCalled := testcase.calledFunctionName != "" && !testcase.throwError && len(testcase.workspaceNames) <= 1
 
 		// waitGroup is needed since one of the mocked methods is called as a go routine internally
 		var waitGroup sync.WaitGroup
@@ -862,11 +1176,14 @@ func Test_TerminateEndpoint(t *testing.T) {
 			"deleteK8sPod":          0,
 			"terminateEcsWorkspace": 0,
 		}
-		deleteK8sPod = func(ctx context.Context, userName, accessToken string, payModelPtr *PayModel) error {
+		deleteK8sPod = func(ctx context.Context, userName, workspaceName, accessToken string, payModelPtr *PayModel) error {
 			FuncCounter["deleteK8sPod"] += 1
 			if testcase.throwError {
 				return errors.New("error deleting k8s pod")
 			}
+			if workspaceName != testcase.mockRequest.workspace {
+				return fmt.Errorf("unexpected workspace name '%s'", workspaceName)
+			}
 			return nil
 		}
 		terminateEcsWorkspace = func(ctx context.Context, userName, accessToken, awsAcctID string) (string, error) {
@@ -882,7 +1199,11 @@ func Test_TerminateEndpoint(t *testing.T) {
 			return testcase.mockCurrentPayModel, nil
 		}
 
-		getWorkspaceStatus = func(context.Context, string, string) (*WorkspaceStatus, error) {
+		getUserWorkspaceNames = func(context.Context, string, string) ([]string, error) {
+			return testcase.workspaceNames, nil
+		}
+
+		getWorkspaceStatus = func(context.Context, string, string, string) (*WorkspaceStatus, error) {
 			workspaceStatusCallCounter += 1
 			if workspaceTerminationPending {
 				// we assume that the workspace is terminated by the time this fucntion is called again
@@ -903,6 +1224,9 @@ func Test_TerminateEndpoint(t *testing.T) {
 		}
 
 		url := "/terminate"
+		if testcase.mockRequest.workspace != "" {
+			url += "?workspace=" + testcase.mockRequest.workspace
+		}
 		req, err := http.NewRequest(testcase.mockRequest.Method, url, nil)
 		if testcase.mockRequest.username != "" {
 			req.Header.Set("REMOTE_USER", testcase.mockRequest.username)
@@ -960,7 +1284,7 @@ func Test_TerminateEndpoint(t *testing.T) {
 func TestOptionsEndpointAuthorization(t *testing.T) {
 	defer SetupAndTeardownTest()()
 
-	Config.ContainersMap = map[string]Container{
+	getConfig().ContainersMap = map[string]Container{
 		"container_a": {
 			Name: "Container without authz (accessible by default)",
 		},
@@ -1052,6 +1376,65 @@ func TestOptionsEndpointAuthorization(t *testing.T) {
 	}
 }
 
+func TestOptionsEndpointFilters(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_containersMap := getConfig().ContainersMap
+	original_isUserAuthorizedForContainer := isUserAuthorizedForContainer
+	defer func() {
+		getConfig().ContainersMap = original_containersMap
+		isUserAuthorizedForContainer = original_isUserAuthorizedForContainer
+	}()
+	isUserAuthorizedForContainer = func(string, string, Container) (bool, error) {
+		return true, nil
+	}
+	getConfig().ContainersMap = map[string]Container{
+		"rstudio": {Name: "RStudio", Category: "Notebooks", Tags: []string{"R", "Stats"}},
+		"jupyter": {Name: "Jupyter", Category: "Notebooks", Tags: []string{"python"}, NextflowConfig: NextflowConfig{Enabled: true}},
+		"stata":   {Name: "Stata", Category: "Statistics", Tags: []string{"stats"}, License: LicenseInfo{Enabled: true}, HourlyCost: 1.5},
+		"firefox": {Name: "Firefox"},
+	}
+
+	testCases := []struct {
+		query   string
+		wantIDs []string
+	}{
+		{query: "", wantIDs: []string{"firefox", "jupyter", "rstudio", "stata"}},
+		{query: "?category=notebooks", wantIDs: []string{"jupyter", "rstudio"}},
+		{query: "?tag=stats", wantIDs: []string{"rstudio", "stata"}},
+		{query: "?tag=stats&tag=r", wantIDs: []string{"rstudio"}},
+		{query: "?category=Notebooks&tag=stats", wantIDs: []string{"rstudio"}},
+		{query: "?category=imaging", wantIDs: []string{}},
+	}
+	for _, testcase := range testCases {
+		req, err := http.NewRequest("GET", "/options"+testcase.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		http.HandlerFunc(options).ServeHTTP(w, req)
+
+		var got []containerOption
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("unable to parse the options returned for '%s': %v", testcase.query, w.Body.String())
+		}
+		gotIDs := []string{}
+		for _, option := range got {
+			gotIDs = append(gotIDs, option.ID)
+			if option.ID == "stata" && (!option.Licensed || option.Nextflow || option.HourlyCost != 1.5 || option.Category != "Statistics") {
+				t.Errorf("unexpected catalog metadata: %+v", option)
+			}
+			if option.ID == "jupyter" && (option.Licensed || !option.Nextflow) {
+				t.Errorf("unexpected catalog metadata: %+v", option)
+			}
+		}
+		sort.Strings(gotIDs)
+		if !reflect.DeepEqual(gotIDs, testcase.wantIDs) {
+			t.Errorf("unexpected options for '%s': got %v, want %v", testcase.query, gotIDs, testcase.wantIDs)
+		}
+	}
+}
+
 func TestMountFilesEndpoint(t *testing.T) {
 	defer SetupAndTeardownTest()()
 
@@ -1081,7 +1464,7 @@ aws {
 		G3autoKey:       "test-g3auto-key",
 		WorkspaceFlavor: "licensed-flavor",
 	}
-	Config.ContainersMap = map[string]Container{
+	getConfig().ContainersMap = map[string]Container{
 		"container_a": {
 			Name:    "Container with license",
 			License: licenseInfo,
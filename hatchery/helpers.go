@@ -132,8 +132,13 @@ func getFenceURL() string {
 	return fenceURL
 }
 
+// URL of ambassador from inside the cluster
+// the URL of ambassador inside the cluster, which routes to the workspaces
+// based on the `remote_user` header
+var inClusterAmbassadorURL = "http://ambassador-service/"
+
 func getAmbassadorURL() string {
-	ambassadorURL := "http://ambassador-service/"
+	ambassadorURL := inClusterAmbassadorURL
 	_, ok := os.LookupEnv("GEN3_ENDPOINT")
 	if ok {
 		ambassadorURL = "https://" + os.Getenv("GEN3_ENDPOINT") + "/lw-workspace/proxy/"
@@ -141,7 +146,7 @@ func getAmbassadorURL() string {
 	return ambassadorURL
 }
 
-func getAPIKeyWithContext(ctx context.Context, accessToken string) (apiKey *APIKeyStruct, err error) {
+var getAPIKeyWithContext = func(ctx context.Context, accessToken string) (apiKey *APIKeyStruct, err error) {
 	if accessToken == "" {
 		return nil, errors.New("No valid access token")
 	}
@@ -151,11 +156,14 @@ func getAPIKeyWithContext(ctx context.Context, accessToken string) (apiKey *APIK
 
 	resp, err := MakeARequestWithContext(ctx, "POST", fenceAPIKeyURL, accessToken, "application/json", nil, body)
 	if err != nil {
+		recordDependencyError(dependencyFence, err)
 		return nil, err
 	}
 
 	if resp != nil && resp.StatusCode != 200 {
-		return nil, errors.New("Error occurred when creating API key with error code " + strconv.Itoa(resp.StatusCode))
+		err = errors.New("Error occurred when creating API key with error code " + strconv.Itoa(resp.StatusCode))
+		recordDependencyError(dependencyFence, err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
@@ -175,24 +183,89 @@ func deleteAPIKeyWithContext(ctx context.Context, accessToken string, apiKeyID s
 	fenceDeleteAPIKeyURL := getFenceURL() + "credentials/api/" + apiKeyID
 	resp, err := MakeARequestWithContext(ctx, "DELETE", fenceDeleteAPIKeyURL, accessToken, "", nil, nil)
 	if err != nil {
+		recordDependencyError(dependencyFence, err)
 		return err
 	}
 	if resp != nil && resp.StatusCode != 204 {
-		return errors.New("Error occurred when deleting API key with error code " + strconv.Itoa(resp.StatusCode))
+		err = errors.New("Error occurred when deleting API key with error code " + strconv.Itoa(resp.StatusCode))
+		recordDependencyError(dependencyFence, err)
+		return err
 	}
 	return nil
 }
 
-func getKernelIdleTimeWithContext(ctx context.Context, accessToken string) (lastActivityTime int64, err error) {
+// getAccessTokenFromAPIKey returns an access token of the user the API key
+// belongs to
+var getAccessTokenFromAPIKey = func(ctx context.Context, apiKey string) (string, error) {
+	body, err := json.Marshal(map[string]string{"api_key": apiKey})
+	if err != nil {
+		return "", err
+	}
+	resp, err := MakeARequestWithContext(ctx, "POST", getFenceURL()+"credentials/api/access_token", "", "application/json", nil, bytes.NewBuffer(body))
+	if err != nil {
+		recordDependencyError(dependencyFence, err)
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		err = errors.New("Error occurred when getting an access token from an API key with error code " + strconv.Itoa(resp.StatusCode))
+		recordDependencyError(dependencyFence, err)
+		return "", err
+	}
+	var tokenResponse struct {
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tokenResponse)
+	if err != nil {
+		return "", errors.New("Unable to decode access token response: " + err.Error())
+	}
+	return tokenResponse.AccessToken, nil
+}
+
+// deleteWorkspaceAPIKey deletes the API key mounted in a workspace. Fence
+// only lets users delete their own keys: when the workspace is terminated
+// without the user's access token, eg by the idle reaper, the key itself is
+// exchanged for an access token to delete it with.
+func deleteWorkspaceAPIKey(ctx context.Context, accessToken string, apiKey string, apiKeyID string) error {
+	if accessToken == "" && apiKey != "" {
+		token, err := getAccessTokenFromAPIKey(ctx, apiKey)
+		if err != nil {
+			return err
+		}
+		accessToken = token
+	}
+	return deleteAPIKeyWithContext(ctx, accessToken, apiKeyID)
+}
+
+func getKernelIdleTimeWithContext(ctx context.Context, workspaceName string, accessToken string) (lastActivityTime int64, err error) {
 	if accessToken == "" {
 		return -1, errors.New("No valid access token")
 	}
 
-	workspaceKernelStatusURL := getAmbassadorURL() + "api/status"
+	workspaceKernelStatusURL := getAmbassadorURL() + workspaceURLPrefix(workspaceName) + "api/status"
 	resp, err := MakeARequestWithContext(ctx, "GET", workspaceKernelStatusURL, accessToken, "", nil, nil)
 	if err != nil {
 		return -1, err
 	}
+	return decodeKernelStatusResponse(resp)
+}
+
+// getKernelIdleTimeForUser is like getKernelIdleTimeWithContext, but
+// talks to ambassador directly as the user instead of going through the
+// reverse proxy with the user's access token, so it can be used by
+// background jobs.
+var getKernelIdleTimeForUser = func(ctx context.Context, userName string, workspaceName string) (lastActivityTime int64, err error) {
+	workspaceKernelStatusURL := inClusterAmbassadorURL + workspaceURLPrefix(workspaceName) + "api/status"
+	// ambassador routes requests to the user's workspace based on this header
+	headers := map[string]string{"remote_user": userName}
+	resp, err := MakeARequestWithContext(ctx, "GET", workspaceKernelStatusURL, "", "", headers, nil)
+	if err != nil {
+		return -1, err
+	}
+	return decodeKernelStatusResponse(resp)
+}
+
+func decodeKernelStatusResponse(resp *http.Response) (lastActivityTime int64, err error) {
 	if resp != nil && resp.StatusCode != 200 {
 		return -1, errors.New("Error occurred when getting workspace kernel status with error code " + strconv.Itoa(resp.StatusCode))
 	}
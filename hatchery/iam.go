@@ -175,14 +175,14 @@ func createOrUpdatePolicy(iamSvc *iam.IAM, policyName string, pathPrefix *string
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			if aerr.Code() == iam.ErrCodeEntityAlreadyExistsException {
-				Config.Logger.Printf("Policy '%s' already exists. Deleting old versions and updating it...", policyName)
+				getConfig().Logger.Printf("Policy '%s' already exists. Deleting old versions and updating it...", policyName)
 
 				// find the policy's ARN
 				listPoliciesResult, err := iamSvc.ListPolicies(&iam.ListPoliciesInput{
 					PathPrefix: pathPrefix,
 				})
 				if err != nil {
-					Config.Logger.Printf("Error getting existing policy '%s': %v", policyName, err)
+					getConfig().Logger.Printf("Error getting existing policy '%s': %v", policyName, err)
 					return "", err
 				}
 				for _, policy := range listPoliciesResult.Policies {
@@ -200,20 +200,20 @@ func createOrUpdatePolicy(iamSvc *iam.IAM, policyName string, pathPrefix *string
 					PolicyArn: &policyArn,
 				})
 				if err != nil {
-					Config.Logger.Printf("Error getting policy '%s' versions: %v", policyName, err)
+					getConfig().Logger.Printf("Error getting policy '%s' versions: %v", policyName, err)
 					return "", err
 				}
 				for _, version := range listVersionsResult.Versions {
 					if *version.IsDefaultVersion {
 						continue
 					}
-					Config.Logger.Printf("Deleting policy '%s' version '%s'", policyName, *version.VersionId)
+					getConfig().Logger.Printf("Deleting policy '%s' version '%s'", policyName, *version.VersionId)
 					_, err = iamSvc.DeletePolicyVersion(&iam.DeletePolicyVersionInput{
 						PolicyArn: &policyArn,
 						VersionId: version.VersionId,
 					})
 					if err != nil {
-						Config.Logger.Printf("Warning: Unable to delete policy '%s' version '%s': %v", policyName, *version.VersionId, err)
+						getConfig().Logger.Printf("Warning: Unable to delete policy '%s' version '%s': %v", policyName, *version.VersionId, err)
 					}
 				}
 
@@ -224,20 +224,20 @@ func createOrUpdatePolicy(iamSvc *iam.IAM, policyName string, pathPrefix *string
 					SetAsDefault:   aws.Bool(true),
 				})
 				if err != nil {
-					Config.Logger.Printf("Error updating policy '%s': %v", policyName, err)
+					getConfig().Logger.Printf("Error updating policy '%s': %v", policyName, err)
 					return "", err
 				}
 			} else {
-				Config.Logger.Printf("Error creating policy '%s': %v", policyName, aerr)
-				Config.Logger.Printf("Policy document: '%s'", *policyDocument)
+				getConfig().Logger.Printf("Error creating policy '%s': %v", policyName, aerr)
+				getConfig().Logger.Printf("Policy document: '%s'", *policyDocument)
 				return "", err
 			}
 		} else {
-			Config.Logger.Printf("Error creating policy '%s': %v", policyName, err)
+			getConfig().Logger.Printf("Error creating policy '%s': %v", policyName, err)
 			return "", err
 		}
 	} else {
-		Config.Logger.Printf("Created policy '%s'", policyName)
+		getConfig().Logger.Printf("Created policy '%s'", policyName)
 		policyArn = *policyResult.Policy.Arn
 	}
 	return policyArn, nil
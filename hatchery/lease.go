@@ -0,0 +1,107 @@
+package hatchery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	k8sv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// `app` label of the config maps that hold the leases of the background
+// jobs
+const jobLeaseApp = "hatchery-lease"
+
+// jobLease is held by the replica that runs a background job. The holder
+// renews it at every run; a lease that is not renewed in time is taken
+// over by another replica.
+type jobLease struct {
+	Holder    string    `json:"holder"`
+	RenewedAt time.Time `json:"renewed_at"`
+}
+
+func jobLeaseName(job string) string {
+	return fmt.Sprintf("%s-%s", jobLeaseApp, job)
+}
+
+// acquireJobLease returns true if this replica holds the lease of the job,
+// which it acquires or renews. The lease is a config map in the local
+// cluster, updated with optimistic concurrency like the pending
+// operations, so that only one replica holds it. It expires if the holder
+// does not renew it for `duration`.
+var acquireJobLease = func(ctx context.Context, job string, duration time.Duration) (bool, error) {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return false, fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	configMaps := podClient.ConfigMaps(getConfig().Config.UserNamespace)
+	now := time.Now().UTC()
+	data, err := json.Marshal(jobLease{Holder: instanceID, RenewedAt: now})
+	if err != nil {
+		return false, err
+	}
+
+	configMap, err := configMaps.Get(ctx, jobLeaseName(job), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		configMap = &k8sv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      jobLeaseName(job),
+				Namespace: getConfig().Config.UserNamespace,
+				Labels:    map[string]string{"app": jobLeaseApp},
+			},
+			Data: map[string]string{"lease.json": string(data)},
+		}
+		_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+		if k8serrors.IsAlreadyExists(err) {
+			// another replica created it first
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var held jobLease
+	if err := json.Unmarshal([]byte(configMap.Data["lease.json"]), &held); err == nil && held.Holder != instanceID && now.Sub(held.RenewedAt) < duration {
+		return false, nil
+	}
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data["lease.json"] = string(data)
+	// fails with a conflict if another replica renewed or took over the
+	// lease since it was read
+	_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	if k8serrors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// startLeasedJob runs `run` every `interval`, on the replica that holds the
+// job's lease only, so that the jobs that act on the workspaces of all the
+// users do not run once per replica. Another replica takes over if the
+// holder misses two runs. The job stops when hatchery shuts down.
+func startLeasedJob(job string, interval time.Duration, run func()) {
+	go func() {
+		for {
+			select {
+			case <-backgroundWork.stopped():
+				return
+			case <-time.After(interval):
+			}
+			held, err := acquireJobLease(context.Background(), job, 2*interval)
+			if err != nil {
+				getConfig().Logger.Printf("Unable to acquire the lease of the %s, skipping this run: %v", job, err)
+				continue
+			}
+			if held {
+				run()
+			}
+		}
+	}()
+}
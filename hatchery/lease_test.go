@@ -0,0 +1,75 @@
+package hatchery
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+func TestAcquireJobLease(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_getLocalPodClient := getLocalPodClient
+	original_instanceID := instanceID
+	defer func() {
+		SetConfig(original_config)
+		getLocalPodClient = original_getLocalPodClient
+		instanceID = original_instanceID
+	}()
+
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags)})
+	podClient := fake.NewSimpleClientset().CoreV1()
+	getLocalPodClient = func() corev1.CoreV1Interface {
+		return podClient
+	}
+	ctx := context.Background()
+
+	acquire := func(replica string) bool {
+		instanceID = replica
+		held, err := acquireJobLease(ctx, "idle-reaper", time.Minute)
+		if err != nil {
+			t.Fatalf("unable to acquire the lease as %s: %v", replica, err)
+		}
+		return held
+	}
+
+	if !acquire("replica-1") {
+		t.Errorf("the first replica should acquire the free lease")
+	}
+	if acquire("replica-2") {
+		t.Errorf("another replica should not acquire a lease that is held")
+	}
+	if !acquire("replica-1") {
+		t.Errorf("the holder should renew its lease")
+	}
+	// each job has its own lease
+	instanceID = "replica-2"
+	if held, err := acquireJobLease(ctx, "session-sweeper", time.Minute); err != nil || !held {
+		t.Errorf("the lease of another job should be free, got %v, %v", held, err)
+	}
+
+	// the holder stopped renewing the lease
+	configMap, err := podClient.ConfigMaps("").Get(ctx, jobLeaseName("idle-reaper"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unable to get the lease: %v", err)
+	}
+	expired, _ := json.Marshal(jobLease{Holder: "replica-1", RenewedAt: time.Now().UTC().Add(-2 * time.Minute)})
+	configMap.Data["lease.json"] = string(expired)
+	if _, err := podClient.ConfigMaps("").Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unable to update the lease: %v", err)
+	}
+	if !acquire("replica-2") {
+		t.Errorf("another replica should take over an expired lease")
+	}
+	if acquire("replica-1") {
+		t.Errorf("the previous holder should not get the lease back")
+	}
+}
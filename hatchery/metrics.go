@@ -0,0 +1,225 @@
+package hatchery
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
+)
+
+// External services whose errors are counted in `hatchery_dependency_errors_total`
+const (
+	dependencyArborist = "arborist"
+	dependencyFence    = "fence"
+	dependencyDynamoDB = "dynamodb"
+)
+
+var (
+	launchesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hatchery_launches_total",
+		Help: "Number of workspace launches, by container, backend and outcome.",
+	}, []string{"container", "backend", "status"})
+
+	launchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "hatchery_launch_duration_seconds",
+		Help: "Time it takes to launch a workspace, by container and backend. A launch is done once all the workspace resources are created; it does not wait for the workspace to be ready.",
+		// ECS launches create a lot of AWS resources and can take several minutes
+		Buckets: []float64{1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1200},
+	}, []string{"container", "backend"})
+
+	terminationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hatchery_terminations_total",
+		Help: "Number of workspace terminations, by container and backend.",
+	}, []string{"container", "backend"})
+
+	dependencyErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hatchery_dependency_errors_total",
+		Help: "Number of failed calls to the services hatchery depends on (arborist, fence, dynamodb).",
+	}, []string{"dependency"})
+
+	activeWorkspacesDesc = prometheus.NewDesc(
+		"hatchery_active_workspaces",
+		"Number of running workspaces, by container.",
+		[]string{"container"}, nil,
+	)
+
+	licensesInUseDesc = prometheus.NewDesc(
+		"hatchery_licenses_in_use",
+		"Number of gen3 licenses in use, by license type.",
+		[]string{"license_type"}, nil,
+	)
+)
+
+func init() {
+	prometheus.MustRegister(launchesTotal, launchDuration, terminationsTotal, dependencyErrorsTotal)
+}
+
+// The gauges are computed at most once per this long, however often
+// `/metrics` is scraped: counting the licenses in use queries DynamoDB
+const workspaceMetricsTTL = 30 * time.Second
+
+var (
+	workspaceMetrics           = &workspaceCollector{}
+	registerWorkspaceCollector sync.Once
+)
+
+// RegisterMetrics exposes the Prometheus metrics at `/metrics`. It can be
+// called for several muxes: the collectors are only registered once.
+func RegisterMetrics(mux *httptrace.ServeMux) {
+	registerWorkspaceCollector.Do(func() {
+		prometheus.MustRegister(workspaceMetrics)
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+}
+
+// containerLabel returns the container name to use as a metric label
+func containerLabel(containerID string) string {
+	if container, ok := getConfig().ContainersMap[containerID]; ok {
+		return container.Name
+	}
+	return "unknown"
+}
+
+// recordOperationMetrics updates the metrics once an operation is finished
+func recordOperationMetrics(op *Operation, err error) {
+	if op.Type != "launch" {
+		return
+	}
+	status := operationSucceeded
+	if err != nil {
+		status = operationFailed
+	}
+	launchesTotal.WithLabelValues(op.ContainerName, op.Backend, status).Inc()
+	launchDuration.WithLabelValues(op.ContainerName, op.Backend).Observe(time.Since(op.CreatedAt).Seconds())
+}
+
+// recordTermination counts a workspace termination
+func recordTermination(containerID string, backend string) {
+	terminationsTotal.WithLabelValues(containerLabel(containerID), backend).Inc()
+}
+
+// recordDependencyError counts the error, if any, returned by a call to
+// one of the services hatchery depends on
+func recordDependencyError(dependency string, err error) {
+	if err != nil {
+		dependencyErrorsTotal.WithLabelValues(dependency).Inc()
+	}
+}
+
+// getWorkspaceContainerID returns the ID of the container the workspace was
+// launched with, read from the local service that routes traffic to it, or
+// "" if it is unknown
+var getWorkspaceContainerID = func(ctx context.Context, userName string, workspaceName string) string {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return ""
+	}
+	service, err := podClient.Services(getConfig().Config.UserNamespace).Get(ctx, workspaceToResourceName(userName, workspaceName, "service"), metav1.GetOptions{})
+	if err != nil {
+		return ""
+	}
+	return resolveContainerID(service.Annotations[containerIDAnnotation])
+}
+
+// getWorkspaceResourceProfile returns the resource profile the workspace was
+// launched with, read like its container ID, or "" if there is none
+var getWorkspaceResourceProfile = func(ctx context.Context, userName string, workspaceName string) string {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return ""
+	}
+	service, err := podClient.Services(getConfig().Config.UserNamespace).Get(ctx, workspaceToResourceName(userName, workspaceName, "service"), metav1.GetOptions{})
+	if err != nil {
+		return ""
+	}
+	return service.Annotations[resourceProfileAnnotation]
+}
+
+// workspaceCollector computes the gauges that reflect the current state of
+// the workspaces when Prometheus scrapes `/metrics`, and serves them again
+// to the scrapes that follow within `workspaceMetricsTTL`
+type workspaceCollector struct {
+	mu               sync.Mutex
+	computedAt       time.Time
+	activeWorkspaces map[string]int
+	licensesInUse    map[string]int
+}
+
+func (collector *workspaceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeWorkspacesDesc
+	ch <- licensesInUseDesc
+}
+
+func (collector *workspaceCollector) Collect(ch chan<- prometheus.Metric) {
+	// concurrent scrapes wait for the counts rather than compute them again
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if time.Since(collector.computedAt) >= workspaceMetricsTTL {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		collector.activeWorkspaces = countActiveWorkspaces(ctx)
+		collector.licensesInUse = countLicensesInUse()
+		collector.computedAt = time.Now()
+	}
+
+	for container, count := range collector.activeWorkspaces {
+		ch <- prometheus.MustNewConstMetric(activeWorkspacesDesc, prometheus.GaugeValue, float64(count), container)
+	}
+	for licenseType, count := range collector.licensesInUse {
+		ch <- prometheus.MustNewConstMetric(licensesInUseDesc, prometheus.GaugeValue, float64(count), licenseType)
+	}
+}
+
+// countActiveWorkspaces returns the number of running workspaces per
+// container name. Every configured container is listed, even if it has no
+// running workspaces. The local workspaces are listed from the status
+// cache once it is synced.
+func countActiveWorkspaces(ctx context.Context) map[string]int {
+	counts := make(map[string]int)
+	for _, container := range getConfig().ContainersMap {
+		counts[container.Name] = 0
+	}
+	workspaces, err := listActiveWorkspaces(ctx)
+	if err != nil {
+		getConfig().Logger.Printf("Metrics: unable to list the active workspaces: %v", err)
+		return counts
+	}
+	for _, workspace := range workspaces {
+		counts[containerLabel(workspace.ContainerID)]++
+	}
+	return counts
+}
+
+// countLicensesInUse returns the number of active gen3 license user maps per
+// license type, for the license types used by the configured containers
+func countLicensesInUse() map[string]int {
+	counts := make(map[string]int)
+	if getConfig().Config.LicenseUserMapsTable == "" || getConfig().Config.LicenseUserMapsGSI == "" {
+		return counts
+	}
+	// several containers can share the same license type
+	containersByLicenseType := make(map[string]Container)
+	for _, container := range getConfig().ContainersMap {
+		if container.License.Enabled && container.License.LicenseType != "" {
+			containersByLicenseType[container.License.LicenseType] = container
+		}
+	}
+	if len(containersByLicenseType) == 0 {
+		return counts
+	}
+	dbconfig := initializeDbConfig()
+	for licenseType, container := range containersByLicenseType {
+		licenseUserMaps, err := getActiveGen3LicenseUserMaps(dbconfig, container)
+		if err != nil {
+			getConfig().Logger.Printf("Metrics: unable to get the active %s licenses: %v", licenseType, err)
+			continue
+		}
+		counts[licenseType] = len(licenseUserMaps)
+	}
+	return counts
+}
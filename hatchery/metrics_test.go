@@ -0,0 +1,145 @@
+package hatchery
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
+)
+
+func TestBackendForPayModel(t *testing.T) {
+	testCases := []struct {
+		name     string
+		payModel *PayModel
+		want     string
+	}{
+		{name: "NoPayModel", want: backendLocalK8s},
+		{name: "LocalPayModel", payModel: &PayModel{Local: true}, want: backendLocalK8s},
+		{name: "EcsPayModel", payModel: &PayModel{Ecs: true}, want: backendEcs},
+		{name: "ExternalK8sPayModel", payModel: &PayModel{}, want: backendExternalK8s},
+	}
+	for _, testcase := range testCases {
+		got := backendForPayModel(testcase.payModel)
+		if got != testcase.want {
+			t.Errorf("unexpected backend when %s: got %s, want %s", testcase.name, got, testcase.want)
+		}
+	}
+}
+
+func TestRecordOperationMetrics(t *testing.T) {
+	op := newOperation("launch", "testUser", "hash", "Metrics test container")
+	op.Backend = backendEcs
+
+	recordOperationMetrics(op, nil)
+	recordOperationMetrics(op, errors.New("launch failed"))
+	recordOperationMetrics(op, errors.New("launch failed"))
+
+	if got := testutil.ToFloat64(launchesTotal.WithLabelValues("Metrics test container", backendEcs, operationSucceeded)); got != 1 {
+		t.Errorf("expected 1 successful launch, got %v", got)
+	}
+	if got := testutil.ToFloat64(launchesTotal.WithLabelValues("Metrics test container", backendEcs, operationFailed)); got != 2 {
+		t.Errorf("expected 2 failed launches, got %v", got)
+	}
+
+	// only launches are counted
+	recordOperationMetrics(newOperation("other", "testUser", "hash", "Metrics test container 2"), nil)
+	if got := testutil.ToFloat64(launchesTotal.WithLabelValues("Metrics test container 2", "", operationSucceeded)); got != 0 {
+		t.Errorf("expected other operations not to be counted as launches, got %v", got)
+	}
+}
+
+func TestCountActiveWorkspaces(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_listActiveWorkspaces := listActiveWorkspaces
+	defer func() {
+		// restore original functions
+		SetConfig(original_config)
+		listActiveWorkspaces = original_listActiveWorkspaces
+	}()
+
+	// other tests may leave a config without a logger behind
+	SetConfig(&FullHatcheryConfig{
+		Logger: log.New(io.Discard, "", log.LstdFlags),
+	})
+	getConfig().ContainersMap = map[string]Container{
+		"rstudio": {Name: "RStudio"},
+		"jupyter": {Name: "Jupyter"},
+	}
+	listActiveWorkspaces = func(context.Context) ([]WorkspaceInfo, error) {
+		return []WorkspaceInfo{
+			{UserName: "user1", ContainerID: "rstudio"},
+			{UserName: "user1", WorkspaceName: "second", ContainerID: "rstudio"},
+			{UserName: "user2", ContainerID: "removed-from-config"},
+		}, nil
+	}
+
+	counts := countActiveWorkspaces(context.Background())
+	want := map[string]int{"RStudio": 2, "Jupyter": 0, "unknown": 1}
+	if len(counts) != len(want) {
+		t.Errorf("unexpected active workspace counts: got %v, want %v", counts, want)
+	}
+	for container, count := range want {
+		if counts[container] != count {
+			t.Errorf("unexpected number of active %s workspaces: got %d, want %d", container, counts[container], count)
+		}
+	}
+
+	// the configured containers are still reported when listing fails
+	listActiveWorkspaces = func(context.Context) ([]WorkspaceInfo, error) {
+		return nil, errors.New("unable to list workspaces")
+	}
+	counts = countActiveWorkspaces(context.Background())
+	if len(counts) != 2 || counts["RStudio"] != 0 || counts["Jupyter"] != 0 {
+		t.Errorf("unexpected active workspace counts when listing fails: %v", counts)
+	}
+}
+
+func TestWorkspaceCollector(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_listActiveWorkspaces := listActiveWorkspaces
+	defer func() {
+		// restore original functions
+		SetConfig(original_config)
+		listActiveWorkspaces = original_listActiveWorkspaces
+	}()
+
+	SetConfig(&FullHatcheryConfig{
+		Logger: log.New(io.Discard, "", log.LstdFlags),
+	})
+	getConfig().ContainersMap = map[string]Container{
+		"rstudio": {Name: "RStudio"},
+	}
+	listCalls := 0
+	listActiveWorkspaces = func(context.Context) ([]WorkspaceInfo, error) {
+		listCalls++
+		return []WorkspaceInfo{{UserName: "user1", ContainerID: "rstudio"}}, nil
+	}
+
+	collector := &workspaceCollector{}
+	for i := 0; i < 3; i++ {
+		if count := testutil.CollectAndCount(collector, "hatchery_active_workspaces"); count != 1 {
+			t.Errorf("expected 1 hatchery_active_workspaces sample, got %d", count)
+		}
+	}
+	if listCalls != 1 {
+		t.Errorf("expected the scrapes within the TTL to list the workspaces once, got %d times", listCalls)
+	}
+
+	collector.computedAt = collector.computedAt.Add(-workspaceMetricsTTL)
+	testutil.CollectAndCount(collector)
+	if listCalls != 2 {
+		t.Errorf("expected a scrape after the TTL to list the workspaces again, got %d times", listCalls)
+	}
+
+	// serving the metrics on another mux does not register the collector again
+	RegisterMetrics(httptrace.NewServeMux())
+	RegisterMetrics(httptrace.NewServeMux())
+}
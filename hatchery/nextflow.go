@@ -45,7 +45,7 @@ func createNextflowResources(userName string, nextflowConfig NextflowConfig) (st
 	if err != nil {
 		return "", "", err
 	}
-	Config.Logger.Printf("AWS account ID: '%v'", awsAccountId)
+	getConfig().Logger.Printf("AWS account ID: '%v'", awsAccountId)
 	batchSvc := batch.New(sess, &awsConfig)
 	iamSvc := iam.New(sess, &awsConfig)
 	s3Svc := s3.New(sess, &awsConfig)
@@ -104,21 +104,21 @@ func createNextflowResources(userName string, nextflowConfig NextflowConfig) (st
 	// create the VPC if it doesn't exist
 	vpcid, subnetids, err := setupVpcAndSquid(ec2Svc, userName, hostname)
 	if err != nil {
-		Config.Logger.Printf("Unable to setup VPC: %v", err)
+		getConfig().Logger.Printf("Unable to setup VPC: %v", err)
 		return "", "", err
 	}
 
 	// Create nextflow compute environment if it does not exist
 	batchComputeEnvArn, err := createBatchComputeEnvironment(userName, hostname, tagsMap, batchSvc, ec2Svc, iamSvc, *vpcid, *subnetids, payModel, awsAccountId, nextflowConfig)
 	if err != nil {
-		Config.Logger.Printf("Error creating compute environment for user %s: %s", userName, err.Error())
+		getConfig().Logger.Printf("Error creating compute environment for user %s: %s", userName, err.Error())
 		return "", "", err
 	}
 
 	// Create S3 bucket
 	err = createS3bucket(s3Svc, bucketName)
 	if err != nil {
-		Config.Logger.Printf("Error creating S3 bucket '%s': %v", bucketName, err)
+		getConfig().Logger.Printf("Error creating S3 bucket '%s': %v", bucketName, err)
 		return "", "", err
 	}
 
@@ -137,13 +137,13 @@ func createNextflowResources(userName string, nextflowConfig NextflowConfig) (st
 	})
 	if err != nil {
 		if strings.Contains(err.Error(), "Object already exists") {
-			Config.Logger.Printf("Debug: Batch job queue '%s' already exists", batchJobQueueName)
+			getConfig().Logger.Printf("Debug: Batch job queue '%s' already exists", batchJobQueueName)
 		} else {
-			Config.Logger.Printf("Error creating Batch job queue '%s': %v", batchJobQueueName, err)
+			getConfig().Logger.Printf("Error creating Batch job queue '%s': %v", batchJobQueueName, err)
 			return "", "", err
 		}
 	} else {
-		Config.Logger.Printf("Created Batch job queue '%s'", batchJobQueueName)
+		getConfig().Logger.Printf("Created Batch job queue '%s'", batchJobQueueName)
 	}
 
 	// create IAM policy for nextflow-created jobs
@@ -210,25 +210,25 @@ func createNextflowResources(userName string, nextflowConfig NextflowConfig) (st
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			if aerr.Code() == iam.ErrCodeEntityAlreadyExistsException {
-				Config.Logger.Printf("Debug: role '%s' already exists", roleName)
+				getConfig().Logger.Printf("Debug: role '%s' already exists", roleName)
 				listRolesResult, err := iamSvc.ListRoles(&iam.ListRolesInput{
 					PathPrefix: pathPrefix,
 				})
 				if err != nil || len(listRolesResult.Roles) == 0 {
-					Config.Logger.Printf("Error getting existing role '%s': %v", roleName, err)
+					getConfig().Logger.Printf("Error getting existing role '%s': %v", roleName, err)
 					return "", "", err
 				}
 				nextflowJobsRoleArn = *listRolesResult.Roles[0].Arn
 			} else {
-				Config.Logger.Printf("Error creating role '%s': %v", roleName, aerr)
+				getConfig().Logger.Printf("Error creating role '%s': %v", roleName, aerr)
 				return "", "", err
 			}
 		} else {
-			Config.Logger.Printf("Error creating role '%s': %v", roleName, err)
+			getConfig().Logger.Printf("Error creating role '%s': %v", roleName, err)
 			return "", "", err
 		}
 	} else {
-		Config.Logger.Printf("Created role '%s'", roleName)
+		getConfig().Logger.Printf("Created role '%s'", roleName)
 		nextflowJobsRoleArn = *roleResult.Role.Arn
 	}
 
@@ -238,10 +238,10 @@ func createNextflowResources(userName string, nextflowConfig NextflowConfig) (st
 		RoleName:  &roleName,
 	})
 	if err != nil {
-		Config.Logger.Printf("Error attaching policy '%s' to role '%s': %v", policyName, roleName, err)
+		getConfig().Logger.Printf("Error attaching policy '%s' to role '%s': %v", policyName, roleName, err)
 		return "", "", err
 	} else {
-		Config.Logger.Printf("Attached policy '%s' to role '%s'", policyName, roleName)
+		getConfig().Logger.Printf("Attached policy '%s' to role '%s'", policyName, roleName)
 	}
 
 	// create IAM policy for nextflow client
@@ -354,22 +354,22 @@ func createNextflowResources(userName string, nextflowConfig NextflowConfig) (st
 	})
 	if err != nil {
 		if strings.Contains(err.Error(), "EntityAlreadyExists") {
-			Config.Logger.Printf("Debug: user '%s' already exists", nextflowUserName)
+			getConfig().Logger.Printf("Debug: user '%s' already exists", nextflowUserName)
 
 			// delete any existing access keys to avoid `LimitExceeded: Cannot exceed
 			// quota for AccessKeysPerUser: 2` error
 			err = deleteUserAccessKeys(nextflowUserName, iamSvc)
 			if err != nil {
-				Config.Logger.Printf("Unable to delete access keys for user '%s': %v", nextflowUserName, err)
+				getConfig().Logger.Printf("Unable to delete access keys for user '%s': %v", nextflowUserName, err)
 				return "", "", err
 			}
 
 		} else {
-			Config.Logger.Printf("Error creating user '%s': %v", nextflowUserName, err)
+			getConfig().Logger.Printf("Error creating user '%s': %v", nextflowUserName, err)
 			return "", "", err
 		}
 	} else {
-		Config.Logger.Printf("Created user '%s'", nextflowUserName)
+		getConfig().Logger.Printf("Created user '%s'", nextflowUserName)
 	}
 
 	// attach policy to user for nextflow client
@@ -378,10 +378,10 @@ func createNextflowResources(userName string, nextflowConfig NextflowConfig) (st
 		PolicyArn: &nextflowPolicyArn,
 	})
 	if err != nil {
-		Config.Logger.Printf("Error attaching policy '%s' to user '%s': %v", policyName, nextflowUserName, err)
+		getConfig().Logger.Printf("Error attaching policy '%s' to user '%s': %v", policyName, nextflowUserName, err)
 		return "", "", err
 	} else {
-		Config.Logger.Printf("Attached policy '%s' to user '%s'", policyName, nextflowUserName)
+		getConfig().Logger.Printf("Attached policy '%s' to user '%s'", policyName, nextflowUserName)
 	}
 
 	// create access key for the nextflow user
@@ -389,12 +389,12 @@ func createNextflowResources(userName string, nextflowConfig NextflowConfig) (st
 		UserName: &nextflowUserName,
 	})
 	if err != nil {
-		Config.Logger.Printf("Error creating access key for user '%s': %v", nextflowUserName, err)
+		getConfig().Logger.Printf("Error creating access key for user '%s': %v", nextflowUserName, err)
 		return "", "", err
 	}
 	keyId := *accessKeyResult.AccessKey.AccessKeyId
 	keySecret := *accessKeyResult.AccessKey.SecretAccessKey
-	Config.Logger.Printf("Created access key '%v' for user '%s'", keyId, nextflowUserName)
+	getConfig().Logger.Printf("Created access key '%v' for user '%s'", keyId, nextflowUserName)
 
 	return keyId, keySecret, nil
 }
@@ -404,19 +404,19 @@ func getNextflowAwsSettings(sess *session.Session, payModel *PayModel, userName
 	var awsConfig aws.Config
 	var awsAccountId string
 	if payModel != nil && payModel.Ecs {
-		Config.Logger.Printf("Info: pay model enabled for user '%s': %s Nextflow resources in user's AWS account", userName, action)
+		getConfig().Logger.Printf("Info: pay model enabled for user '%s': %s Nextflow resources in user's AWS account", userName, action)
 		roleArn := fmt.Sprintf("arn:aws:iam::%s:role/csoc_adminvm", payModel.AWSAccountId)
 		awsConfig = aws.Config{
 			Credentials: stscreds.NewCredentials(sess, roleArn),
 		}
 		awsAccountId = payModel.AWSAccountId
 	} else {
-		Config.Logger.Printf("Info: pay model disabled for user '%s': %s Nextflow resources in main AWS account", userName, action)
+		getConfig().Logger.Printf("Info: pay model disabled for user '%s': %s Nextflow resources in main AWS account", userName, action)
 		awsConfig = aws.Config{}
-		Config.Logger.Printf("Debug: Getting AWS account ID...")
+		getConfig().Logger.Printf("Debug: Getting AWS account ID...")
 		awsAccountId, err := getAwsAccountId(sess, &awsConfig)
 		if err != nil {
-			Config.Logger.Printf("Error getting AWS account ID: %v", err)
+			getConfig().Logger.Printf("Error getting AWS account ID: %v", err)
 			return awsAccountId, awsConfig, err
 		}
 	}
@@ -469,12 +469,12 @@ func setupVpcAndSquid(ec2Svc *ec2.EC2, userName string, hostname string) (*strin
 	vpcid := ""
 	// TODO: Check that the VPC is configured correctly, and not just that it exists
 	if len(vpc.Vpcs) == 0 {
-		Config.Logger.Print("Debug: VPC does not exist, creating it now")
+		getConfig().Logger.Print("Debug: VPC does not exist, creating it now")
 		vpc, err := createVPC(cidrstring, vpcName, ec2Svc)
 		if err != nil {
 			return nil, nil, err
 		}
-		Config.Logger.Printf("Debug: Created VPC '%s'", vpcName)
+		getConfig().Logger.Printf("Debug: Created VPC '%s'", vpcName)
 
 		vpcid = *vpc.Vpc.VpcId
 	} else {
@@ -520,9 +520,9 @@ func setupVpcAndSquid(ec2Svc *ec2.EC2, userName string, hostname string) (*strin
 	if err != nil {
 		return nil, nil, err
 	}
-	Config.Logger.Printf("Debug: Created Squid '%s'", *fwSubnetId)
+	getConfig().Logger.Printf("Debug: Created Squid '%s'", *fwSubnetId)
 
-	Config.Logger.Print("Debug: Nextflow VPC setup complete")
+	getConfig().Logger.Print("Debug: Nextflow VPC setup complete")
 	return &vpcid, &subnetIds, nil
 }
 
@@ -535,7 +535,7 @@ func ensureLaunchTemplate(ec2Svc *ec2.EC2, userName string, hostname string, job
 
 	launchTemplateName := fmt.Sprintf("%s-nf-%s", hostname, userName)
 
-	Config.Logger.Printf("Debug: Launch template name: %s", launchTemplateName)
+	getConfig().Logger.Printf("Debug: Launch template name: %s", launchTemplateName)
 
 	// create launch template
 	launchTemplate, err := ec2Svc.DescribeLaunchTemplates(&ec2.DescribeLaunchTemplatesInput{
@@ -546,7 +546,7 @@ func ensureLaunchTemplate(ec2Svc *ec2.EC2, userName string, hostname string, job
 	if err != nil {
 		// If no launch template exists, create it
 		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidLaunchTemplateName.NotFoundException" {
-			Config.Logger.Printf("Debug: Launch template '%s' does not exist, creating it", launchTemplateName)
+			getConfig().Logger.Printf("Debug: Launch template '%s' does not exist, creating it", launchTemplateName)
 			launchTemplate, err := ec2Svc.CreateLaunchTemplate(&ec2.CreateLaunchTemplateInput{
 				LaunchTemplateName: aws.String(launchTemplateName),
 				LaunchTemplateData: &ec2.RequestLaunchTemplateData{
@@ -554,20 +554,20 @@ func ensureLaunchTemplate(ec2Svc *ec2.EC2, userName string, hostname string, job
 				},
 			})
 			if err != nil {
-				Config.Logger.Printf("Error creating launch template '%s': %v", launchTemplateName, err)
+				getConfig().Logger.Printf("Error creating launch template '%s': %v", launchTemplateName, err)
 				return nil, err
 			}
-			Config.Logger.Printf("Debug: Created launch template '%s'", launchTemplateName)
+			getConfig().Logger.Printf("Debug: Created launch template '%s'", launchTemplateName)
 			return launchTemplate.LaunchTemplate.LaunchTemplateName, nil
 		} else {
-			Config.Logger.Printf("Error describing launch template '%s': %v", launchTemplateName, err)
+			getConfig().Logger.Printf("Error describing launch template '%s': %v", launchTemplateName, err)
 		}
 		return nil, err
 	}
 
 	if len(launchTemplate.LaunchTemplates) == 1 {
 		// TODO: Make sure user data in the existing launch template matches the user data we want
-		Config.Logger.Printf("Debug: Launch template '%s' already exists", launchTemplateName)
+		getConfig().Logger.Printf("Debug: Launch template '%s' already exists", launchTemplateName)
 		return launchTemplate.LaunchTemplates[0].LaunchTemplateName, nil
 	}
 	return nil, fmt.Errorf("more than one launch template with the same name exist: %v", launchTemplate.LaunchTemplates)
@@ -577,7 +577,7 @@ func ensureLaunchTemplate(ec2Svc *ec2.EC2, userName string, hostname string, job
 func createBatchComputeEnvironment(userName string, hostname string, tagsMap map[string]*string, batchSvc *batch.Batch, ec2Svc *ec2.EC2, iamSvc *iam.IAM, vpcid string, subnetids []string, payModel *PayModel, awsAccountId string, nextflowConfig NextflowConfig) (string, error) {
 	instanceProfileArn, err := createEcsInstanceProfile(iamSvc, fmt.Sprintf("%s-nf-ecsInstanceRole", hostname))
 	if err != nil {
-		Config.Logger.Printf("Unable to create ECS instance profile: %s", err.Error())
+		getConfig().Logger.Printf("Unable to create ECS instance profile: %s", err.Error())
 		return "", err
 	}
 
@@ -616,7 +616,7 @@ func createBatchComputeEnvironment(userName string, hostname string, tagsMap map
 
 	var batchComputeEnvArn string
 	if len(batchComputeEnv.ComputeEnvironments) > 0 {
-		Config.Logger.Printf("Debug: Batch compute environment '%s' already exists, updating it", batchComputeEnvName)
+		getConfig().Logger.Printf("Debug: Batch compute environment '%s' already exists, updating it", batchComputeEnvName)
 		batchComputeEnvArn = *batchComputeEnv.ComputeEnvironments[0].ComputeEnvironmentArn
 
 		// wait for the compute env to be ready to be updated
@@ -652,7 +652,7 @@ func createBatchComputeEnvironment(userName string, hostname string, tagsMap map
 			},
 		})
 		if err != nil {
-			Config.Logger.Printf("Unable to update Batch compute environment '%s': %v", batchComputeEnvName, err)
+			getConfig().Logger.Printf("Unable to update Batch compute environment '%s': %v", batchComputeEnvName, err)
 			return "", err
 		}
 	} else { // compute environment does not exist, create it
@@ -705,7 +705,7 @@ func createBatchComputeEnvironment(userName string, hostname string, tagsMap map
 			return "", err
 		}
 
-		Config.Logger.Printf("Debug: Created AWS Batch compute environment '%s'", batchComputeEnvName)
+		getConfig().Logger.Printf("Debug: Created AWS Batch compute environment '%s'", batchComputeEnvName)
 		batchComputeEnvArn = *batchComputeEnvResult.ComputeEnvironmentArn
 	}
 
@@ -734,17 +734,17 @@ func waitForBatchComputeEnvironment(batchComputeEnvName string, batchSvc *batch.
 		compEnvStatus = *batchComputeEnvs.ComputeEnvironments[0].Status
 		// possible statuses: CREATING | UPDATING | DELETING | DELETED | VALID | INVALID
 		if compEnvStatus == "VALID" {
-			Config.Logger.Print("Debug: Compute environment is ready")
+			getConfig().Logger.Print("Debug: Compute environment is ready")
 			break
 		}
 		if !mustBeValid && compEnvStatus == "INVALID" {
-			Config.Logger.Printf("Debug: Compute environment is %s and can't be used, but can be updated", compEnvStatus)
+			getConfig().Logger.Printf("Debug: Compute environment is %s and can't be used, but can be updated", compEnvStatus)
 			break
 		}
 		if i == maxIter {
 			return fmt.Errorf("compute environment is not ready after %v seconds. Exiting", maxIter*iterDelaySecs)
 		}
-		Config.Logger.Printf("Info: Compute environment is %s, waiting %vs and checking again", compEnvStatus, iterDelaySecs)
+		getConfig().Logger.Printf("Info: Compute environment is %s, waiting %vs and checking again", compEnvStatus, iterDelaySecs)
 		time.Sleep(time.Duration(iterDelaySecs) * time.Second)
 	}
 	return nil
@@ -752,14 +752,14 @@ func waitForBatchComputeEnvironment(batchComputeEnvName string, batchSvc *batch.
 
 // Create IAM role for AWS Batch compute environment
 func createEcsInstanceProfile(iamSvc *iam.IAM, name string) (*string, error) {
-	Config.Logger.Printf("Debug: Creating ECS instance profile '%s'", name)
+	getConfig().Logger.Printf("Debug: Creating ECS instance profile '%s'", name)
 
 	instanceProfile, err := iamSvc.GetInstanceProfile(&iam.GetInstanceProfileInput{
 		InstanceProfileName: aws.String(name),
 	})
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == iam.ErrCodeNoSuchEntityException {
-			Config.Logger.Printf("Debug: Instance profile '%s' does not exist, creating it", name)
+			getConfig().Logger.Printf("Debug: Instance profile '%s' does not exist, creating it", name)
 			_, err = iamSvc.CreateInstanceProfile(&iam.CreateInstanceProfileInput{
 				InstanceProfileName: aws.String(name),
 			})
@@ -771,7 +771,7 @@ func createEcsInstanceProfile(iamSvc *iam.IAM, name string) (*string, error) {
 	}
 
 	// Create the IAM role
-	Config.Logger.Printf("Debug: Creating IAM role '%s'", name)
+	getConfig().Logger.Printf("Debug: Creating IAM role '%s'", name)
 	rolePolicy := `{
 		"Version": "2012-10-17",
 		"Statement": [
@@ -788,10 +788,10 @@ func createEcsInstanceProfile(iamSvc *iam.IAM, name string) (*string, error) {
 	})
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == iam.ErrCodeEntityAlreadyExistsException {
-			Config.Logger.Printf("Debug: Role '%s' already exists, assuming it is already linked to instance profile and continuing", name)
+			getConfig().Logger.Printf("Debug: Role '%s' already exists, assuming it is already linked to instance profile and continuing", name)
 			return instanceProfile.InstanceProfile.Arn, nil
 		} else {
-			Config.Logger.Printf("Unable to create IAM role '%s': %v", name, err)
+			getConfig().Logger.Printf("Unable to create IAM role '%s': %v", name, err)
 			return nil, err
 		}
 	}
@@ -810,11 +810,11 @@ func createEcsInstanceProfile(iamSvc *iam.IAM, name string) (*string, error) {
 		RoleName:            aws.String(name),
 	})
 	if err != nil {
-		Config.Logger.Printf("Unable to add role '%s' to instance profile '%s': %s", name, name, err.Error())
+		getConfig().Logger.Printf("Unable to add role '%s' to instance profile '%s': %s", name, name, err.Error())
 		return nil, err
 	}
 
-	Config.Logger.Printf("Info: Set up ECS instance profile '%s'", name)
+	getConfig().Logger.Printf("Info: Set up ECS instance profile '%s'", name)
 	return instanceProfile.InstanceProfile.Arn, nil
 }
 
@@ -832,11 +832,11 @@ func createS3bucket(s3Svc *s3.S3, bucketName string) error {
 	if err != nil {
 		// no need to check for a specific "bucket already exists" error since
 		// `s3Svc.CreateBucket` does not error when the bucket exists
-		Config.Logger.Printf("Error creating S3 bucket '%s': %v", bucketName, err)
+		getConfig().Logger.Printf("Error creating S3 bucket '%s': %v", bucketName, err)
 		return err
 	}
 
-	Config.Logger.Printf("Created S3 bucket '%s'", bucketName)
+	getConfig().Logger.Printf("Created S3 bucket '%s'", bucketName)
 	return nil
 }
 
@@ -851,7 +851,7 @@ func setupSquid(hostname string, userName string, cidrstring string, ec2svc *ec2
 
 	// create subnet
 	subnetName := fmt.Sprintf("%s-nf-subnet-fw-%s", hostname, userName)
-	Config.Logger.Printf("Debug: Creating subnet '%s' with name '%s'", subnet, subnetName)
+	getConfig().Logger.Printf("Debug: Creating subnet '%s' with name '%s'", subnet, subnetName)
 
 	subnetId, err := setupSubnet(subnetName, subnetString, vpcid, ec2svc)
 	if err != nil {
@@ -859,7 +859,7 @@ func setupSquid(hostname string, userName string, cidrstring string, ec2svc *ec2
 	}
 
 	// add route to internet gateway
-	Config.Logger.Printf("Debug: Creating route to internet '%s' in route table '%s'", *igw, *fwRouteTableId)
+	getConfig().Logger.Printf("Debug: Creating route to internet '%s' in route table '%s'", *igw, *fwRouteTableId)
 	_, err = ec2svc.CreateRoute(&ec2.CreateRouteInput{
 		DestinationCidrBlock: aws.String("0.0.0.0/0"),
 		GatewayId:            igw,
@@ -877,7 +877,7 @@ func setupSquid(hostname string, userName string, cidrstring string, ec2svc *ec2
 	if err != nil {
 		return nil, err
 	}
-	Config.Logger.Printf("Debug: Associated route table '%s' to subnet '%s'", *fwRouteTableId, *subnetId)
+	getConfig().Logger.Printf("Debug: Associated route table '%s' to subnet '%s'", *fwRouteTableId, *subnetId)
 
 	// launch squid
 	squidInstanceId, err := launchSquidInstance(hostname, userName, ec2svc, subnetId, vpcid, subnetString)
@@ -885,7 +885,7 @@ func setupSquid(hostname string, userName string, cidrstring string, ec2svc *ec2
 		return nil, err
 	}
 
-	Config.Logger.Printf("Debug: Will add route to Squid '%s' in route table '%s'", *squidInstanceId, *routeTableId)
+	getConfig().Logger.Printf("Debug: Will add route to Squid '%s' in route table '%s'", *squidInstanceId, *routeTableId)
 	// add or replace route to squid
 	_, err = ec2svc.CreateRoute(&ec2.CreateRouteInput{
 		DestinationCidrBlock: aws.String("0.0.0.0/0"),
@@ -899,7 +899,7 @@ func setupSquid(hostname string, userName string, cidrstring string, ec2svc *ec2
 			// waits until the instance is ready.
 			if aerr.Code() == "RouteAlreadyExists" {
 				// the route already exists, replace it
-				Config.Logger.Print("Debug: Route already exists, replacing it")
+				getConfig().Logger.Print("Debug: Route already exists, replacing it")
 				_, err = ec2svc.ReplaceRoute(&ec2.ReplaceRouteInput{
 					DestinationCidrBlock: aws.String("0.0.0.0/0"),
 					InstanceId:           squidInstanceId,
@@ -916,7 +916,7 @@ func setupSquid(hostname string, userName string, cidrstring string, ec2svc *ec2
 		}
 	}
 
-	Config.Logger.Printf("Debug: Created route to Squid '%s' in route table '%s'", *squidInstanceId, *routeTableId)
+	getConfig().Logger.Printf("Debug: Created route to Squid '%s' in route table '%s'", *squidInstanceId, *routeTableId)
 	return subnetId, nil
 }
 
@@ -943,12 +943,12 @@ func setupSubnet(subnetName string, cidr string, vpcid string, ec2Svc *ec2.EC2)
 		return nil, err
 	}
 	if len(exsubnet.Subnets) > 0 {
-		Config.Logger.Printf("Debug: Subnet '%s' already exists, skipping creation", subnetName)
+		getConfig().Logger.Printf("Debug: Subnet '%s' already exists, skipping creation", subnetName)
 		return exsubnet.Subnets[0].SubnetId, nil
 	}
 
 	// create subnet
-	Config.Logger.Printf("Debug: Creating subnet '%v' with name '%s'", cidr, subnetName)
+	getConfig().Logger.Printf("Debug: Creating subnet '%v' with name '%s'", cidr, subnetName)
 	sn, err := ec2Svc.CreateSubnet(&ec2.CreateSubnetInput{
 		CidrBlock: aws.String(cidr),
 		VpcId:     aws.String(vpcid),
@@ -994,7 +994,7 @@ func setupRouteTable(hostname string, userName string, ec2svc *ec2.EC2, vpcid st
 	}
 
 	if len(exrouteTable.RouteTables) > 0 {
-		Config.Logger.Printf("Debug: Route table '%s' already exists, skipping creation", routeTableName)
+		getConfig().Logger.Printf("Debug: Route table '%s' already exists, skipping creation", routeTableName)
 		return exrouteTable.RouteTables[0].RouteTableId, nil
 	}
 	routeTable, err := ec2svc.CreateRouteTable(&ec2.CreateRouteTableInput{
@@ -1019,11 +1019,11 @@ func setupRouteTable(hostname string, userName string, ec2svc *ec2.EC2, vpcid st
 	if err != nil {
 		return nil, err
 	}
-	Config.Logger.Printf("Debug: Created route table '%s' with name '%s'", *routeTable.RouteTable.RouteTableId, routeTableName)
+	getConfig().Logger.Printf("Debug: Created route table '%s' with name '%s'", *routeTable.RouteTable.RouteTableId, routeTableName)
 
 	if routeTableName == fmt.Sprintf("%s-nf-fw-rt-%s", hostname, userName) {
 		// create route
-		Config.Logger.Printf("Debug: Creating route to internet '%s' in route table '%s'", igwid, *routeTable.RouteTable.RouteTableId)
+		getConfig().Logger.Printf("Debug: Creating route to internet '%s' in route table '%s'", igwid, *routeTable.RouteTable.RouteTableId)
 		_, err = ec2svc.CreateRoute(&ec2.CreateRouteInput{
 			DestinationCidrBlock: aws.String("0.0.0.0/0"),
 			GatewayId:            aws.String(igwid),
@@ -1046,7 +1046,7 @@ func associateRouteTablesToSubnets(ec2svc *ec2.EC2, subnets []string, routeTable
 		if err != nil {
 			return err
 		}
-		Config.Logger.Printf("Debug: Associated route table '%s' to subnet '%s'", routeTableId, subnet)
+		getConfig().Logger.Printf("Debug: Associated route table '%s' to subnet '%s'", routeTableId, subnet)
 	}
 	return nil
 }
@@ -1151,7 +1151,7 @@ $(command -v docker) run --name squid --restart=always --network=host -d \
 		_, ipnet, _ := net.ParseCIDR(subnet)
 		privateIP := ipnet.IP
 		privateIP[3] += 10
-		Config.Logger.Print("Debug: Private IP: ", privateIP.String())
+		getConfig().Logger.Print("Debug: Private IP: ", privateIP.String())
 
 		// Get the latest amazonlinux AMI
 		amiId, err := getLatestAmazonLinuxAmi(ec2svc)
@@ -1204,7 +1204,7 @@ $(command -v docker) run --name squid --restart=always --network=host -d \
 			},
 		})
 		if err != nil {
-			Config.Logger.Print("Error launching instance: ", err)
+			getConfig().Logger.Print("Error launching instance: ", err)
 			return nil, err
 		}
 
@@ -1220,7 +1220,7 @@ $(command -v docker) run --name squid --restart=always --network=host -d \
 			return nil, err
 		}
 
-		Config.Logger.Print("Debug: Launched Squid instance")
+		getConfig().Logger.Print("Debug: Launched Squid instance")
 		instanceId = *squid.Instances[0].InstanceId
 	}
 
@@ -1235,11 +1235,11 @@ $(command -v docker) run --name squid --restart=always --network=host -d \
 		}
 		instanceState = *exinstance.Reservations[0].Instances[0].State.Name
 		if instanceState == "running" {
-			Config.Logger.Print("Debug: Squid instance is ready")
+			getConfig().Logger.Print("Debug: Squid instance is ready")
 			break
 		}
 		if instanceState == "stopped" {
-			Config.Logger.Print("Debug: Instance already exists and is stopped, starting it now")
+			getConfig().Logger.Print("Debug: Instance already exists and is stopped, starting it now")
 			_, err := ec2svc.StartInstances(&ec2.StartInstancesInput{
 				InstanceIds: []*string{
 					&instanceId,
@@ -1252,7 +1252,7 @@ $(command -v docker) run --name squid --restart=always --network=host -d \
 		if i == maxIter {
 			return nil, fmt.Errorf("squid instance is not ready after %v seconds. Exiting", maxIter*iterDelaySecs)
 		}
-		Config.Logger.Printf("Info: Squid instance is %s, waiting %vs and checking again", instanceState, iterDelaySecs)
+		getConfig().Logger.Printf("Info: Squid instance is %s, waiting %vs and checking again", instanceState, iterDelaySecs)
 		time.Sleep(time.Duration(iterDelaySecs) * time.Second)
 	}
 
@@ -1280,7 +1280,7 @@ func setupFwSecurityGroup(hostname string, userName string, ec2svc *ec2.EC2, vpc
 		return nil, err
 	}
 	if len(exsecurityGroup.SecurityGroups) > 0 {
-		Config.Logger.Printf("Debug: Security group '%s' already exists, skipping creation", sgName)
+		getConfig().Logger.Printf("Debug: Security group '%s' already exists, skipping creation", sgName)
 		return exsecurityGroup.SecurityGroups[0].GroupId, nil
 	}
 
@@ -1291,7 +1291,7 @@ func setupFwSecurityGroup(hostname string, userName string, ec2svc *ec2.EC2, vpc
 		VpcId:       vpcId,
 	})
 	if err != nil {
-		Config.Logger.Printf("Error creating security group '%s': %v", sgName, err)
+		getConfig().Logger.Printf("Error creating security group '%s': %v", sgName, err)
 		return nil, err
 	}
 
@@ -1313,7 +1313,7 @@ func setupFwSecurityGroup(hostname string, userName string, ec2svc *ec2.EC2, vpc
 		},
 	})
 	if err != nil {
-		Config.Logger.Print("Error adding ingress rule to security group: ", err)
+		getConfig().Logger.Print("Error adding ingress rule to security group: ", err)
 		return nil, err
 	}
 
@@ -1340,7 +1340,7 @@ func getLatestAmazonLinuxAmi(ec2svc *ec2.EC2) (*string, error) {
 		},
 	})
 	if err != nil {
-		Config.Logger.Print("Error getting latest amazonlinux AMI: ", err)
+		getConfig().Logger.Print("Error getting latest amazonlinux AMI: ", err)
 		return nil, err
 	}
 
@@ -1359,7 +1359,7 @@ func getLatestAmazonLinuxAmi(ec2svc *ec2.EC2) (*string, error) {
 
 		}
 
-		Config.Logger.Printf("Info: Found latest amazonlinux AMI: '%s'", *latestImage.ImageId)
+		getConfig().Logger.Printf("Info: Found latest amazonlinux AMI: '%s'", *latestImage.ImageId)
 		return latestImage.ImageId, nil
 	}
 	return nil, errors.New("No amazonlinux AMI found")
@@ -1380,7 +1380,7 @@ func cleanUpNextflowResources(userName string) error {
 	if err != nil {
 		return err
 	}
-	Config.Logger.Printf("Debug: AWS account ID: '%v'", awsAccountId)
+	getConfig().Logger.Printf("Debug: AWS account ID: '%v'", awsAccountId)
 	iamSvc := iam.New(sess, &awsConfig)
 	ec2Svc := ec2.New(sess, &awsConfig)
 
@@ -1391,13 +1391,13 @@ func cleanUpNextflowResources(userName string) error {
 	nextflowUserName := fmt.Sprintf("%s-nf-%s", hostname, userName)
 	err = deleteUserAccessKeys(nextflowUserName, iamSvc)
 	if err != nil {
-		Config.Logger.Printf("Unable to delete access keys for user '%s': %v", nextflowUserName, err)
+		getConfig().Logger.Printf("Unable to delete access keys for user '%s': %v", nextflowUserName, err)
 		return err
 	}
 
 	err = stopSquidInstance(hostname, userName, ec2Svc)
 	if err != nil {
-		Config.Logger.Printf("Warning: Unable to stop Squid instance - continuing: %v", err)
+		getConfig().Logger.Printf("Warning: Unable to stop Squid instance - continuing: %v", err)
 	}
 
 	// NOTE: This was disabled because researchers may need to keep the intermediary files. Instead of
@@ -1416,9 +1416,9 @@ func cleanUpNextflowResources(userName string) error {
 	// 	Prefix: aws.String("xxx-40uchicago-2eedu/"),
 	// })
 	// if err := s3manager.NewBatchDeleteWithClient(s3Svc).Delete(context.Background(), objectsIter); err != nil {
-	// 	Config.Logger.Printf("Unable to delete objects in bucket '%s' at '%s' - continuing: %v", bucketName, objectsKey, err)
+	// 	getConfig().Logger.Printf("Unable to delete objects in bucket '%s' at '%s' - continuing: %v", bucketName, objectsKey, err)
 	// } else {
-	// 	Config.Logger.Printf("Debug: Deleted objects in bucket '%s' at '%s'", bucketName, objectsKey)
+	// 	getConfig().Logger.Printf("Debug: Deleted objects in bucket '%s' at '%s'", bucketName, objectsKey)
 	// }
 
 	return nil
@@ -1429,20 +1429,20 @@ func deleteUserAccessKeys(nextflowUserName string, iamSvc *iam.IAM) error {
 		UserName: &nextflowUserName,
 	})
 	if err != nil {
-		Config.Logger.Printf("Unable to list access keys for user '%s': %v", nextflowUserName, err)
+		getConfig().Logger.Printf("Unable to list access keys for user '%s': %v", nextflowUserName, err)
 		return err
 	}
 	for _, key := range listAccessKeysResult.AccessKeyMetadata {
-		Config.Logger.Printf("Deleting access key '%s' for user '%s'", *key.AccessKeyId, nextflowUserName)
+		getConfig().Logger.Printf("Deleting access key '%s' for user '%s'", *key.AccessKeyId, nextflowUserName)
 		_, err := iamSvc.DeleteAccessKey(&iam.DeleteAccessKeyInput{
 			UserName:    &nextflowUserName,
 			AccessKeyId: key.AccessKeyId,
 		})
 		if err != nil {
-			Config.Logger.Printf("Warning: Unable to delete access key '%s' for user '%s' - continuing: %v", *key.AccessKeyId, nextflowUserName, err)
+			getConfig().Logger.Printf("Warning: Unable to delete access key '%s' for user '%s' - continuing: %v", *key.AccessKeyId, nextflowUserName, err)
 		}
 	}
-	Config.Logger.Printf("Debug: Deleted all access keys for Nextflow AWS user '%s'", nextflowUserName)
+	getConfig().Logger.Printf("Debug: Deleted all access keys for Nextflow AWS user '%s'", nextflowUserName)
 	return nil
 }
 
@@ -1470,12 +1470,12 @@ func stopSquidInstance(hostname string, userName string, ec2svc *ec2.EC2) error
 	if len(exinstance.Reservations) > 0 {
 		// Make sure the instance is stopped
 		if *exinstance.Reservations[0].Instances[0].State.Name == "stopped" {
-			Config.Logger.Print("Debug: Squid instance already stopped, skipping")
+			getConfig().Logger.Print("Debug: Squid instance already stopped, skipping")
 			return nil
 		}
 
 		// Terminate the instance
-		Config.Logger.Print("Debug: running Squid instance found, terminating it now")
+		getConfig().Logger.Print("Debug: running Squid instance found, terminating it now")
 		_, err := ec2svc.TerminateInstances(&ec2.TerminateInstancesInput{
 			InstanceIds: []*string{
 				exinstance.Reservations[0].Instances[0].InstanceId,
@@ -1518,12 +1518,12 @@ var generateNextflowConfig = func(userName string) (string, error) {
 		PathPrefix: pathPrefix,
 	})
 	if err != nil || len(listRolesResult.Roles) == 0 {
-		Config.Logger.Printf("Error getting role with path prefix '%s', which should already exist: %v", *pathPrefix, err)
+		getConfig().Logger.Printf("Error getting role with path prefix '%s', which should already exist: %v", *pathPrefix, err)
 		return "", err
 	}
 	nextflowJobsRoleArn := *listRolesResult.Roles[0].Arn
 
-	Config.Logger.Printf("Generating Nextflow configuration with: Batch queue: '%s'. Job role: '%s'. Workdir: '%s'.", batchJobQueueName, nextflowJobsRoleArn, workDir)
+	getConfig().Logger.Printf("Generating Nextflow configuration with: Batch queue: '%s'. Job role: '%s'. Workdir: '%s'.", batchJobQueueName, nextflowJobsRoleArn, workDir)
 
 	// TODO "ubuntu" container may not always be authorized - replace with a public approved container?
 	configContents := fmt.Sprintf(
@@ -0,0 +1,652 @@
+package hatchery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/google/uuid"
+	k8sv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Operation status values
+const (
+	operationRunning   = "running"
+	operationSucceeded = "succeeded"
+	operationFailed    = "failed"
+)
+
+// Launch phases, in the order they usually run.
+// Not every backend goes through every phase.
+const (
+	phaseNextflowResources = "nextflow-resources"
+	phaseLicense           = "license"
+	phaseAPIKey            = "api-key"
+	phasePVC               = "pvc"
+	phasePod               = "pod"
+	phaseService           = "service"
+	phaseEcsCluster        = "ecs-cluster"
+	phaseEfs               = "efs"
+	phaseTaskDefinition    = "task-definition"
+	phaseEcsService        = "ecs-service-and-alb"
+	phaseTransitGateway    = "transit-gateway"
+	phaseCleanup           = "cleanup"
+)
+
+// How long finished operations are kept around for the `/operations` endpoint
+const operationRetention = 24 * time.Hour
+
+// How often the old operations are deleted from the OperationStore
+const operationStorePruneInterval = time.Hour
+
+// `app` label of the config maps that record the operations
+const operationApp = "hatchery-operation"
+
+// OperationPhase is one step of an Operation
+type OperationPhase struct {
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Operation tracks the progress of a background workspace action (eg a launch)
+type Operation struct {
+	ID            string `json:"id"`
+	Type          string `json:"type"`
+	UserName      string `json:"user"`
+	ContainerID   string `json:"container_id"`
+	ContainerName string `json:"container_name"`
+	WorkspaceName string `json:"workspace,omitempty"`
+	Backend       string `json:"backend"`
+	// resource profile the workspace is launched with, if any
+	ResourceProfile string           `json:"resource_profile,omitempty"`
+	Status          string           `json:"status"`
+	Error           string           `json:"error,omitempty"`
+	Phases          []OperationPhase `json:"phases"`
+	CreatedAt       time.Time        `json:"created_at"`
+	UpdatedAt       time.Time        `json:"updated_at"`
+
+	mu   sync.Mutex
+	done chan struct{}
+}
+
+// operationStore holds the operations started by this replica. When an
+// OperationStore is configured, the operations are also saved there, so
+// that they can be read from any replica.
+type operationStore struct {
+	mu         sync.Mutex
+	operations map[string]*Operation
+	// the last time the old operations were deleted from the
+	// OperationStore
+	lastStorePrune time.Time
+}
+
+// OperationStore persists the operations, so that `/operations` can be
+// served by any replica
+type OperationStore interface {
+	// Save creates or replaces the operation
+	Save(op *Operation) error
+	// Get returns nil if the user has no such operation
+	Get(userName string, id string) (*Operation, error)
+	List(userName string) ([]Operation, error)
+	// Prune deletes the operations that were last updated before `cutoff`
+	Prune(cutoff time.Time) error
+}
+
+var operations = &operationStore{operations: make(map[string]*Operation)}
+
+type operationContextKey struct{}
+
+func newOperation(opType string, userName string, containerID string, containerName string) *Operation {
+	now := time.Now().UTC()
+	return &Operation{
+		ID:            uuid.New().String(),
+		Type:          opType,
+		UserName:      userName,
+		ContainerID:   containerID,
+		ContainerName: containerName,
+		Status:        operationRunning,
+		Phases:        []OperationPhase{},
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		done:          make(chan struct{}),
+	}
+}
+
+// withOperation returns a copy of ctx that carries the given operation, so that
+// the launch functions can report their progress without changing signatures.
+func withOperation(ctx context.Context, op *Operation) context.Context {
+	return context.WithValue(ctx, operationContextKey{}, op)
+}
+
+// operationFromContext returns the operation carried by ctx, or nil. All the
+// Operation methods are safe to call on a nil operation.
+func operationFromContext(ctx context.Context) *Operation {
+	if ctx == nil {
+		return nil
+	}
+	op, _ := ctx.Value(operationContextKey{}).(*Operation)
+	return op
+}
+
+func (op *Operation) startPhase(name string) {
+	if op == nil {
+		return
+	}
+	op.mu.Lock()
+	now := time.Now().UTC()
+	op.Phases = append(op.Phases, OperationPhase{
+		Name:      name,
+		Status:    operationRunning,
+		StartedAt: now,
+	})
+	op.UpdatedAt = now
+	op.mu.Unlock()
+	op.persist()
+}
+
+// endPhase marks the latest phase called `name` as finished
+func (op *Operation) endPhase(name string, err error) {
+	if op == nil {
+		return
+	}
+	op.mu.Lock()
+	now := time.Now().UTC()
+	for i := len(op.Phases) - 1; i >= 0; i-- {
+		if op.Phases[i].Name != name {
+			continue
+		}
+		op.Phases[i].FinishedAt = &now
+		if err != nil {
+			op.Phases[i].Status = operationFailed
+			op.Phases[i].Error = err.Error()
+		} else {
+			op.Phases[i].Status = operationSucceeded
+		}
+		break
+	}
+	op.UpdatedAt = now
+	op.mu.Unlock()
+	op.persist()
+}
+
+// finish records the outcome of the operation and unblocks `wait`
+func (op *Operation) finish(err error) {
+	if op == nil {
+		return
+	}
+	op.mu.Lock()
+	if op.Status != operationRunning {
+		op.mu.Unlock()
+		return
+	}
+	// phases still running when the operation ends did not complete
+	now := time.Now().UTC()
+	for i := range op.Phases {
+		if op.Phases[i].Status == operationRunning {
+			op.Phases[i].Status = operationFailed
+			op.Phases[i].FinishedAt = &now
+		}
+	}
+	if err != nil {
+		op.Status = operationFailed
+		op.Error = err.Error()
+	} else {
+		op.Status = operationSucceeded
+	}
+	op.UpdatedAt = now
+	op.mu.Unlock()
+	op.persist()
+	close(op.done)
+}
+
+// persist saves the operation in the operation store, if there is one, so
+// that other replicas can serve it
+func (op *Operation) persist() {
+	store := getConfig().OperationStore
+	if store == nil {
+		return
+	}
+	snapshot := op.snapshot()
+	if err := store.Save(&snapshot); err != nil {
+		getConfig().Logger.Printf("Unable to save operation %s: %v", op.ID, err)
+	}
+}
+
+// wait blocks until the operation is finished
+func (op *Operation) wait() {
+	<-op.done
+}
+
+// snapshot returns a copy of the operation that is safe to marshal
+func (op *Operation) snapshot() Operation {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return Operation{
+		ID:              op.ID,
+		Type:            op.Type,
+		UserName:        op.UserName,
+		ContainerID:     op.ContainerID,
+		ContainerName:   op.ContainerName,
+		WorkspaceName:   op.WorkspaceName,
+		Backend:         op.Backend,
+		ResourceProfile: op.ResourceProfile,
+		Status:          op.Status,
+		Error:           op.Error,
+		Phases:          append([]OperationPhase{}, op.Phases...),
+		CreatedAt:       op.CreatedAt,
+		UpdatedAt:       op.UpdatedAt,
+	}
+}
+
+func (store *operationStore) add(op *Operation) {
+	store.mu.Lock()
+	store.prune()
+	store.operations[op.ID] = op
+	pruneStored := getConfig().OperationStore != nil && time.Since(store.lastStorePrune) > operationStorePruneInterval
+	if pruneStored {
+		store.lastStorePrune = time.Now()
+	}
+	store.mu.Unlock()
+
+	op.persist()
+	if pruneStored {
+		if err := getConfig().OperationStore.Prune(time.Now().UTC().Add(-operationRetention)); err != nil {
+			getConfig().Logger.Printf("Unable to delete the old operations: %v", err)
+		}
+	}
+}
+
+func (store *operationStore) get(id string) (*Operation, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	op, ok := store.operations[id]
+	return op, ok
+}
+
+// forUser returns the user's operations, most recent first
+func (store *operationStore) forUser(userName string) []Operation {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	result := []Operation{}
+	for _, op := range store.operations {
+		if op.UserName == userName {
+			result = append(result, op.snapshot())
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result
+}
+
+// prune drops finished operations older than `operationRetention`.
+// The caller must hold the store lock.
+func (store *operationStore) prune() {
+	cutoff := time.Now().UTC().Add(-operationRetention)
+	for id, op := range store.operations {
+		snap := op.snapshot()
+		if snap.Status != operationRunning && snap.UpdatedAt.Before(cutoff) {
+			delete(store.operations, id)
+		}
+	}
+}
+
+// runOperation registers the operation and runs `fn` in a goroutine, which
+// hatchery waits for on shutdown. The context passed to `fn` carries the
+// operation.
+var runOperation = func(op *Operation, fn func(ctx context.Context) error) {
+	operations.add(op)
+	goBackground(func() {
+		ctx := withOperation(context.Background(), op)
+		err := callOperation(ctx, fn)
+		if err != nil {
+			getConfig().Logger.Printf("Operation %s (%s) for user %s failed: %v", op.ID, op.Type, op.UserName, err)
+		}
+		op.finish(err)
+		recordOperationMetrics(op, err)
+	})
+}
+
+// getOperation returns the user's operation, or nil if there is none. The
+// operations started by other replicas are read from the OperationStore.
+func getOperation(userName string, id string) (*Operation, error) {
+	if op, ok := operations.get(id); ok {
+		if op.UserName != userName {
+			return nil, nil
+		}
+		snapshot := op.snapshot()
+		return &snapshot, nil
+	}
+	if getConfig().OperationStore == nil {
+		return nil, nil
+	}
+	return getConfig().OperationStore.Get(userName, id)
+}
+
+// listOperations returns the user's operations, including the ones started
+// by other replicas, most recent first
+func listOperations(userName string) ([]Operation, error) {
+	ops := operations.forUser(userName)
+	if getConfig().OperationStore == nil {
+		return ops, nil
+	}
+	stored, err := getConfig().OperationStore.List(userName)
+	if err != nil {
+		return nil, err
+	}
+	// the operations of this replica are more up to date than their
+	// saved copy
+	local := make(map[string]bool)
+	for i := range ops {
+		local[ops[i].ID] = true
+	}
+	for i := range stored {
+		if !local[stored[i].ID] {
+			ops = append(ops, stored[i].snapshot())
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].CreatedAt.After(ops[j].CreatedAt)
+	})
+	return ops, nil
+}
+
+// callOperation calls `fn` and turns a panic into an error, so that a
+// failed operation does not crash hatchery and every other running operation
+func callOperation(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			op := operationFromContext(ctx)
+			getConfig().Logger.Printf("Operation %s panicked: %v\n%s", op.ID, recovered, debug.Stack())
+			err = fmt.Errorf("unexpected error: %v", recovered)
+		}
+	}()
+	return fn(ctx)
+}
+
+// `/operations?id=abc` => return the specified operation
+// `/operations` => return all the current user's operations
+func operationsEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userName := getCurrentUserName(r)
+	if userName == "" {
+		http.Error(w, "Please login", http.StatusUnauthorized)
+		return
+	}
+
+	var result interface{}
+	id := r.URL.Query().Get("id")
+	if id != "" {
+		op, err := getOperation(userName, id)
+		if err != nil {
+			getConfig().Logger.Printf("Unable to read operation %s: %v", id, err)
+			http.Error(w, "Unable to read the operation", http.StatusInternalServerError)
+			return
+		}
+		if op == nil {
+			// do not let users find out about other users' operations
+			http.Error(w, fmt.Sprintf("Operation '%s' not found", id), http.StatusNotFound)
+			return
+		}
+		result = op
+	} else {
+		ops, err := listOperations(userName)
+		if err != nil {
+			getConfig().Logger.Printf("Unable to list the operations of user %s: %v", userName, err)
+			http.Error(w, "Unable to list the operations", http.StatusInternalServerError)
+			return
+		}
+		result = ops
+	}
+
+	out, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, string(out))
+}
+
+// validate checks the settings without creating the store
+func (config OperationsConfig) validate() error {
+	switch config.Type {
+	case "", "configmap":
+	case "dynamodb":
+		if config.DynamodbTable == "" {
+			return fmt.Errorf("'operations' of type 'dynamodb' requires a 'dynamodb-table'")
+		}
+	default:
+		return fmt.Errorf("unknown 'operations' type '%s'", config.Type)
+	}
+	return nil
+}
+
+// newOperationStore returns the store configured by `operations`, or nil
+// if the operations are only kept in memory
+func newOperationStore(config OperationsConfig) (OperationStore, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	switch config.Type {
+	case "configmap":
+		return &configMapOperationStore{}, nil
+	case "dynamodb":
+		return &dynamodbOperationStore{tableName: config.DynamodbTable, db: initializeDbConfig().DynamoDb}, nil
+	}
+	return nil, nil
+}
+
+// configMapOperationStore records each operation in a config map in the
+// local cluster
+type configMapOperationStore struct{}
+
+func operationConfigMapName(id string) string {
+	return fmt.Sprintf("%s-%s", operationApp, id)
+}
+
+func (store *configMapOperationStore) Save(op *Operation) error {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	configMap := &k8sv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        operationConfigMapName(op.ID),
+			Namespace:   getConfig().Config.UserNamespace,
+			Labels:      map[string]string{"app": operationApp},
+			Annotations: map[string]string{userNameAnnotation: op.UserName},
+		},
+		Data: map[string]string{"operation.json": string(data)},
+	}
+	ctx := context.Background()
+	_, err = podClient.ConfigMaps(getConfig().Config.UserNamespace).Create(ctx, configMap, metav1.CreateOptions{})
+	if k8serrors.IsAlreadyExists(err) {
+		_, err = podClient.ConfigMaps(getConfig().Config.UserNamespace).Update(ctx, configMap, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// list returns the saved operations of all the users
+func (store *configMapOperationStore) list(ctx context.Context) ([]Operation, error) {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return nil, fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	configMaps, err := podClient.ConfigMaps(getConfig().Config.UserNamespace).List(ctx, metav1.ListOptions{LabelSelector: "app=" + operationApp})
+	if err != nil {
+		return nil, err
+	}
+	ops := make([]Operation, len(configMaps.Items))
+	for i, configMap := range configMaps.Items {
+		if err := json.Unmarshal([]byte(configMap.Data["operation.json"]), &ops[i]); err != nil {
+			getConfig().Logger.Printf("Unable to parse operation '%s': %v", configMap.Name, err)
+		}
+	}
+	return ops, nil
+}
+
+func (store *configMapOperationStore) Get(userName string, id string) (*Operation, error) {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return nil, fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	configMap, err := podClient.ConfigMaps(getConfig().Config.UserNamespace).Get(context.Background(), operationConfigMapName(id), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	op := &Operation{}
+	if err := json.Unmarshal([]byte(configMap.Data["operation.json"]), op); err != nil {
+		return nil, err
+	}
+	if op.UserName != userName {
+		return nil, nil
+	}
+	return op, nil
+}
+
+func (store *configMapOperationStore) List(userName string) ([]Operation, error) {
+	ops, err := store.list(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	result := []Operation{}
+	for i := range ops {
+		if ops[i].UserName == userName {
+			result = append(result, ops[i].snapshot())
+		}
+	}
+	return result, nil
+}
+
+func (store *configMapOperationStore) Prune(cutoff time.Time) error {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	ctx := context.Background()
+	ops, err := store.list(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range ops {
+		if ops[i].ID == "" || !ops[i].UpdatedAt.Before(cutoff) {
+			continue
+		}
+		err := podClient.ConfigMaps(getConfig().Config.UserNamespace).Delete(ctx, operationConfigMapName(ops[i].ID), metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// dynamodbOperationStore records the operations in a DynamoDB table with
+// partition key `user` and sort key `id` (both strings). The old operations
+// are deleted by DynamoDB, through the `expires_at` TTL attribute.
+type dynamodbOperationStore struct {
+	tableName string
+	db        dynamodbiface.DynamoDBAPI
+}
+
+func (store *dynamodbOperationStore) Save(op *Operation) error {
+	item, err := dynamodbattribute.MarshalMap(op)
+	if err != nil {
+		return err
+	}
+	expiresAt := op.UpdatedAt.Add(operationRetention).Unix()
+	item["expires_at"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(expiresAt, 10))}
+	_, err = store.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(store.tableName),
+		Item:      item,
+	})
+	recordDependencyError(dependencyDynamoDB, err)
+	return err
+}
+
+func (store *dynamodbOperationStore) Get(userName string, id string) (*Operation, error) {
+	output, err := store.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(store.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user": {S: aws.String(userName)},
+			"id":   {S: aws.String(id)},
+		},
+	})
+	recordDependencyError(dependencyDynamoDB, err)
+	if err != nil {
+		return nil, err
+	}
+	if len(output.Item) == 0 {
+		return nil, nil
+	}
+	op := &Operation{}
+	err = dynamodbattribute.UnmarshalMap(output.Item, op)
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (store *dynamodbOperationStore) List(userName string) ([]Operation, error) {
+	keyCondition := expression.Key("user").Equal(expression.Value(userName))
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCondition).Build()
+	if err != nil {
+		return nil, err
+	}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(store.tableName),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		KeyConditionExpression:    expr.KeyCondition(),
+	}
+	ops := []Operation{}
+	for {
+		output, err := store.db.Query(input)
+		recordDependencyError(dependencyDynamoDB, err)
+		if err != nil {
+			return nil, err
+		}
+		page := []Operation{}
+		err = dynamodbattribute.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		for i := range page {
+			ops = append(ops, page[i].snapshot())
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			return ops, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+// Prune does nothing: DynamoDB deletes the expired operations
+func (store *dynamodbOperationStore) Prune(cutoff time.Time) error {
+	return nil
+}
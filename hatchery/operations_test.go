@@ -0,0 +1,209 @@
+package hatchery
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+func TestOperationPhases(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	op := newOperation("launch", "testUser", "container_a", "Container A")
+	runOperation(op, func(ctx context.Context) error {
+		ctxOp := operationFromContext(ctx)
+		ctxOp.startPhase(phaseAPIKey)
+		ctxOp.endPhase(phaseAPIKey, nil)
+		ctxOp.startPhase(phasePod)
+		ctxOp.endPhase(phasePod, errors.New("pod creation failed"))
+		ctxOp.startPhase(phaseService)
+		return errors.New("pod creation failed")
+	})
+	op.wait()
+
+	got := op.snapshot()
+	if got.Status != operationFailed || got.Error != "pod creation failed" {
+		t.Errorf("unexpected operation outcome: %v %v", got.Status, got.Error)
+	}
+	wantPhases := []OperationPhase{
+		{Name: phaseAPIKey, Status: operationSucceeded},
+		{Name: phasePod, Status: operationFailed, Error: "pod creation failed"},
+		// phases that were still running when the operation ended are marked as failed
+		{Name: phaseService, Status: operationFailed},
+	}
+	if len(got.Phases) != len(wantPhases) {
+		t.Fatalf("expected %v phases, got %v", len(wantPhases), got.Phases)
+	}
+	for i, want := range wantPhases {
+		phase := got.Phases[i]
+		if phase.Name != want.Name || phase.Status != want.Status || phase.Error != want.Error || phase.FinishedAt == nil {
+			t.Errorf("unexpected phase %v: got %+v, want %+v", i, phase, want)
+		}
+	}
+
+	// a nil operation (eg a launch function called outside of an operation) is a no-op
+	var nilOp *Operation
+	nilOp.startPhase(phasePod)
+	nilOp.endPhase(phasePod, nil)
+	nilOp.finish(nil)
+	if operationFromContext(context.Background()) != nil {
+		t.Errorf("expected no operation in an empty context")
+	}
+}
+
+func TestOperationPanic(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	op := newOperation("launch", "testUser", "container_a", "Container A")
+	runOperation(op, func(ctx context.Context) error {
+		operationFromContext(ctx).startPhase(phasePod)
+		var nodes []string
+		_ = nodes[0]
+		return nil
+	})
+	op.wait()
+
+	got := op.snapshot()
+	if got.Status != operationFailed || !strings.Contains(got.Error, "index out of range") {
+		t.Errorf("expected the panic to fail the operation, got %v %v", got.Status, got.Error)
+	}
+	if len(got.Phases) != 1 || got.Phases[0].Status != operationFailed {
+		t.Errorf("expected the running phase to be marked as failed, got %+v", got.Phases)
+	}
+}
+
+func TestOperationsEndpoint(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	op := newOperation("launch", "testUser", "container_a", "Container A")
+	runOperation(op, func(ctx context.Context) error {
+		return nil
+	})
+	op.wait()
+
+	testCases := []struct {
+		name       string
+		url        string
+		username   string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "OwnOperation",
+			url:        "/operations?id=" + op.ID,
+			username:   "testUser",
+			wantStatus: http.StatusOK,
+			wantBody:   "\"status\":\"succeeded\"",
+		},
+		{
+			name:       "OtherUserOperation",
+			url:        "/operations?id=" + op.ID,
+			username:   "otherUser",
+			wantStatus: http.StatusNotFound,
+			wantBody:   "not found",
+		},
+		{
+			name:       "ListOperations",
+			url:        "/operations",
+			username:   "testUser",
+			wantStatus: http.StatusOK,
+			wantBody:   op.ID,
+		},
+		{
+			name:       "NoUsername",
+			url:        "/operations",
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   "Please login",
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Logf("Testing Operations Endpoint when %s", testcase.name)
+		req, err := http.NewRequest("GET", testcase.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if testcase.username != "" {
+			req.Header.Set("REMOTE_USER", testcase.username)
+		}
+		w := httptest.NewRecorder()
+		http.HandlerFunc(operationsEndpoint).ServeHTTP(w, req)
+
+		if w.Code != testcase.wantStatus {
+			t.Errorf("handler returned wrong status code:\ngot: '%v'\nwant: '%v'", w.Code, testcase.wantStatus)
+		}
+		if !strings.Contains(w.Body.String(), testcase.wantBody) {
+			t.Errorf("handler returned wrong response:\ngot: '%v'\nwant it to contain: '%v'", w.Body.String(), testcase.wantBody)
+		}
+	}
+}
+
+func TestConfigMapOperationStore(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_getLocalPodClient := getLocalPodClient
+	original_operations := operations
+	defer func() {
+		SetConfig(original_config)
+		getLocalPodClient = original_getLocalPodClient
+		operations = original_operations
+	}()
+
+	SetConfig(&FullHatcheryConfig{
+		Logger:         log.New(io.Discard, "", log.LstdFlags),
+		Config:         HatcheryConfig{UserNamespace: "jupyter-pods"},
+		OperationStore: &configMapOperationStore{},
+	})
+	podClient := fake.NewSimpleClientset().CoreV1()
+	getLocalPodClient = func() corev1.CoreV1Interface {
+		return podClient
+	}
+	operations = &operationStore{operations: make(map[string]*Operation)}
+
+	op := newOperation("launch", "testUser", "container_a", "Container A")
+	runOperation(op, func(ctx context.Context) error {
+		operationFromContext(ctx).startPhase(phasePod)
+		operationFromContext(ctx).endPhase(phasePod, nil)
+		return nil
+	})
+	op.wait()
+
+	// another replica, which did not run the operation
+	operations = &operationStore{operations: make(map[string]*Operation)}
+	got, err := getOperation("testUser", op.ID)
+	if err != nil || got == nil || got.Status != operationSucceeded || len(got.Phases) != 1 {
+		t.Errorf("expected the saved operation, got %+v, %v", got, err)
+	}
+	if got, err := getOperation("otherUser", op.ID); got != nil || err != nil {
+		t.Errorf("expected other users not to see the operation, got %+v, %v", got, err)
+	}
+	ops, err := listOperations("testUser")
+	if err != nil || len(ops) != 1 || ops[0].ID != op.ID {
+		t.Errorf("expected the saved operation to be listed, got %+v, %v", ops, err)
+	}
+
+	// only the operations that were not updated recently are deleted
+	err = getConfig().OperationStore.Prune(time.Now().UTC().Add(-time.Hour))
+	if ops, _ := listOperations("testUser"); err != nil || len(ops) != 1 {
+		t.Errorf("expected the recent operation to be kept, got %+v, %v", ops, err)
+	}
+	err = getConfig().OperationStore.Prune(time.Now().UTC().Add(time.Hour))
+	if ops, _ := listOperations("testUser"); err != nil || len(ops) != 0 {
+		t.Errorf("expected the old operation to be deleted, got %+v, %v", ops, err)
+	}
+	configMaps, _ := podClient.ConfigMaps("jupyter-pods").List(context.Background(), metav1.ListOptions{})
+	if len(configMaps.Items) != 0 {
+		t.Errorf("expected no config map left, got %d", len(configMaps.Items))
+	}
+}
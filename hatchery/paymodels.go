@@ -1,6 +1,7 @@
 package hatchery
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
@@ -9,6 +10,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var ErrNopaymodels = errors.New("no paymodels found")
@@ -31,7 +33,7 @@ var payModelsFromDatabase = func(userName string, current bool) (payModels *[]Pa
 	}
 	expr, err := expression.NewBuilder().WithFilter(filt).Build()
 	if err != nil {
-		Config.Logger.Printf("Got error building expression: %s", err)
+		getConfig().Logger.Printf("Got error building expression: %s", err)
 		return nil, err
 	}
 
@@ -39,11 +41,12 @@ var payModelsFromDatabase = func(userName string, current bool) (payModels *[]Pa
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		FilterExpression:          expr.Filter(),
-		TableName:                 aws.String(Config.Config.PayModelsDynamodbTable),
+		TableName:                 aws.String(getConfig().Config.PayModelsDynamodbTable),
 	}
 	res, err := dynamodbSvc.Scan(params)
+	recordDependencyError(dependencyDynamoDB, err)
 	if err != nil {
-		Config.Logger.Printf("Query API call failed: %s", err)
+		getConfig().Logger.Printf("Query API call failed: %s", err)
 		return nil, err
 	}
 
@@ -51,7 +54,7 @@ var payModelsFromDatabase = func(userName string, current bool) (payModels *[]Pa
 	var payModelMap []PayModel
 	err = dynamodbattribute.UnmarshalListOfMaps(res.Items, &payModelMap)
 	if err != nil {
-		Config.Logger.Printf("Got error unmarshalling paymodels: %s", err)
+		getConfig().Logger.Printf("Got error unmarshalling paymodels: %s", err)
 		return nil, err
 	}
 
@@ -60,7 +63,7 @@ var payModelsFromDatabase = func(userName string, current bool) (payModels *[]Pa
 
 func payModelFromConfig(userName string) (pm *PayModel, err error) {
 	var payModel PayModel
-	for _, configPaymodel := range Config.PayModelMap {
+	for _, configPaymodel := range getConfig().PayModelMap {
 		if configPaymodel.User == userName {
 			payModel = configPaymodel
 		}
@@ -75,7 +78,7 @@ var getCurrentPayModel = func(userName string) (result *PayModel, err error) {
 
 	var pm *[]PayModel
 
-	if Config != nil && Config.Config.PayModelsDynamodbTable == "" {
+	if config := getConfig(); config != nil && config.Config.PayModelsDynamodbTable == "" {
 		pm, err := getDefaultPayModel()
 		if err != nil {
 			return nil, nil
@@ -115,7 +118,7 @@ var getCurrentPayModel = func(userName string) (result *PayModel, err error) {
 	// If exactly one current pay model is found in the database
 	payModel := (*pm)[0]
 	if err != nil {
-		Config.Logger.Printf("Got error unmarshalling: %s", err)
+		getConfig().Logger.Printf("Got error unmarshalling: %s", err)
 		return nil, err
 	}
 	return &payModel, nil
@@ -123,10 +126,10 @@ var getCurrentPayModel = func(userName string) (result *PayModel, err error) {
 
 var getDefaultPayModel = func() (defaultPaymodel *PayModel, err error) {
 	var pm PayModel
-	if Config.Config.DefaultPayModel == pm {
+	if getConfig().Config.DefaultPayModel == pm {
 		return nil, fmt.Errorf("no default paymodel set")
 	}
-	return &Config.Config.DefaultPayModel, nil
+	return &getConfig().Config.DefaultPayModel, nil
 }
 
 var getPayModelsForUser = func(userName string) (result *AllPayModels, err error) {
@@ -136,7 +139,7 @@ var getPayModelsForUser = func(userName string) (result *AllPayModels, err error
 	PayModels := AllPayModels{}
 	var payModelMap *[]PayModel
 
-	if Config.Config.PayModelsDynamodbTable != "" {
+	if getConfig().Config.PayModelsDynamodbTable != "" {
 		payModelMap, err = payModelsFromDatabase(userName, false)
 		if err != nil {
 			return nil, err
@@ -205,6 +208,65 @@ var setCurrentPaymodel = func(userName string, workspaceid string) (paymodel *Pa
 	return nil, fmt.Errorf("no paymodel with id %s found for user %s", workspaceid, userName)
 }
 
+type launchPayModelContextKey struct{}
+
+// withLaunchPayModel returns a copy of ctx that records the pay model the
+// workspace is launched with
+func withLaunchPayModel(ctx context.Context, payModel *PayModel) context.Context {
+	return context.WithValue(ctx, launchPayModelContextKey{}, payModel)
+}
+
+// setPayModelAnnotation records the ID of the pay model the workspace is
+// launched with in the annotations of its resources, so that it is
+// terminated with that pay model even if the user switches to another one
+func setPayModelAnnotation(ctx context.Context, annotations map[string]string) {
+	if payModel, _ := ctx.Value(launchPayModelContextKey{}).(*PayModel); payModel != nil && payModel.Id != "" {
+		annotations[payModelAnnotation] = payModel.Id
+	}
+}
+
+// getWorkspacePayModelID returns the ID of the pay model the workspace was
+// launched with, read from the local service that routes traffic to it, or
+// "" if it is unknown
+var getWorkspacePayModelID = func(ctx context.Context, userName string, workspaceName string) string {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return ""
+	}
+	service, err := podClient.Services(getConfig().Config.UserNamespace).Get(ctx, workspaceToResourceName(userName, workspaceName, "service"), metav1.GetOptions{})
+	if err != nil {
+		return ""
+	}
+	return service.Annotations[payModelAnnotation]
+}
+
+// getWorkspacePayModel returns the user's pay model with the ID a workspace
+// was launched with. The user's current pay model is returned for the
+// workspaces launched before the pay model was recorded, and if the pay
+// model does not exist anymore.
+func getWorkspacePayModel(userName string, payModelID string) (*PayModel, error) {
+	if payModelID == "" {
+		return getCurrentPayModel(userName)
+	}
+	payModels, err := getPayModelsForUser(userName)
+	if err != nil {
+		getConfig().Logger.Printf("Unable to get the pay models of user %s, using their current pay model: %v", userName, err)
+		return getCurrentPayModel(userName)
+	}
+	if payModels != nil {
+		for _, payModel := range payModels.PayModels {
+			if payModel.Id == payModelID {
+				return &payModel, nil
+			}
+		}
+		if payModels.CurrentPayModel != nil && payModels.CurrentPayModel.Id == payModelID {
+			return payModels.CurrentPayModel, nil
+		}
+	}
+	getConfig().Logger.Printf("Pay model %s of user %s not found, using their current pay model", payModelID, userName)
+	return getCurrentPayModel(userName)
+}
+
 var resetCurrentPaymodel = func(userName string) error {
 	sess := session.Must(session.NewSessionWithOptions(session.Options{
 		Config: aws.Config{
@@ -241,10 +303,11 @@ func updateCurrentPaymodelInDB(userName string, workspaceid string, svc *dynamod
 			},
 		},
 		ReturnValues:     aws.String("ALL_NEW"),
-		TableName:        aws.String(Config.Config.PayModelsDynamodbTable),
+		TableName:        aws.String(getConfig().Config.PayModelsDynamodbTable),
 		UpdateExpression: aws.String("SET #CPM = :f"),
 	}
 	_, err = svc.UpdateItem(input)
+	recordDependencyError(dependencyDynamoDB, err)
 	if err != nil {
 		return err
 	}
@@ -275,10 +338,11 @@ func resetCurrentPaymodelInDB(userName string, svc *dynamodb.DynamoDB) error {
 				},
 			},
 			ReturnValues:     aws.String("ALL_NEW"),
-			TableName:        aws.String(Config.Config.PayModelsDynamodbTable),
+			TableName:        aws.String(getConfig().Config.PayModelsDynamodbTable),
 			UpdateExpression: aws.String("SET #CPM = :f"),
 		}
 		_, err := svc.UpdateItem(input)
+		recordDependencyError(dependencyDynamoDB, err)
 		if err != nil {
 			return err
 		}
@@ -1,6 +1,8 @@
 package hatchery
 
 import (
+	"io"
+	"log"
 	"reflect"
 	"testing"
 )
@@ -115,7 +117,7 @@ func Test_GetCurrentPayModel(t *testing.T) {
 	for _, testcase := range testCases {
 		t.Logf("Testing GetCurrentPaymodel when %s", testcase.name)
 		/* Setup */
-		Config = testcase.mockConfig
+		SetConfig(testcase.mockConfig)
 		getDefaultPayModel = func() (*PayModel, error) {
 			return testcase.mockDefaultPaymodel, nil
 		}
@@ -288,7 +290,7 @@ func Test_GetPayModelsForUser(t *testing.T) {
 		t.Logf("Testing getPayModelsForUser when %s", testcase.name)
 
 		/* Setup */
-		Config = testcase.mockConfig
+		SetConfig(testcase.mockConfig)
 		getCurrentPayModel = func(username string) (*PayModel, error) {
 			return testcase.mockCurrentPayModel, nil
 		}
@@ -314,3 +316,45 @@ func Test_GetPayModelsForUser(t *testing.T) {
 		}
 	}
 }
+
+func TestGetWorkspacePayModel(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_getCurrentPayModel := getCurrentPayModel
+	original_getPayModelsForUser := getPayModelsForUser
+	defer func() {
+		SetConfig(original_config)
+		getCurrentPayModel = original_getCurrentPayModel
+		getPayModelsForUser = original_getPayModelsForUser
+	}()
+
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags)})
+
+	launchPayModel := PayModel{Id: "launch", Name: "Direct Pay", AWSAccountId: "111"}
+	currentPayModel := PayModel{Id: "current", Name: "STRIDES Credits", AWSAccountId: "222", CurrentPayModel: true}
+	getCurrentPayModel = func(userName string) (*PayModel, error) {
+		return &currentPayModel, nil
+	}
+	getPayModelsForUser = func(userName string) (*AllPayModels, error) {
+		return &AllPayModels{CurrentPayModel: &currentPayModel, PayModels: []PayModel{launchPayModel, currentPayModel}}, nil
+	}
+
+	testCases := []struct {
+		name       string
+		payModelID string
+		want       string
+	}{
+		// the user switched pay models after the launch
+		{name: "the pay model was recorded at launch", payModelID: "launch", want: "launch"},
+		{name: "the workspace was launched before the pay model was recorded", payModelID: "", want: "current"},
+		{name: "the pay model does not exist anymore", payModelID: "deleted", want: "current"},
+	}
+	for _, testcase := range testCases {
+		t.Logf("Testing getWorkspacePayModel when %s", testcase.name)
+		got, err := getWorkspacePayModel("testUser", testcase.payModelID)
+		if err != nil || got == nil || got.Id != testcase.want {
+			t.Errorf("expected pay model '%s', got %+v, %v", testcase.want, got, err)
+		}
+	}
+}
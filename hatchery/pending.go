@@ -0,0 +1,636 @@
+package hatchery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/google/uuid"
+	k8sv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Kinds of pending operations
+const (
+	// an ECS launch. A launch that was interrupted is rolled back rather
+	// than completed, since that would need the user's access token, which
+	// is not persisted, unless its task was already running.
+	pendingEcsLaunch = "ecs-launch"
+	// resets the user's current pay model once their workspace is gone
+	pendingPayModelReset = "paymodel-reset"
+	// publishes the event for a launched workspace once it is running or
+	// failed to start
+	pendingStartupWatch = "startup-watch"
+)
+
+// `app` label of the config maps that record the pending operations
+const pendingOperationApp = "hatchery-pending-operation"
+
+const (
+	// how often a replica records that it is still running its pending
+	// operations
+	pendingHeartbeatInterval = 30 * time.Second
+	// an operation that has not been heartbeated for this long is resumed
+	// by another replica, or by the same one after a restart
+	pendingStaleAfter               = 2 * time.Minute
+	defaultPendingOperationDeadline = time.Hour
+	pendingRetryMaxBackoff          = 2 * time.Minute
+)
+
+// the delay before the first retry, doubled at each attempt
+var pendingRetryBackoff = 5 * time.Second
+
+// instanceID identifies this replica as the owner of pending operations
+var instanceID = func() string {
+	hostname, _ := os.Hostname()
+	return hostname + "-" + uuid.New().String()[:8]
+}()
+
+// PendingOperation records a background operation that must complete even
+// if hatchery restarts. It is retried until it succeeds or its deadline
+// passes.
+type PendingOperation struct {
+	ID            string `json:"id"`
+	Kind          string `json:"kind"`
+	UserName      string `json:"user"`
+	WorkspaceName string `json:"workspace,omitempty"`
+	ContainerID   string `json:"container_id,omitempty"`
+	AWSAccountId  string `json:"aws_account_id,omitempty"`
+	// set once an ECS launch failed and is being rolled back
+	RollBack bool `json:"roll_back,omitempty"`
+	// the launch operation a startup watch publishes the events of
+	OperationID string `json:"operation_id,omitempty"`
+	Backend     string `json:"backend,omitempty"`
+	// the replica running the operation, and the last time it said so
+	Owner       string    `json:"owner"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Deadline    time.Time `json:"deadline"`
+
+	// the version of the config map the operation was read from
+	resourceVersion string
+}
+
+// PendingOperationStore persists the pending operations, so that they can
+// be resumed after a restart
+type PendingOperationStore interface {
+	// Save creates or replaces the operation
+	Save(op PendingOperation) error
+	Delete(id string) error
+	List() ([]PendingOperation, error)
+	// Claim makes `owner` the owner of an operation returned by List. It
+	// returns false if the operation changed since it was listed, eg because
+	// another replica claimed it first.
+	Claim(op PendingOperation, owner string) (bool, error)
+}
+
+// validate checks the settings without creating the store
+func (config PendingOperationsConfig) validate() error {
+	switch config.Type {
+	case "", "configmap":
+	case "dynamodb":
+		if config.DynamodbTable == "" {
+			return fmt.Errorf("'pending-operations' of type 'dynamodb' requires a 'dynamodb-table'")
+		}
+	default:
+		return fmt.Errorf("unknown 'pending-operations' type '%s'", config.Type)
+	}
+	return nil
+}
+
+// newPendingOperationStore returns the store described by the
+// configuration, or nil if pending operations are not persisted
+func newPendingOperationStore(config PendingOperationsConfig) (PendingOperationStore, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	switch config.Type {
+	case "configmap":
+		return &configMapPendingOperationStore{}, nil
+	case "dynamodb":
+		return &dynamodbPendingOperationStore{tableName: config.DynamodbTable, db: initializeDbConfig().DynamoDb}, nil
+	}
+	return nil, nil
+}
+
+func newPendingOperation(kind string, userName string, workspaceName string) *PendingOperation {
+	deadline := time.Duration(getConfig().Config.PendingOperations.DeadlineSeconds) * time.Second
+	if deadline <= 0 {
+		deadline = defaultPendingOperationDeadline
+	}
+	now := time.Now().UTC()
+	return &PendingOperation{
+		ID:            uuid.New().String(),
+		Kind:          kind,
+		UserName:      userName,
+		WorkspaceName: workspaceName,
+		CreatedAt:     now,
+		Deadline:      now.Add(deadline),
+	}
+}
+
+// pendingOperationSteps runs one attempt of each kind of operation
+var pendingOperationSteps = map[string]func(ctx context.Context, op PendingOperation) error{
+	pendingEcsLaunch:     rollBackEcsLaunch,
+	pendingPayModelReset: resetPayModelOnceTerminated,
+}
+
+// rollBackEcsLaunch terminates what an interrupted or failed ECS launch
+// created. Without the user's access token, the workspace's API key is
+// deleted with the key itself.
+func rollBackEcsLaunch(ctx context.Context, op PendingOperation) error {
+	status, err := statusEcs(ctx, op.UserName, "", op.AWSAccountId)
+	if err != nil {
+		return fmt.Errorf("unable to get the status of the ECS workspace: %v", err)
+	}
+	// the launch failed before the ECS service was created, or it is
+	// already being deleted
+	if status.Status == "Not Found" || status.Status == "Terminating" {
+		return nil
+	}
+	_, err = terminateEcsWorkspace(ctx, op.UserName, "", op.AWSAccountId)
+	return err
+}
+
+// resumeInterruptedEcsLaunch checks the ECS task of a launch that was
+// interrupted by a restart. A launch whose task is running had completed:
+// its record is deleted and it returns true. Otherwise the launch must be
+// rolled back.
+func resumeInterruptedEcsLaunch(ctx context.Context, op PendingOperation) (bool, error) {
+	status, err := statusEcs(ctx, op.UserName, "", op.AWSAccountId)
+	if err != nil {
+		return false, fmt.Errorf("unable to get the status of the ECS workspace: %v", err)
+	}
+	if status.Status == "Running" {
+		getConfig().Logger.Printf("The ECS workspace of pending %s operation %s of user %s is running: the launch completed", op.Kind, op.ID, op.UserName)
+		publishEvent(newEcsLaunchEvent(eventWorkspaceRunning, op))
+		if err := getConfig().PendingOperationStore.Delete(op.ID); err != nil {
+			getConfig().Logger.Printf("Unable to delete pending %s operation %s of user %s: %v", op.Kind, op.ID, op.UserName, err)
+		}
+		return true, nil
+	}
+	event := newEcsLaunchEvent(eventWorkspaceFailed, op)
+	event.Details = map[string]string{"error": "the launch was interrupted by a hatchery restart"}
+	publishEvent(event)
+	return false, nil
+}
+
+func newEcsLaunchEvent(eventType string, op PendingOperation) Event {
+	event := newEvent(eventType, op.UserName, op.WorkspaceName, op.ContainerID)
+	event.Backend = backendEcs
+	event.OperationID = op.ID
+	return event
+}
+
+// resetPayModelOnceTerminated resets the user's current pay model if the
+// terminated workspace is gone
+func resetPayModelOnceTerminated(ctx context.Context, op PendingOperation) error {
+	status, err := getWorkspaceStatus(ctx, op.UserName, op.WorkspaceName, "")
+	if err != nil {
+		return fmt.Errorf("unable to get the status of the workspace: %v", err)
+	}
+	if status == nil || status.Status != "Not Found" {
+		return fmt.Errorf("the workspace is not terminated yet")
+	}
+	return resetCurrentPaymodel(op.UserName)
+}
+
+// pendingRegistry holds the pending operations this replica runs
+type pendingRegistry struct {
+	mu         sync.Mutex
+	operations map[string]*PendingOperation
+}
+
+var pendingOperations = &pendingRegistry{operations: make(map[string]*PendingOperation)}
+
+// start records that this replica runs the operation, and returns false if
+// it already does
+func (registry *pendingRegistry) start(op *PendingOperation) bool {
+	registry.mu.Lock()
+	if _, ok := registry.operations[op.ID]; ok {
+		registry.mu.Unlock()
+		return false
+	}
+	registry.operations[op.ID] = op
+	registry.mu.Unlock()
+	registry.save(op)
+	return true
+}
+
+func (registry *pendingRegistry) isRunning(id string) bool {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	_, ok := registry.operations[id]
+	return ok
+}
+
+// snapshot returns a copy of the operation that is safe to read
+func (registry *pendingRegistry) snapshot(op *PendingOperation) PendingOperation {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return *op
+}
+
+// save records the operation in the configured store, if any. Failing to
+// record it does not fail the operation: it can only not be resumed.
+func (registry *pendingRegistry) save(op *PendingOperation) {
+	registry.mu.Lock()
+	op.Owner = instanceID
+	op.HeartbeatAt = time.Now().UTC()
+	record := *op
+	registry.mu.Unlock()
+
+	store := getConfig().PendingOperationStore
+	if store == nil {
+		return
+	}
+	if err := store.Save(record); err != nil {
+		getConfig().Logger.Printf("Unable to save pending %s operation %s of user %s: %v", op.Kind, op.ID, op.UserName, err)
+	}
+}
+
+// update changes the operation with `fn` and records it
+func (registry *pendingRegistry) update(op *PendingOperation, fn func(op *PendingOperation)) {
+	registry.mu.Lock()
+	fn(op)
+	registry.mu.Unlock()
+	registry.save(op)
+}
+
+// failed records a failed attempt and returns how many attempts were made
+func (registry *pendingRegistry) failed(op *PendingOperation, err error) int {
+	registry.mu.Lock()
+	op.Attempts++
+	op.LastError = err.Error()
+	attempts := op.Attempts
+	registry.mu.Unlock()
+	registry.save(op)
+	return attempts
+}
+
+// finish stops running the operation, and deletes it from the store unless
+// it is left for a later resume
+func (registry *pendingRegistry) finish(op *PendingOperation, keep bool) {
+	registry.mu.Lock()
+	delete(registry.operations, op.ID)
+	registry.mu.Unlock()
+
+	store := getConfig().PendingOperationStore
+	if store == nil || keep {
+		return
+	}
+	if err := store.Delete(op.ID); err != nil {
+		getConfig().Logger.Printf("Unable to delete pending %s operation %s of user %s: %v", op.Kind, op.ID, op.UserName, err)
+	}
+}
+
+// heartbeat records that this replica still runs its operations
+func (registry *pendingRegistry) heartbeat() {
+	registry.mu.Lock()
+	running := make([]*PendingOperation, 0, len(registry.operations))
+	for _, op := range registry.operations {
+		running = append(running, op)
+	}
+	registry.mu.Unlock()
+	for _, op := range running {
+		registry.save(op)
+	}
+}
+
+// pendingRetryDelay returns how long to wait before the next attempt
+func pendingRetryDelay(attempts int, deadline time.Time) time.Duration {
+	delay := pendingRetryBackoff
+	for i := 1; i < attempts && delay < pendingRetryMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > pendingRetryMaxBackoff {
+		delay = pendingRetryMaxBackoff
+	}
+	if untilDeadline := time.Until(deadline); untilDeadline < delay {
+		delay = untilDeadline
+	}
+	return delay
+}
+
+// runPendingOperation runs the operation, which must have been started,
+// until it succeeds or its deadline passes, with an exponential backoff
+// between attempts. When hatchery shuts down, a persisted operation is left
+// to be resumed after the restart.
+func runPendingOperation(ctx context.Context, op *PendingOperation) error {
+	step, ok := pendingOperationSteps[op.Kind]
+	if !ok {
+		pendingOperations.finish(op, false)
+		return fmt.Errorf("unknown pending operation kind '%s'", op.Kind)
+	}
+	for {
+		err := step(ctx, pendingOperations.snapshot(op))
+		if err == nil {
+			getConfig().Logger.Printf("Completed pending %s operation %s of user %s", op.Kind, op.ID, op.UserName)
+			pendingOperations.finish(op, false)
+			return nil
+		}
+		attempts := pendingOperations.failed(op, err)
+		if !time.Now().Before(op.Deadline) {
+			getConfig().Logger.Printf("Giving up on pending %s operation %s of user %s after %d attempts: %v", op.Kind, op.ID, op.UserName, attempts, err)
+			pendingOperations.finish(op, false)
+			return err
+		}
+		getConfig().Logger.Printf("Attempt %d of pending %s operation %s of user %s failed, retrying: %v", attempts, op.Kind, op.ID, op.UserName, err)
+
+		// operations that are not persisted are not interrupted
+		var stopped <-chan struct{}
+		if getConfig().PendingOperationStore != nil {
+			stopped = backgroundWork.stopped()
+		}
+		select {
+		case <-time.After(pendingRetryDelay(attempts, op.Deadline)):
+		case <-stopped:
+			getConfig().Logger.Printf("Shutting down: pending %s operation %s of user %s will be resumed after the restart", op.Kind, op.ID, op.UserName)
+			pendingOperations.finish(op, true)
+			return err
+		}
+	}
+}
+
+// startPendingOperation records the operation and runs it in the
+// background
+func startPendingOperation(op *PendingOperation) {
+	pendingOperations.start(op)
+	if op.Kind == pendingStartupWatch {
+		// a watch can last for as long as a workspace takes to start: it is
+		// not waited for on shutdown, but resumed after the restart
+		go runStartupWatch(context.Background(), op)
+		return
+	}
+	goBackground(func() {
+		_ = runPendingOperation(context.Background(), op)
+	})
+}
+
+// StartPendingOperationResumer starts the background job that resumes the
+// pending operations that were interrupted by a restart, or whose replica
+// is gone, if a `pending-operations` store is configured
+func StartPendingOperationResumer() {
+	if getConfig().PendingOperationStore == nil {
+		getConfig().Logger.Printf("Pending operations are not persisted: they will not be resumed after a restart")
+		return
+	}
+	interval := time.Duration(getConfig().Config.PendingOperations.ResumeIntervalSeconds) * time.Second
+	getConfig().Logger.Printf("Starting the pending operation resumer, running every %v", interval)
+	go func() {
+		for {
+			resumePendingOperations(context.Background())
+			time.Sleep(interval)
+		}
+	}()
+	go func() {
+		for {
+			time.Sleep(pendingHeartbeatInterval)
+			pendingOperations.heartbeat()
+		}
+	}()
+}
+
+// resumePendingOperations claims and runs the stale pending operations, and
+// returns how many it resumed
+func resumePendingOperations(ctx context.Context) int {
+	store := getConfig().PendingOperationStore
+	if store == nil {
+		return 0
+	}
+	select {
+	case <-backgroundWork.stopped():
+		return 0
+	default:
+	}
+	ops, err := store.List()
+	if err != nil {
+		getConfig().Logger.Printf("Unable to list the pending operations: %v", err)
+		return 0
+	}
+
+	resumed := 0
+	now := time.Now()
+	for _, op := range ops {
+		if pendingOperations.isRunning(op.ID) || now.Sub(op.HeartbeatAt) < pendingStaleAfter {
+			continue
+		}
+		claimed, err := store.Claim(op, instanceID)
+		if err != nil {
+			getConfig().Logger.Printf("Unable to claim pending %s operation %s of user %s: %v", op.Kind, op.ID, op.UserName, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		getConfig().Logger.Printf("Resuming pending %s operation %s of user %s, last run by %s", op.Kind, op.ID, op.UserName, op.Owner)
+		if op.Kind == pendingEcsLaunch && !op.RollBack {
+			completed, err := resumeInterruptedEcsLaunch(ctx, op)
+			if err != nil {
+				// resumed again once the claim is stale
+				getConfig().Logger.Printf("Unable to resume pending %s operation %s of user %s: %v", op.Kind, op.ID, op.UserName, err)
+				continue
+			}
+			if completed {
+				resumed++
+				continue
+			}
+			op.RollBack = true
+		}
+		op := op
+		startPendingOperation(&op)
+		resumed++
+	}
+	return resumed
+}
+
+// configMapPendingOperationStore records each pending operation in a config
+// map in the local cluster. Claims use optimistic concurrency.
+type configMapPendingOperationStore struct{}
+
+func pendingOperationConfigMapName(id string) string {
+	return fmt.Sprintf("%s-%s", pendingOperationApp, id)
+}
+
+func (store *configMapPendingOperationStore) configMap(op PendingOperation) (*k8sv1.ConfigMap, error) {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return nil, err
+	}
+	return &k8sv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            pendingOperationConfigMapName(op.ID),
+			Namespace:       getConfig().Config.UserNamespace,
+			Labels:          map[string]string{"app": pendingOperationApp},
+			Annotations:     map[string]string{userNameAnnotation: op.UserName},
+			ResourceVersion: op.resourceVersion,
+		},
+		Data: map[string]string{"operation.json": string(data)},
+	}, nil
+}
+
+func (store *configMapPendingOperationStore) Save(op PendingOperation) error {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	// replaced whatever its version
+	op.resourceVersion = ""
+	configMap, err := store.configMap(op)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	_, err = podClient.ConfigMaps(getConfig().Config.UserNamespace).Create(ctx, configMap, metav1.CreateOptions{})
+	if k8serrors.IsAlreadyExists(err) {
+		_, err = podClient.ConfigMaps(getConfig().Config.UserNamespace).Update(ctx, configMap, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+func (store *configMapPendingOperationStore) Delete(id string) error {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	err := podClient.ConfigMaps(getConfig().Config.UserNamespace).Delete(context.Background(), pendingOperationConfigMapName(id), metav1.DeleteOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (store *configMapPendingOperationStore) List() ([]PendingOperation, error) {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return nil, fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	configMaps, err := podClient.ConfigMaps(getConfig().Config.UserNamespace).List(context.Background(), metav1.ListOptions{LabelSelector: "app=" + pendingOperationApp})
+	if err != nil {
+		return nil, err
+	}
+	ops := []PendingOperation{}
+	for _, configMap := range configMaps.Items {
+		var op PendingOperation
+		if err := json.Unmarshal([]byte(configMap.Data["operation.json"]), &op); err != nil {
+			getConfig().Logger.Printf("Unable to parse pending operation '%s': %v", configMap.Name, err)
+			continue
+		}
+		op.resourceVersion = configMap.ResourceVersion
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+func (store *configMapPendingOperationStore) Claim(op PendingOperation, owner string) (bool, error) {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return false, fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	op.Owner = owner
+	op.HeartbeatAt = time.Now().UTC()
+	configMap, err := store.configMap(op)
+	if err != nil {
+		return false, err
+	}
+	// fails with a conflict if the config map was updated since it was
+	// listed
+	_, err = podClient.ConfigMaps(getConfig().Config.UserNamespace).Update(context.Background(), configMap, metav1.UpdateOptions{})
+	if k8serrors.IsConflict(err) || k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// dynamodbPendingOperationStore records the pending operations in a
+// DynamoDB table with partition key `id` (string). Claims are conditional
+// on the heartbeat the operation was listed with.
+type dynamodbPendingOperationStore struct {
+	tableName string
+	db        dynamodbiface.DynamoDBAPI
+}
+
+func (store *dynamodbPendingOperationStore) put(op PendingOperation, condition *expression.ConditionBuilder) error {
+	item, err := dynamodbattribute.MarshalMap(op)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(store.tableName),
+		Item:      item,
+	}
+	if condition != nil {
+		expr, err := expression.NewBuilder().WithCondition(*condition).Build()
+		if err != nil {
+			return err
+		}
+		input.ConditionExpression = expr.Condition()
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
+	}
+	_, err = store.db.PutItem(input)
+	return err
+}
+
+func (store *dynamodbPendingOperationStore) Save(op PendingOperation) error {
+	err := store.put(op, nil)
+	recordDependencyError(dependencyDynamoDB, err)
+	return err
+}
+
+func (store *dynamodbPendingOperationStore) Delete(id string) error {
+	_, err := store.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(store.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(id)},
+		},
+	})
+	recordDependencyError(dependencyDynamoDB, err)
+	return err
+}
+
+func (store *dynamodbPendingOperationStore) List() ([]PendingOperation, error) {
+	ops := []PendingOperation{}
+	input := &dynamodb.ScanInput{TableName: aws.String(store.tableName)}
+	for {
+		output, err := store.db.Scan(input)
+		recordDependencyError(dependencyDynamoDB, err)
+		if err != nil {
+			return nil, err
+		}
+		page := []PendingOperation{}
+		err = dynamodbattribute.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			return ops, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+func (store *dynamodbPendingOperationStore) Claim(op PendingOperation, owner string) (bool, error) {
+	condition := expression.Name("heartbeat_at").Equal(expression.Value(op.HeartbeatAt))
+	op.Owner = owner
+	op.HeartbeatAt = time.Now().UTC()
+	err := store.put(op, &condition)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	recordDependencyError(dependencyDynamoDB, err)
+	return err == nil, err
+}
@@ -0,0 +1,414 @@
+package hatchery
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+func TestNewPendingOperationStore(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	testCases := []struct {
+		name       string
+		config     PendingOperationsConfig
+		wantStore  bool
+		wantErrors bool
+	}{
+		{name: "NotConfigured", config: PendingOperationsConfig{}, wantStore: false},
+		{name: "ConfigMap", config: PendingOperationsConfig{Type: "configmap"}, wantStore: true},
+		{name: "Dynamodb", config: PendingOperationsConfig{Type: "dynamodb", DynamodbTable: "pending"}, wantStore: true},
+		{name: "DynamodbWithoutTable", config: PendingOperationsConfig{Type: "dynamodb"}, wantErrors: true},
+		{name: "UnknownType", config: PendingOperationsConfig{Type: "etcd"}, wantErrors: true},
+	}
+	for _, testcase := range testCases {
+		t.Logf("Testing newPendingOperationStore when %s", testcase.name)
+		store, err := newPendingOperationStore(testcase.config)
+		if testcase.wantErrors != (err != nil) {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if testcase.wantStore != (store != nil) {
+			t.Errorf("expected a store: %v, got %v", testcase.wantStore, store)
+		}
+	}
+}
+
+// setupPendingOperationsTest stores the pending operations in config maps
+// of a fake cluster, with short retry delays
+func setupPendingOperationsTest(t *testing.T) func() {
+	original_config := getConfig()
+	original_getLocalPodClient := getLocalPodClient
+	original_pendingOperationSteps := pendingOperationSteps
+	original_pendingRetryBackoff := pendingRetryBackoff
+	original_backgroundWork := backgroundWork
+
+	SetConfig(&FullHatcheryConfig{
+		Logger:                log.New(io.Discard, "", log.LstdFlags),
+		Config:                HatcheryConfig{UserNamespace: "jupyter-pods"},
+		PendingOperationStore: &configMapPendingOperationStore{},
+	})
+	podClient := fake.NewSimpleClientset().CoreV1()
+	getLocalPodClient = func() corev1.CoreV1Interface {
+		return podClient
+	}
+	pendingRetryBackoff = time.Millisecond
+	backgroundWork = &backgroundTracker{}
+
+	return func() {
+		SetConfig(original_config)
+		getLocalPodClient = original_getLocalPodClient
+		pendingOperationSteps = original_pendingOperationSteps
+		pendingRetryBackoff = original_pendingRetryBackoff
+		backgroundWork = original_backgroundWork
+	}
+}
+
+func TestRunPendingOperation(t *testing.T) {
+	defer SetupAndTeardownTest()()
+	defer setupPendingOperationsTest(t)()
+
+	attempts := 0
+	pendingOperationSteps = map[string]func(context.Context, PendingOperation) error{
+		pendingPayModelReset: func(ctx context.Context, op PendingOperation) error {
+			attempts++
+			stored, err := getConfig().PendingOperationStore.List()
+			if err != nil || len(stored) != 1 || stored[0].ID != op.ID || stored[0].Owner != instanceID {
+				t.Errorf("expected the operation to be stored while it runs, got %v, %v", stored, err)
+			}
+			if attempts < 3 {
+				return errors.New("the workspace is not terminated yet")
+			}
+			return nil
+		},
+	}
+
+	op := newPendingOperation(pendingPayModelReset, "testUser", "")
+	pendingOperations.start(op)
+	err := runPendingOperation(context.Background(), op)
+	if err != nil || attempts != 3 || op.Attempts != 2 {
+		t.Errorf("expected the operation to succeed at the 3rd attempt, got %v after %d attempts", err, attempts)
+	}
+	stored, err := getConfig().PendingOperationStore.List()
+	if err != nil || len(stored) != 0 {
+		t.Errorf("expected the completed operation to be deleted, got %v, %v", stored, err)
+	}
+	if pendingOperations.isRunning(op.ID) {
+		t.Error("expected the completed operation to be finished")
+	}
+
+	// the operation is given up on once its deadline passes
+	attempts = 0
+	pendingOperationSteps[pendingPayModelReset] = func(context.Context, PendingOperation) error {
+		attempts++
+		return errors.New("the workspace is not terminated yet")
+	}
+	op = newPendingOperation(pendingPayModelReset, "testUser", "")
+	op.Deadline = time.Now().Add(20 * time.Millisecond)
+	pendingOperations.start(op)
+	err = runPendingOperation(context.Background(), op)
+	if err == nil || attempts < 2 {
+		t.Errorf("expected the operation to be retried until its deadline, got %v after %d attempts", err, attempts)
+	}
+	stored, _ = getConfig().PendingOperationStore.List()
+	if len(stored) != 0 {
+		t.Errorf("expected the failed operation to be deleted, got %v", stored)
+	}
+
+	// a persisted operation is left for the next replica on shutdown
+	pendingRetryBackoff = time.Hour
+	op = newPendingOperation(pendingPayModelReset, "testUser", "")
+	pendingOperations.start(op)
+	finished := make(chan error, 1)
+	go func() {
+		finished <- runPendingOperation(context.Background(), op)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	_ = backgroundWork.drain(context.Background())
+	select {
+	case err = <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("expected the operation to stop on shutdown")
+	}
+	stored, _ = getConfig().PendingOperationStore.List()
+	if err == nil || len(stored) != 1 || stored[0].ID != op.ID || pendingOperations.isRunning(op.ID) {
+		t.Errorf("expected the interrupted operation to be kept in the store, got %v, %v", stored, err)
+	}
+}
+
+func TestResumePendingOperations(t *testing.T) {
+	defer SetupAndTeardownTest()()
+	defer setupPendingOperationsTest(t)()
+
+	resumed := make(chan PendingOperation, 2)
+	pendingOperationSteps = map[string]func(context.Context, PendingOperation) error{
+		pendingEcsLaunch: func(ctx context.Context, op PendingOperation) error {
+			resumed <- op
+			return nil
+		},
+	}
+	store := getConfig().PendingOperationStore
+	original_statusEcs := statusEcs
+	defer func() {
+		statusEcs = original_statusEcs
+	}()
+	statusEcs = func(context.Context, string, string, string) (*WorkspaceStatus, error) {
+		return &WorkspaceStatus{Status: "Launching"}, nil
+	}
+
+	// interrupted by a restart
+	stale := *newPendingOperation(pendingEcsLaunch, "user1", "")
+	stale.Owner = "hatchery-old"
+	stale.HeartbeatAt = time.Now().Add(-time.Hour)
+	// still running on another replica
+	live := *newPendingOperation(pendingEcsLaunch, "user2", "")
+	live.Owner = "hatchery-other"
+	live.HeartbeatAt = time.Now()
+	for _, op := range []PendingOperation{stale, live} {
+		if err := store.Save(op); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if count := resumePendingOperations(context.Background()); count != 1 {
+		t.Errorf("expected 1 operation to be resumed, got %d", count)
+	}
+	select {
+	case op := <-resumed:
+		if op.ID != stale.ID || op.Owner != instanceID || !op.RollBack {
+			t.Errorf("expected the stale operation to be claimed and rolled back, got %+v", op)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the stale operation to be resumed")
+	}
+	if err := backgroundWork.drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.List()
+	if err != nil || len(stored) != 1 || stored[0].ID != live.ID {
+		t.Errorf("expected only the live operation to be left, got %v, %v", stored, err)
+	}
+
+	// nothing is resumed while shutting down
+	live.HeartbeatAt = time.Now().Add(-time.Hour)
+	if err := store.Save(live); err != nil {
+		t.Fatal(err)
+	}
+	if count := resumePendingOperations(context.Background()); count != 0 {
+		t.Errorf("expected no operation to be resumed on shutdown, got %d", count)
+	}
+}
+
+func TestResumeInterruptedEcsLaunch(t *testing.T) {
+	defer SetupAndTeardownTest()()
+	defer setupPendingOperationsTest(t)()
+
+	original_statusEcs := statusEcs
+	defer func() {
+		statusEcs = original_statusEcs
+	}()
+	sink := make(channelEventSink, 10)
+	getConfig().EventSink = sink
+	rolledBack := make(chan PendingOperation, 3)
+	pendingOperationSteps = map[string]func(context.Context, PendingOperation) error{
+		pendingEcsLaunch: func(ctx context.Context, op PendingOperation) error {
+			rolledBack <- op
+			return nil
+		},
+	}
+	store := getConfig().PendingOperationStore
+
+	testCases := []struct {
+		name         string
+		status       string
+		rollBack     bool
+		wantRollBack bool
+		wantEvent    string
+	}{
+		{name: "the task is running", status: "Running", wantRollBack: false, wantEvent: eventWorkspaceRunning},
+		{name: "the task is starting", status: "Launching", wantRollBack: true, wantEvent: eventWorkspaceFailed},
+		{name: "the service was not created", status: "Not Found", wantRollBack: true, wantEvent: eventWorkspaceFailed},
+		// the launch had failed, and its task may have started since
+		{name: "the launch was being rolled back", status: "Running", rollBack: true, wantRollBack: true},
+	}
+	for _, testcase := range testCases {
+		statusChecked := false
+		statusEcs = func(ctx context.Context, userName, accessToken, awsAcctID string) (*WorkspaceStatus, error) {
+			statusChecked = true
+			return &WorkspaceStatus{Status: testcase.status}, nil
+		}
+		op := *newPendingOperation(pendingEcsLaunch, "testUser", "")
+		op.AWSAccountId = "123456789012"
+		op.RollBack = testcase.rollBack
+		op.Owner = "hatchery-old"
+		op.HeartbeatAt = time.Now().Add(-time.Hour)
+		if err := store.Save(op); err != nil {
+			t.Fatal(err)
+		}
+
+		if count := resumePendingOperations(context.Background()); count != 1 {
+			t.Errorf("expected the operation to be resumed when %s, got %d", testcase.name, count)
+		}
+		if statusChecked == testcase.rollBack {
+			t.Errorf("expected the status to be checked: %v, got %v, when %s", !testcase.rollBack, statusChecked, testcase.name)
+		}
+		select {
+		case resumed := <-rolledBack:
+			if !testcase.wantRollBack || resumed.ID != op.ID {
+				t.Errorf("unexpected roll back of %s when %s", resumed.ID, testcase.name)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if testcase.wantRollBack {
+				t.Errorf("expected the launch to be rolled back when %s", testcase.name)
+			}
+		}
+		if testcase.wantEvent != "" {
+			select {
+			case event := <-sink:
+				if event.Type != testcase.wantEvent || event.OperationID != op.ID || event.Backend != backendEcs {
+					t.Errorf("unexpected event when %s: %+v", testcase.name, event)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("expected a %s event when %s", testcase.wantEvent, testcase.name)
+			}
+		}
+		if err := backgroundWork.drain(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		backgroundWork = &backgroundTracker{}
+		if stored, err := store.List(); err != nil || len(stored) != 0 {
+			t.Errorf("expected the operation to be deleted when %s, got %v, %v", testcase.name, stored, err)
+		}
+	}
+}
+
+func TestRollBackEcsLaunch(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_statusEcs := statusEcs
+	original_terminateEcsWorkspace := terminateEcsWorkspace
+	defer func() {
+		statusEcs = original_statusEcs
+		terminateEcsWorkspace = original_terminateEcsWorkspace
+	}()
+
+	testCases := []struct {
+		name          string
+		status        string
+		wantTerminate bool
+	}{
+		{name: "the ECS service was not created", status: "Not Found", wantTerminate: false},
+		{name: "the ECS service is being deleted", status: "Terminating", wantTerminate: false},
+		{name: "the ECS service is launching", status: "Launching", wantTerminate: true},
+	}
+	for _, testcase := range testCases {
+		t.Logf("Testing rollBackEcsLaunch when %s", testcase.name)
+		terminated := false
+		statusEcs = func(ctx context.Context, userName, accessToken, awsAcctID string) (*WorkspaceStatus, error) {
+			return &WorkspaceStatus{Status: testcase.status}, nil
+		}
+		terminateEcsWorkspace = func(ctx context.Context, userName, accessToken, awsAcctID string) (string, error) {
+			terminated = userName == "testUser" && awsAcctID == "123456789012"
+			return "", nil
+		}
+		op := newPendingOperation(pendingEcsLaunch, "testUser", "")
+		op.AWSAccountId = "123456789012"
+		if err := rollBackEcsLaunch(context.Background(), *op); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if terminated != testcase.wantTerminate {
+			t.Errorf("expected the workspace to be terminated: %v, got %v", testcase.wantTerminate, terminated)
+		}
+	}
+}
+
+type pendingDynamodbMockClient struct {
+	dynamodbiface.DynamoDBAPI
+	putItemInput *dynamodb.PutItemInput
+	items        map[string]map[string]*dynamodb.AttributeValue
+}
+
+func (m *pendingDynamodbMockClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	m.putItemInput = input
+	id := aws.StringValue(input.Item["id"].S)
+	if input.ConditionExpression != nil {
+		// the only condition is on the listed heartbeat
+		var wantHeartbeat string
+		for _, value := range input.ExpressionAttributeValues {
+			wantHeartbeat = aws.StringValue(value.S)
+		}
+		current, ok := m.items[id]
+		if !ok || aws.StringValue(current["heartbeat_at"].S) != wantHeartbeat {
+			return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "condition failed", nil)
+		}
+	}
+	m.items[id] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *pendingDynamodbMockClient) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	delete(m.items, aws.StringValue(input.Key["id"].S))
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (m *pendingDynamodbMockClient) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	items := []map[string]*dynamodb.AttributeValue{}
+	for _, item := range m.items {
+		items = append(items, item)
+	}
+	return &dynamodb.ScanOutput{Items: items}, nil
+}
+
+func TestDynamodbPendingOperationStore(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	mockClient := &pendingDynamodbMockClient{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	store := &dynamodbPendingOperationStore{tableName: "pending", db: mockClient}
+
+	op := *newPendingOperation(pendingPayModelReset, "user1", "")
+	op.Owner = "hatchery-old"
+	op.HeartbeatAt = time.Now().Add(-time.Hour).UTC()
+	if err := store.Save(op); err != nil {
+		t.Fatalf("unable to save the operation: %v", err)
+	}
+	if aws.StringValue(mockClient.putItemInput.TableName) != "pending" {
+		t.Errorf("expected the operation to be put in the pending table, got %v", mockClient.putItemInput)
+	}
+
+	listed, err := store.List()
+	if err != nil || len(listed) != 1 || listed[0].ID != op.ID || listed[0].Kind != pendingPayModelReset {
+		t.Fatalf("unexpected operations %v: %v", listed, err)
+	}
+
+	claimed, err := store.Claim(listed[0], "hatchery-new")
+	if err != nil || !claimed {
+		t.Errorf("expected the operation to be claimed, got %v, %v", claimed, err)
+	}
+	var stored PendingOperation
+	if err := dynamodbattribute.UnmarshalMap(mockClient.items[op.ID], &stored); err != nil || stored.Owner != "hatchery-new" {
+		t.Errorf("expected the new owner to be stored, got %+v, %v", stored, err)
+	}
+	// the operation changed since it was listed
+	claimed, err = store.Claim(listed[0], "hatchery-other")
+	if err != nil || claimed {
+		t.Errorf("expected the operation not to be claimed twice, got %v, %v", claimed, err)
+	}
+
+	if err := store.Delete(op.ID); err != nil {
+		t.Fatalf("unable to delete the operation: %v", err)
+	}
+	listed, err = store.List()
+	if err != nil || len(listed) != 0 {
+		t.Errorf("expected no operations after the delete, got %v, %v", listed, err)
+	}
+}
@@ -38,7 +38,7 @@ const ambassadorYaml = `---
 apiVersion: ambassador/v1
 kind:  Mapping
 name:  %s
-prefix: /
+prefix: %s
 headers:
   remote_user: %s
 service: %s.%s.svc.cluster.local:80
@@ -49,6 +49,24 @@ rewrite: %s
 tls: %s
 `
 
+// localAmbassadorYaml is the Ambassador mapping of the workspaces outside of
+// hatchery's cluster, which are reached at an address (host:port) instead of
+// through a service of the cluster
+const localAmbassadorYaml = `---
+apiVersion: ambassador/v1
+kind:  Mapping
+name:  %s
+prefix: %s
+headers:
+  remote_user: %s
+service: %s
+bypass_auth: true
+timeout_ms: 300000
+use_websocket: true
+rewrite: %s
+tls: %s
+`
+
 type PodConditions struct {
 	Type   string `json:"type"`
 	Status string `json:"status"`
@@ -67,13 +85,18 @@ type WorkspaceStatus struct {
 	IdleTimeLimit    int               `json:"idleTimeLimit"`
 	LastActivityTime int64             `json:"lastActivityTime"`
 	WorkspaceType    string            `json:"workspaceType"`
+	WorkspaceName    string            `json:"workspaceName,omitempty"`
+	// session limits, in milliseconds
+	MaxSessionDuration   int   `json:"maxSessionDuration,omitempty"`
+	SessionEndTime       int64 `json:"sessionEndTime,omitempty"`
+	RemainingSessionTime int64 `json:"remainingSessionTime,omitempty"`
 }
 
 func getPodClient(ctx context.Context, userName string, payModelPtr *PayModel) (corev1.CoreV1Interface, bool, error) {
 	if payModelPtr != nil && !(*payModelPtr).Local {
 		podClient, err := NewEKSClientset(ctx, userName, *payModelPtr)
 		if err != nil {
-			Config.Logger.Printf("Error fetching EKS kubeconfig: %v", err)
+			getConfig().Logger.Printf("Error fetching EKS kubeconfig: %v", err)
 			return nil, true, err
 		} else {
 			return podClient, true, nil
@@ -83,18 +106,18 @@ func getPodClient(ctx context.Context, userName string, payModelPtr *PayModel) (
 	}
 }
 
-func getLocalPodClient() corev1.CoreV1Interface {
+var getLocalPodClient = func() corev1.CoreV1Interface {
 	// creates the in-cluster config
 	config, err := rest.InClusterConfig()
-	config.WrapTransport = kubernetestrace.WrapRoundTripper
 	if err != nil {
-		Config.Logger.Printf("Error creating in-cluster config: %v", err)
+		getConfig().Logger.Printf("Error creating in-cluster config: %v", err)
 		return nil
 	}
+	config.WrapTransport = kubernetestrace.WrapRoundTripper
 	// creates the clientset
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		Config.Logger.Printf("Error creating in-cluster clientset: %v", err)
+		getConfig().Logger.Printf("Error creating in-cluster clientset: %v", err)
 		return nil
 	}
 	// Access jobs. We can't do it all in one line, since we need to receive the
@@ -117,7 +140,7 @@ func NewEKSClientset(ctx context.Context, userName string, payModel PayModel) (c
 	}
 	result, err := eksSvc.DescribeClusterWithContext(ctx, input)
 	if err != nil {
-		Config.Logger.Printf("Error calling DescribeCluster: %v", err)
+		getConfig().Logger.Printf("Error calling DescribeCluster: %v", err)
 		return nil, err
 	}
 	cluster := result.Cluster
@@ -165,22 +188,49 @@ func checkPodReadiness(pod *k8sv1.Pod) bool {
 	return true
 }
 
-func podStatus(ctx context.Context, userName string, accessToken string, payModelPtr *PayModel) (*WorkspaceStatus, error) {
+// workspacePodStatus returns the status of a workspace pod, as reported
+// by `/status`, or "" if the pod phase is unknown
+func workspacePodStatus(pod *k8sv1.Pod) string {
+	if pod.DeletionTimestamp != nil {
+		return "Terminating"
+	}
+	switch pod.Status.Phase {
+	case "Failed", "Succeeded", "Unknown":
+		return "Stopped"
+	case "Pending", "Running":
+		if checkPodReadiness(pod) {
+			return "Running"
+		}
+		return "Launching"
+	}
+	return ""
+}
+
+func podStatus(ctx context.Context, userName string, workspaceName string, accessToken string, payModelPtr *PayModel) (*WorkspaceStatus, error) {
 	status := WorkspaceStatus{}
 	status.WorkspaceType = "Kubernetes"
+	status.WorkspaceName = workspaceName
 	podClient, isExternalClient, err := getPodClient(ctx, userName, payModelPtr)
 	if err != nil {
-		// Config.Logger.Panic("Error trying to fetch kubeConfig: %v", err)
+		// getConfig().Logger.Panic("Error trying to fetch kubeConfig: %v", err)
 		status.Status = fmt.Sprintf("%v", err)
 		return &status, err
 	}
 
-	podName := userToResourceName(userName, "pod")
+	podName := workspaceToResourceName(userName, workspaceName, "pod")
 
-	serviceName := userToResourceName(userName, "service")
+	serviceName := workspaceToResourceName(userName, workspaceName, "service")
 
-	pod, err := podClient.Pods(Config.Config.UserNamespace).Get(ctx, podName, metav1.GetOptions{})
-	_, serviceErr := podClient.Services(Config.Config.UserNamespace).Get(ctx, serviceName, metav1.GetOptions{})
+	var pod *k8sv1.Pod
+	var serviceErr error
+	if statusCache := getStatusCache(); statusCache != nil && !isExternalClient {
+		// the pods of the local cluster are watched: no need to call the
+		// k8s API. Pods from the cache must not be modified.
+		pod, err = statusCache.getPod(ctx, podClient, getConfig().Config.UserNamespace, podName)
+	} else {
+		pod, err = podClient.Pods(getConfig().Config.UserNamespace).Get(ctx, podName, metav1.GetOptions{})
+		_, serviceErr = podClient.Services(getConfig().Config.UserNamespace).Get(ctx, serviceName, metav1.GetOptions{})
+	}
 	if err != nil {
 		if isExternalClient && serviceErr == nil {
 			// only worry about service if podClient is external EKS
@@ -188,11 +238,11 @@ func podStatus(ctx context.Context, userName string, accessToken string, payMode
 			deleteOptions := metav1.DeleteOptions{
 				PropagationPolicy: &policy,
 			}
-			err := podClient.Services(Config.Config.UserNamespace).Delete(ctx, serviceName, deleteOptions)
+			err := podClient.Services(getConfig().Config.UserNamespace).Delete(ctx, serviceName, deleteOptions)
 			if err != nil {
-				Config.Logger.Printf("Error deleting service. %s", err)
+				getConfig().Logger.Printf("Error deleting service. %s", err)
 			}
-			Config.Logger.Printf("Pod has been terminated, but service is still being terminated. Wait for service to be killed.")
+			getConfig().Logger.Printf("Pod has been terminated, but service is still being terminated. Wait for service to be killed.")
 			// Pod has been terminated, but service is still being terminated. Wait for service to be killed
 			status.Status = "Terminating"
 			return &status, nil
@@ -208,71 +258,65 @@ func podStatus(ctx context.Context, userName string, accessToken string, payMode
 		return &status, nil
 	}
 
-	switch pod.Status.Phase {
-	case "Failed":
-		fallthrough
-	case "Succeeded":
-		fallthrough
-	case "Unknown":
-		status.Status = "Stopped"
-	case "Pending":
-		fallthrough
+	var hatchApp *Container
+	if container, ok := getConfig().ContainersMap[resolveContainerID(pod.Annotations[containerIDAnnotation])]; ok {
+		hatchApp = &container
+	}
+	setSessionTimes(&status, pod.CreationTimestamp.Time, getMaxSessionDuration(hatchApp, payModelPtr))
+
+	status.Status = workspacePodStatus(pod)
+	switch status.Status {
 	case "Running":
-		allReady := checkPodReadiness(pod)
-		if allReady {
-			status.Status = "Running"
-			for _, container := range pod.Spec.Containers {
-				for _, arg := range container.Args {
-					if strings.Contains(arg, "shutdown_no_activity_timeout=") {
-						argSplit := strings.Split(arg, "=")
-						idleTimeLimit, err := strconv.Atoi(argSplit[len(argSplit)-1])
-						if err == nil {
-							status.IdleTimeLimit = idleTimeLimit * 1000
-							lastActivityTime, err := getKernelIdleTimeWithContext(ctx, accessToken)
-							status.LastActivityTime = lastActivityTime
-							if err != nil {
-								log.Println(err.Error())
-							}
-						} else {
+		for _, container := range pod.Spec.Containers {
+			for _, arg := range container.Args {
+				if strings.Contains(arg, "shutdown_no_activity_timeout=") {
+					argSplit := strings.Split(arg, "=")
+					idleTimeLimit, err := strconv.Atoi(argSplit[len(argSplit)-1])
+					if err == nil {
+						status.IdleTimeLimit = idleTimeLimit * 1000
+						lastActivityTime, err := getKernelIdleTimeWithContext(ctx, workspaceName, accessToken)
+						status.LastActivityTime = lastActivityTime
+						if err != nil {
 							log.Println(err.Error())
 						}
-						break
+					} else {
+						log.Println(err.Error())
 					}
+					break
 				}
 			}
-		} else {
-			status.Status = "Launching"
-			conditions := make([]PodConditions, len(pod.Status.Conditions))
-			for i, cond := range pod.Status.Conditions {
-				conditions[i].Status = string(cond.Status)
-				conditions[i].Type = string(cond.Type)
-			}
-			status.Conditions = conditions
-			containerStates := make([]ContainerStates, len(pod.Status.ContainerStatuses))
-			for i, cs := range pod.Status.ContainerStatuses {
-				containerStates[i].State = cs.State
-				containerStates[i].Name = cs.Name
-				containerStates[i].Ready = cs.Ready
-			}
-			status.ContainerStates = containerStates
 		}
-	default:
+	case "Launching":
+		conditions := make([]PodConditions, len(pod.Status.Conditions))
+		for i, cond := range pod.Status.Conditions {
+			conditions[i].Status = string(cond.Status)
+			conditions[i].Type = string(cond.Type)
+		}
+		status.Conditions = conditions
+		containerStates := make([]ContainerStates, len(pod.Status.ContainerStatuses))
+		for i, cs := range pod.Status.ContainerStatuses {
+			containerStates[i].State = cs.State
+			containerStates[i].Name = cs.Name
+			containerStates[i].Ready = cs.Ready
+		}
+		status.ContainerStates = containerStates
+	case "":
 		fmt.Printf("Unknown pod status for %s: %s\n", podName, string(pod.Status.Phase))
 	}
 
 	return &status, nil
 }
 
-var statusK8sPod = func(ctx context.Context, userName string, accessToken string, payModelPtr *PayModel) (*WorkspaceStatus, error) {
-	status, err := podStatus(ctx, userName, accessToken, payModelPtr)
+var statusK8sPod = func(ctx context.Context, userName string, workspaceName string, accessToken string, payModelPtr *PayModel) (*WorkspaceStatus, error) {
+	status, err := podStatus(ctx, userName, workspaceName, accessToken, payModelPtr)
 	if err != nil {
 		status.Status = fmt.Sprintf("%v", err)
-		Config.Logger.Printf("Error getting status: %v", err)
+		getConfig().Logger.Printf("Error getting status: %v", err)
 	}
 	return status, nil
 }
 
-var deleteK8sPod = func(ctx context.Context, userName string, accessToken string, payModelPtr *PayModel) error {
+var deleteK8sPod = func(ctx context.Context, userName string, workspaceName string, accessToken string, payModelPtr *PayModel) error {
 	podClient, _, err := getPodClient(ctx, userName, payModelPtr)
 	if err != nil {
 		return err
@@ -285,19 +329,21 @@ var deleteK8sPod = func(ctx context.Context, userName string, accessToken string
 		GracePeriodSeconds: &grace,
 	}
 
-	podName := userToResourceName(userName, "pod")
-	pod, err := podClient.Pods(Config.Config.UserNamespace).Get(ctx, podName, metav1.GetOptions{})
+	podName := workspaceToResourceName(userName, workspaceName, "pod")
+	pod, err := podClient.Pods(getConfig().Config.UserNamespace).Get(ctx, podName, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("a workspace pod was not found: %s", err)
 	}
 	containers := pod.Spec.Containers
-	var mountedAPIKeyID string
+	var mountedAPIKey, mountedAPIKeyID string
 	for i := range containers {
 		if containers[i].Name == "hatchery-container" {
 			for j := range containers[i].Env {
-				if containers[i].Env[j].Name == "API_KEY_ID" {
+				switch containers[i].Env[j].Name {
+				case "API_KEY":
+					mountedAPIKey = containers[i].Env[j].Value
+				case "API_KEY_ID":
 					mountedAPIKeyID = containers[i].Env[j].Value
-					break
 				}
 			}
 			break
@@ -305,7 +351,7 @@ var deleteK8sPod = func(ctx context.Context, userName string, accessToken string
 	}
 	if mountedAPIKeyID != "" {
 		fmt.Printf("Found mounted API key. Attempting to delete API Key with ID %s for user %s\n", mountedAPIKeyID, userName)
-		err := deleteAPIKeyWithContext(ctx, accessToken, mountedAPIKeyID)
+		err := deleteWorkspaceAPIKey(ctx, accessToken, mountedAPIKey, mountedAPIKeyID)
 		if err != nil {
 			fmt.Printf("Error occurred when deleting API Key with ID %s for user %s: %s\n", mountedAPIKeyID, userName, err.Error())
 		} else {
@@ -314,18 +360,18 @@ var deleteK8sPod = func(ctx context.Context, userName string, accessToken string
 	}
 
 	fmt.Printf("Attempting to delete pod %s for user %s\n", podName, userName)
-	err = podClient.Pods(Config.Config.UserNamespace).Delete(ctx, podName, deleteOptions)
+	err = podClient.Pods(getConfig().Config.UserNamespace).Delete(ctx, podName, deleteOptions)
 	if err != nil {
 		fmt.Printf("Error occurred when deleting pod: %s", err)
 	}
 
-	serviceName := userToResourceName(userName, "service")
-	_, err = podClient.Services(Config.Config.UserNamespace).Get(ctx, serviceName, metav1.GetOptions{})
+	serviceName := workspaceToResourceName(userName, workspaceName, "service")
+	_, err = podClient.Services(getConfig().Config.UserNamespace).Get(ctx, serviceName, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("a workspace service was not found: %s", err)
 	}
 	fmt.Printf("Attempting to delete service %s for user %s\n", serviceName, userName)
-	err = podClient.Services(Config.Config.UserNamespace).Delete(ctx, serviceName, deleteOptions)
+	err = podClient.Services(getConfig().Config.UserNamespace).Delete(ctx, serviceName, deleteOptions)
 	if err != nil {
 		fmt.Printf("Error occurred when deleting service: %s", err)
 	}
@@ -351,15 +397,41 @@ func userToResourceName(userName string, resourceType string) string {
 	return fmt.Sprintf("%s-%s", resourceType, safeUserName)
 }
 
+// workspaceToResourceName is like userToResourceName, but for the
+// resources of one of the user's named workspaces. The default
+// workspace ("") keeps the historical names, and the persistent
+// volume claim is shared by all the user's workspaces.
+// `escapism` never outputs "--", so the names can not collide with
+// the default workspace of another user.
+func workspaceToResourceName(userName string, workspaceName string, resourceType string) string {
+	if workspaceName == "" || resourceType == "claim" {
+		return userToResourceName(userName, resourceType)
+	}
+	safeUserName := escapism(userName)
+	if resourceType == "pod" {
+		return fmt.Sprintf("hatchery-%s--%s", safeUserName, workspaceName)
+	}
+	if resourceType == "service" {
+		return fmt.Sprintf("h-%s--%s-s", safeUserName, workspaceName)
+	}
+	if resourceType == "mapping" { // ambassador mapping
+		return fmt.Sprintf("%s--%s-mapping", safeUserName, workspaceName)
+	}
+
+	return fmt.Sprintf("%s-%s--%s", resourceType, safeUserName, workspaceName)
+}
+
 // buildPod returns a pod ready to pass to the k8s API given
 // a hatchery Container instance, and the name of the user
 // launching the app
-func buildPod(hatchConfig *FullHatcheryConfig, hatchApp *Container, userName string, extraVars []k8sv1.EnvVar) (pod *k8sv1.Pod, err error) {
-	podName := userToResourceName(userName, "pod")
+func buildPod(hatchConfig *FullHatcheryConfig, hatchApp *Container, userName string, workspaceName string, extraVars []k8sv1.EnvVar) (pod *k8sv1.Pod, err error) {
+	podName := workspaceToResourceName(userName, workspaceName, "pod")
 	labels := make(map[string]string)
 	labels["app"] = podName
+	labels[workspaceLabel] = workspaceLabelValue
 	annotations := make(map[string]string)
-	annotations["gen3username"] = userName
+	annotations[userNameAnnotation] = userName
+	annotations[workspaceNameAnnotation] = workspaceName
 	var sideCarRunAsUser int64
 	var sideCarRunAsGroup int64
 	var hostToContainer = k8sv1.MountPropagationHostToContainer
@@ -376,6 +448,7 @@ func buildPod(hatchConfig *FullHatcheryConfig, hatchApp *Container, userName str
 		}
 		envVars = append(envVars, envVar)
 	}
+	envVars = append(envVars, getK8sEnvValueFrom(hatchApp.EnvValueFrom)...)
 
 	//hatchConfig.Logger.Printf("environment configured")
 
@@ -387,6 +460,7 @@ func buildPod(hatchConfig *FullHatcheryConfig, hatchApp *Container, userName str
 		}
 		sidecarEnvVars = append(sidecarEnvVars, envVar)
 	}
+	sidecarEnvVars = append(sidecarEnvVars, getK8sEnvValueFrom(hatchConfig.Config.Sidecar.EnvValueFrom)...)
 	for _, value := range extraVars {
 		sidecarEnvVars = append(sidecarEnvVars, value)
 		envVars = append(envVars, value)
@@ -400,6 +474,15 @@ func buildPod(hatchConfig *FullHatcheryConfig, hatchApp *Container, userName str
 		Name:  "GEN3_ENDPOINT",
 		Value: os.Getenv("GEN3_ENDPOINT"),
 	})
+	// named workspaces are served under their own path, so apps that
+	// generate absolute links need to know it
+	envVars = append(envVars, k8sv1.EnvVar{
+		Name:  "WORKSPACE_NAME",
+		Value: workspaceName,
+	}, k8sv1.EnvVar{
+		Name:  "WORKSPACE_PROXY_PATH",
+		Value: "/lw-workspace/proxy/" + workspaceURLPrefix(workspaceName),
+	})
 
 	//hatchConfig.Logger.Printf("sidecar configured")
 
@@ -468,6 +551,8 @@ func buildPod(hatchConfig *FullHatcheryConfig, hatchApp *Container, userName str
 		})
 	}
 
+	volumes = append(volumes, hatchApp.ExtraVolumes...)
+
 	//hatchConfig.Logger.Printf("volumes configured")
 
 	var pullPolicy k8sv1.PullPolicy
@@ -501,6 +586,12 @@ func buildPod(hatchConfig *FullHatcheryConfig, hatchApp *Container, userName str
 		})
 	}
 
+	scheduling := getPodScheduling(hatchConfig.Config.SchedulingConfig, hatchApp.SchedulingConfig)
+	if mountUserVolume {
+		labels[userVolumeLabel] = userToResourceName(userName, "pod")
+		scheduling.Affinity = withUserVolumeAffinity(scheduling.Affinity, userName)
+	}
+
 	pod = &k8sv1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        podName,
@@ -510,7 +601,7 @@ func buildPod(hatchConfig *FullHatcheryConfig, hatchApp *Container, userName str
 		},
 		Spec: k8sv1.PodSpec{
 			SecurityContext:    &securityContext,
-			InitContainers:     []k8sv1.Container{},
+			InitContainers:     append([]k8sv1.Container{}, hatchApp.InitContainers...),
 			EnableServiceLinks: &falseVal,
 			Containers: []k8sv1.Container{
 				{
@@ -526,16 +617,7 @@ func buildPod(hatchConfig *FullHatcheryConfig, hatchApp *Container, userName str
 					Command:         hatchConfig.Config.Sidecar.Command,
 					Args:            hatchConfig.Config.Sidecar.Args,
 					VolumeMounts:    volumeMounts,
-					Resources: k8sv1.ResourceRequirements{
-						Limits: k8sv1.ResourceList{
-							k8sv1.ResourceCPU:    resource.MustParse(hatchConfig.Config.Sidecar.CPULimit),
-							k8sv1.ResourceMemory: resource.MustParse(hatchConfig.Config.Sidecar.MemoryLimit),
-						},
-						Requests: k8sv1.ResourceList{
-							k8sv1.ResourceCPU:    resource.MustParse(hatchConfig.Config.Sidecar.CPULimit),
-							k8sv1.ResourceMemory: resource.MustParse(hatchConfig.Config.Sidecar.MemoryLimit),
-						},
-					},
+					Resources:       getSidecarResourceRequirements(hatchConfig.Config.Sidecar, hatchConfig.Config.OvercommitRatio),
 					Lifecycle: &k8sv1.Lifecycle{
 						PreStop: &k8sv1.Handler{
 							Exec: &k8sv1.ExecAction{
@@ -545,13 +627,13 @@ func buildPod(hatchConfig *FullHatcheryConfig, hatchApp *Container, userName str
 					},
 				},
 			},
-			RestartPolicy:    k8sv1.RestartPolicyNever,
-			ImagePullSecrets: []k8sv1.LocalObjectReference{},
-			NodeSelector: map[string]string{
-				"role": "jupyter",
-			},
-			Tolerations: []k8sv1.Toleration{{Key: "role", Operator: "Equal", Value: "jupyter", Effect: "NoSchedule", TolerationSeconds: nil}},
-			Volumes:     volumes,
+			RestartPolicy:     k8sv1.RestartPolicyNever,
+			ImagePullSecrets:  []k8sv1.LocalObjectReference{},
+			NodeSelector:      scheduling.NodeSelector,
+			Tolerations:       scheduling.Tolerations,
+			Affinity:          scheduling.Affinity,
+			PriorityClassName: scheduling.PriorityClassName,
+			Volumes:           volumes,
 		},
 	}
 
@@ -583,6 +665,7 @@ func buildPod(hatchConfig *FullHatcheryConfig, hatchApp *Container, userName str
 				Name:      "user-data",
 			})
 		}
+		volumeMounts = append(volumeMounts, hatchApp.ExtraVolumeMounts...)
 
 		pod.Spec.Containers = append(pod.Spec.Containers, k8sv1.Container{
 			Name:  "hatchery-container",
@@ -595,17 +678,8 @@ func buildPod(hatchConfig *FullHatcheryConfig, hatchApp *Container, userName str
 			Command:         hatchApp.Command,
 			Args:            hatchApp.Args,
 			VolumeMounts:    volumeMounts,
-			Resources: k8sv1.ResourceRequirements{
-				Limits: k8sv1.ResourceList{
-					k8sv1.ResourceCPU:    resource.MustParse(hatchApp.CPULimit),
-					k8sv1.ResourceMemory: resource.MustParse(hatchApp.MemoryLimit),
-				},
-				Requests: k8sv1.ResourceList{
-					k8sv1.ResourceCPU:    resource.MustParse(hatchApp.CPULimit),
-					k8sv1.ResourceMemory: resource.MustParse(hatchApp.MemoryLimit),
-				},
-			},
-			Lifecycle: &lifeCycle,
+			Resources:       getContainerResourceRequirements(*hatchApp, hatchConfig.Config.OvercommitRatio),
+			Lifecycle:       &lifeCycle,
 			ReadinessProbe: &k8sv1.Probe{
 				Handler: k8sv1.Handler{
 					HTTPGet: &k8sv1.HTTPGetAction{
@@ -622,20 +696,34 @@ func buildPod(hatchConfig *FullHatcheryConfig, hatchApp *Container, userName str
 	return pod, nil
 }
 
-var createLocalK8sPod = func(ctx context.Context, hash string, userName string, accessToken string, envVars []k8sv1.EnvVar) error {
-	hatchApp := Config.ContainersMap[hash]
-	Config.Logger.Printf("Creating a Local K8s Pod")
+// getLocalK8sEnvVars returns the extra environment variables of a workspace
+// in hatchery's cluster
+func getLocalK8sEnvVars(envVars []k8sv1.EnvVar, apiKey *APIKeyStruct) []k8sv1.EnvVar {
+	var extraVars []k8sv1.EnvVar
+	extraVars = append(extraVars, envVars...)
 
-	apiKey, err := getAPIKeyWithContext(ctx, accessToken)
-	if err != nil {
-		Config.Logger.Printf("Failed to get API key for user '%v', Error: %v", userName, err)
-		return err
-	}
-	Config.Logger.Printf("Created API key for user %v, key ID: %v", userName, apiKey.KeyID)
+	extraVars = append(extraVars, k8sv1.EnvVar{
+		Name:  "API_KEY",
+		Value: apiKey.APIKey,
+	})
+	extraVars = append(extraVars, k8sv1.EnvVar{
+		Name:  "API_KEY_ID",
+		Value: apiKey.KeyID,
+	})
+	return extraVars
+}
 
+// getExternalK8sEnvVars returns the extra environment variables of a
+// workspace in an external cluster, which reaches the commons through its
+// public endpoint
+func getExternalK8sEnvVars(envVars []k8sv1.EnvVar, apiKey *APIKeyStruct, accessToken string) []k8sv1.EnvVar {
 	var extraVars []k8sv1.EnvVar
 	extraVars = append(extraVars, envVars...)
 
+	extraVars = append(extraVars, k8sv1.EnvVar{
+		Name:  "WTS_OVERRIDE_URL",
+		Value: "https://" + os.Getenv("GEN3_ENDPOINT") + "/wts",
+	})
 	extraVars = append(extraVars, k8sv1.EnvVar{
 		Name:  "API_KEY",
 		Value: apiKey.APIKey,
@@ -644,84 +732,84 @@ var createLocalK8sPod = func(ctx context.Context, hash string, userName string,
 		Name:  "API_KEY_ID",
 		Value: apiKey.KeyID,
 	})
+	// TODO: still mounting access token for now, remove this when fully switched to use API key
+	extraVars = append(extraVars, k8sv1.EnvVar{
+		Name:  "ACCESS_TOKEN",
+		Value: accessToken,
+	})
+	return extraVars
+}
 
-	pod, err := buildPod(Config, &hatchApp, userName, extraVars)
-	if err != nil {
-		Config.Logger.Printf("Failed to configure pod for launch for user %v, Error: %v", userName, err)
-		return err
-	}
-	podName := userToResourceName(userName, "pod")
-	podClient, _, err := getPodClient(ctx, userName, nil)
+// buildWorkspacePod returns the pod of the workspace, annotated with the
+// container ID, the resource profile and the pay model
+func buildWorkspacePod(ctx context.Context, hatchApp *Container, hash string, userName string, workspaceName string, extraVars []k8sv1.EnvVar) (*k8sv1.Pod, error) {
+	pod, err := buildPod(getConfig(), hatchApp, userName, workspaceName, extraVars)
 	if err != nil {
-		Config.Logger.Panicf("Error in createLocalK8sPod: %v", err)
-		return err
+		return nil, err
 	}
-	// a null image indicates a dockstore app - always mount user volume
-	mountUserVolume := hatchApp.UserVolumeLocation != ""
-	if mountUserVolume {
-		claimName := userToResourceName(userName, "claim")
+	pod.Annotations[containerIDAnnotation] = hash
+	setResourceProfileAnnotation(ctx, pod.Annotations)
+	setPayModelAnnotation(ctx, pod.Annotations)
+	return pod, nil
+}
 
-		_, err := podClient.PersistentVolumeClaims(Config.Config.UserNamespace).Get(ctx, claimName, metav1.GetOptions{})
-		if err != nil {
-			Config.Logger.Printf("Creating PersistentVolumeClaim %s.\n", claimName)
-			pvc := &k8sv1.PersistentVolumeClaim{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:        claimName,
-					Annotations: pod.Annotations,
-					Labels:      pod.Labels,
-				},
-				Spec: k8sv1.PersistentVolumeClaimSpec{
-					AccessModes: []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteOnce},
-					Resources: k8sv1.ResourceRequirements{
-						Requests: k8sv1.ResourceList{
-							k8sv1.ResourceStorage: resource.MustParse(Config.Config.UserVolumeSize),
-						},
-					},
+// buildUserVolumeClaim returns the claim of the user volume, which is shared
+// by all the user's workspaces. Since it is ReadWriteOnce, the workspace
+// pods that mount it run on the same node.
+func buildUserVolumeClaim(userName string) *k8sv1.PersistentVolumeClaim {
+	return &k8sv1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: userToResourceName(userName, "claim"),
+			// the claim is shared by all the user's workspaces
+			Annotations: map[string]string{userNameAnnotation: userName},
+			Labels:      map[string]string{"app": userToResourceName(userName, "pod")},
+		},
+		Spec: k8sv1.PersistentVolumeClaimSpec{
+			AccessModes: []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteOnce},
+			Resources: k8sv1.ResourceRequirements{
+				Requests: k8sv1.ResourceList{
+					k8sv1.ResourceStorage: resource.MustParse(getConfig().Config.UserVolumeSize),
 				},
-			}
-			_, err := podClient.PersistentVolumeClaims(Config.Config.UserNamespace).Create(ctx, pvc, metav1.CreateOptions{})
-			if err != nil {
-				Config.Logger.Printf("Failed to create PVC %s. Error: %s\n", claimName, err)
-				return err
-			}
-		}
+			},
+		},
 	}
+}
 
-	_, err = podClient.Pods(Config.Config.UserNamespace).Create(ctx, pod, metav1.CreateOptions{})
-	if err != nil {
-		Config.Logger.Printf("Failed to launch pod %s for user %s. Image: %s, CPU %s, Memory %s. Error: %s\n", hatchApp.Name, userName, hatchApp.Image, hatchApp.CPULimit, hatchApp.MemoryLimit, err)
-		return err
-	}
+// getAmbassadorMapping returns the Ambassador mapping that routes the
+// workspace URL to the workspace service in hatchery's cluster
+func getAmbassadorMapping(hatchApp Container, userName string, workspaceName string) string {
+	serviceName := workspaceToResourceName(userName, workspaceName, "service")
+	return fmt.Sprintf(ambassadorYaml, workspaceToResourceName(userName, workspaceName, "mapping"), "/"+workspaceURLPrefix(workspaceName), userName, serviceName, getConfig().Config.UserNamespace, hatchApp.PathRewrite, hatchApp.UseTLS)
+}
 
-	Config.Logger.Printf("Launched pod %s for user %s. Image: %s, CPU %s, Memory %s\n", hatchApp.Name, userName, hatchApp.Image, hatchApp.CPULimit, hatchApp.MemoryLimit)
+// getLocalAmbassadorMapping returns the Ambassador mapping that routes the
+// workspace URL to a workspace outside of hatchery's cluster, at
+// `serviceAddress` (host:port)
+func getLocalAmbassadorMapping(hatchApp Container, userName string, workspaceName string, serviceAddress string) string {
+	return fmt.Sprintf(localAmbassadorYaml, workspaceToResourceName(userName, workspaceName, "mapping"), "/"+workspaceURLPrefix(workspaceName), userName, serviceAddress, hatchApp.PathRewrite, hatchApp.UseTLS)
+}
 
-	serviceName := userToResourceName(userName, "service")
+// buildWorkspaceService returns the service of the workspace, which the
+// Ambassador mapping is an annotation of
+func buildWorkspaceService(ctx context.Context, hatchApp Container, hash string, userName string, workspaceName string, serviceType k8sv1.ServiceType, ambassadorMapping string) *k8sv1.Service {
+	podName := workspaceToResourceName(userName, workspaceName, "pod")
 	labelsService := make(map[string]string)
 	labelsService["app"] = podName
-	annotationsService := make(map[string]string)
-	annotationsService["getambassador.io/config"] = fmt.Sprintf(ambassadorYaml, userToResourceName(userName, "mapping"), userName, serviceName, Config.Config.UserNamespace, hatchApp.PathRewrite, hatchApp.UseTLS)
+	labelsService[workspaceLabel] = workspaceLabelValue
+	annotationsService := workspaceAnnotations(userName, workspaceName, hash)
+	setResourceProfileAnnotation(ctx, annotationsService)
+	setPayModelAnnotation(ctx, annotationsService)
+	annotationsService["getambassador.io/config"] = ambassadorMapping
 
-	_, err = podClient.Services(Config.Config.UserNamespace).Get(ctx, serviceName, metav1.GetOptions{})
-	if err == nil {
-		policy := metav1.DeletePropagationBackground
-		deleteOptions := metav1.DeleteOptions{
-			PropagationPolicy: &policy,
-		}
-		err = podClient.Services(Config.Config.UserNamespace).Delete(ctx, serviceName, deleteOptions)
-		if err != nil {
-			fmt.Printf("Error occurred when deleting service: %s", err)
-		}
-	}
-
-	service := &k8sv1.Service{
+	return &k8sv1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:        serviceName,
-			Namespace:   Config.Config.UserNamespace,
+			Name:        workspaceToResourceName(userName, workspaceName, "service"),
+			Namespace:   getConfig().Config.UserNamespace,
 			Labels:      labelsService,
 			Annotations: annotationsService,
 		},
 		Spec: k8sv1.ServiceSpec{
-			Type:     k8sv1.ServiceTypeClusterIP,
+			Type:     serviceType,
 			Selector: map[string]string{"app": podName},
 			Ports: []k8sv1.ServicePort{
 				{
@@ -736,8 +824,101 @@ var createLocalK8sPod = func(ctx context.Context, hash string, userName string,
 			},
 		},
 	}
+}
+
+// deleteLeftoverService deletes the workspace service if it exists. This
+// probably happened as the result of some error... there was no pod but was
+// a service.
+func deleteLeftoverService(ctx context.Context, podClient corev1.CoreV1Interface, serviceName string) {
+	_, err := podClient.Services(getConfig().Config.UserNamespace).Get(ctx, serviceName, metav1.GetOptions{})
+	if err == nil {
+		policy := metav1.DeletePropagationBackground
+		deleteOptions := metav1.DeleteOptions{
+			PropagationPolicy: &policy,
+		}
+		err = podClient.Services(getConfig().Config.UserNamespace).Delete(ctx, serviceName, deleteOptions)
+		if err != nil {
+			fmt.Printf("Error occurred when deleting service: %s", err)
+		}
+	}
+}
+
+// createUserVolumeClaim creates the claim of the user volume if it does not
+// exist yet
+func createUserVolumeClaim(ctx context.Context, podClient corev1.CoreV1Interface, userName string) error {
+	op := operationFromContext(ctx)
+	claimName := userToResourceName(userName, "claim")
+
+	op.startPhase(phasePVC)
+	_, err := podClient.PersistentVolumeClaims(getConfig().Config.UserNamespace).Get(ctx, claimName, metav1.GetOptions{})
+	if err != nil {
+		getConfig().Logger.Printf("Creating PersistentVolumeClaim %s.\n", claimName)
+		_, err := podClient.PersistentVolumeClaims(getConfig().Config.UserNamespace).Create(ctx, buildUserVolumeClaim(userName), metav1.CreateOptions{})
+		if err != nil {
+			getConfig().Logger.Printf("Failed to create PVC %s. Error: %s\n", claimName, err)
+			op.endPhase(phasePVC, err)
+			return err
+		}
+	}
+	op.endPhase(phasePVC, nil)
+	return nil
+}
+
+var createLocalK8sPod = func(ctx context.Context, hash string, userName string, workspaceName string, accessToken string, envVars []k8sv1.EnvVar) error {
+	hatchApp, err := getLaunchContainer(ctx, hash)
+	if err != nil {
+		return err
+	}
+	op := operationFromContext(ctx)
+	getConfig().Logger.Printf("Creating a Local K8s Pod")
+
+	op.startPhase(phaseAPIKey)
+	apiKey, err := getAPIKeyWithContext(ctx, accessToken)
+	op.endPhase(phaseAPIKey, err)
+	if err != nil {
+		getConfig().Logger.Printf("Failed to get API key for user '%v', Error: %v", userName, err)
+		return err
+	}
+	getConfig().Logger.Printf("Created API key for user %v, key ID: %v", userName, apiKey.KeyID)
 
-	_, err = podClient.Services(Config.Config.UserNamespace).Create(ctx, service, metav1.CreateOptions{})
+	pod, err := buildWorkspacePod(ctx, &hatchApp, hash, userName, workspaceName, getLocalK8sEnvVars(envVars, apiKey))
+	if err != nil {
+		getConfig().Logger.Printf("Failed to configure pod for launch for user %v, Error: %v", userName, err)
+		return err
+	}
+	podClient, _, err := getPodClient(ctx, userName, nil)
+	if err != nil {
+		getConfig().Logger.Printf("Error in createLocalK8sPod: %v", err)
+		return err
+	}
+	// a null image indicates a dockstore app - always mount user volume
+	mountUserVolume := hatchApp.UserVolumeLocation != ""
+	if mountUserVolume {
+		err = createUserVolumeClaim(ctx, podClient, userName)
+		if err != nil {
+			return err
+		}
+	}
+
+	op.startPhase(phasePod)
+	_, err = podClient.Pods(getConfig().Config.UserNamespace).Create(ctx, pod, metav1.CreateOptions{})
+	op.endPhase(phasePod, err)
+	if err != nil {
+		getConfig().Logger.Printf("Failed to launch pod %s for user %s. Image: %s, CPU %s, Memory %s. Error: %s\n", hatchApp.Name, userName, hatchApp.Image, hatchApp.CPULimit, hatchApp.MemoryLimit, err)
+		return err
+	}
+	// found even before the status cache receives it
+	recentPodLaunches.add(pod.Name)
+
+	getConfig().Logger.Printf("Launched pod %s for user %s. Image: %s, CPU %s, Memory %s\n", hatchApp.Name, userName, hatchApp.Image, hatchApp.CPULimit, hatchApp.MemoryLimit)
+
+	op.startPhase(phaseService)
+	serviceName := workspaceToResourceName(userName, workspaceName, "service")
+	deleteLeftoverService(ctx, podClient, serviceName)
+
+	service := buildWorkspaceService(ctx, hatchApp, hash, userName, workspaceName, k8sv1.ServiceTypeClusterIP, getAmbassadorMapping(hatchApp, userName, workspaceName))
+	_, err = podClient.Services(getConfig().Config.UserNamespace).Create(ctx, service, metav1.CreateOptions{})
+	op.endPhase(phaseService, err)
 	if err != nil {
 		fmt.Printf("Failed to launch service %s for user %s forwarding port %d. Error: %s\n", serviceName, userName, hatchApp.TargetPort, err)
 		return err
@@ -748,161 +929,92 @@ var createLocalK8sPod = func(ctx context.Context, hash string, userName string,
 	return nil
 }
 
-var createExternalK8sPod = func(ctx context.Context, hash string, userName string, accessToken string, payModel PayModel, envVars []k8sv1.EnvVar) error {
-	hatchApp := Config.ContainersMap[hash]
-	Config.Logger.Printf("Creating a External K8s Pod")
+var createExternalK8sPod = func(ctx context.Context, hash string, userName string, workspaceName string, accessToken string, payModel PayModel, envVars []k8sv1.EnvVar) error {
+	hatchApp, err := getLaunchContainer(ctx, hash)
+	if err != nil {
+		return err
+	}
+	op := operationFromContext(ctx)
+	getConfig().Logger.Printf("Creating a External K8s Pod")
 	podClient, err := NewEKSClientset(ctx, userName, payModel)
 	if err != nil {
-		Config.Logger.Printf("Failed to create pod client for user %v, Error: %v", userName, err)
+		getConfig().Logger.Printf("Failed to create pod client for user %v, Error: %v", userName, err)
 		return err
 	}
 
+	op.startPhase(phaseAPIKey)
 	apiKey, err := getAPIKeyWithContext(ctx, accessToken)
+	op.endPhase(phaseAPIKey, err)
 	if err != nil {
-		Config.Logger.Printf("Failed to get API key for user '%v', Error: %v", userName, err)
+		getConfig().Logger.Printf("Failed to get API key for user '%v', Error: %v", userName, err)
 		return err
 	}
-	Config.Logger.Printf("Created API key for user %v, key ID: %v", userName, apiKey.KeyID)
+	getConfig().Logger.Printf("Created API key for user %v, key ID: %v", userName, apiKey.KeyID)
 
 	// Check if NS exists in external cluster, if not create it.
-	ns, err := podClient.Namespaces().Get(ctx, Config.Config.UserNamespace, metav1.GetOptions{})
+	ns, err := podClient.Namespaces().Get(ctx, getConfig().Config.UserNamespace, metav1.GetOptions{})
 	if err != nil {
 		nsName := &k8sv1.Namespace{
 			ObjectMeta: metav1.ObjectMeta{
-				Name: Config.Config.UserNamespace,
+				Name: getConfig().Config.UserNamespace,
 			},
 		}
 		_, err = podClient.Namespaces().Create(ctx, nsName, metav1.CreateOptions{})
 		if err != nil {
-			Config.Logger.Printf("Error occurred when creating namespace: %s", err)
+			getConfig().Logger.Printf("Error occurred when creating namespace: %s", err)
 		} else {
-			Config.Logger.Printf("Namespace created: %v", ns)
+			getConfig().Logger.Printf("Namespace created: %v", ns)
 		}
 	}
 
-	var extraVars []k8sv1.EnvVar
-	extraVars = append(extraVars, envVars...)
-
-	extraVars = append(extraVars, k8sv1.EnvVar{
-		Name:  "WTS_OVERRIDE_URL",
-		Value: "https://" + os.Getenv("GEN3_ENDPOINT") + "/wts",
-	})
-	extraVars = append(extraVars, k8sv1.EnvVar{
-		Name:  "API_KEY",
-		Value: apiKey.APIKey,
-	})
-	extraVars = append(extraVars, k8sv1.EnvVar{
-		Name:  "API_KEY_ID",
-		Value: apiKey.KeyID,
-	})
-	// TODO: still mounting access token for now, remove this when fully switched to use API key
-	extraVars = append(extraVars, k8sv1.EnvVar{
-		Name:  "ACCESS_TOKEN",
-		Value: accessToken,
-	})
-
-	pod, err := buildPod(Config, &hatchApp, userName, extraVars)
+	pod, err := buildWorkspacePod(ctx, &hatchApp, hash, userName, workspaceName, getExternalK8sEnvVars(envVars, apiKey, accessToken))
 	if err != nil {
-		Config.Logger.Printf("Failed to configure pod for launch for user %v, Error: %v", userName, err)
+		getConfig().Logger.Printf("Failed to configure pod for launch for user %v, Error: %v", userName, err)
 		return err
 	}
-	podName := userToResourceName(userName, "pod")
 	// a null image indicates a dockstore app - always mount user volume
 	mountUserVolume := hatchApp.UserVolumeLocation != ""
 	if mountUserVolume {
-		claimName := userToResourceName(userName, "claim")
-
-		_, err := podClient.PersistentVolumeClaims(Config.Config.UserNamespace).Get(ctx, claimName, metav1.GetOptions{})
+		err = createUserVolumeClaim(ctx, podClient, userName)
 		if err != nil {
-			Config.Logger.Printf("Creating PersistentVolumeClaim %s.\n", claimName)
-			pvc := &k8sv1.PersistentVolumeClaim{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:        claimName,
-					Annotations: pod.Annotations,
-					Labels:      pod.Labels,
-				},
-				Spec: k8sv1.PersistentVolumeClaimSpec{
-					AccessModes: []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteOnce},
-					Resources: k8sv1.ResourceRequirements{
-						Requests: k8sv1.ResourceList{
-							k8sv1.ResourceStorage: resource.MustParse(Config.Config.UserVolumeSize),
-						},
-					},
-				},
-			}
-
-			_, err := podClient.PersistentVolumeClaims(Config.Config.UserNamespace).Create(ctx, pvc, metav1.CreateOptions{})
-			if err != nil {
-				Config.Logger.Printf("Failed to create PVC %s. Error: %s\n", claimName, err)
-				return err
-			}
+			return err
 		}
 	}
 
-	_, err = podClient.Pods(Config.Config.UserNamespace).Create(ctx, pod, metav1.CreateOptions{})
+	op.startPhase(phasePod)
+	_, err = podClient.Pods(getConfig().Config.UserNamespace).Create(ctx, pod, metav1.CreateOptions{})
+	op.endPhase(phasePod, err)
 	if err != nil {
-		Config.Logger.Printf("Failed to launch pod %s for user %s. Image: %s, CPU %s, Memory %s. Error: %s\n", hatchApp.Name, userName, hatchApp.Image, hatchApp.CPULimit, hatchApp.MemoryLimit, err)
+		getConfig().Logger.Printf("Failed to launch pod %s for user %s. Image: %s, CPU %s, Memory %s. Error: %s\n", hatchApp.Name, userName, hatchApp.Image, hatchApp.CPULimit, hatchApp.MemoryLimit, err)
 		return err
 	}
 
-	Config.Logger.Printf("Launched pod %s for user %s. Image: %s, CPU %s, Memory %s\n", hatchApp.Name, userName, hatchApp.Image, hatchApp.CPULimit, hatchApp.MemoryLimit)
-
-	serviceName := userToResourceName(userName, "service")
-	labelsService := make(map[string]string)
-	labelsService["app"] = podName
-	annotationsService := make(map[string]string)
-	annotationsService["getambassador.io/config"] = fmt.Sprintf(ambassadorYaml, userToResourceName(userName, "mapping"), userName, serviceName, Config.Config.UserNamespace, hatchApp.PathRewrite, hatchApp.UseTLS)
-	annotationsService["service.beta.kubernetes.io/aws-load-balancer-internal"] = "true"
-	_, err = podClient.Services(Config.Config.UserNamespace).Get(ctx, serviceName, metav1.GetOptions{})
-	if err == nil {
-		// This probably happened as the result of some error... there was no pod but was a service
-		// Lets just clean it up and proceed
-		policy := metav1.DeletePropagationBackground
-		deleteOptions := metav1.DeleteOptions{
-			PropagationPolicy: &policy,
-		}
-		err = podClient.Services(Config.Config.UserNamespace).Delete(ctx, serviceName, deleteOptions)
-		if err != nil {
-			fmt.Printf("Error occurred when deleting service: %s", err)
-		}
-	}
+	getConfig().Logger.Printf("Launched pod %s for user %s. Image: %s, CPU %s, Memory %s\n", hatchApp.Name, userName, hatchApp.Image, hatchApp.CPULimit, hatchApp.MemoryLimit)
 
-	service := &k8sv1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:        serviceName,
-			Namespace:   Config.Config.UserNamespace,
-			Labels:      labelsService,
-			Annotations: annotationsService,
-		},
-		Spec: k8sv1.ServiceSpec{
-			Type:     k8sv1.ServiceTypeNodePort,
-			Selector: map[string]string{"app": podName},
-			Ports: []k8sv1.ServicePort{
-				{
-					Name:     podName,
-					Protocol: k8sv1.ProtocolTCP,
-					Port:     80,
-					TargetPort: intstr.IntOrString{
-						Type:   intstr.Int,
-						IntVal: hatchApp.TargetPort,
-					},
-				},
-			},
-		},
-	}
+	op.startPhase(phaseService)
+	serviceName := workspaceToResourceName(userName, workspaceName, "service")
+	deleteLeftoverService(ctx, podClient, serviceName)
 
-	_, err = podClient.Services(Config.Config.UserNamespace).Create(ctx, service, metav1.CreateOptions{})
+	service := buildExternalK8sService(ctx, hatchApp, hash, userName, workspaceName)
+	_, err = podClient.Services(getConfig().Config.UserNamespace).Create(ctx, service, metav1.CreateOptions{})
+	op.endPhase(phaseService, err)
 	if err != nil {
 		fmt.Printf("Failed to launch service %s for user %s forwarding port %d. Error: %s\n", serviceName, userName, hatchApp.TargetPort, err)
 		return err
 	}
 
-	Config.Logger.Printf("Launched service %s for user %s forwarding port %d\n", serviceName, userName, hatchApp.TargetPort)
+	getConfig().Logger.Printf("Launched service %s for user %s forwarding port %d\n", serviceName, userName, hatchApp.TargetPort)
 
-	nodes, _ := podClient.Nodes().List(context.TODO(), metav1.ListOptions{})
+	nodes, err := podClient.Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	if len(nodes.Items) == 0 || len(nodes.Items[0].Status.Addresses) == 0 {
+		return fmt.Errorf("no node address found to reach service %s", serviceName)
+	}
 	NodeIP := nodes.Items[0].Status.Addresses[0].Address
 
-	err = createLocalService(ctx, userName, hash, NodeIP, payModel)
+	err = createLocalService(ctx, userName, workspaceName, hash, NodeIP, payModel)
 	if err != nil {
 		fmt.Println(err.Error())
 		return err
@@ -911,90 +1023,46 @@ var createExternalK8sPod = func(ctx context.Context, hash string, userName strin
 	return nil
 }
 
+// buildExternalK8sService returns the node port service of a workspace in
+// an external cluster, which the local service routes to
+func buildExternalK8sService(ctx context.Context, hatchApp Container, hash string, userName string, workspaceName string) *k8sv1.Service {
+	service := buildWorkspaceService(ctx, hatchApp, hash, userName, workspaceName, k8sv1.ServiceTypeNodePort, getAmbassadorMapping(hatchApp, userName, workspaceName))
+	service.Annotations["service.beta.kubernetes.io/aws-load-balancer-internal"] = "true"
+	return service
+}
+
 // Creates a local service that portal can reach
 // and route traffic to pod in external cluster.
-func createLocalService(ctx context.Context, userName string, hash string, serviceURL string, payModel PayModel) error {
-	const localAmbassadorYaml = `---
-apiVersion: ambassador/v1
-kind:  Mapping
-name:  %s
-prefix: /
-headers:
-  remote_user: %s
-service: %s:%d
-bypass_auth: true
-timeout_ms: 300000
-use_websocket: true
-rewrite: %s
-tls: %s
-`
-	hatchApp := Config.ContainersMap[hash]
+func createLocalService(ctx context.Context, userName string, workspaceName string, hash string, serviceURL string, payModel PayModel) error {
+	hatchApp, err := getLaunchContainer(ctx, hash)
+	if err != nil {
+		return err
+	}
 
-	serviceName := userToResourceName(userName, "service")
+	serviceName := workspaceToResourceName(userName, workspaceName, "service")
 	NodePort := int32(80)
 	if !payModel.Ecs {
 		externalPodClient, err := NewEKSClientset(ctx, userName, payModel)
 		if err != nil {
 			return err
 		}
-		service, err := externalPodClient.Services(Config.Config.UserNamespace).Get(ctx, serviceName, metav1.GetOptions{})
+		service, err := externalPodClient.Services(getConfig().Config.UserNamespace).Get(ctx, serviceName, metav1.GetOptions{})
 		NodePort = service.Spec.Ports[0].NodePort
 		if err != nil {
 			return err
 		}
 	}
-	podName := userToResourceName(userName, "pod")
-
-	labelsService := make(map[string]string)
-	labelsService["app"] = podName
-	annotationsService := make(map[string]string)
-	annotationsService["getambassador.io/config"] = fmt.Sprintf(localAmbassadorYaml, userToResourceName(userName, "mapping"), userName, serviceURL, NodePort, hatchApp.PathRewrite, hatchApp.UseTLS)
 
 	localPodClient := getLocalPodClient()
-	_, err := localPodClient.Services(Config.Config.UserNamespace).Get(ctx, serviceName, metav1.GetOptions{})
-	if err == nil {
-		// This probably happened as the result of some error... there was no pod but was a service
-		// Lets just clean it up and proceed
-		policy := metav1.DeletePropagationBackground
-		deleteOptions := metav1.DeleteOptions{
-			PropagationPolicy: &policy,
-		}
-		err = localPodClient.Services(Config.Config.UserNamespace).Delete(ctx, serviceName, deleteOptions)
-		if err != nil {
-			fmt.Printf("Error occurred when deleting service: %s", err)
-		}
-	}
-
-	localService := &k8sv1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:        serviceName,
-			Namespace:   Config.Config.UserNamespace,
-			Labels:      labelsService,
-			Annotations: annotationsService,
-		},
-		Spec: k8sv1.ServiceSpec{
-			Type:     k8sv1.ServiceTypeClusterIP,
-			Selector: map[string]string{"app": podName},
-			Ports: []k8sv1.ServicePort{
-				{
-					Name:     podName,
-					Protocol: k8sv1.ProtocolTCP,
-					Port:     80,
-					TargetPort: intstr.IntOrString{
-						Type:   intstr.Int,
-						IntVal: hatchApp.TargetPort,
-					},
-				},
-			},
-		},
-	}
+	deleteLeftoverService(ctx, localPodClient, serviceName)
 
-	_, err = localPodClient.Services(Config.Config.UserNamespace).Create(ctx, localService, metav1.CreateOptions{})
+	localService := buildWorkspaceService(ctx, hatchApp, hash, userName, workspaceName, k8sv1.ServiceTypeClusterIP, getLocalAmbassadorMapping(hatchApp, userName, workspaceName, fmt.Sprintf("%s:%d", serviceURL, NodePort)))
+	_, err = localPodClient.Services(getConfig().Config.UserNamespace).Create(ctx, localService, metav1.CreateOptions{})
 	if err != nil {
 		fmt.Printf("Failed to launch local service %s for user %s forwarding port %d. Error: %s\n", serviceName, userName, hatchApp.TargetPort, err)
 		return err
 	}
 
-	Config.Logger.Printf("Launched local service %s for user %s forwarding port %d\n", serviceName, userName, hatchApp.TargetPort)
+	getConfig().Logger.Printf("Launched local service %s for user %s forwarding port %d\n", serviceName, userName, hatchApp.TargetPort)
 	return nil
 }
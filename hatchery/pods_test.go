@@ -2,6 +2,11 @@ package hatchery
 
 import (
 	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -20,7 +25,7 @@ func TestBuildPodFromJSON(t *testing.T) {
 		return
 	}
 	app := &config.Config.Containers[numApps-3]
-	pod, err := buildPod(config, app, "frickjack", nil)
+	pod, err := buildPod(config, app, "frickjack", "", nil)
 
 	if nil != err {
 		t.Errorf("failed to build a pod - %v", err)
@@ -53,7 +58,7 @@ func TestBuildPodFromDockstore(t *testing.T) {
 		return
 	}
 	app := &config.Config.Containers[numApps-2]
-	pod, err := buildPod(config, app, "frickjack", nil)
+	pod, err := buildPod(config, app, "frickjack", "", nil)
 
 	if nil != err {
 		t.Errorf("failed to build a pod - %v", err)
@@ -70,3 +75,207 @@ func TestBuildPodFromDockstore(t *testing.T) {
 
 	config.Logger.Printf("pod_test marshalled pod: %v", string(jsBytes))
 }
+
+func TestWorkspaceToResourceName(t *testing.T) {
+	testCases := []struct {
+		workspaceName string
+		resourceType  string
+		want          string
+	}{
+		// the default workspace keeps the historical names
+		{workspaceName: "", resourceType: "pod", want: "hatchery-frickjack"},
+		{workspaceName: "", resourceType: "service", want: "h-frickjack-s"},
+		{workspaceName: "", resourceType: "mapping", want: "frickjack-mapping"},
+		{workspaceName: "", resourceType: "claim", want: "claim-frickjack"},
+		{workspaceName: "rstudio", resourceType: "pod", want: "hatchery-frickjack--rstudio"},
+		{workspaceName: "rstudio", resourceType: "service", want: "h-frickjack--rstudio-s"},
+		{workspaceName: "rstudio", resourceType: "mapping", want: "frickjack--rstudio-mapping"},
+		// the claim is shared by all the user's workspaces
+		{workspaceName: "rstudio", resourceType: "claim", want: "claim-frickjack"},
+	}
+	for _, testcase := range testCases {
+		got := workspaceToResourceName("frickjack", testcase.workspaceName, testcase.resourceType)
+		if got != testcase.want {
+			t.Errorf("unexpected %s name for workspace '%s': got '%s', want '%s'", testcase.resourceType, testcase.workspaceName, got, testcase.want)
+		}
+	}
+}
+
+func TestBuildPodForNamedWorkspace(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	config, err := LoadConfig("../testData/testConfig.json", nil)
+	if nil != err {
+		t.Errorf("failed to load config, got: %v", err)
+		return
+	}
+	app := &config.Config.Containers[0]
+	pod, err := buildPod(config, app, "frickjack", "rstudio", nil)
+	if nil != err {
+		t.Errorf("failed to build a pod - %v", err)
+		return
+	}
+
+	if pod.Name != "hatchery-frickjack--rstudio" || pod.Labels["app"] != pod.Name {
+		t.Errorf("unexpected pod name or app label: '%s', '%s'", pod.Name, pod.Labels["app"])
+	}
+	if pod.Annotations[userNameAnnotation] != "frickjack" || pod.Annotations[workspaceNameAnnotation] != "rstudio" {
+		t.Errorf("unexpected pod annotations: %v", pod.Annotations)
+	}
+	foundProxyPath := false
+	for _, container := range pod.Spec.Containers {
+		if container.Name != "hatchery-container" {
+			continue
+		}
+		for _, envVar := range container.Env {
+			if envVar.Name == "WORKSPACE_PROXY_PATH" {
+				foundProxyPath = envVar.Value == "/lw-workspace/proxy/rstudio/"
+			}
+		}
+	}
+	if !foundProxyPath {
+		t.Errorf("the workspace container should have WORKSPACE_PROXY_PATH=/lw-workspace/proxy/rstudio/")
+	}
+}
+
+func TestBuildPodScheduling(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	configPath := filepath.Join(t.TempDir(), "hatchery.json")
+	err := ioutil.WriteFile(configPath, []byte(`{
+		"sidecar": {"cpu-limit": "0.1", "memory-limit": "64Mi"},
+		"node-selector": {"role": "workspaces"},
+		"priority-class-name": "workspaces",
+		"containers": [
+			{"name": "Default", "cpu-limit": "1", "memory-limit": "1Gi"},
+			{
+				"name": "GPU", "cpu-limit": "1", "memory-limit": "1Gi",
+				"node-selector": {"role": "gpu"},
+				"tolerations": [{"key": "nvidia.com/gpu", "operator": "Exists", "effect": "NoSchedule"}],
+				"affinity": {"podAntiAffinity": {"preferredDuringSchedulingIgnoredDuringExecution": [
+					{"weight": 100, "podAffinityTerm": {"topologyKey": "kubernetes.io/hostname", "labelSelector": {"matchExpressions": [{"key": "app", "operator": "Exists"}]}}}
+				]}}
+			},
+			{
+				"name": "Volume", "cpu-limit": "1", "memory-limit": "1Gi",
+				"user-volume-location": "/home/jovyan/pd",
+				"affinity": {"podAntiAffinity": {"preferredDuringSchedulingIgnoredDuringExecution": [
+					{"weight": 100, "podAffinityTerm": {"topologyKey": "kubernetes.io/hostname", "labelSelector": {"matchExpressions": [{"key": "app", "operator": "Exists"}]}}}
+				]}}
+			}
+		]
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(configPath, log.New(io.Discard, "", log.LstdFlags))
+	if err != nil {
+		t.Fatalf("failed to load config, got: %v", err)
+	}
+
+	pod, err := buildPod(config, &config.Config.Containers[0], "frickjack", "", nil)
+	if err != nil {
+		t.Fatalf("failed to build a pod - %v", err)
+	}
+	// the global settings replace the default node selector, but not the
+	// default toleration
+	if !reflect.DeepEqual(pod.Spec.NodeSelector, map[string]string{"role": "workspaces"}) || !reflect.DeepEqual(pod.Spec.Tolerations, defaultTolerations) {
+		t.Errorf("unexpected node selector or tolerations: %v, %v", pod.Spec.NodeSelector, pod.Spec.Tolerations)
+	}
+	if pod.Spec.PriorityClassName != "workspaces" || pod.Spec.Affinity != nil {
+		t.Errorf("unexpected priority class or affinity: '%s', %v", pod.Spec.PriorityClassName, pod.Spec.Affinity)
+	}
+
+	pod, err = buildPod(config, &config.Config.Containers[1], "frickjack", "", nil)
+	if err != nil {
+		t.Fatalf("failed to build a pod - %v", err)
+	}
+	if pod.Spec.NodeSelector["role"] != "gpu" || len(pod.Spec.Tolerations) != 1 || pod.Spec.Tolerations[0].Key != "nvidia.com/gpu" {
+		t.Errorf("unexpected node selector or tolerations: %v, %v", pod.Spec.NodeSelector, pod.Spec.Tolerations)
+	}
+	if pod.Spec.PriorityClassName != "workspaces" || pod.Spec.Affinity == nil || pod.Spec.Affinity.PodAntiAffinity == nil {
+		t.Errorf("unexpected priority class or affinity: '%s', %v", pod.Spec.PriorityClassName, pod.Spec.Affinity)
+	}
+
+	// the user's workspaces that mount the ReadWriteOnce user volume run
+	// on the same node
+	pod, err = buildPod(config, &config.Config.Containers[2], "frickjack", "rstudio", nil)
+	if err != nil {
+		t.Fatalf("failed to build a pod - %v", err)
+	}
+	if pod.Labels[userVolumeLabel] != "hatchery-frickjack" {
+		t.Errorf("unexpected user volume label: %v", pod.Labels)
+	}
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.PodAntiAffinity == nil || affinity.PodAffinity == nil || len(affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution) != 1 {
+		t.Fatalf("unexpected affinity: %v", affinity)
+	}
+	term := affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0]
+	if term.TopologyKey != "kubernetes.io/hostname" || !reflect.DeepEqual(term.LabelSelector.MatchLabels, map[string]string{userVolumeLabel: "hatchery-frickjack"}) {
+		t.Errorf("unexpected pod affinity term: %+v", term)
+	}
+	if config.Config.Containers[2].Affinity.PodAffinity != nil {
+		t.Errorf("the container's affinity should not be modified: %v", config.Config.Containers[2].Affinity)
+	}
+}
+
+func TestBuildPodInitContainersAndVolumes(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	configPath := filepath.Join(t.TempDir(), "hatchery.json")
+	err := ioutil.WriteFile(configPath, []byte(`{
+		"sidecar": {"cpu-limit": "0.1", "memory-limit": "64Mi"},
+		"containers": [
+			{
+				"name": "Genomics", "cpu-limit": "1", "memory-limit": "1Gi", "image": "quay.io/cdis/jupyter",
+				"user-volume-location": "/home/jovyan/pd",
+				"init-containers": [
+					{"name": "seed-notebooks", "image": "busybox", "command": ["cp", "-rn", "/notebooks/.", "/pd"], "volumeMounts": [{"name": "user-data", "mountPath": "/pd"}]}
+				],
+				"extra-volumes": [
+					{"name": "reference-genomes", "persistentVolumeClaim": {"claimName": "reference-genomes", "readOnly": true}},
+					{"name": "site-settings", "configMap": {"name": "jupyter-settings"}}
+				],
+				"extra-volume-mounts": [
+					{"name": "reference-genomes", "mountPath": "/data/reference", "readOnly": true},
+					{"name": "site-settings", "mountPath": "/etc/jupyter/site"}
+				]
+			}
+		]
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(configPath, log.New(io.Discard, "", log.LstdFlags))
+	if err != nil {
+		t.Fatalf("failed to load config, got: %v", err)
+	}
+
+	pod, err := buildPod(config, &config.Config.Containers[0], "frickjack", "", nil)
+	if err != nil {
+		t.Fatalf("failed to build a pod - %v", err)
+	}
+	if len(pod.Spec.InitContainers) != 1 || pod.Spec.InitContainers[0].Name != "seed-notebooks" {
+		t.Errorf("unexpected init containers: %v", pod.Spec.InitContainers)
+	}
+	volumes := make(map[string]bool)
+	for _, volume := range pod.Spec.Volumes {
+		volumes[volume.Name] = true
+	}
+	if len(pod.Spec.Volumes) != 5 || !volumes["user-data"] || !volumes["reference-genomes"] || !volumes["site-settings"] {
+		t.Errorf("unexpected volumes: %v", pod.Spec.Volumes)
+	}
+	mounts := make(map[string]string)
+	for _, volumeMount := range pod.Spec.Containers[1].VolumeMounts {
+		mounts[volumeMount.Name] = volumeMount.MountPath
+	}
+	if mounts["reference-genomes"] != "/data/reference" || mounts["site-settings"] != "/etc/jupyter/site" || mounts["user-data"] != "/home/jovyan/pd" {
+		t.Errorf("unexpected workspace container mounts: %v", pod.Spec.Containers[1].VolumeMounts)
+	}
+	// the sidecar does not get the extra mounts
+	for _, volumeMount := range pod.Spec.Containers[0].VolumeMounts {
+		if volumeMount.Name == "reference-genomes" || volumeMount.Name == "site-settings" {
+			t.Errorf("unexpected sidecar mount: %v", volumeMount)
+		}
+	}
+}
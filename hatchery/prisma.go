@@ -26,7 +26,7 @@ func getPrismaToken(username string, password string) (*string, error) {
 		"password": password,
 	})
 	reqBody := bytes.NewBuffer(postBody)
-	authEndpoint := Config.Config.PrismaConfig.ConsoleAddress + "/api/v1/authenticate"
+	authEndpoint := getConfig().Config.PrismaConfig.ConsoleAddress + "/api/v1/authenticate"
 	resp, err := http.Post(authEndpoint, "application/json", reqBody)
 	if err != nil {
 		return nil, err
@@ -34,7 +34,7 @@ func getPrismaToken(username string, password string) (*string, error) {
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		b, _ := io.ReadAll(resp.Body)
-		Config.Logger.Print(string(b))
+		getConfig().Logger.Print(string(b))
 		return nil, errors.New("Error authenticating with Prisma Cloud: " + string(b))
 	}
 	//We Read the response body on the line below.
@@ -59,7 +59,7 @@ func getInstallBundle() (*InstallBundle, error) {
 		return nil, err
 	}
 
-	installBundleEndpoint := Config.Config.PrismaConfig.ConsoleAddress + fmt.Sprintf("/api/%s/defenders/install-bundle?consoleaddr=", Config.Config.PrismaConfig.ConsoleVersion) + Config.Config.PrismaConfig.ConsoleAddress + "&defenderType=appEmbedded"
+	installBundleEndpoint := getConfig().Config.PrismaConfig.ConsoleAddress + fmt.Sprintf("/api/%s/defenders/install-bundle?consoleaddr=", getConfig().Config.PrismaConfig.ConsoleVersion) + getConfig().Config.PrismaConfig.ConsoleAddress + "&defenderType=appEmbedded"
 	var bearer = "Bearer " + *token
 	// Create a new request using http
 	req, err := http.NewRequest("GET", installBundleEndpoint, nil)
@@ -78,7 +78,7 @@ func getInstallBundle() (*InstallBundle, error) {
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		b, _ := io.ReadAll(resp.Body)
-		Config.Logger.Print(string(b))
+		getConfig().Logger.Print(string(b))
 		return nil, errors.New("Error getting install bundle: " + string(b))
 	}
 	//We Read the response body on the line below.
@@ -101,7 +101,7 @@ func getPrismaImage() (*string, error) {
 		return nil, err
 	}
 
-	imageEndpoint := Config.Config.PrismaConfig.ConsoleAddress + fmt.Sprintf("/api/%s/defenders/image-name", Config.Config.PrismaConfig.ConsoleVersion)
+	imageEndpoint := getConfig().Config.PrismaConfig.ConsoleAddress + fmt.Sprintf("/api/%s/defenders/image-name", getConfig().Config.PrismaConfig.ConsoleVersion)
 	var bearer = "Bearer " + *token
 	// Create a new request using http
 	req, err := http.NewRequest("GET", imageEndpoint, nil)
@@ -120,7 +120,7 @@ func getPrismaImage() (*string, error) {
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		b, _ := io.ReadAll(resp.Body)
-		Config.Logger.Print(string(b))
+		getConfig().Logger.Print(string(b))
 		return nil, errors.New("Error getting install bundle: " + string(b))
 	}
 	//We Read the response body on the line below.
@@ -0,0 +1,140 @@
+package hatchery
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// validateResourceProfiles checks the container's resource profiles, so
+// that launches do not fail on a misconfigured profile
+func validateResourceProfiles(logger *log.Logger, container Container) error {
+	names := make(map[string]bool)
+	for _, profile := range container.ResourceProfiles {
+		if profile.Name == "" {
+			return fmt.Errorf("container '%s' has a resource profile without a 'name'", container.Name)
+		}
+		if names[profile.Name] {
+			return fmt.Errorf("container '%s' has several resource profiles named '%s'", container.Name, profile.Name)
+		}
+		names[profile.Name] = true
+		if _, err := resource.ParseQuantity(profile.CPULimit); err != nil {
+			return fmt.Errorf("resource profile '%s' of container '%s' has an invalid 'cpu-limit' '%s': %v", profile.Name, container.Name, profile.CPULimit, err)
+		}
+		if _, err := resource.ParseQuantity(profile.MemoryLimit); err != nil {
+			return fmt.Errorf("resource profile '%s' of container '%s' has an invalid 'memory-limit' '%s': %v", profile.Name, container.Name, profile.MemoryLimit, err)
+		}
+		if err := validateResourceRequests(profile.CPULimit, profile.CPURequest, profile.MemoryLimit, profile.MemoryRequest); err != nil {
+			return fmt.Errorf("resource profile '%s' of container '%s' has invalid resources: %v", profile.Name, container.Name, err)
+		}
+		if profile.Nextflow.InstanceMinVCpus < 0 || profile.Nextflow.InstanceMaxVCpus < 0 {
+			return fmt.Errorf("resource profile '%s' of container '%s' has a negative Nextflow 'instance-min-vcpus' or 'instance-max-vcpus'", profile.Name, container.Name)
+		}
+		if err := ValidateAuthzConfig(logger, profile.Authz); err != nil {
+			return fmt.Errorf("resource profile '%s' of container '%s' has an invalid 'authz' configuration: %v", profile.Name, container.Name, err)
+		}
+	}
+	if container.DefaultResourceProfile != "" && !names[container.DefaultResourceProfile] {
+		return fmt.Errorf("container '%s' has an unknown 'default-resource-profile' '%s'", container.Name, container.DefaultResourceProfile)
+	}
+	return nil
+}
+
+// getResourceProfile returns the container's profile with the specified
+// name, or its default profile if `profileName` is empty. It returns nil if
+// no profile is specified and the container has no default profile: the
+// container's own limits apply.
+func getResourceProfile(container Container, profileName string) (*ResourceProfile, error) {
+	if profileName == "" {
+		profileName = container.DefaultResourceProfile
+	}
+	if profileName == "" {
+		return nil, nil
+	}
+	for _, profile := range container.ResourceProfiles {
+		if profile.Name == profileName {
+			return &profile, nil
+		}
+	}
+	return nil, fmt.Errorf("Invalid 'profile' parameter '%s'", profileName)
+}
+
+// applyResourceProfile returns a copy of the container sized with the
+// profile. A nil profile leaves the container unchanged.
+func applyResourceProfile(container Container, profile *ResourceProfile) Container {
+	if profile == nil {
+		return container
+	}
+	container.CPULimit = profile.CPULimit
+	container.MemoryLimit = profile.MemoryLimit
+	container.CPURequest = profile.CPURequest
+	container.MemoryRequest = profile.MemoryRequest
+	if profile.Nextflow.InstanceType != "" {
+		container.NextflowConfig.InstanceType = profile.Nextflow.InstanceType
+	}
+	if profile.Nextflow.InstanceMinVCpus != 0 {
+		container.NextflowConfig.InstanceMinVCpus = profile.Nextflow.InstanceMinVCpus
+	}
+	if profile.Nextflow.InstanceMaxVCpus != 0 {
+		container.NextflowConfig.InstanceMaxVCpus = profile.Nextflow.InstanceMaxVCpus
+	}
+	return container
+}
+
+type resourceProfileContextKey struct{}
+
+// withResourceProfile returns a copy of ctx that records the resource
+// profile selected at launch
+func withResourceProfile(ctx context.Context, profileName string) context.Context {
+	return context.WithValue(ctx, resourceProfileContextKey{}, profileName)
+}
+
+func resourceProfileFromContext(ctx context.Context) string {
+	profileName, _ := ctx.Value(resourceProfileContextKey{}).(string)
+	return profileName
+}
+
+// getLaunchContainer returns the container to launch, sized with the
+// resource profile selected at launch
+func getLaunchContainer(ctx context.Context, hash string) (Container, error) {
+	container, ok := configFromContext(ctx).ContainersMap[hash]
+	if !ok {
+		return container, fmt.Errorf("container '%s' is no longer available", hash)
+	}
+	profile, err := getResourceProfile(container, resourceProfileFromContext(ctx))
+	if err != nil {
+		return container, err
+	}
+	return applyResourceProfile(container, profile), nil
+}
+
+// setResourceProfileAnnotation records the resource profile selected at
+// launch in the annotations of a workspace's pod or service
+func setResourceProfileAnnotation(ctx context.Context, annotations map[string]string) {
+	if profileName := resourceProfileFromContext(ctx); profileName != "" {
+		annotations[resourceProfileAnnotation] = profileName
+	}
+}
+
+// filterResourceProfileOptions removes from the option the resource profiles
+// that the user is not allowed to pick
+func filterResourceProfileOptions(userName string, accessToken string, container Container, option *containerOption) error {
+	allowedProfiles := []resourceProfileOption{}
+	for i, profile := range container.ResourceProfiles {
+		allowed, err := isUserAuthorizedForResourceProfile(userName, accessToken, container, profile)
+		if err != nil {
+			return err
+		}
+		if allowed {
+			allowedProfiles = append(allowedProfiles, option.ResourceProfiles[i])
+		}
+	}
+	if len(allowedProfiles) > 0 {
+		option.ResourceProfiles = allowedProfiles
+	} else {
+		option.ResourceProfiles = nil
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package hatchery
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+)
+
+var testResourceProfiles = []ResourceProfile{
+	{Name: "small", CPULimit: "1", MemoryLimit: "2Gi"},
+	{
+		Name:        "large",
+		CPULimit:    "8",
+		MemoryLimit: "32Gi",
+		Nextflow:    ResourceProfileNextflowConfig{InstanceType: "m5.4xlarge", InstanceMaxVCpus: 64},
+		Authz: AuthzConfig{
+			Version:          0.1,
+			AuthzVersion_0_1: AuthzVersion_0_1{ResourcePaths: []string{"/workspace/large"}},
+		},
+	},
+}
+
+func TestValidateResourceProfiles(t *testing.T) {
+	logger := log.New(io.Discard, "", log.LstdFlags)
+	testCases := []struct {
+		name      string
+		container Container
+		wantError bool
+	}{
+		{name: "NoProfiles", container: Container{Name: "c"}},
+		{name: "Valid", container: Container{Name: "c", ResourceProfiles: testResourceProfiles, DefaultResourceProfile: "small"}},
+		{name: "MissingName", container: Container{Name: "c", ResourceProfiles: []ResourceProfile{{CPULimit: "1", MemoryLimit: "1Gi"}}}, wantError: true},
+		{name: "DuplicateName", container: Container{Name: "c", ResourceProfiles: []ResourceProfile{testResourceProfiles[0], testResourceProfiles[0]}}, wantError: true},
+		{name: "InvalidCPU", container: Container{Name: "c", ResourceProfiles: []ResourceProfile{{Name: "p", CPULimit: "lots", MemoryLimit: "1Gi"}}}, wantError: true},
+		{name: "InvalidMemory", container: Container{Name: "c", ResourceProfiles: []ResourceProfile{{Name: "p", CPULimit: "1"}}}, wantError: true},
+		{name: "NegativeVCpus", container: Container{Name: "c", ResourceProfiles: []ResourceProfile{{Name: "p", CPULimit: "1", MemoryLimit: "1Gi", Nextflow: ResourceProfileNextflowConfig{InstanceMaxVCpus: -1}}}}, wantError: true},
+		{name: "InvalidAuthz", container: Container{Name: "c", ResourceProfiles: []ResourceProfile{{Name: "p", CPULimit: "1", MemoryLimit: "1Gi", Authz: AuthzConfig{Version: 0.2}}}}, wantError: true},
+		{name: "UnknownDefault", container: Container{Name: "c", ResourceProfiles: testResourceProfiles, DefaultResourceProfile: "medium"}, wantError: true},
+	}
+	for _, testcase := range testCases {
+		err := validateResourceProfiles(logger, testcase.container)
+		if testcase.wantError != (err != nil) {
+			t.Errorf("unexpected validation result when %s: %v", testcase.name, err)
+		}
+	}
+}
+
+func TestGetLaunchContainer(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	defer func() {
+		SetConfig(original_config)
+	}()
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags)})
+	getConfig().ContainersMap = map[string]Container{
+		"profiles": {
+			Name:             "Sized container",
+			CPULimit:         "0.5",
+			MemoryLimit:      "1Gi",
+			NextflowConfig:   NextflowConfig{InstanceType: "m5.large", InstanceMinVCpus: 0, InstanceMaxVCpus: 9},
+			ResourceProfiles: testResourceProfiles,
+		},
+		"default": {
+			Name:                   "Container with a default profile",
+			CPULimit:               "0.5",
+			MemoryLimit:            "1Gi",
+			ResourceProfiles:       testResourceProfiles,
+			DefaultResourceProfile: "small",
+		},
+	}
+
+	testCases := []struct {
+		name              string
+		hash              string
+		profileName       string
+		wantError         bool
+		wantCPU           string
+		wantMemory        string
+		wantInstanceType  string
+		wantInstanceVCpus int32
+	}{
+		{name: "NoProfile", hash: "profiles", wantCPU: "0.5", wantMemory: "1Gi", wantInstanceType: "m5.large", wantInstanceVCpus: 9},
+		{name: "DefaultProfile", hash: "default", wantCPU: "1", wantMemory: "2Gi"},
+		{name: "SelectedProfile", hash: "default", profileName: "large", wantCPU: "8", wantMemory: "32Gi", wantInstanceType: "m5.4xlarge", wantInstanceVCpus: 64},
+		{name: "UnknownProfile", hash: "profiles", profileName: "medium", wantError: true},
+	}
+	for _, testcase := range testCases {
+		ctx := withResourceProfile(context.Background(), testcase.profileName)
+		container, err := getLaunchContainer(ctx, testcase.hash)
+		if testcase.wantError {
+			if err == nil {
+				t.Errorf("expected an error when %s", testcase.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error when %s: %v", testcase.name, err)
+			continue
+		}
+		if container.CPULimit != testcase.wantCPU || container.MemoryLimit != testcase.wantMemory {
+			t.Errorf("unexpected limits when %s: got %s CPU and %s memory", testcase.name, container.CPULimit, container.MemoryLimit)
+		}
+		if container.NextflowConfig.InstanceType != testcase.wantInstanceType || container.NextflowConfig.InstanceMaxVCpus != testcase.wantInstanceVCpus {
+			t.Errorf("unexpected Nextflow settings when %s: %+v", testcase.name, container.NextflowConfig)
+		}
+	}
+	// the configuration is not modified
+	if getConfig().ContainersMap["default"].CPULimit != "0.5" {
+		t.Errorf("the container configuration was modified")
+	}
+}
+
+func TestLaunchResourceProfile(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_isUserAuthorizedForContainer := isUserAuthorizedForContainer
+	original_isUserAuthorizedForResourceProfile := isUserAuthorizedForResourceProfile
+	original_getPayModelsForUser := getPayModelsForUser
+	original_listK8sWorkspaceNames := listK8sWorkspaceNames
+	original_reserveWorkspace := reserveWorkspace
+	original_listStoppedWorkspaceNames := listStoppedWorkspaceNames
+	original_createLocalK8sPod := createLocalK8sPod
+	defer func() {
+		SetConfig(original_config)
+		isUserAuthorizedForContainer = original_isUserAuthorizedForContainer
+		isUserAuthorizedForResourceProfile = original_isUserAuthorizedForResourceProfile
+		getPayModelsForUser = original_getPayModelsForUser
+		listK8sWorkspaceNames = original_listK8sWorkspaceNames
+		reserveWorkspace = original_reserveWorkspace
+		listStoppedWorkspaceNames = original_listStoppedWorkspaceNames
+		createLocalK8sPod = original_createLocalK8sPod
+	}()
+	reserveWorkspace = func(context.Context, string, string, []string) error {
+		return nil
+	}
+
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags)})
+	getConfig().Config.MaxWorkspacesPerUser = 1
+	getConfig().ContainersMap = map[string]Container{
+		"sized": {Name: "Sized container", CPULimit: "0.5", MemoryLimit: "1Gi", ResourceProfiles: testResourceProfiles, DefaultResourceProfile: "small"},
+	}
+	isUserAuthorizedForContainer = func(string, string, Container) (bool, error) {
+		return true, nil
+	}
+	isUserAuthorizedForResourceProfile = func(userName string, accessToken string, container Container, profile ResourceProfile) (bool, error) {
+		return profile.Authz.Version == 0, nil
+	}
+	getPayModelsForUser = func(string) (*AllPayModels, error) {
+		return nil, nil
+	}
+	listK8sWorkspaceNames = func(context.Context, string, *PayModel) ([]string, error) {
+		return []string{}, nil
+	}
+	listStoppedWorkspaceNames = func(context.Context, string) ([]string, error) {
+		return []string{}, nil
+	}
+
+	testCases := []struct {
+		name        string
+		profileName string
+		wantStatus  int
+		wantProfile string
+		wantCPU     string
+	}{
+		{name: "DefaultProfile", wantStatus: http.StatusOK, wantProfile: "small", wantCPU: "1"},
+		{name: "UnknownProfile", profileName: "medium", wantStatus: http.StatusBadRequest},
+		{name: "UnauthorizedProfile", profileName: "large", wantStatus: http.StatusBadRequest},
+	}
+	for _, testcase := range testCases {
+		launchedContainer := Container{}
+		createLocalK8sPod = func(ctx context.Context, hash, userName, workspaceName, accessToken string, envVars []k8sv1.EnvVar) error {
+			launchedContainer, _ = getLaunchContainer(ctx, hash)
+			return nil
+		}
+
+		req, err := http.NewRequest("POST", "/launch?id=sized&profile="+testcase.profileName, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("REMOTE_USER", "testUser")
+		w := httptest.NewRecorder()
+		http.HandlerFunc(launch).ServeHTTP(w, req)
+
+		if w.Code != testcase.wantStatus {
+			t.Errorf("handler returned wrong status code when %s:\ngot: '%v'\nwant: '%v'", testcase.name, w.Code, testcase.wantStatus)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var returnedOp Operation
+		if err := json.Unmarshal(w.Body.Bytes(), &returnedOp); err != nil {
+			t.Fatalf("handler did not return an operation: '%v'", w.Body.String())
+		}
+		if returnedOp.ResourceProfile != testcase.wantProfile {
+			t.Errorf("unexpected resource profile when %s: got '%s', want '%s'", testcase.name, returnedOp.ResourceProfile, testcase.wantProfile)
+		}
+		op, ok := operations.get(returnedOp.ID)
+		if !ok {
+			t.Fatalf("operation '%s' was not registered", returnedOp.ID)
+		}
+		op.wait()
+		if launchedContainer.CPULimit != testcase.wantCPU {
+			t.Errorf("launched the wrong size when %s: got %s CPU, want %s", testcase.name, launchedContainer.CPULimit, testcase.wantCPU)
+		}
+	}
+}
+
+func TestOptionsResourceProfiles(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_isUserAuthorizedForContainer := isUserAuthorizedForContainer
+	original_isUserAuthorizedForResourceProfile := isUserAuthorizedForResourceProfile
+	defer func() {
+		SetConfig(original_config)
+		isUserAuthorizedForContainer = original_isUserAuthorizedForContainer
+		isUserAuthorizedForResourceProfile = original_isUserAuthorizedForResourceProfile
+	}()
+
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags)})
+	getConfig().ContainersMap = map[string]Container{
+		"sized": {Name: "Sized container", CPULimit: "0.5", MemoryLimit: "1Gi", ResourceProfiles: testResourceProfiles, DefaultResourceProfile: "small"},
+	}
+	isUserAuthorizedForContainer = func(string, string, Container) (bool, error) {
+		return true, nil
+	}
+	isUserAuthorizedForResourceProfile = func(userName string, accessToken string, container Container, profile ResourceProfile) (bool, error) {
+		return profile.Authz.Version == 0 || userName == "largeUser", nil
+	}
+
+	testCases := []struct {
+		userName     string
+		wantProfiles []string
+	}{
+		{userName: "testUser", wantProfiles: []string{"small"}},
+		{userName: "largeUser", wantProfiles: []string{"small", "large"}},
+	}
+	for _, testcase := range testCases {
+		req, err := http.NewRequest("GET", "/options?id=sized", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("REMOTE_USER", testcase.userName)
+		w := httptest.NewRecorder()
+		http.HandlerFunc(options).ServeHTTP(w, req)
+
+		var got containerOption
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("unable to parse the response for %s: %v", testcase.userName, w.Body.String())
+		}
+		// the limits of a launch without a profile
+		if got.CPULimit != "1" || got.MemoryLimit != "2Gi" || got.DefaultResourceProfile != "small" {
+			t.Errorf("unexpected default size for %s: %+v", testcase.userName, got)
+		}
+		gotProfiles := []string{}
+		for _, profile := range got.ResourceProfiles {
+			gotProfiles = append(gotProfiles, profile.Name)
+		}
+		if len(gotProfiles) != len(testcase.wantProfiles) {
+			t.Errorf("unexpected resource profiles for %s: got %v, want %v", testcase.userName, gotProfiles, testcase.wantProfiles)
+			continue
+		}
+		for i := range gotProfiles {
+			if gotProfiles[i] != testcase.wantProfiles[i] {
+				t.Errorf("unexpected resource profiles for %s: got %v, want %v", testcase.userName, gotProfiles, testcase.wantProfiles)
+				break
+			}
+		}
+	}
+}
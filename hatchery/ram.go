@@ -30,7 +30,7 @@ func acceptTransitGatewayShare(pm *PayModel, sess *session.Session, ramArn *stri
 	})
 	if err != nil {
 		// Log error
-		Config.Logger.Printf(err.Error())
+		getConfig().Logger.Printf(err.Error())
 		return err
 	}
 	if len(exResourceShares.ResourceShares) == 0 {
@@ -39,12 +39,12 @@ func acceptTransitGatewayShare(pm *PayModel, sess *session.Session, ramArn *stri
 		err := svc.acceptTGWShare(ramArn)
 		if err != nil {
 			// Log error
-			Config.Logger.Printf(err.Error())
+			getConfig().Logger.Printf(err.Error())
 			return err
 		}
 	} else {
 		// Log that resource share is already accepted
-		Config.Logger.Printf("Resource share already accepted")
+		getConfig().Logger.Printf("Resource share already accepted")
 	}
 	return nil
 }
@@ -64,14 +64,14 @@ func (creds *CREDS) acceptTGWShare(ramArn *string) error {
 	resourceShareInvitation, err := svc.GetResourceShareInvitations(ramInvitationInput)
 	if err != nil {
 		// Log error
-		Config.Logger.Printf(err.Error())
+		getConfig().Logger.Printf(err.Error())
 		return err
 	}
 
 	// Check if we have an invitation to accept
 	if len(resourceShareInvitation.ResourceShareInvitations) == 0 {
 		// No invitation found, possible that we have to wait a bit for the invitation to show up.
-		Config.Logger.Printf("No resource share invitation found, waiting 10 seconds")
+		getConfig().Logger.Printf("No resource share invitation found, waiting 10 seconds")
 		time.Sleep(10 * time.Second)
 
 		err := creds.acceptTGWShare(ramArn)
@@ -88,11 +88,11 @@ func (creds *CREDS) acceptTGWShare(ramArn *string) error {
 				return err
 			}
 			// Log that invitation was accepted
-			Config.Logger.Printf("Resource share invitation accepted")
+			getConfig().Logger.Printf("Resource share invitation accepted")
 			return nil
 		}
 		// Log that invitation was already accepted
-		Config.Logger.Printf("Resource share invitation already accepted")
+		getConfig().Logger.Printf("Resource share invitation already accepted")
 		return nil
 	}
 }
@@ -114,7 +114,7 @@ func shareTransitGateway(session *session.Session, tgwArn string, accountid stri
 		return nil, err
 	}
 	if len(exRs.ResourceShares) == 0 {
-		Config.Logger.Printf("Did not find existing resource share, creating a resource share")
+		getConfig().Logger.Printf("Did not find existing resource share, creating a resource share")
 		resourceShareInput := &ram.CreateResourceShareInput{
 			// Indicates whether principals outside your organization in Organizations can
 			// be associated with a resource share.
@@ -139,7 +139,7 @@ func shareTransitGateway(session *session.Session, tgwArn string, accountid stri
 		}
 		return resourceShare.ResourceShare.ResourceShareArn, nil
 	} else {
-		Config.Logger.Printf("Found existing resource share, associating resource share with account")
+		getConfig().Logger.Printf("Found existing resource share, associating resource share with account")
 		listResourcesInput := &ram.ListResourcesInput{
 			ResourceOwner: aws.String("SELF"),
 			ResourceArns:  []*string{&tgwArn},
@@ -156,11 +156,11 @@ func shareTransitGateway(session *session.Session, tgwArn string, accountid stri
 		}
 		listPrincipals, err := svc.ListPrincipals(listPrincipalsInput)
 		if err != nil {
-			Config.Logger.Printf("failed to ListPrincipals: %s", listPrincipalsInput)
+			getConfig().Logger.Printf("failed to ListPrincipals: %s", listPrincipalsInput)
 			return nil, fmt.Errorf("failed to ListPrincipals: %s", err)
 		}
 		if len(listPrincipals.Principals) == 0 || len(listResources.Resources) == 0 {
-			Config.Logger.Printf("TransitGateway is not shared with AWS account %s, associating resource share with account", accountid)
+			getConfig().Logger.Printf("TransitGateway is not shared with AWS account %s, associating resource share with account", accountid)
 			associateResourceShareInput := &ram.AssociateResourceShareInput{
 				Principals:       []*string{aws.String(accountid)},
 				ResourceArns:     []*string{&tgwArn},
@@ -171,7 +171,7 @@ func shareTransitGateway(session *session.Session, tgwArn string, accountid stri
 				return nil, err
 			}
 		} else {
-			Config.Logger.Printf("TransitGateway is already shared with AWS account %s ", *listPrincipals.Principals[0].Id)
+			getConfig().Logger.Printf("TransitGateway is already shared with AWS account %s ", *listPrincipals.Principals[0].Id)
 		}
 		return exRs.ResourceShares[len(exRs.ResourceShares)-1].ResourceShareArn, nil
 	}
@@ -0,0 +1,66 @@
+package hatchery
+
+import (
+	"context"
+	"time"
+)
+
+// StartIdleReaper starts the background job that terminates the workspaces
+// that have been idle for longer than their container's
+// `shutdown_no_activity_timeout`, if it is enabled in the configuration.
+// This does not rely on the workspace shutting itself down, and releases
+// the licenses and Nextflow resources even if the user never comes back.
+func StartIdleReaper() {
+	if !getConfig().Config.IdleReaper.Enabled {
+		getConfig().Logger.Printf("Idle workspace reaper is not enabled")
+		return
+	}
+	interval := time.Duration(getConfig().Config.IdleReaper.IntervalSeconds) * time.Second
+	getConfig().Logger.Printf("Starting the idle workspace reaper, running every %v on the replica that holds its lease", interval)
+	startLeasedJob("idle-reaper", interval, func() {
+		reapIdleWorkspaces(withAuditActor(context.Background(), "idle-reaper"))
+	})
+}
+
+// reapIdleWorkspaces terminates all the idle workspaces and returns how
+// many it terminated
+func reapIdleWorkspaces(ctx context.Context) int {
+	workspaces, err := listActiveWorkspaces(ctx)
+	if err != nil {
+		getConfig().Logger.Printf("Idle reaper: unable to list the active workspaces: %v", err)
+		return 0
+	}
+
+	reaped := 0
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, workspace := range workspaces {
+		container, ok := getConfig().ContainersMap[workspace.ContainerID]
+		if !ok {
+			// the container was removed from the configuration
+			getConfig().Logger.Printf("Idle reaper: skipping workspace '%s' of user %s: unknown container '%s'", workspace.WorkspaceName, workspace.UserName, workspace.ContainerID)
+			continue
+		}
+		idleTimeLimit := getIdleTimeLimit(container)
+		if idleTimeLimit <= 0 {
+			continue
+		}
+		lastActivityTime, err := getKernelIdleTimeForUser(ctx, workspace.UserName, workspace.WorkspaceName)
+		if err != nil {
+			// the workspace may still be launching
+			getConfig().Logger.Printf("Idle reaper: unable to get the last activity time of workspace '%s' of user %s: %v", workspace.WorkspaceName, workspace.UserName, err)
+			continue
+		}
+		if now-lastActivityTime < int64(idleTimeLimit) {
+			continue
+		}
+
+		getConfig().Logger.Printf("Idle reaper: workspace '%s' of user %s has been idle since %v, terminating it", workspace.WorkspaceName, workspace.UserName, time.Unix(lastActivityTime/1000, 0).UTC())
+		_, err = terminateWorkspace(ctx, workspace.UserName, workspace.WorkspaceName, "")
+		if err != nil {
+			getConfig().Logger.Printf("Idle reaper: unable to terminate workspace '%s' of user %s: %v", workspace.WorkspaceName, workspace.UserName, err)
+			continue
+		}
+		reaped++
+	}
+	return reaped
+}
@@ -0,0 +1,157 @@
+package hatchery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReapIdleWorkspaces(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	testCases := []struct {
+		name             string
+		workspace        WorkspaceInfo
+		lastActivityTime int64
+		activityError    bool
+		wantTerminated   bool
+	}{
+		{
+			name:             "IdleWorkspace",
+			workspace:        WorkspaceInfo{UserName: "idleUser", ContainerID: "with_timeout"},
+			lastActivityTime: now - 2*3600*1000,
+			wantTerminated:   true,
+		},
+		{
+			name:             "IdleNamedWorkspace",
+			workspace:        WorkspaceInfo{UserName: "idleUser", WorkspaceName: "rstudio", ContainerID: "with_timeout"},
+			lastActivityTime: now - 2*3600*1000,
+			wantTerminated:   true,
+		},
+		{
+			name:             "ActiveWorkspace",
+			workspace:        WorkspaceInfo{UserName: "activeUser", ContainerID: "with_timeout"},
+			lastActivityTime: now - 60*1000,
+		},
+		{
+			name:             "NoIdleTimeout",
+			workspace:        WorkspaceInfo{UserName: "idleUser", ContainerID: "without_timeout"},
+			lastActivityTime: now - 2*3600*1000,
+		},
+		{
+			name:             "UnknownContainer",
+			workspace:        WorkspaceInfo{UserName: "idleUser", ContainerID: "unknown"},
+			lastActivityTime: now - 2*3600*1000,
+		},
+		{
+			name:          "ActivityUnavailable",
+			workspace:     WorkspaceInfo{UserName: "launchingUser", ContainerID: "with_timeout"},
+			activityError: true,
+		},
+	}
+
+	original_config := getConfig()
+	original_listActiveWorkspaces := listActiveWorkspaces
+	original_getKernelIdleTimeForUser := getKernelIdleTimeForUser
+	original_terminateWorkspace := terminateWorkspace
+	defer func() {
+		// restore original functions
+		SetConfig(original_config)
+		listActiveWorkspaces = original_listActiveWorkspaces
+		getKernelIdleTimeForUser = original_getKernelIdleTimeForUser
+		terminateWorkspace = original_terminateWorkspace
+	}()
+
+	// other tests may leave a config without a logger behind
+	SetConfig(&FullHatcheryConfig{
+		Logger: log.New(io.Discard, "", log.LstdFlags),
+	})
+	getConfig().ContainersMap = map[string]Container{
+		"with_timeout": {
+			Name: "Container with a 1h idle timeout",
+			Args: []string{"--NotebookApp.shutdown_no_activity_timeout=3600"},
+		},
+		"without_timeout": {
+			Name: "Container without idle timeout",
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Logf("Testing reapIdleWorkspaces when %s", testcase.name)
+
+		listActiveWorkspaces = func(context.Context) ([]WorkspaceInfo, error) {
+			return []WorkspaceInfo{testcase.workspace}, nil
+		}
+		getKernelIdleTimeForUser = func(ctx context.Context, userName string, workspaceName string) (int64, error) {
+			if testcase.activityError {
+				return -1, errors.New("workspace is not ready")
+			}
+			return testcase.lastActivityTime, nil
+		}
+		var terminated []WorkspaceInfo
+		terminateWorkspace = func(ctx context.Context, userName string, workspaceName string, accessToken string) (string, error) {
+			terminated = append(terminated, WorkspaceInfo{UserName: userName, WorkspaceName: workspaceName})
+			return "Terminated workspace", nil
+		}
+
+		reaped := reapIdleWorkspaces(context.Background())
+
+		if testcase.wantTerminated {
+			if reaped != 1 || len(terminated) != 1 {
+				t.Errorf("expected the workspace to be terminated, reaped %d", reaped)
+			} else if terminated[0].UserName != testcase.workspace.UserName || terminated[0].WorkspaceName != testcase.workspace.WorkspaceName {
+				t.Errorf("terminated the wrong workspace: %+v", terminated[0])
+			}
+		} else if reaped != 0 || len(terminated) != 0 {
+			t.Errorf("expected the workspace not to be terminated, reaped %d", reaped)
+		}
+	}
+}
+
+func TestGetKernelIdleTimeForUser(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_inClusterAmbassadorURL := inClusterAmbassadorURL
+	defer func() {
+		inClusterAmbassadorURL = original_inClusterAmbassadorURL
+	}()
+
+	var gotPath, gotUser string
+	ambassador := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotUser = r.Header.Get("remote_user")
+		fmt.Fprint(w, `{"last_activity": "2026-10-17T01:02:03Z"}`)
+	}))
+	defer ambassador.Close()
+	inClusterAmbassadorURL = ambassador.URL + "/"
+
+	for _, workspaceName := range []string{"", "rstudio"} {
+		lastActivityTime, err := getKernelIdleTimeForUser(context.Background(), "user@example.com", workspaceName)
+		if err != nil {
+			t.Fatalf("unable to get the last activity time of workspace '%s': %v", workspaceName, err)
+		}
+		if want := time.Date(2026, 10, 17, 1, 2, 3, 0, time.UTC).Unix() * 1000; lastActivityTime != want {
+			t.Errorf("wrong last activity time for workspace '%s': got %v, want %v", workspaceName, lastActivityTime, want)
+		}
+
+		// the request must match the ambassador mapping of the workspace
+		mapping := getAmbassadorMapping(Container{}, "user@example.com", workspaceName)
+		if !strings.Contains(mapping, "\nprefix: /"+workspaceURLPrefix(workspaceName)+"\n") {
+			t.Fatalf("unexpected ambassador mapping: %s", mapping)
+		}
+		if gotPath != "/"+workspaceURLPrefix(workspaceName)+"api/status" {
+			t.Errorf("wrong path for workspace '%s': got '%s'", workspaceName, gotPath)
+		}
+		if gotUser != "user@example.com" || !strings.Contains(mapping, "remote_user: "+gotUser+"\n") {
+			t.Errorf("wrong remote_user header for workspace '%s': got '%s'", workspaceName, gotUser)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package hatchery
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// the active configuration, a `*FullHatcheryConfig` replaced as a whole
+// when the configuration is reloaded
+var activeConfig atomic.Value
+
+// only one reload at a time
+var reloadMutex sync.Mutex
+
+// getConfig returns the active configuration, or nil if none is loaded.
+// A request or operation that reads the configuration more than once should
+// use the snapshot from `configFromContext`, so that a reload can not change
+// it halfway through.
+func getConfig() *FullHatcheryConfig {
+	config, _ := activeConfig.Load().(*FullHatcheryConfig)
+	return config
+}
+
+// Config is the active configuration, kept up to date for the code outside
+// this package that still reads it.
+//
+// Deprecated: Config is not safe to read while the configuration is
+// reloaded, and assigning to it has no effect. Use SetConfig to replace the
+// configuration.
+var Config *FullHatcheryConfig
+
+// SetConfig replaces the active configuration
+func SetConfig(config *FullHatcheryConfig) {
+	activeConfig.Store(config)
+	Config = config
+}
+
+type configContextKey struct{}
+
+// withConfig returns a copy of ctx that records the configuration a request
+// or operation started with
+func withConfig(ctx context.Context, config *FullHatcheryConfig) context.Context {
+	return context.WithValue(ctx, configContextKey{}, config)
+}
+
+// configFromContext returns the configuration recorded in ctx, or the
+// active configuration if there is none
+func configFromContext(ctx context.Context) *FullHatcheryConfig {
+	if config, ok := ctx.Value(configContextKey{}).(*FullHatcheryConfig); ok && config != nil {
+		return config
+	}
+	return getConfig()
+}
+
+// configVersion returns a short hash of the configuration file and of the
+// `more-configs` files it references
+func configVersion(plan []byte, moreConfigs []AppConfigInfo) (string, error) {
+	hash := sha256.New()
+	hash.Write(plan)
+	for _, info := range moreConfigs {
+		content, err := ioutil.ReadFile(info.Path)
+		if err != nil {
+			return "", err
+		}
+		hash.Write(content)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:12], nil
+}
+
+// readConfigVersion returns the version of the configuration currently on
+// disk, without loading it
+func readConfigVersion(configFilePath string) (string, error) {
+	plan, err := ioutil.ReadFile(configFilePath)
+	if err != nil {
+		return "", err
+	}
+	var config struct {
+		MoreConfigs []AppConfigInfo `json:"more-configs"`
+	}
+	err = json.Unmarshal(plan, &config)
+	if err != nil {
+		return "", err
+	}
+	return configVersion(plan, config.MoreConfigs)
+}
+
+// ReloadConfig loads the configuration again and, if it is valid, replaces
+// the active configuration. The configuration is replaced as a whole, so
+// requests never see a partially loaded configuration, and the requests and
+// operations that started with the previous one keep their snapshot of it.
+// The event sink and the stores are kept when their settings did not
+// change. The active configuration is kept if the new one is invalid.
+func ReloadConfig(configFilePath string) error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	current := getConfig()
+	config, err := ParseConfig(configFilePath, current.Logger)
+	if err != nil {
+		current.Logger.Printf("Unable to reload the configuration, keeping version %s: %v", current.Version, err)
+		return err
+	}
+	if config.Version == current.Version {
+		return nil
+	}
+	err = config.buildStores(current)
+	if err != nil {
+		current.Logger.Printf("Unable to reload the configuration, keeping version %s: %v", current.Version, err)
+		return err
+	}
+	SetConfig(config)
+	config.Logger.Printf("Reloaded the configuration: version %s replaces version %s", config.Version, current.Version)
+	return nil
+}
+
+// WatchConfig reloads the configuration when hatchery receives a SIGHUP
+// and, if `config-reload` is enabled, when the configuration files change.
+// The files are polled rather than watched, since files mounted from
+// ConfigMaps are replaced through symlinks.
+func WatchConfig(configFilePath string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			getConfig().Logger.Printf("Received SIGHUP: reloading the configuration")
+			_ = ReloadConfig(configFilePath)
+		}
+	}()
+
+	if !getConfig().Config.ConfigReload.Enabled {
+		getConfig().Logger.Printf("Configuration file polling is not enabled: send SIGHUP to reload the configuration")
+		return
+	}
+	interval := time.Duration(getConfig().Config.ConfigReload.IntervalSeconds) * time.Second
+	getConfig().Logger.Printf("Polling the configuration files for changes every %v", interval)
+	go func() {
+		// the version of the last invalid configuration, not to log the
+		// same errors at every poll
+		var failedVersion string
+		for {
+			time.Sleep(interval)
+			version, err := readConfigVersion(configFilePath)
+			if err != nil {
+				getConfig().Logger.Printf("Unable to read the configuration files: %v", err)
+				continue
+			}
+			if version == getConfig().Version || version == failedVersion {
+				continue
+			}
+			if ReloadConfig(configFilePath) != nil {
+				failedVersion = version
+			}
+		}
+	}()
+}
@@ -0,0 +1,137 @@
+package hatchery
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	defer func() {
+		SetConfig(original_config)
+	}()
+
+	logger := log.New(io.Discard, "", log.LstdFlags)
+	configPath := filepath.Join(t.TempDir(), "hatchery.json")
+	writeConfig := func(content string) {
+		if err := ioutil.WriteFile(configPath, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeConfig(`{"user-namespace": "jupyter-pods", "containers": [{"name": "RStudio"}]}`)
+	config, err := LoadConfig(configPath, logger)
+	if err != nil {
+		t.Fatalf("unable to load the configuration: %v", err)
+	}
+	SetConfig(config)
+	initialVersion := getConfig().Version
+	if initialVersion == "" {
+		t.Fatalf("the configuration has no version")
+	}
+
+	// unchanged files: the configuration is not replaced
+	if err := ReloadConfig(configPath); err != nil || getConfig() != config {
+		t.Errorf("expected the configuration to be kept, got %v", err)
+	}
+
+	writeConfig(`{"user-namespace": "jupyter-pods", "containers": [{"name": "RStudio"}, {"name": "Jupyter"}]}`)
+	if version, err := readConfigVersion(configPath); err != nil || version == initialVersion {
+		t.Errorf("expected a new version of the configuration files, got %v, %v", version, err)
+	}
+	if err := ReloadConfig(configPath); err != nil {
+		t.Fatalf("unable to reload the configuration: %v", err)
+	}
+	if len(getConfig().ContainersMap) != 2 || getConfig().Version == initialVersion {
+		t.Errorf("expected the reloaded configuration to be active, got %d containers and version %s", len(getConfig().ContainersMap), getConfig().Version)
+	}
+	reloadedConfig := getConfig()
+
+	// the stores are kept unless their settings change
+	writeConfig(`{"user-namespace": "jupyter-pods", "containers": [{"name": "RStudio"}], "audit-log": {"type": "jsonl", "file-path": "audit.jsonl"}}`)
+	if err := ReloadConfig(configPath); err != nil {
+		t.Fatalf("unable to reload the configuration: %v", err)
+	}
+	auditStore := getConfig().AuditStore
+	writeConfig(`{"user-namespace": "jupyter-pods", "containers": [{"name": "Jupyter"}], "audit-log": {"type": "jsonl", "file-path": "audit.jsonl"}}`)
+	if err := ReloadConfig(configPath); err != nil {
+		t.Fatalf("unable to reload the configuration: %v", err)
+	}
+	if auditStore == nil || getConfig().AuditStore != auditStore {
+		t.Errorf("expected the audit store to be kept")
+	}
+	writeConfig(`{"user-namespace": "jupyter-pods", "containers": [{"name": "Jupyter"}], "audit-log": {"type": "jsonl", "file-path": "other.jsonl"}}`)
+	if err := ReloadConfig(configPath); err != nil {
+		t.Fatalf("unable to reload the configuration: %v", err)
+	}
+	if getConfig().AuditStore == auditStore {
+		t.Errorf("expected a new audit store for the new settings")
+	}
+	reloadedConfig = getConfig()
+
+	// invalid configurations are not applied
+	writeConfig(`{"user-namespace": "jupyter-pods", "containers": [{"name": "RStudio", "max-concurrent": -1}]}`)
+	if err := ReloadConfig(configPath); err == nil {
+		t.Errorf("expected an error for an invalid configuration")
+	}
+	if getConfig() != reloadedConfig {
+		t.Errorf("the invalid configuration replaced the active one")
+	}
+}
+
+func TestConfigSnapshot(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	defer func() {
+		SetConfig(original_config)
+	}()
+
+	logger := log.New(io.Discard, "", log.LstdFlags)
+	SetConfig(&FullHatcheryConfig{
+		Logger:        logger,
+		ContainersMap: map[string]Container{"hash": {Name: "RStudio"}},
+	})
+	ctx := withConfig(context.Background(), getConfig())
+
+	// a launch that started before the reload still sees its container
+	SetConfig(&FullHatcheryConfig{Logger: logger, ContainersMap: map[string]Container{}})
+	container, err := getLaunchContainer(ctx, "hash")
+	if err != nil || container.Name != "RStudio" {
+		t.Errorf("expected the container from the snapshot, got '%+v', %v", container, err)
+	}
+	if _, err := getLaunchContainer(context.Background(), "hash"); err == nil {
+		t.Errorf("expected an error for a container that is no longer available")
+	}
+}
+
+func TestSystemVersion(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	defer func() {
+		SetConfig(original_config)
+	}()
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags), Version: "0123456789ab"})
+
+	req, err := http.NewRequest("GET", "/_version", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	http.HandlerFunc(systemVersion).ServeHTTP(w, req)
+
+	var got versionSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.ConfigVersion != "0123456789ab" || got.ConfigLoadedAt == nil {
+		t.Errorf("unexpected version summary '%s': %v", w.Body.String(), err)
+	}
+}
@@ -0,0 +1,190 @@
+package hatchery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/ecs"
+	k8sv1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// WorkspaceSpec holds the resources a launch would create, as returned by
+// `/launch?dry-run=true`. Values that are only known once the workspace is
+// launched (API keys, node ports, AWS resource IDs...) are placeholders.
+type WorkspaceSpec struct {
+	Backend               string                       `json:"backend"`
+	Pod                   *k8sv1.Pod                   `json:"pod,omitempty"`
+	PersistentVolumeClaim *k8sv1.PersistentVolumeClaim `json:"persistent_volume_claim,omitempty"`
+	Service               *k8sv1.Service               `json:"service,omitempty"`
+	// the service of hatchery's cluster that routes to a workspace that
+	// runs in another cluster or on ECS
+	LocalService      *k8sv1.Service `json:"local_service,omitempty"`
+	AmbassadorMapping string         `json:"ambassador_mapping"`
+	// does not include the Prisma defender
+	TaskDefinition *ecs.RegisterTaskDefinitionInput `json:"task_definition,omitempty"`
+}
+
+// dryRunPlaceholder returns the value shown in a rendered workspace instead
+// of a value that is only known at launch
+func dryRunPlaceholder(name string) string {
+	return "<" + name + ">"
+}
+
+// dryRunAPIKey replaces the API key that is minted for the user at launch
+func dryRunAPIKey() *APIKeyStruct {
+	return &APIKeyStruct{
+		APIKey: dryRunPlaceholder("API key"),
+		KeyID:  dryRunPlaceholder("API key ID"),
+	}
+}
+
+// renderLocalK8sWorkspace returns the resources `createLocalK8sPod` creates
+func renderLocalK8sWorkspace(ctx context.Context, launch WorkspaceLaunch) (*WorkspaceSpec, error) {
+	hatchApp, err := getLaunchContainer(ctx, launch.ContainerID)
+	if err != nil {
+		return nil, err
+	}
+	pod, err := buildWorkspacePod(ctx, &hatchApp, launch.ContainerID, launch.UserName, launch.WorkspaceName, getLocalK8sEnvVars(launch.EnvVars, dryRunAPIKey()))
+	if err != nil {
+		return nil, err
+	}
+	mapping := getAmbassadorMapping(hatchApp, launch.UserName, launch.WorkspaceName)
+	spec := &WorkspaceSpec{
+		Pod:               pod,
+		Service:           buildWorkspaceService(ctx, hatchApp, launch.ContainerID, launch.UserName, launch.WorkspaceName, k8sv1.ServiceTypeClusterIP, mapping),
+		AmbassadorMapping: mapping,
+	}
+	if hatchApp.UserVolumeLocation != "" {
+		spec.PersistentVolumeClaim = buildUserVolumeClaim(launch.UserName)
+	}
+	setK8sTypeMeta(spec)
+	return spec, nil
+}
+
+// renderExternalK8sWorkspace returns the resources `createExternalK8sPod`
+// creates. The node the local service routes to is picked at launch.
+func renderExternalK8sWorkspace(ctx context.Context, launch WorkspaceLaunch) (*WorkspaceSpec, error) {
+	hatchApp, err := getLaunchContainer(ctx, launch.ContainerID)
+	if err != nil {
+		return nil, err
+	}
+	envVars := getExternalK8sEnvVars(launch.EnvVars, dryRunAPIKey(), dryRunPlaceholder("access token"))
+	pod, err := buildWorkspacePod(ctx, &hatchApp, launch.ContainerID, launch.UserName, launch.WorkspaceName, envVars)
+	if err != nil {
+		return nil, err
+	}
+	mapping := getLocalAmbassadorMapping(hatchApp, launch.UserName, launch.WorkspaceName, dryRunPlaceholder("node IP")+":"+dryRunPlaceholder("node port"))
+	spec := &WorkspaceSpec{
+		Pod:               pod,
+		Service:           buildExternalK8sService(ctx, hatchApp, launch.ContainerID, launch.UserName, launch.WorkspaceName),
+		LocalService:      buildWorkspaceService(ctx, hatchApp, launch.ContainerID, launch.UserName, launch.WorkspaceName, k8sv1.ServiceTypeClusterIP, mapping),
+		AmbassadorMapping: mapping,
+	}
+	if hatchApp.UserVolumeLocation != "" {
+		spec.PersistentVolumeClaim = buildUserVolumeClaim(launch.UserName)
+	}
+	setK8sTypeMeta(spec)
+	return spec, nil
+}
+
+// renderEcsWorkspace returns the task definition `launchEcsWorkspace`
+// registers, and the local service that routes to the ECS service's load
+// balancer. The EFS volume, the task role and the load balancer are set up
+// at launch.
+func renderEcsWorkspace(ctx context.Context, launch WorkspaceLaunch) (*WorkspaceSpec, error) {
+	hatchApp, err := getLaunchContainer(ctx, launch.ContainerID)
+	if err != nil {
+		return nil, err
+	}
+	mem, err := mem(hatchApp.MemoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	cpu, err := cpu(hatchApp.CPULimit)
+	if err != nil {
+		return nil, err
+	}
+	envVars := getEcsEnvVars(hatchApp, launch.EcsEnvVars, dryRunAPIKey(), dryRunPlaceholder("access token"))
+	volumes := &EFS{
+		FileSystemId:  dryRunPlaceholder("EFS file system ID"),
+		AccessPointId: dryRunPlaceholder("EFS access point ID"),
+	}
+	taskDef := buildEcsTaskDefinitionInput(hatchApp, launch.UserName, cpu, mem, dryRunPlaceholder("task role ARN"), volumes, envVars, *launch.PayModel)
+
+	mapping := getLocalAmbassadorMapping(hatchApp, launch.UserName, "", dryRunPlaceholder("load balancer DNS name")+":80")
+	spec := &WorkspaceSpec{
+		LocalService:      buildWorkspaceService(ctx, hatchApp, launch.ContainerID, launch.UserName, "", k8sv1.ServiceTypeClusterIP, mapping),
+		AmbassadorMapping: mapping,
+		TaskDefinition:    taskDef.buildRegisterTaskDefinitionInput(launch.UserName, fmt.Sprintf("/hatchery/%s/", launch.PayModel.AWSAccountId)),
+	}
+	setK8sTypeMeta(spec)
+	return spec, nil
+}
+
+// setK8sTypeMeta sets the kind and API version of the rendered k8s
+// resources, so that the output can be passed to kubectl
+func setK8sTypeMeta(spec *WorkspaceSpec) {
+	if spec.Pod != nil {
+		spec.Pod.APIVersion, spec.Pod.Kind = "v1", "Pod"
+	}
+	if spec.PersistentVolumeClaim != nil {
+		spec.PersistentVolumeClaim.APIVersion, spec.PersistentVolumeClaim.Kind = "v1", "PersistentVolumeClaim"
+		spec.PersistentVolumeClaim.Namespace = getConfig().Config.UserNamespace
+	}
+	for _, service := range []*k8sv1.Service{spec.Service, spec.LocalService} {
+		if service != nil {
+			service.APIVersion, service.Kind = "v1", "Service"
+		}
+	}
+}
+
+// RenderPod returns the YAML of the pod of the user's default workspace in
+// hatchery's cluster. `container` is the ID, an alias or the name of the
+// container. The API key is a placeholder, and the Nextflow and license
+// settings, which are only known at launch, are left out.
+func RenderPod(container string, userName string) ([]byte, error) {
+	id, err := findContainerID(container)
+	if err != nil {
+		return nil, err
+	}
+	envVars := []k8sv1.EnvVar{{Name: "WORKSPACE_FLAVOR", Value: getWorkspaceFlavor(getConfig().ContainersMap[id])}}
+	spec, err := renderLocalK8sWorkspace(context.Background(), WorkspaceLaunch{UserName: userName, ContainerID: id, EnvVars: envVars})
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(spec.Pod)
+}
+
+// findContainerID returns the ID of the container with the ID, alias or
+// name
+func findContainerID(container string) (string, error) {
+	id := resolveContainerID(container)
+	if _, ok := getConfig().ContainersMap[id]; ok {
+		return id, nil
+	}
+	var ids []string
+	for id, other := range getConfig().ContainersMap {
+		if other.Name == container {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > 1 {
+		return "", fmt.Errorf("several containers are named '%s': use the ID of the container", container)
+	}
+	if len(ids) == 0 {
+		return "", fmt.Errorf("unknown container '%s'", container)
+	}
+	return ids[0], nil
+}
+
+// marshalWorkspaceSpec returns the spec as JSON, or as YAML if `format` is
+// "yaml"
+func marshalWorkspaceSpec(spec *WorkspaceSpec, format string) ([]byte, error) {
+	out, err := json.Marshal(spec)
+	if err != nil || format != "yaml" {
+		return out, err
+	}
+	return yaml.JSONToYAML(out)
+}
@@ -0,0 +1,257 @@
+package hatchery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	k8sv1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func getEnvVarValue(envVars []k8sv1.EnvVar, name string) string {
+	for _, envVar := range envVars {
+		if envVar.Name == name {
+			return envVar.Value
+		}
+	}
+	return ""
+}
+
+func TestRenderK8sWorkspace(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_getAPIKeyWithContext := getAPIKeyWithContext
+	defer func() {
+		SetConfig(original_config)
+		getAPIKeyWithContext = original_getAPIKeyWithContext
+	}()
+
+	SetConfig(&FullHatcheryConfig{
+		Logger: log.New(io.Discard, "", log.LstdFlags),
+		Config: HatcheryConfig{
+			UserNamespace:  "jupyter-pods",
+			UserVolumeSize: "10Gi",
+			Sidecar:        SidecarContainer{CPULimit: "0.1", MemoryLimit: "64Mi"},
+		},
+		ContainersMap: map[string]Container{
+			"test-container": {Name: "Test", CPULimit: "1", MemoryLimit: "1Gi", Image: "quay.io/cdis/jupyter", UserVolumeLocation: "/home/jovyan/pd", TargetPort: 8888},
+		},
+	})
+	getAPIKeyWithContext = func(context.Context, string) (*APIKeyStruct, error) {
+		t.Error("rendering a workspace should not mint an API key")
+		return nil, errors.New("unexpected call")
+	}
+
+	launch := WorkspaceLaunch{UserName: "testUser", WorkspaceName: "abc", ContainerID: "test-container", AccessToken: "secret-token"}
+
+	spec, err := workspaceBackends[backendLocalK8s].Render(context.Background(), launch)
+	if err != nil {
+		t.Fatalf("unexpected render error: %v", err)
+	}
+	if spec.Pod == nil || spec.Pod.Name != workspaceToResourceName("testUser", "abc", "pod") || spec.Pod.Kind != "Pod" {
+		t.Fatalf("unexpected pod: %+v", spec.Pod)
+	}
+	if value := getEnvVarValue(spec.Pod.Spec.Containers[1].Env, "API_KEY"); value != dryRunPlaceholder("API key") {
+		t.Errorf("expected a placeholder API key, got '%s'", value)
+	}
+	if spec.PersistentVolumeClaim == nil || spec.PersistentVolumeClaim.Name != userToResourceName("testUser", "claim") {
+		t.Errorf("unexpected user volume claim: %+v", spec.PersistentVolumeClaim)
+	}
+	if spec.Service == nil || spec.Service.Spec.Type != k8sv1.ServiceTypeClusterIP || spec.LocalService != nil {
+		t.Errorf("expected a cluster IP service and no local service, got %+v and %+v", spec.Service, spec.LocalService)
+	}
+	if spec.Service.Annotations["getambassador.io/config"] != spec.AmbassadorMapping || !strings.Contains(spec.AmbassadorMapping, "prefix: /"+workspaceURLPrefix("abc")) {
+		t.Errorf("unexpected Ambassador mapping: %s", spec.AmbassadorMapping)
+	}
+
+	spec, err = workspaceBackends[backendExternalK8s].Render(context.Background(), launch)
+	if err != nil {
+		t.Fatalf("unexpected render error: %v", err)
+	}
+	if value := getEnvVarValue(spec.Pod.Spec.Containers[1].Env, "ACCESS_TOKEN"); value != dryRunPlaceholder("access token") {
+		t.Errorf("expected a placeholder access token, got '%s'", value)
+	}
+	if spec.Service.Spec.Type != k8sv1.ServiceTypeNodePort || spec.LocalService == nil {
+		t.Errorf("expected a node port service and a local service, got %+v and %+v", spec.Service, spec.LocalService)
+	}
+	if spec.LocalService.Annotations["getambassador.io/config"] != spec.AmbassadorMapping || !strings.Contains(spec.AmbassadorMapping, "service: <node IP>:<node port>") {
+		t.Errorf("unexpected Ambassador mapping: %s", spec.AmbassadorMapping)
+	}
+}
+
+func TestRenderEcsWorkspace(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	defer func() {
+		SetConfig(original_config)
+	}()
+
+	SetConfig(&FullHatcheryConfig{
+		Logger: log.New(io.Discard, "", log.LstdFlags),
+		Config: HatcheryConfig{
+			UserNamespace: "jupyter-pods",
+			Sidecar:       SidecarContainer{Image: "quay.io/cdis/gen3fuse-sidecar"},
+		},
+		ContainersMap: map[string]Container{
+			"test-container": {Name: "Test", CPULimit: "1.0", MemoryLimit: "2Gi", Image: "quay.io/cdis/jupyter", TargetPort: 8888},
+		},
+	})
+
+	launch := WorkspaceLaunch{
+		UserName:    "testUser",
+		ContainerID: "test-container",
+		AccessToken: "secret-token",
+		PayModel:    &PayModel{Ecs: true, AWSAccountId: "123456789012"},
+		EcsEnvVars:  []EnvVar{{Key: "WORKSPACE_FLAVOR", Value: "jupyter"}},
+	}
+	spec, err := workspaceBackends[backendEcs].Render(context.Background(), launch)
+	if err != nil {
+		t.Fatalf("unexpected render error: %v", err)
+	}
+	if spec.Pod != nil || spec.Service != nil || spec.LocalService == nil || spec.TaskDefinition == nil {
+		t.Fatalf("expected a task definition and a local service only, got %+v", spec)
+	}
+	taskDef := spec.TaskDefinition
+	if aws.StringValue(taskDef.Cpu) != "1024" || aws.StringValue(taskDef.Memory) != "2048" || aws.StringValue(taskDef.TaskRoleArn) != dryRunPlaceholder("task role ARN") {
+		t.Errorf("unexpected task definition: %v", taskDef)
+	}
+	if len(taskDef.ContainerDefinitions) != 2 {
+		t.Fatalf("expected the workspace and sidecar containers, got %d containers", len(taskDef.ContainerDefinitions))
+	}
+	environment := map[string]string{}
+	for _, pair := range taskDef.ContainerDefinitions[0].Environment {
+		environment[aws.StringValue(pair.Name)] = aws.StringValue(pair.Value)
+	}
+	if environment["ACCESS_TOKEN"] != dryRunPlaceholder("access token") || environment["API_KEY"] != dryRunPlaceholder("API key") || environment["WORKSPACE_FLAVOR"] != "jupyter" {
+		t.Errorf("unexpected environment: %v", environment)
+	}
+	if !strings.Contains(spec.AmbassadorMapping, "service: <load balancer DNS name>:80") {
+		t.Errorf("unexpected Ambassador mapping: %s", spec.AmbassadorMapping)
+	}
+}
+
+func TestLaunchDryRun(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	testCases := []struct {
+		name       string
+		isAdmin    bool
+		format     string
+		wantStatus int
+	}{
+		{
+			name:       "the user is not an admin",
+			isAdmin:    false,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "the format is unknown",
+			isAdmin:    true,
+			format:     "xml",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "the format is JSON",
+			isAdmin:    true,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "the format is YAML",
+			isAdmin:    true,
+			format:     "yaml",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	original_config := getConfig()
+	original_isUserHatcheryAdmin := isUserHatcheryAdmin
+	original_isUserAuthorizedForContainer := isUserAuthorizedForContainer
+	original_getPayModelsForUser := getPayModelsForUser
+	original_createLocalK8sPod := createLocalK8sPod
+	original_listStoppedWorkspaceNames := listStoppedWorkspaceNames
+	defer func() {
+		SetConfig(original_config)
+		isUserHatcheryAdmin = original_isUserHatcheryAdmin
+		isUserAuthorizedForContainer = original_isUserAuthorizedForContainer
+		getPayModelsForUser = original_getPayModelsForUser
+		createLocalK8sPod = original_createLocalK8sPod
+		listStoppedWorkspaceNames = original_listStoppedWorkspaceNames
+	}()
+
+	SetConfig(&FullHatcheryConfig{
+		Logger: log.New(io.Discard, "", log.LstdFlags),
+		Config: HatcheryConfig{
+			UserNamespace: "jupyter-pods",
+			Sidecar:       SidecarContainer{CPULimit: "0.1", MemoryLimit: "64Mi"},
+		},
+		ContainersMap: map[string]Container{
+			"test-container": {Name: "Test", CPULimit: "1", MemoryLimit: "1Gi", Image: "quay.io/cdis/jupyter"},
+		},
+	})
+	isUserAuthorizedForContainer = func(string, string, Container) (bool, error) {
+		return true, nil
+	}
+	getPayModelsForUser = func(string) (*AllPayModels, error) {
+		return nil, nil
+	}
+	createLocalK8sPod = func(context.Context, string, string, string, string, []k8sv1.EnvVar) error {
+		t.Error("a dry run should not launch the workspace")
+		return nil
+	}
+	listStoppedWorkspaceNames = func(context.Context, string) ([]string, error) {
+		t.Error("a dry run should not check the stopped workspaces")
+		return nil, nil
+	}
+
+	for _, testcase := range testCases {
+		t.Logf("Testing dry-run launch when %s", testcase.name)
+
+		isUserHatcheryAdmin = func(string, string) (bool, error) {
+			return testcase.isAdmin, nil
+		}
+
+		url := "/launch?id=test-container&dry-run=true"
+		if testcase.format != "" {
+			url += "&format=" + testcase.format
+		}
+		req, err := http.NewRequest("POST", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("REMOTE_USER", "admin")
+		w := httptest.NewRecorder()
+		http.HandlerFunc(launch).ServeHTTP(w, req)
+
+		if w.Code != testcase.wantStatus {
+			t.Errorf("handler returned wrong status code:\ngot: '%v'\nwant: '%v'", w.Code, testcase.wantStatus)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		body := w.Body.Bytes()
+		if testcase.format == "yaml" {
+			body, err = yaml.YAMLToJSON(body)
+			if err != nil {
+				t.Fatalf("unable to parse the YAML response: %v", err)
+			}
+		}
+		var spec WorkspaceSpec
+		if err := json.Unmarshal(body, &spec); err != nil {
+			t.Fatalf("unable to parse the response: %v", err)
+		}
+		if spec.Backend != backendLocalK8s || spec.Pod == nil || spec.Service == nil || spec.AmbassadorMapping == "" {
+			t.Errorf("unexpected rendered workspace: %+v", spec)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package hatchery
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Fargate tasks get 20 GiB of ephemeral storage, and can be given between
+// 21 and 200 GiB
+const (
+	minEcsEphemeralStorageGiB = 21
+	maxEcsEphemeralStorageGiB = 200
+)
+
+// validateExtendedResources checks that the resources can be requested
+// from k8s: extended resources are counted in whole units and their names
+// are prefixed with a domain, eg `nvidia.com/gpu`
+func validateExtendedResources(extendedResources map[string]string) error {
+	for name, value := range extendedResources {
+		if !strings.Contains(name, "/") || strings.HasPrefix(name, "kubernetes.io/") {
+			return fmt.Errorf("invalid extended resource '%s': expected a name prefixed with a domain other than 'kubernetes.io', eg 'nvidia.com/gpu'", name)
+		}
+		if errs := validation.IsQualifiedName(name); len(errs) > 0 {
+			return fmt.Errorf("invalid extended resource '%s': %v", name, errs)
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return fmt.Errorf("invalid quantity '%s' for extended resource '%s': %v", value, name, err)
+		}
+		if quantity.Sign() <= 0 || quantity.MilliValue()%1000 != 0 {
+			return fmt.Errorf("invalid quantity '%s' for extended resource '%s': expected a positive whole number", value, name)
+		}
+	}
+	return nil
+}
+
+// validateContainerResources checks the resources of the container that
+// `buildPod` would otherwise fail to parse at launch
+func validateContainerResources(container Container) error {
+	if err := validateExtendedResources(container.ExtendedResources); err != nil {
+		return err
+	}
+	if container.EphemeralStorageLimit != "" {
+		if _, err := resource.ParseQuantity(container.EphemeralStorageLimit); err != nil {
+			return fmt.Errorf("invalid 'ephemeral-storage-limit' '%s': %v", container.EphemeralStorageLimit, err)
+		}
+	}
+	return nil
+}
+
+// validateResourceRequests checks that the CPU and memory requests, if set,
+// are valid quantities that do not exceed the limits
+func validateResourceRequests(cpuLimit string, cpuRequest string, memoryLimit string, memoryRequest string) error {
+	if err := validateResourceRequest("cpu", cpuLimit, cpuRequest); err != nil {
+		return err
+	}
+	return validateResourceRequest("memory", memoryLimit, memoryRequest)
+}
+
+func validateResourceRequest(name string, limit string, request string) error {
+	if request == "" {
+		return nil
+	}
+	requestQuantity, err := resource.ParseQuantity(request)
+	if err != nil {
+		return fmt.Errorf("invalid '%s-request' '%s': %v", name, request, err)
+	}
+	limitQuantity, err := resource.ParseQuantity(limit)
+	if err == nil && requestQuantity.Cmp(limitQuantity) > 0 {
+		return fmt.Errorf("'%s-request' '%s' is more than the '%s-limit' '%s'", name, request, name, limit)
+	}
+	return nil
+}
+
+// getResourceRequest returns the request, or else the limit divided by the
+// `overcommit-ratio`. CPU is rounded down to the millicore and memory to
+// the byte.
+func getResourceRequest(name k8sv1.ResourceName, limit string, request string, overcommitRatio float64) resource.Quantity {
+	if request != "" {
+		return resource.MustParse(request)
+	}
+	quantity := resource.MustParse(limit)
+	ratio := overcommitRatio
+	if ratio <= 1 {
+		return quantity
+	}
+	if name == k8sv1.ResourceCPU {
+		return *resource.NewMilliQuantity(int64(float64(quantity.MilliValue())/ratio), quantity.Format)
+	}
+	return *resource.NewQuantity(int64(float64(quantity.Value())/ratio), quantity.Format)
+}
+
+// getContainerResourceRequirements returns the requests and limits of the
+// workspace container. The requests of the extended resources and of the
+// ephemeral storage are their limits.
+func getContainerResourceRequirements(container Container, overcommitRatio float64) k8sv1.ResourceRequirements {
+	limits := getContainerResourceList(container)
+	requests := getContainerResourceList(container)
+	requests[k8sv1.ResourceCPU] = getResourceRequest(k8sv1.ResourceCPU, container.CPULimit, container.CPURequest, overcommitRatio)
+	requests[k8sv1.ResourceMemory] = getResourceRequest(k8sv1.ResourceMemory, container.MemoryLimit, container.MemoryRequest, overcommitRatio)
+	return k8sv1.ResourceRequirements{Limits: limits, Requests: requests}
+}
+
+// getSidecarResourceRequirements returns the requests and limits of the
+// fuse sidecar
+func getSidecarResourceRequirements(sidecar SidecarContainer, overcommitRatio float64) k8sv1.ResourceRequirements {
+	return k8sv1.ResourceRequirements{
+		Limits: k8sv1.ResourceList{
+			k8sv1.ResourceCPU:    resource.MustParse(sidecar.CPULimit),
+			k8sv1.ResourceMemory: resource.MustParse(sidecar.MemoryLimit),
+		},
+		Requests: k8sv1.ResourceList{
+			k8sv1.ResourceCPU:    getResourceRequest(k8sv1.ResourceCPU, sidecar.CPULimit, sidecar.CPURequest, overcommitRatio),
+			k8sv1.ResourceMemory: getResourceRequest(k8sv1.ResourceMemory, sidecar.MemoryLimit, sidecar.MemoryRequest, overcommitRatio),
+		},
+	}
+}
+
+// getContainerResourceList returns the limits of the workspace container
+func getContainerResourceList(container Container) k8sv1.ResourceList {
+	resources := k8sv1.ResourceList{
+		k8sv1.ResourceCPU:    resource.MustParse(container.CPULimit),
+		k8sv1.ResourceMemory: resource.MustParse(container.MemoryLimit),
+	}
+	if container.EphemeralStorageLimit != "" {
+		resources[k8sv1.ResourceEphemeralStorage] = resource.MustParse(container.EphemeralStorageLimit)
+	}
+	for name, value := range container.ExtendedResources {
+		resources[k8sv1.ResourceName(name)] = resource.MustParse(value)
+	}
+	return resources
+}
+
+// isExtendedResource returns true for the resources that are not built
+// into k8s, eg GPUs
+func isExtendedResource(name k8sv1.ResourceName) bool {
+	return name != k8sv1.ResourceCPU && name != k8sv1.ResourceMemory && name != k8sv1.ResourceEphemeralStorage
+}
+
+// getGPUCount returns the container's `gpu-count`, or else the number of
+// GPUs it requests as extended resources (eg `nvidia.com/gpu`)
+func getGPUCount(container Container) int {
+	if container.GPUCount > 0 {
+		return container.GPUCount
+	}
+	count := 0
+	for name, value := range container.ExtendedResources {
+		if strings.HasSuffix(name, "/gpu") {
+			quantity, err := resource.ParseQuantity(value)
+			if err == nil {
+				count += int(quantity.Value())
+			}
+		}
+	}
+	return count
+}
+
+// getEcsResourceRequirements returns the ECS equivalent of the container's
+// extended resources. ECS only knows about GPUs: the other extended
+// resources are ignored.
+func getEcsResourceRequirements(container Container) []*ecs.ResourceRequirement {
+	var requirements []*ecs.ResourceRequirement
+	if gpuCount := getGPUCount(container); gpuCount > 0 {
+		requirements = append(requirements, &ecs.ResourceRequirement{
+			Type:  aws.String(ecs.ResourceTypeGpu),
+			Value: aws.String(fmt.Sprintf("%d", gpuCount)),
+		})
+	}
+	var ignored []string
+	for name := range container.ExtendedResources {
+		if !strings.HasSuffix(name, "/gpu") {
+			ignored = append(ignored, name)
+		}
+	}
+	if len(ignored) > 0 {
+		sort.Strings(ignored)
+		getConfig().Logger.Printf("Warning: extended resources %v of container '%s' are not supported on ECS and are ignored", ignored, container.Name)
+	}
+	return requirements
+}
+
+// getEcsEphemeralStorage returns the ephemeral storage of the ECS task, or
+// nil to use the default. The container's `ephemeral-storage-limit` is
+// rounded up to the next GiB, within the limits of Fargate.
+func getEcsEphemeralStorage(container Container) *ecs.EphemeralStorage {
+	if container.EphemeralStorageLimit == "" {
+		return nil
+	}
+	quantity, err := resource.ParseQuantity(container.EphemeralStorageLimit)
+	if err != nil {
+		return nil
+	}
+	const gib = 1024 * 1024 * 1024
+	sizeInGiB := (quantity.Value() + gib - 1) / gib
+	if sizeInGiB < minEcsEphemeralStorageGiB {
+		return nil
+	}
+	if sizeInGiB > maxEcsEphemeralStorageGiB {
+		getConfig().Logger.Printf("Warning: the 'ephemeral-storage-limit' of container '%s' is more than the %d GiB ECS supports: using %d GiB", container.Name, maxEcsEphemeralStorageGiB, maxEcsEphemeralStorageGiB)
+		sizeInGiB = maxEcsEphemeralStorageGiB
+	}
+	return &ecs.EphemeralStorage{SizeInGiB: aws.Int64(sizeInGiB)}
+}
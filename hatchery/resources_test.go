@@ -0,0 +1,174 @@
+package hatchery
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	k8sv1 "k8s.io/api/core/v1"
+)
+
+func TestValidateContainerResources(t *testing.T) {
+	testCases := []struct {
+		name      string
+		container Container
+		wantError bool
+	}{
+		{name: "NoResources", container: Container{}},
+		{name: "Valid", container: Container{ExtendedResources: map[string]string{"nvidia.com/gpu": "1"}, EphemeralStorageLimit: "10Gi"}},
+		{name: "UnprefixedResource", container: Container{ExtendedResources: map[string]string{"gpu": "1"}}, wantError: true},
+		{name: "KubernetesResource", container: Container{ExtendedResources: map[string]string{"kubernetes.io/gpu": "1"}}, wantError: true},
+		{name: "InvalidQuantity", container: Container{ExtendedResources: map[string]string{"nvidia.com/gpu": "one"}}, wantError: true},
+		{name: "FractionalQuantity", container: Container{ExtendedResources: map[string]string{"nvidia.com/gpu": "500m"}}, wantError: true},
+		{name: "ZeroQuantity", container: Container{ExtendedResources: map[string]string{"nvidia.com/gpu": "0"}}, wantError: true},
+		{name: "InvalidEphemeralStorage", container: Container{EphemeralStorageLimit: "lots"}, wantError: true},
+	}
+	for _, testcase := range testCases {
+		err := validateContainerResources(testcase.container)
+		if testcase.wantError != (err != nil) {
+			t.Errorf("unexpected validation result when %s: %v", testcase.name, err)
+		}
+	}
+}
+
+func TestGetContainerResourceList(t *testing.T) {
+	resources := getContainerResourceList(Container{
+		CPULimit:              "2",
+		MemoryLimit:           "4Gi",
+		EphemeralStorageLimit: "20Gi",
+		ExtendedResources:     map[string]string{"nvidia.com/gpu": "1"},
+	})
+	gpus := resources[k8sv1.ResourceName("nvidia.com/gpu")]
+	storage := resources[k8sv1.ResourceEphemeralStorage]
+	if len(resources) != 4 || gpus.Value() != 1 || storage.String() != "20Gi" {
+		t.Errorf("unexpected resources: %v", resources)
+	}
+}
+
+func TestGetEcsResources(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	defer func() {
+		SetConfig(original_config)
+	}()
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags)})
+
+	testCases := []struct {
+		name           string
+		container      Container
+		wantGPUs       string
+		wantStorageGiB int64
+	}{
+		{name: "NoResources", container: Container{}},
+		{name: "ExtendedGPUs", container: Container{ExtendedResources: map[string]string{"nvidia.com/gpu": "2", "example.com/fpga": "1"}}, wantGPUs: "2"},
+		{name: "GPUCount", container: Container{GPUCount: 1}, wantGPUs: "1"},
+		{name: "SmallStorage", container: Container{EphemeralStorageLimit: "10Gi"}},
+		{name: "Storage", container: Container{EphemeralStorageLimit: "50G"}, wantStorageGiB: 47},
+		{name: "LargeStorage", container: Container{EphemeralStorageLimit: "1Ti"}, wantStorageGiB: maxEcsEphemeralStorageGiB},
+	}
+	for _, testcase := range testCases {
+		requirements := getEcsResourceRequirements(testcase.container)
+		gpus := ""
+		if len(requirements) == 1 && aws.StringValue(requirements[0].Type) == "GPU" {
+			gpus = aws.StringValue(requirements[0].Value)
+		} else if len(requirements) > 0 {
+			t.Errorf("unexpected ECS resource requirements when %s: %v", testcase.name, requirements)
+		}
+		if gpus != testcase.wantGPUs {
+			t.Errorf("unexpected ECS GPUs when %s: got '%s', want '%s'", testcase.name, gpus, testcase.wantGPUs)
+		}
+		storage := getEcsEphemeralStorage(testcase.container)
+		if (storage == nil) != (testcase.wantStorageGiB == 0) || (storage != nil && *storage.SizeInGiB != testcase.wantStorageGiB) {
+			t.Errorf("unexpected ECS ephemeral storage when %s: got %v, want %d GiB", testcase.name, storage, testcase.wantStorageGiB)
+		}
+	}
+}
+
+func TestValidateResourceRequests(t *testing.T) {
+	testCases := []struct {
+		name          string
+		cpuLimit      string
+		cpuRequest    string
+		memoryLimit   string
+		memoryRequest string
+		wantError     bool
+	}{
+		{name: "NoRequests", cpuLimit: "1", memoryLimit: "1Gi"},
+		{name: "LowerRequests", cpuLimit: "1", cpuRequest: "250m", memoryLimit: "1Gi", memoryRequest: "512Mi"},
+		{name: "EqualRequests", cpuLimit: "1", cpuRequest: "1000m", memoryLimit: "1Gi", memoryRequest: "1024Mi"},
+		{name: "InvalidCPURequest", cpuLimit: "1", cpuRequest: "some", memoryLimit: "1Gi", wantError: true},
+		{name: "CPURequestAboveLimit", cpuLimit: "1", cpuRequest: "2", memoryLimit: "1Gi", wantError: true},
+		{name: "DecimalMemoryRequest", cpuLimit: "1", memoryLimit: "1Gi", memoryRequest: "1G"},
+		{name: "MemoryRequestAboveLimit", cpuLimit: "1", memoryLimit: "1G", memoryRequest: "1Gi", wantError: true},
+	}
+	for _, testcase := range testCases {
+		err := validateResourceRequests(testcase.cpuLimit, testcase.cpuRequest, testcase.memoryLimit, testcase.memoryRequest)
+		if testcase.wantError != (err != nil) {
+			t.Errorf("unexpected validation result when %s: %v", testcase.name, err)
+		}
+	}
+}
+
+func TestGetContainerResourceRequirements(t *testing.T) {
+	testCases := []struct {
+		name              string
+		container         Container
+		overcommitRatio   float64
+		wantCPURequest    string
+		wantMemoryRequest string
+	}{
+		{name: "NoOvercommit", container: Container{CPULimit: "2", MemoryLimit: "4Gi"}, overcommitRatio: 1, wantCPURequest: "2", wantMemoryRequest: "4Gi"},
+		{name: "UnsetRatio", container: Container{CPULimit: "2", MemoryLimit: "4Gi"}, wantCPURequest: "2", wantMemoryRequest: "4Gi"},
+		{name: "Overcommit", container: Container{CPULimit: "2", MemoryLimit: "4Gi"}, overcommitRatio: 4, wantCPURequest: "500m", wantMemoryRequest: "1Gi"},
+		{name: "ExplicitRequests", container: Container{CPULimit: "2", CPURequest: "1", MemoryLimit: "4Gi", MemoryRequest: "3Gi"}, overcommitRatio: 4, wantCPURequest: "1", wantMemoryRequest: "3Gi"},
+	}
+	for _, testcase := range testCases {
+		testcase.container.ExtendedResources = map[string]string{"nvidia.com/gpu": "1"}
+		resources := getContainerResourceRequirements(testcase.container, testcase.overcommitRatio)
+		cpuRequest := resources.Requests[k8sv1.ResourceCPU]
+		memoryRequest := resources.Requests[k8sv1.ResourceMemory]
+		if cpuRequest.String() != testcase.wantCPURequest || memoryRequest.String() != testcase.wantMemoryRequest {
+			t.Errorf("unexpected requests when %s: got %s CPU and %s memory, want %s CPU and %s memory", testcase.name, cpuRequest.String(), memoryRequest.String(), testcase.wantCPURequest, testcase.wantMemoryRequest)
+		}
+		cpuLimit := resources.Limits[k8sv1.ResourceCPU]
+		if cpuLimit.String() != testcase.container.CPULimit {
+			t.Errorf("unexpected CPU limit when %s: %s", testcase.name, cpuLimit.String())
+		}
+		gpuRequest := resources.Requests[k8sv1.ResourceName("nvidia.com/gpu")]
+		if gpuRequest.Value() != 1 {
+			t.Errorf("expected the GPU request to equal the limit when %s, got %v", testcase.name, resources.Requests)
+		}
+	}
+
+	sidecarResources := getSidecarResourceRequirements(SidecarContainer{CPULimit: "1", MemoryLimit: "1Gi", MemoryRequest: "256Mi"}, 2)
+	sidecarCPURequest := sidecarResources.Requests[k8sv1.ResourceCPU]
+	sidecarMemoryRequest := sidecarResources.Requests[k8sv1.ResourceMemory]
+	if sidecarCPURequest.String() != "500m" || sidecarMemoryRequest.String() != "256Mi" {
+		t.Errorf("unexpected sidecar requests: %v", sidecarResources.Requests)
+	}
+}
+
+func TestOptionResourceRequests(t *testing.T) {
+	original_config := getConfig()
+	defer func() {
+		SetConfig(original_config)
+	}()
+	SetConfig(&FullHatcheryConfig{Config: HatcheryConfig{OvercommitRatio: 2}})
+
+	option := getOptionOutputForContainer("test-id", Container{
+		Name:        "test",
+		CPULimit:    "1",
+		MemoryLimit: "2Gi",
+		ResourceProfiles: []ResourceProfile{
+			{Name: "large", CPULimit: "4", CPURequest: "3", MemoryLimit: "8Gi"},
+		},
+	})
+	if option.CPURequest != "500m" || option.MemoryRequest != "1Gi" {
+		t.Errorf("unexpected option requests: %s CPU and %s memory", option.CPURequest, option.MemoryRequest)
+	}
+	if len(option.ResourceProfiles) != 1 || option.ResourceProfiles[0].CPURequest != "3" || option.ResourceProfiles[0].MemoryRequest != "4Gi" {
+		t.Errorf("unexpected resource profile options: %+v", option.ResourceProfiles)
+	}
+}
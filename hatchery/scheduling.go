@@ -0,0 +1,172 @@
+package hatchery
+
+import (
+	"fmt"
+
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// SchedulingConfig controls which nodes the workspace pods run on. It can
+// be set for all the containers at the root of the configuration, and for
+// each container, in which case the container's settings replace the
+// global ones.
+type SchedulingConfig struct {
+	NodeSelector map[string]string `json:"node-selector,omitempty"`
+	// in the Kubernetes format, eg `{"key": "role", "operator": "Equal",
+	// "value": "jupyter", "effect": "NoSchedule"}`
+	Tolerations       []k8sv1.Toleration `json:"tolerations,omitempty"`
+	Affinity          *k8sv1.Affinity    `json:"affinity,omitempty"`
+	PriorityClassName string             `json:"priority-class-name,omitempty"`
+}
+
+// Where workspaces run when neither the container nor the global
+// configuration sets a `node-selector` or `tolerations`
+var (
+	defaultNodeSelector = map[string]string{"role": "jupyter"}
+	defaultTolerations  = []k8sv1.Toleration{{Key: "role", Operator: "Equal", Value: "jupyter", Effect: "NoSchedule", TolerationSeconds: nil}}
+)
+
+// getPodScheduling returns the scheduling settings of the container's pods.
+// An empty `node-selector` or `tolerations` (as opposed to an unset one)
+// removes the default.
+func getPodScheduling(global SchedulingConfig, container SchedulingConfig) SchedulingConfig {
+	scheduling := SchedulingConfig{
+		NodeSelector:      defaultNodeSelector,
+		Tolerations:       defaultTolerations,
+		Affinity:          global.Affinity,
+		PriorityClassName: global.PriorityClassName,
+	}
+	if global.NodeSelector != nil {
+		scheduling.NodeSelector = global.NodeSelector
+	}
+	if global.Tolerations != nil {
+		scheduling.Tolerations = global.Tolerations
+	}
+	if container.NodeSelector != nil {
+		scheduling.NodeSelector = container.NodeSelector
+	}
+	if container.Tolerations != nil {
+		scheduling.Tolerations = container.Tolerations
+	}
+	if container.Affinity != nil {
+		scheduling.Affinity = container.Affinity
+	}
+	if container.PriorityClassName != "" {
+		scheduling.PriorityClassName = container.PriorityClassName
+	}
+	return scheduling
+}
+
+// withUserVolumeAffinity returns a copy of `affinity` that also requires the
+// pod to run on the same node as the user's other workspaces. All of a
+// user's workspaces mount the same ReadWriteOnce user volume, which can
+// only be attached to one node at a time. The first workspace matches its
+// own affinity, so it can run on any node.
+func withUserVolumeAffinity(affinity *k8sv1.Affinity, userName string) *k8sv1.Affinity {
+	result := &k8sv1.Affinity{}
+	if affinity != nil {
+		result = affinity.DeepCopy()
+	}
+	if result.PodAffinity == nil {
+		result.PodAffinity = &k8sv1.PodAffinity{}
+	}
+	result.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution = append(
+		result.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution,
+		k8sv1.PodAffinityTerm{
+			LabelSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{userVolumeLabel: userToResourceName(userName, "pod")},
+			},
+			TopologyKey: "kubernetes.io/hostname",
+		},
+	)
+	return result
+}
+
+// validateSchedulingConfig catches the settings the k8s API would reject
+// when the pod is created
+func validateSchedulingConfig(scheduling SchedulingConfig) error {
+	for key, value := range scheduling.NodeSelector {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid 'node-selector' key '%s': %v", key, errs)
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid 'node-selector' value '%s': %v", value, errs)
+		}
+	}
+
+	for _, toleration := range scheduling.Tolerations {
+		if toleration.Key != "" {
+			if errs := validation.IsQualifiedName(toleration.Key); len(errs) > 0 {
+				return fmt.Errorf("invalid 'tolerations' key '%s': %v", toleration.Key, errs)
+			}
+		}
+		switch toleration.Operator {
+		case k8sv1.TolerationOpEqual, "":
+			if toleration.Key == "" {
+				return fmt.Errorf("'tolerations' without a key must have the 'Exists' operator")
+			}
+		case k8sv1.TolerationOpExists:
+			if toleration.Value != "" {
+				return fmt.Errorf("'tolerations' with the 'Exists' operator can not have a value, got '%s'", toleration.Value)
+			}
+		default:
+			return fmt.Errorf("invalid 'tolerations' operator '%s': expected 'Equal' or 'Exists'", toleration.Operator)
+		}
+		switch toleration.Effect {
+		case k8sv1.TaintEffectNoSchedule, k8sv1.TaintEffectPreferNoSchedule, k8sv1.TaintEffectNoExecute, "":
+		default:
+			return fmt.Errorf("invalid 'tolerations' effect '%s': expected 'NoSchedule', 'PreferNoSchedule' or 'NoExecute'", toleration.Effect)
+		}
+		if toleration.TolerationSeconds != nil && toleration.Effect != k8sv1.TaintEffectNoExecute {
+			return fmt.Errorf("'tolerations' with 'tolerationSeconds' must have the 'NoExecute' effect")
+		}
+	}
+
+	if scheduling.Affinity != nil {
+		if err := validateAffinity(scheduling.Affinity); err != nil {
+			return fmt.Errorf("invalid 'affinity': %v", err)
+		}
+	}
+
+	if scheduling.PriorityClassName != "" {
+		if errs := validation.IsDNS1123Subdomain(scheduling.PriorityClassName); len(errs) > 0 {
+			return fmt.Errorf("invalid 'priority-class-name' '%s': %v", scheduling.PriorityClassName, errs)
+		}
+	}
+	return nil
+}
+
+func validateAffinity(affinity *k8sv1.Affinity) error {
+	if nodeAffinity := affinity.NodeAffinity; nodeAffinity != nil {
+		if required := nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution; required != nil && len(required.NodeSelectorTerms) == 0 {
+			return fmt.Errorf("'requiredDuringSchedulingIgnoredDuringExecution' node affinity must have 'nodeSelectorTerms'")
+		}
+	}
+	var requiredTerms []k8sv1.PodAffinityTerm
+	var preferredTerms []k8sv1.WeightedPodAffinityTerm
+	if affinity.PodAffinity != nil {
+		requiredTerms = append(requiredTerms, affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution...)
+		preferredTerms = append(preferredTerms, affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution...)
+	}
+	if affinity.PodAntiAffinity != nil {
+		requiredTerms = append(requiredTerms, affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution...)
+		preferredTerms = append(preferredTerms, affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution...)
+	}
+	for _, preferred := range preferredTerms {
+		if preferred.Weight < 1 || preferred.Weight > 100 {
+			return fmt.Errorf("pod affinity 'weight' must be between 1 and 100, got %d", preferred.Weight)
+		}
+		requiredTerms = append(requiredTerms, preferred.PodAffinityTerm)
+	}
+	for _, term := range requiredTerms {
+		if term.TopologyKey == "" {
+			return fmt.Errorf("pod affinity terms must have a 'topologyKey'")
+		}
+		if _, err := metav1.LabelSelectorAsSelector(term.LabelSelector); err != nil {
+			return fmt.Errorf("pod affinity term has an invalid 'labelSelector': %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package hatchery
+
+import (
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateSchedulingConfig(t *testing.T) {
+	tolerationSeconds := int64(60)
+	testCases := []struct {
+		name       string
+		scheduling SchedulingConfig
+		wantError  bool
+	}{
+		{name: "Empty", scheduling: SchedulingConfig{}},
+		{name: "Defaults", scheduling: SchedulingConfig{NodeSelector: defaultNodeSelector, Tolerations: defaultTolerations}},
+		{name: "InvalidNodeSelectorKey", scheduling: SchedulingConfig{NodeSelector: map[string]string{"not a key": "gpu"}}, wantError: true},
+		{name: "InvalidNodeSelectorValue", scheduling: SchedulingConfig{NodeSelector: map[string]string{"role": "not a value"}}, wantError: true},
+		{name: "TolerateEverything", scheduling: SchedulingConfig{Tolerations: []k8sv1.Toleration{{Operator: "Exists"}}}},
+		{name: "EqualWithoutKey", scheduling: SchedulingConfig{Tolerations: []k8sv1.Toleration{{Operator: "Equal", Value: "gpu"}}}, wantError: true},
+		{name: "ExistsWithValue", scheduling: SchedulingConfig{Tolerations: []k8sv1.Toleration{{Key: "role", Operator: "Exists", Value: "gpu"}}}, wantError: true},
+		{name: "InvalidOperator", scheduling: SchedulingConfig{Tolerations: []k8sv1.Toleration{{Key: "role", Operator: "In"}}}, wantError: true},
+		{name: "InvalidEffect", scheduling: SchedulingConfig{Tolerations: []k8sv1.Toleration{{Key: "role", Effect: "Never"}}}, wantError: true},
+		{name: "TolerationSecondsWithoutNoExecute", scheduling: SchedulingConfig{Tolerations: []k8sv1.Toleration{{Key: "role", Effect: "NoSchedule", TolerationSeconds: &tolerationSeconds}}}, wantError: true},
+		{name: "EmptyRequiredNodeAffinity", scheduling: SchedulingConfig{Affinity: &k8sv1.Affinity{NodeAffinity: &k8sv1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &k8sv1.NodeSelector{}}}}, wantError: true},
+		{name: "PodAffinityWithoutTopologyKey", scheduling: SchedulingConfig{Affinity: &k8sv1.Affinity{PodAffinity: &k8sv1.PodAffinity{RequiredDuringSchedulingIgnoredDuringExecution: []k8sv1.PodAffinityTerm{{}}}}}, wantError: true},
+		{name: "InvalidAntiAffinityWeight", scheduling: SchedulingConfig{Affinity: &k8sv1.Affinity{PodAntiAffinity: &k8sv1.PodAntiAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []k8sv1.WeightedPodAffinityTerm{{Weight: 0, PodAffinityTerm: k8sv1.PodAffinityTerm{TopologyKey: "kubernetes.io/hostname"}}}}}}, wantError: true},
+		{name: "InvalidLabelSelector", scheduling: SchedulingConfig{Affinity: &k8sv1.Affinity{PodAntiAffinity: &k8sv1.PodAntiAffinity{RequiredDuringSchedulingIgnoredDuringExecution: []k8sv1.PodAffinityTerm{{
+			TopologyKey:   "kubernetes.io/hostname",
+			LabelSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Sometimes"}}},
+		}}}}}, wantError: true},
+		{name: "InvalidPriorityClassName", scheduling: SchedulingConfig{PriorityClassName: "High Priority"}, wantError: true},
+	}
+	for _, testcase := range testCases {
+		err := validateSchedulingConfig(testcase.scheduling)
+		if testcase.wantError != (err != nil) {
+			t.Errorf("unexpected validation result when %s: %v", testcase.name, err)
+		}
+	}
+}
+
+func TestGetPodScheduling(t *testing.T) {
+	global := SchedulingConfig{Tolerations: []k8sv1.Toleration{{Key: "role", Operator: "Exists"}}, PriorityClassName: "workspaces"}
+
+	scheduling := getPodScheduling(global, SchedulingConfig{})
+	if scheduling.NodeSelector["role"] != "jupyter" || scheduling.Tolerations[0].Operator != "Exists" || scheduling.PriorityClassName != "workspaces" {
+		t.Errorf("unexpected scheduling without container settings: %+v", scheduling)
+	}
+
+	// an empty node selector removes the default
+	scheduling = getPodScheduling(global, SchedulingConfig{NodeSelector: map[string]string{}, PriorityClassName: "gpu"})
+	if len(scheduling.NodeSelector) != 0 || scheduling.PriorityClassName != "gpu" {
+		t.Errorf("unexpected scheduling with container settings: %+v", scheduling)
+	}
+}
@@ -0,0 +1,272 @@
+package hatchery
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// backgroundTracker counts the goroutines that must finish before hatchery
+// exits, such as launches and terminations that outlive their request
+type backgroundTracker struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	running  int
+	draining bool
+	// closed when hatchery starts shutting down
+	stopping chan struct{}
+}
+
+var backgroundWork = &backgroundTracker{}
+
+// goBackground runs `fn` in a goroutine that hatchery waits for on
+// shutdown. Work started once hatchery is shutting down is not waited for.
+func goBackground(fn func()) {
+	tracked := backgroundWork.add()
+	go func() {
+		if tracked {
+			defer backgroundWork.done()
+		}
+		fn()
+	}()
+}
+
+func (tracker *backgroundTracker) add() bool {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if tracker.draining {
+		return false
+	}
+	tracker.running++
+	tracker.wg.Add(1)
+	return true
+}
+
+func (tracker *backgroundTracker) done() {
+	tracker.mu.Lock()
+	tracker.running--
+	tracker.mu.Unlock()
+	tracker.wg.Done()
+}
+
+// count returns how many tracked goroutines are still running
+func (tracker *backgroundTracker) count() int {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	return tracker.running
+}
+
+// stopped returns a channel that is closed when hatchery starts shutting
+// down, for the work that can be interrupted and resumed after a restart
+func (tracker *backgroundTracker) stopped() <-chan struct{} {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if tracker.stopping == nil {
+		tracker.stopping = make(chan struct{})
+	}
+	return tracker.stopping
+}
+
+// drain stops tracking new work and waits for the running work to finish,
+// or for the context to be done
+func (tracker *backgroundTracker) drain(ctx context.Context) error {
+	tracker.mu.Lock()
+	if !tracker.draining {
+		tracker.draining = true
+		if tracker.stopping == nil {
+			tracker.stopping = make(chan struct{})
+		}
+		close(tracker.stopping)
+	}
+	tracker.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		tracker.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// certReloader serves the TLS certificate from the configured files, and
+// loads it again when the files change, eg when cert-manager renews it
+type certReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	// the content of the files the current certificate was loaded from
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	_, err := reloader.reload()
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// reload loads the certificate again if the files changed, and returns
+// whether it did. The current certificate is kept if the new one is invalid.
+func (reloader *certReloader) reload() (bool, error) {
+	certPEM, err := ioutil.ReadFile(reloader.certFile)
+	if err != nil {
+		return false, fmt.Errorf("unable to read the TLS certificate: %v", err)
+	}
+	keyPEM, err := ioutil.ReadFile(reloader.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("unable to read the TLS key: %v", err)
+	}
+
+	reloader.mu.RLock()
+	unchanged := bytes.Equal(certPEM, reloader.certPEM) && bytes.Equal(keyPEM, reloader.keyPEM)
+	reloader.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, fmt.Errorf("invalid TLS certificate or key: %v", err)
+	}
+	reloader.mu.Lock()
+	reloader.cert, reloader.certPEM, reloader.keyPEM = &cert, certPEM, keyPEM
+	reloader.mu.Unlock()
+	return true, nil
+}
+
+// GetCertificate implements `tls.Config.GetCertificate`
+func (reloader *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mu.RLock()
+	defer reloader.mu.RUnlock()
+	return reloader.cert, nil
+}
+
+// watch reloads the certificate when hatchery receives a SIGHUP, and
+// polls the files for changes at the interval
+func (reloader *certReloader) watch(interval time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-signals:
+			case <-ticker.C:
+			}
+			reloaded, err := reloader.reload()
+			if err != nil {
+				getConfig().Logger.Printf("Unable to reload the TLS certificate, keeping the current one: %v", err)
+			} else if reloaded {
+				getConfig().Logger.Printf("Reloaded the TLS certificate from %s", reloader.certFile)
+			}
+		}
+	}()
+}
+
+// Serve serves the API on the configured address, over HTTPS if a
+// certificate is configured, until hatchery receives a SIGTERM or a SIGINT.
+// It then stops accepting requests and waits for the running requests and
+// background operations to finish, for at most `shutdown-timeout-seconds`.
+func Serve(handler http.Handler) error {
+	settings := getConfig().Config.Server
+	server := &http.Server{
+		Addr:    net.JoinHostPort(settings.Address, strconv.Itoa(settings.Port)),
+		Handler: handler,
+	}
+	// otherwise the status streams would hold up the shutdown until their
+	// clients disconnect
+	server.RegisterOnShutdown(statusStreams.close)
+	server.RegisterOnShutdown(stopWorkspaceStatusCache)
+	if settings.TLSCertFile != "" {
+		reloader, err := newCertReloader(settings.TLSCertFile, settings.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		reloader.watch(time.Duration(settings.TLSReloadIntervalSeconds) * time.Second)
+		server.TLSConfig = &tls.Config{
+			GetCertificate: reloader.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+	}
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+	return runServer(server, listener, signals, time.Duration(settings.ShutdownTimeoutSeconds)*time.Second)
+}
+
+// runServer serves on the listener until it receives a signal, then shuts
+// the server down
+func runServer(server *http.Server, listener net.Listener, signals <-chan os.Signal, shutdownTimeout time.Duration) error {
+	// the server can not be read once it is serving
+	useTLS := server.TLSConfig != nil
+	served := make(chan error, 1)
+	go func() {
+		if useTLS {
+			served <- server.ServeTLS(listener, "", "")
+		} else {
+			served <- server.Serve(listener)
+		}
+	}()
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	getConfig().Logger.Printf("Serving %s on %s", scheme, listener.Addr())
+
+	select {
+	case err := <-served:
+		return err
+	case sig := <-signals:
+		getConfig().Logger.Printf("Received %v: shutting down", sig)
+	}
+	return shutdownServer(server, shutdownTimeout)
+}
+
+// shutdownServer stops accepting requests, then waits for the running
+// requests and the background operations to finish
+func shutdownServer(server *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		getConfig().Logger.Printf("Unable to wait for the running requests: %v", err)
+	}
+	if running := backgroundWork.count(); running > 0 {
+		getConfig().Logger.Printf("Waiting for %d background operation(s) to finish", running)
+	}
+	err = backgroundWork.drain(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("gave up waiting for %d background operation(s) after %v", backgroundWork.count(), timeout)
+	}
+	if err != nil {
+		return err
+	}
+	getConfig().Logger.Printf("Shutdown complete")
+	return nil
+}
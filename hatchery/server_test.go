@@ -0,0 +1,260 @@
+package hatchery
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate and its key to the
+// files
+func writeTestCertificate(t *testing.T, certFile string, keyFile string, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetServerDefaults(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	testCases := []struct {
+		name     string
+		server   ServerConfig
+		want     ServerConfig
+		hasError bool
+	}{
+		{
+			name:   "nothing is set",
+			server: ServerConfig{},
+			want:   ServerConfig{Address: "0.0.0.0", Port: 8000, TLSReloadIntervalSeconds: 60, ShutdownTimeoutSeconds: 25},
+		},
+		{
+			name:   "everything is set",
+			server: ServerConfig{Address: "127.0.0.1", Port: 8443, TLSCertFile: "tls.crt", TLSKeyFile: "tls.key", TLSReloadIntervalSeconds: 10, ShutdownTimeoutSeconds: 300},
+			want:   ServerConfig{Address: "127.0.0.1", Port: 8443, TLSCertFile: "tls.crt", TLSKeyFile: "tls.key", TLSReloadIntervalSeconds: 10, ShutdownTimeoutSeconds: 300},
+		},
+		{
+			name:     "the port is invalid",
+			server:   ServerConfig{Port: 70000},
+			hasError: true,
+		},
+		{
+			name:     "the TLS key is missing",
+			server:   ServerConfig{TLSCertFile: "tls.crt"},
+			hasError: true,
+		},
+		{
+			name:     "the shutdown timeout is negative",
+			server:   ServerConfig{ShutdownTimeoutSeconds: -1},
+			hasError: true,
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Logf("Testing server settings when %s", testcase.name)
+		server := testcase.server
+		err := setServerDefaults(&server)
+		if testcase.hasError {
+			if err == nil {
+				t.Error("expected an error, got nil")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if server != testcase.want {
+			t.Errorf("unexpected settings:\ngot: '%+v'\nwant: '%+v'", server, testcase.want)
+		}
+	}
+}
+
+func TestBackgroundTrackerDrain(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_backgroundWork := backgroundWork
+	defer func() {
+		backgroundWork = original_backgroundWork
+	}()
+
+	backgroundWork = &backgroundTracker{}
+	release := make(chan struct{})
+	goBackground(func() {
+		<-release
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := backgroundWork.drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the drain to time out, got %v", err)
+	}
+	if backgroundWork.count() != 1 {
+		t.Errorf("expected 1 running operation, got %d", backgroundWork.count())
+	}
+
+	// work started while draining is not waited for
+	goBackground(func() {
+		<-release
+	})
+	close(release)
+	if err := backgroundWork.drain(context.Background()); err != nil {
+		t.Errorf("unexpected drain error: %v", err)
+	}
+	if backgroundWork.count() != 0 {
+		t.Errorf("expected no running operation, got %d", backgroundWork.count())
+	}
+}
+
+func TestRunServerShutdown(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_backgroundWork := backgroundWork
+	defer func() {
+		SetConfig(original_config)
+		backgroundWork = original_backgroundWork
+	}()
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags)})
+	backgroundWork = &backgroundTracker{}
+
+	requestStarted := make(chan struct{})
+	releaseRequest := make(chan struct{})
+	operationDone := false
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		goBackground(func() {
+			time.Sleep(100 * time.Millisecond)
+			operationDone = true
+		})
+		close(requestStarted)
+		<-releaseRequest
+		w.Write([]byte("done"))
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan os.Signal, 1)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- runServer(&http.Server{Handler: handler}, listener, signals, 5*time.Second)
+	}()
+
+	responses := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/")
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+	<-requestStarted
+	signals <- syscall.SIGTERM
+
+	// the server stops accepting requests, but finishes the running one
+	time.Sleep(50 * time.Millisecond)
+	if _, err := net.DialTimeout("tcp", listener.Addr().String(), time.Second); err == nil {
+		t.Error("expected the server to stop accepting connections")
+	}
+	close(releaseRequest)
+	if body := <-responses; body != "done" {
+		t.Errorf("expected the running request to finish, got '%s'", body)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("unexpected shutdown error: %v", err)
+	}
+	if !operationDone {
+		t.Error("expected the shutdown to wait for the background operation")
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeTestCertificate(t, certFile, keyFile, "first")
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("unexpected error loading the certificate: %v", err)
+	}
+	getCommonName := func() string {
+		cert, _ := reloader.GetCertificate(nil)
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Subject.CommonName
+	}
+	if getCommonName() != "first" {
+		t.Errorf("expected the first certificate, got '%s'", getCommonName())
+	}
+
+	reloaded, err := reloader.reload()
+	if reloaded || err != nil {
+		t.Errorf("expected no reload when the files did not change, got %v, %v", reloaded, err)
+	}
+
+	writeTestCertificate(t, certFile, keyFile, "second")
+	reloaded, err = reloader.reload()
+	if !reloaded || err != nil || getCommonName() != "second" {
+		t.Errorf("expected the second certificate to be loaded, got %v, %v, '%s'", reloaded, err, getCommonName())
+	}
+
+	err = ioutil.WriteFile(keyFile, []byte("not a key"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err = reloader.reload()
+	if reloaded || err == nil || getCommonName() != "second" {
+		t.Errorf("expected an invalid key to be rejected and the second certificate kept, got %v, %v, '%s'", reloaded, err, getCommonName())
+	}
+
+	_, err = newCertReloader(certFile, keyFile)
+	if err == nil {
+		t.Error("expected an error loading an invalid key")
+	}
+}
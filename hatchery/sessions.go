@@ -0,0 +1,116 @@
+package hatchery
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// getMaxSessionDuration returns the shortest of the container's and the
+// pay model's `max-session-duration`, or 0 if neither sets a limit.
+// Both arguments may be nil.
+func getMaxSessionDuration(container *Container, payModel *PayModel) time.Duration {
+	var maxSessionDuration time.Duration
+	limits := []int{}
+	if container != nil {
+		limits = append(limits, container.MaxSessionDuration)
+	}
+	if payModel != nil {
+		limits = append(limits, payModel.MaxSessionDuration)
+	}
+	for _, limit := range limits {
+		duration := time.Duration(limit) * time.Second
+		if limit > 0 && (maxSessionDuration == 0 || duration < maxSessionDuration) {
+			maxSessionDuration = duration
+		}
+	}
+	return maxSessionDuration
+}
+
+// setSessionTimes fills in the session limit fields of the status, so the
+// UI can warn the user before the workspace is terminated
+func setSessionTimes(status *WorkspaceStatus, startTime time.Time, maxSessionDuration time.Duration) {
+	if maxSessionDuration <= 0 || startTime.IsZero() {
+		return
+	}
+	endTime := startTime.Add(maxSessionDuration)
+	remaining := time.Until(endTime)
+	if remaining < 0 {
+		remaining = 0
+	}
+	status.MaxSessionDuration = int(maxSessionDuration / time.Millisecond)
+	status.SessionEndTime = endTime.UnixNano() / int64(time.Millisecond)
+	status.RemainingSessionTime = int64(remaining / time.Millisecond)
+}
+
+// setEcsSessionTimes is setSessionTimes for ECS workspaces. The container
+// and start time are read from the local service that routes traffic to
+// the workspace.
+func setEcsSessionTimes(ctx context.Context, status *WorkspaceStatus, userName string, payModel *PayModel) {
+	var hatchApp *Container
+	startTime := time.Time{}
+	podClient := getLocalPodClient()
+	if podClient != nil {
+		service, err := podClient.Services(getConfig().Config.UserNamespace).Get(ctx, userToResourceName(userName, "service"), metav1.GetOptions{})
+		if err == nil {
+			startTime = service.CreationTimestamp.Time
+			if container, ok := getConfig().ContainersMap[resolveContainerID(service.Annotations[containerIDAnnotation])]; ok {
+				hatchApp = &container
+			}
+		} else {
+			getConfig().Logger.Printf("Unable to get the local service of the ECS workspace of user %s: %v", userName, err)
+		}
+	}
+	setSessionTimes(status, startTime, getMaxSessionDuration(hatchApp, payModel))
+}
+
+// StartSessionSweeper starts the background job that terminates the
+// workspaces that reached their container's or pay model's
+// `max-session-duration`, if it is enabled in the configuration.
+func StartSessionSweeper() {
+	if !getConfig().Config.SessionSweeper.Enabled {
+		getConfig().Logger.Printf("Workspace session sweeper is not enabled")
+		return
+	}
+	interval := time.Duration(getConfig().Config.SessionSweeper.IntervalSeconds) * time.Second
+	getConfig().Logger.Printf("Starting the workspace session sweeper, running every %v on the replica that holds its lease", interval)
+	startLeasedJob("session-sweeper", interval, func() {
+		sweepExpiredSessions(withAuditActor(context.Background(), "session-sweeper"))
+	})
+}
+
+// sweepExpiredSessions terminates all the workspaces that reached their
+// maximum session duration and returns how many it terminated
+func sweepExpiredSessions(ctx context.Context) int {
+	workspaces, err := listActiveWorkspaces(ctx)
+	if err != nil {
+		getConfig().Logger.Printf("Session sweeper: unable to list the active workspaces: %v", err)
+		return 0
+	}
+
+	swept := 0
+	for _, workspace := range workspaces {
+		var hatchApp *Container
+		if container, ok := getConfig().ContainersMap[workspace.ContainerID]; ok {
+			hatchApp = &container
+		}
+		payModel, err := getWorkspacePayModel(workspace.UserName, workspace.PayModelID)
+		if err != nil {
+			getConfig().Logger.Printf("Session sweeper: unable to get the pay model of user %s: %v", workspace.UserName, err)
+		}
+		maxSessionDuration := getMaxSessionDuration(hatchApp, payModel)
+		if maxSessionDuration <= 0 || time.Since(workspace.StartTime) < maxSessionDuration {
+			continue
+		}
+
+		getConfig().Logger.Printf("Session sweeper: workspace '%s' of user %s started at %v and reached its maximum session duration of %v, terminating it", workspace.WorkspaceName, workspace.UserName, workspace.StartTime, maxSessionDuration)
+		_, err = terminateWorkspace(ctx, workspace.UserName, workspace.WorkspaceName, "")
+		if err != nil {
+			getConfig().Logger.Printf("Session sweeper: unable to terminate workspace '%s' of user %s: %v", workspace.WorkspaceName, workspace.UserName, err)
+			continue
+		}
+		swept++
+	}
+	return swept
+}
@@ -0,0 +1,157 @@
+package hatchery
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestGetMaxSessionDuration(t *testing.T) {
+	testCases := []struct {
+		name      string
+		container *Container
+		payModel  *PayModel
+		want      time.Duration
+	}{
+		{
+			name: "NoLimits",
+			want: 0,
+		},
+		{
+			name:      "ContainerLimitOnly",
+			container: &Container{MaxSessionDuration: 3600},
+			payModel:  &PayModel{},
+			want:      time.Hour,
+		},
+		{
+			name:     "PayModelLimitOnly",
+			payModel: &PayModel{MaxSessionDuration: 7200},
+			want:     2 * time.Hour,
+		},
+		{
+			name:      "ShortestLimitWins",
+			container: &Container{MaxSessionDuration: 7200},
+			payModel:  &PayModel{MaxSessionDuration: 3600},
+			want:      time.Hour,
+		},
+	}
+	for _, testcase := range testCases {
+		got := getMaxSessionDuration(testcase.container, testcase.payModel)
+		if got != testcase.want {
+			t.Errorf("unexpected max session duration when %s: got %v, want %v", testcase.name, got, testcase.want)
+		}
+	}
+}
+
+func TestSetSessionTimes(t *testing.T) {
+	startTime := time.Now().Add(-30 * time.Minute)
+
+	status := WorkspaceStatus{}
+	setSessionTimes(&status, startTime, 0)
+	if status.MaxSessionDuration != 0 || status.SessionEndTime != 0 || status.RemainingSessionTime != 0 {
+		t.Errorf("session times should not be set without a limit: %+v", status)
+	}
+
+	setSessionTimes(&status, startTime, time.Hour)
+	if status.MaxSessionDuration != 3600*1000 {
+		t.Errorf("unexpected max session duration: %v", status.MaxSessionDuration)
+	}
+	if status.SessionEndTime != startTime.Add(time.Hour).UnixNano()/int64(time.Millisecond) {
+		t.Errorf("unexpected session end time: %v", status.SessionEndTime)
+	}
+	// allow some slack for the time it takes to run the test
+	remaining := time.Duration(status.RemainingSessionTime) * time.Millisecond
+	if remaining > 30*time.Minute || remaining < 29*time.Minute {
+		t.Errorf("unexpected remaining session time: %v", remaining)
+	}
+
+	setSessionTimes(&status, startTime, 10*time.Minute)
+	if status.RemainingSessionTime != 0 {
+		t.Errorf("the remaining session time of an expired session should be 0, got %v", status.RemainingSessionTime)
+	}
+}
+
+func TestSweepExpiredSessions(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	testCases := []struct {
+		name           string
+		workspace      WorkspaceInfo
+		payModel       *PayModel
+		wantTerminated bool
+	}{
+		{
+			name:           "ContainerLimitReached",
+			workspace:      WorkspaceInfo{UserName: "testUser", ContainerID: "limited", StartTime: time.Now().Add(-2 * time.Hour)},
+			wantTerminated: true,
+		},
+		{
+			name:      "ContainerLimitNotReached",
+			workspace: WorkspaceInfo{UserName: "testUser", ContainerID: "limited", StartTime: time.Now().Add(-30 * time.Minute)},
+		},
+		{
+			name:           "PayModelLimitReached",
+			workspace:      WorkspaceInfo{UserName: "testUser", WorkspaceName: "rstudio", ContainerID: "unlimited", StartTime: time.Now().Add(-2 * time.Hour)},
+			payModel:       &PayModel{MaxSessionDuration: 3600},
+			wantTerminated: true,
+		},
+		{
+			name:      "NoLimit",
+			workspace: WorkspaceInfo{UserName: "testUser", ContainerID: "unlimited", StartTime: time.Now().Add(-200 * time.Hour)},
+		},
+	}
+
+	original_config := getConfig()
+	original_listActiveWorkspaces := listActiveWorkspaces
+	original_getCurrentPayModel := getCurrentPayModel
+	original_terminateWorkspace := terminateWorkspace
+	defer func() {
+		// restore original functions
+		SetConfig(original_config)
+		listActiveWorkspaces = original_listActiveWorkspaces
+		getCurrentPayModel = original_getCurrentPayModel
+		terminateWorkspace = original_terminateWorkspace
+	}()
+
+	// other tests may leave a config without a logger behind
+	SetConfig(&FullHatcheryConfig{
+		Logger: log.New(io.Discard, "", log.LstdFlags),
+	})
+	getConfig().ContainersMap = map[string]Container{
+		"limited": {
+			Name:               "Container with a 1h session limit",
+			MaxSessionDuration: 3600,
+		},
+		"unlimited": {
+			Name: "Container without session limit",
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Logf("Testing sweepExpiredSessions when %s", testcase.name)
+
+		listActiveWorkspaces = func(context.Context) ([]WorkspaceInfo, error) {
+			return []WorkspaceInfo{testcase.workspace}, nil
+		}
+		getCurrentPayModel = func(string) (*PayModel, error) {
+			return testcase.payModel, nil
+		}
+		var terminated []string
+		terminateWorkspace = func(ctx context.Context, userName string, workspaceName string, accessToken string) (string, error) {
+			terminated = append(terminated, workspaceName)
+			return "Terminated workspace", nil
+		}
+
+		swept := sweepExpiredSessions(context.Background())
+
+		if testcase.wantTerminated {
+			if swept != 1 || len(terminated) != 1 || terminated[0] != testcase.workspace.WorkspaceName {
+				t.Errorf("expected workspace '%s' to be terminated, got %v", testcase.workspace.WorkspaceName, terminated)
+			}
+		} else if swept != 0 || len(terminated) != 0 {
+			t.Errorf("expected the workspace not to be terminated, got %v", terminated)
+		}
+	}
+}
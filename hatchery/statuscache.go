@@ -0,0 +1,487 @@
+package hatchery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	k8sv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// How often the informers list all the pods and services again, on
+	// top of watching them
+	statusCacheResync = 10 * time.Minute
+	// How often an idle status stream sends a comment, so that proxies do
+	// not close it
+	statusStreamKeepAlive = 30 * time.Second
+	// How long the pods this replica created are read from the k8s API if
+	// the cache has not received them yet
+	statusCacheLaunchGracePeriod = time.Minute
+)
+
+var workspaceStatusChangesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "hatchery_workspace_status_changes_total",
+	Help: "Number of status changes of the workspaces in hatchery's cluster, by new status.",
+}, []string{"status"})
+
+func init() {
+	prometheus.MustRegister(workspaceStatusChangesTotal)
+}
+
+// workspaceStatusCache holds the workspace pods and services of the user
+// namespace, kept up to date by shared informers, so that the status of the
+// local workspaces is not read from the k8s API at every `/status` call.
+// The workspaces of external EKS clusters are always read live.
+type workspaceStatusCache struct {
+	factory  informers.SharedInformerFactory
+	pods     listersv1.PodLister
+	services listersv1.ServiceLister
+	synced   []cache.InformerSynced
+	// false until the initial list is loaded, not to count the existing
+	// pods as status changes
+	watching int32
+}
+
+// the cache, once it is synced
+var currentStatusCache atomic.Value
+
+var (
+	statusCacheMutex sync.Mutex
+	// closed on shutdown, to stop the informers
+	statusCacheStop chan struct{}
+)
+
+// getStatusCache returns the cache, or nil if the statuses must be read from
+// the k8s API
+func getStatusCache() *workspaceStatusCache {
+	statusCache, _ := currentStatusCache.Load().(*workspaceStatusCache)
+	return statusCache
+}
+
+// newWorkspaceStatusCache returns a cache of the workspace pods and
+// services of the namespace, which hatchery sets the workspace label on
+func newWorkspaceStatusCache(clientset kubernetes.Interface, namespace string) *workspaceStatusCache {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, statusCacheResync,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = workspaceLabel + "=" + workspaceLabelValue
+		}),
+	)
+	podInformer := factory.Core().V1().Pods()
+	serviceInformer := factory.Core().V1().Services()
+	statusCache := &workspaceStatusCache{
+		factory:  factory,
+		pods:     podInformer.Lister(),
+		services: serviceInformer.Lister(),
+		synced:   []cache.InformerSynced{podInformer.Informer().HasSynced, serviceInformer.Informer().HasSynced},
+	}
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			statusCache.onPodChange(nil, obj)
+		},
+		UpdateFunc: statusCache.onPodChange,
+		DeleteFunc: func(obj interface{}) {
+			statusCache.onPodChange(obj, nil)
+		},
+	})
+	return statusCache
+}
+
+// start starts the informers and blocks until they are synced or `stopCh`
+// is closed. It returns whether the cache can be used.
+func (statusCache *workspaceStatusCache) start(stopCh <-chan struct{}) bool {
+	statusCache.factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, statusCache.synced...) {
+		return false
+	}
+	atomic.StoreInt32(&statusCache.watching, 1)
+	return true
+}
+
+// podFromChange returns the workspace pod received by the pod informer, or
+// nil if there is none
+func podFromChange(obj interface{}) *k8sv1.Pod {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*k8sv1.Pod)
+	if !ok || pod == nil || !isWorkspaceResource(pod.ObjectMeta) {
+		return nil
+	}
+	return pod
+}
+
+// podChangeStatus returns the status of a pod received by the pod informer,
+// "Not Found" if there is no pod, or "" if it is not a workspace pod
+func podChangeStatus(obj interface{}) string {
+	if obj == nil {
+		return "Not Found"
+	}
+	pod := podFromChange(obj)
+	if pod == nil {
+		return ""
+	}
+	return workspacePodStatus(pod)
+}
+
+// onPodChange counts the status changes of the workspace pods and sends
+// them to the status streams of the workspace's user
+func (statusCache *workspaceStatusCache) onPodChange(oldObj interface{}, newObj interface{}) {
+	if pod := podFromChange(newObj); pod != nil {
+		recentPodLaunches.remove(pod.Name)
+	}
+	if atomic.LoadInt32(&statusCache.watching) == 0 {
+		return
+	}
+	oldStatus, newStatus := podChangeStatus(oldObj), podChangeStatus(newObj)
+	if oldStatus == "" || newStatus == "" || oldStatus == newStatus {
+		return
+	}
+	workspaceStatusChangesTotal.WithLabelValues(newStatus).Inc()
+
+	pod := podFromChange(newObj)
+	if pod == nil {
+		pod = podFromChange(oldObj)
+	}
+	statusStreams.publish(pod.Annotations[userNameAnnotation], WorkspaceStatusChange{
+		WorkspaceName: pod.Annotations[workspaceNameAnnotation],
+		Status:        newStatus,
+		Time:          time.Now().UTC(),
+	})
+}
+
+// WorkspaceStatusChange is sent on the status stream when the status of a
+// workspace in hatchery's cluster changes
+type WorkspaceStatusChange struct {
+	WorkspaceName string    `json:"workspaceName,omitempty"`
+	Status        string    `json:"status"`
+	Time          time.Time `json:"time"`
+}
+
+// statusStreamBroker sends the status changes to the open status streams,
+// by user
+type statusStreamBroker struct {
+	mutex       sync.Mutex
+	subscribers map[string]map[chan WorkspaceStatusChange]bool
+	// closed when hatchery shuts down, to end the streams
+	closing chan struct{}
+	closed  bool
+}
+
+var statusStreams = newStatusStreamBroker()
+
+func newStatusStreamBroker() *statusStreamBroker {
+	return &statusStreamBroker{
+		subscribers: make(map[string]map[chan WorkspaceStatusChange]bool),
+		closing:     make(chan struct{}),
+	}
+}
+
+func (broker *statusStreamBroker) subscribe(userName string) chan WorkspaceStatusChange {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	changes := make(chan WorkspaceStatusChange, 16)
+	if broker.subscribers[userName] == nil {
+		broker.subscribers[userName] = make(map[chan WorkspaceStatusChange]bool)
+	}
+	broker.subscribers[userName][changes] = true
+	return changes
+}
+
+func (broker *statusStreamBroker) unsubscribe(userName string, changes chan WorkspaceStatusChange) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	delete(broker.subscribers[userName], changes)
+	if len(broker.subscribers[userName]) == 0 {
+		delete(broker.subscribers, userName)
+	}
+}
+
+// publish sends the change to the user's streams. The informer does not
+// wait for slow clients: a change is dropped if the stream's buffer is full.
+func (broker *statusStreamBroker) publish(userName string, change WorkspaceStatusChange) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	for changes := range broker.subscribers[userName] {
+		select {
+		case changes <- change:
+		default:
+		}
+	}
+}
+
+// close ends the open streams, so that they do not hold up the shutdown
+func (broker *statusStreamBroker) close() {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if !broker.closed {
+		broker.closed = true
+		close(broker.closing)
+	}
+}
+
+// statusStream sends the status changes of the user's workspaces in
+// hatchery's cluster as server-sent events, until the client disconnects
+// or hatchery shuts down. The workspaces of external clusters are not
+// streamed: their status is only available at `/status`.
+func statusStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userName := getCurrentUserName(r)
+	if userName == "" {
+		http.Error(w, "No username found", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	if getStatusCache() == nil {
+		http.Error(w, "The status stream is not available yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	changes := statusStreams.subscribe(userName)
+	defer statusStreams.unsubscribe(userName, changes)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(statusStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-statusStreams.closing:
+			return
+		case change := <-changes:
+			out, err := json.Marshal(change)
+			if err != nil {
+				getConfig().Logger.Printf("Unable to send a status change to user %s: %v", userName, err)
+				continue
+			}
+			fmt.Fprintf(w, "event: status\ndata: %s\n\n", out)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+// recentLaunches holds the local workspace pods this replica created, until
+// the cache receives them. The cache lags behind the k8s API: without
+// them, a workspace would not be found right after its launch.
+type recentLaunches struct {
+	mutex sync.Mutex
+	pods  map[string]time.Time
+}
+
+var recentPodLaunches = &recentLaunches{pods: make(map[string]time.Time)}
+
+func (launches *recentLaunches) add(podName string) {
+	launches.mutex.Lock()
+	defer launches.mutex.Unlock()
+	launches.pods[podName] = time.Now()
+}
+
+func (launches *recentLaunches) remove(podName string) {
+	launches.mutex.Lock()
+	defer launches.mutex.Unlock()
+	delete(launches.pods, podName)
+}
+
+// names returns the pods created within the grace period, and forgets the
+// older ones
+func (launches *recentLaunches) names() []string {
+	launches.mutex.Lock()
+	defer launches.mutex.Unlock()
+	names := []string{}
+	for podName, createdAt := range launches.pods {
+		if time.Since(createdAt) >= statusCacheLaunchGracePeriod {
+			delete(launches.pods, podName)
+			continue
+		}
+		names = append(names, podName)
+	}
+	return names
+}
+
+func (launches *recentLaunches) contains(podName string) bool {
+	for _, name := range launches.names() {
+		if name == podName {
+			return true
+		}
+	}
+	return false
+}
+
+// getPod returns the cached pod, or reads it from the k8s API if this
+// replica just created it and the cache has not received it yet
+func (statusCache *workspaceStatusCache) getPod(ctx context.Context, podClient corev1.CoreV1Interface, namespace string, podName string) (*k8sv1.Pod, error) {
+	pod, err := statusCache.pods.Pods(namespace).Get(podName)
+	if k8serrors.IsNotFound(err) && podClient != nil && recentPodLaunches.contains(podName) {
+		return podClient.Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	}
+	return pod, err
+}
+
+// listPods returns the cached pods, and reads the pods this replica just
+// created from the k8s API if the cache has not received them yet
+func (statusCache *workspaceStatusCache) listPods(ctx context.Context, podClient corev1.CoreV1Interface, namespace string) ([]*k8sv1.Pod, error) {
+	pods, err := statusCache.pods.Pods(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	cached := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		cached[pod.Name] = true
+	}
+	for _, podName := range recentPodLaunches.names() {
+		if cached[podName] || podClient == nil {
+			continue
+		}
+		pod, err := podClient.Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			// deleted since
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+// listWorkspaceResources returns the metadata of the cached pods and
+// services
+func (statusCache *workspaceStatusCache) listWorkspaceResources(namespace string) ([]metav1.ObjectMeta, error) {
+	pods, err := statusCache.pods.Pods(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	services, err := statusCache.services.Services(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	resources := make([]metav1.ObjectMeta, 0, len(pods)+len(services))
+	for _, pod := range pods {
+		resources = append(resources, pod.ObjectMeta)
+	}
+	for _, service := range services {
+		resources = append(resources, service.ObjectMeta)
+	}
+	return resources, nil
+}
+
+// labelExistingWorkspaces sets the workspace label on the pods and services
+// of the workspaces launched before hatchery set it, so that the cache
+// finds them, and the user volume label on the pods that mount the user
+// volume, so that the user's new workspaces run on the same node
+func labelExistingWorkspaces(ctx context.Context, clientset kubernetes.Interface, namespace string) error {
+	patch := []byte(fmt.Sprintf(`{"metadata":{"labels":{%q:%q}}}`, workspaceLabel, workspaceLabelValue))
+	listOptions := metav1.ListOptions{LabelSelector: "app," + workspaceLabel + "!=" + workspaceLabelValue}
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, listOptions)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		if !isWorkspaceResource(pod.ObjectMeta) {
+			continue
+		}
+		podPatch := patch
+		userName := pod.Annotations[userNameAnnotation]
+		for _, volume := range pod.Spec.Volumes {
+			if claim := volume.PersistentVolumeClaim; claim != nil && claim.ClaimName == userToResourceName(userName, "claim") {
+				podPatch = []byte(fmt.Sprintf(`{"metadata":{"labels":{%q:%q,%q:%q}}}`, workspaceLabel, workspaceLabelValue, userVolumeLabel, userToResourceName(userName, "pod")))
+			}
+		}
+		_, err = clientset.CoreV1().Pods(namespace).Patch(ctx, pod.Name, types.MergePatchType, podPatch, metav1.PatchOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+	services, err := clientset.CoreV1().Services(namespace).List(ctx, listOptions)
+	if err != nil {
+		return err
+	}
+	for _, service := range services.Items {
+		if !isWorkspaceResource(service.ObjectMeta) {
+			continue
+		}
+		_, err = clientset.CoreV1().Services(namespace).Patch(ctx, service.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartWorkspaceStatusCache starts watching the workspace pods and services
+// of the user namespace. The statuses are read from the k8s API until the
+// cache is synced, or if it can not be started.
+func StartWorkspaceStatusCache() {
+	clientset, err := getKubeClientSet()
+	if err != nil {
+		getConfig().Logger.Printf("Unable to start the workspace status cache, the statuses will be read from the k8s API: %v", err)
+		return
+	}
+	statusCache := newWorkspaceStatusCache(clientset, getConfig().Config.UserNamespace)
+	stopCh := make(chan struct{})
+	statusCacheMutex.Lock()
+	statusCacheStop = stopCh
+	statusCacheMutex.Unlock()
+	getConfig().Logger.Printf("Starting the workspace status cache for namespace %s", getConfig().Config.UserNamespace)
+	go func() {
+		err := labelExistingWorkspaces(context.Background(), clientset, getConfig().Config.UserNamespace)
+		if err != nil {
+			getConfig().Logger.Printf("Unable to label the existing workspaces, the statuses will be read from the k8s API: %v", err)
+			return
+		}
+		if !statusCache.start(stopCh) {
+			return
+		}
+		statusCacheMutex.Lock()
+		defer statusCacheMutex.Unlock()
+		select {
+		case <-stopCh:
+			// stopped while syncing
+		default:
+			currentStatusCache.Store(statusCache)
+			getConfig().Logger.Printf("The workspace status cache is synced")
+		}
+	}()
+}
+
+// stopWorkspaceStatusCache stops the informers when hatchery shuts down.
+// The background operations that drain on shutdown then read the statuses
+// from the k8s API.
+func stopWorkspaceStatusCache() {
+	statusCacheMutex.Lock()
+	defer statusCacheMutex.Unlock()
+	currentStatusCache.Store((*workspaceStatusCache)(nil))
+	if statusCacheStop != nil {
+		close(statusCacheStop)
+		statusCacheStop = nil
+	}
+}
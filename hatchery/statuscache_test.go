@@ -0,0 +1,291 @@
+package hatchery
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+func TestWorkspaceStatusCache(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_getLocalPodClient := getLocalPodClient
+	defer func() {
+		SetConfig(original_config)
+		getLocalPodClient = original_getLocalPodClient
+		currentStatusCache.Store((*workspaceStatusCache)(nil))
+	}()
+
+	SetConfig(&FullHatcheryConfig{
+		Config: HatcheryConfig{UserNamespace: "jupyter-pods"},
+		Logger: log.New(io.Discard, "", log.LstdFlags),
+	})
+	// a live read would not find the workspace
+	getLocalPodClient = func() corev1.CoreV1Interface {
+		return fake.NewSimpleClientset().CoreV1()
+	}
+
+	podName := workspaceToResourceName("user1", "", "pod")
+	meta := metav1.ObjectMeta{
+		Namespace:   "jupyter-pods",
+		Labels:      map[string]string{"app": podName, workspaceLabel: workspaceLabelValue},
+		Annotations: map[string]string{userNameAnnotation: "user1"},
+	}
+	pod := &k8sv1.Pod{ObjectMeta: meta, Status: k8sv1.PodStatus{Phase: "Pending"}}
+	pod.Name = podName
+	service := &k8sv1.Service{ObjectMeta: *meta.DeepCopy()}
+	service.Name = workspaceToResourceName("user1", "", "service")
+	// launched before the workspace label was set
+	oldMeta := metav1.ObjectMeta{
+		Name:        workspaceToResourceName("user2", "", "pod"),
+		Namespace:   "jupyter-pods",
+		Labels:      map[string]string{"app": workspaceToResourceName("user2", "", "pod")},
+		Annotations: map[string]string{userNameAnnotation: "user2"},
+	}
+	oldPod := &k8sv1.Pod{ObjectMeta: oldMeta, Status: k8sv1.PodStatus{Phase: "Pending"}}
+	oldPod.Spec.Volumes = []k8sv1.Volume{{
+		Name:         "user-data",
+		VolumeSource: k8sv1.VolumeSource{PersistentVolumeClaim: &k8sv1.PersistentVolumeClaimVolumeSource{ClaimName: userToResourceName("user2", "claim")}},
+	}}
+	otherPod := &k8sv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "jupyter-pods", Labels: map[string]string{"app": "other"}}}
+	clientset := fake.NewSimpleClientset(pod, service, oldPod, otherPod)
+
+	if err := labelExistingWorkspaces(context.Background(), clientset, "jupyter-pods"); err != nil {
+		t.Fatalf("unable to label the existing workspaces: %v", err)
+	}
+	labeled, err := clientset.CoreV1().Pods("jupyter-pods").Get(context.Background(), oldMeta.Name, metav1.GetOptions{})
+	if err != nil || labeled.Labels[workspaceLabel] != workspaceLabelValue || labeled.Labels[userVolumeLabel] != userToResourceName("user2", "pod") {
+		t.Errorf("expected the old pod to be labeled, got '%+v', %v", labeled, err)
+	}
+	other, err := clientset.CoreV1().Pods("jupyter-pods").Get(context.Background(), "other", metav1.GetOptions{})
+	if err != nil || other.Labels[workspaceLabel] != "" {
+		t.Errorf("expected the other pod not to be labeled, got '%+v', %v", other, err)
+	}
+
+	statusCache := newWorkspaceStatusCache(clientset, "jupyter-pods")
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if !statusCache.start(stopCh) {
+		t.Fatal("the cache did not sync")
+	}
+	currentStatusCache.Store(statusCache)
+
+	status, err := podStatus(context.Background(), "user1", "", "", nil)
+	if err != nil || status.Status != "Launching" {
+		t.Errorf("expected the status to be read from the cache, got '%+v', %v", status, err)
+	}
+
+	workspaces, err := listActiveWorkspaces(context.Background())
+	if err != nil {
+		t.Fatalf("unable to list the workspaces: %v", err)
+	}
+	if len(workspaces) != 2 || workspaces[0].UserName != "user1" || workspaces[1].UserName != "user2" {
+		t.Errorf("expected the workspaces of user1 and user2 only, got '%+v'", workspaces)
+	}
+
+	workspaceNames, err := listK8sWorkspaceNames(context.Background(), "user1", &PayModel{Local: true})
+	if err != nil || len(workspaceNames) != 1 || workspaceNames[0] != "" {
+		t.Errorf("expected the default workspace of user1 to be read from the cache, got %v, %v", workspaceNames, err)
+	}
+
+	// status changes are counted and streamed once the cache is watching
+	changes := statusStreams.subscribe("user1")
+	defer statusStreams.unsubscribe("user1", changes)
+	running := testutil.ToFloat64(workspaceStatusChangesTotal.WithLabelValues("Running"))
+	pod = pod.DeepCopy()
+	pod.Status = k8sv1.PodStatus{
+		Phase:      "Running",
+		Conditions: []k8sv1.PodCondition{{Type: "Ready", Status: "True"}},
+	}
+	_, err = clientset.CoreV1().Pods("jupyter-pods").UpdateStatus(context.Background(), pod, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(workspaceStatusChangesTotal.WithLabelValues("Running")) != running+1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the status change to be counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	status, err = podStatus(context.Background(), "user1", "", "", nil)
+	if err != nil || status.Status != "Running" {
+		t.Errorf("expected the cached pod to be updated, got '%+v', %v", status, err)
+	}
+	select {
+	case change := <-changes:
+		if change.Status != "Running" || change.WorkspaceName != "" {
+			t.Errorf("unexpected status change: %+v", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected the status change to be streamed")
+	}
+}
+
+func TestWorkspaceStatusCacheMiss(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_getLocalPodClient := getLocalPodClient
+	defer func() {
+		SetConfig(original_config)
+		getLocalPodClient = original_getLocalPodClient
+		currentStatusCache.Store((*workspaceStatusCache)(nil))
+	}()
+
+	SetConfig(&FullHatcheryConfig{
+		Config: HatcheryConfig{UserNamespace: "jupyter-pods"},
+		Logger: log.New(io.Discard, "", log.LstdFlags),
+	})
+	// the pod was just created: the k8s API has it, the cache does not
+	podName := workspaceToResourceName("user1", "rstudio", "pod")
+	pod := &k8sv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        podName,
+			Namespace:   "jupyter-pods",
+			Labels:      map[string]string{"app": podName, workspaceLabel: workspaceLabelValue},
+			Annotations: map[string]string{userNameAnnotation: "user1", workspaceNameAnnotation: "rstudio"},
+		},
+		Status: k8sv1.PodStatus{Phase: "Pending"},
+	}
+	podClient := fake.NewSimpleClientset(pod).CoreV1()
+	getLocalPodClient = func() corev1.CoreV1Interface {
+		return podClient
+	}
+	statusCache := newWorkspaceStatusCache(fake.NewSimpleClientset(), "jupyter-pods")
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if !statusCache.start(stopCh) {
+		t.Fatal("the cache did not sync")
+	}
+	currentStatusCache.Store(statusCache)
+
+	// pods this replica did not create are only read from the cache
+	status, err := podStatus(context.Background(), "user1", "rstudio", "", nil)
+	if err != nil || status.Status != "Not Found" {
+		t.Errorf("expected the status to be read from the cache, got '%+v', %v", status, err)
+	}
+
+	recentPodLaunches.add(podName)
+	defer recentPodLaunches.remove(podName)
+	status, err = podStatus(context.Background(), "user1", "rstudio", "", nil)
+	if err != nil || status.Status != "Launching" {
+		t.Errorf("expected the status of the launched pod to be read from the k8s API, got '%+v', %v", status, err)
+	}
+	workspaceNames, err := listK8sWorkspaceNames(context.Background(), "user1", &PayModel{Local: true})
+	if err != nil || len(workspaceNames) != 1 || workspaceNames[0] != "rstudio" {
+		t.Errorf("expected the launched workspace to be listed, got %v, %v", workspaceNames, err)
+	}
+
+	// the pod is read from the k8s API for a limited time only
+	recentPodLaunches.mutex.Lock()
+	recentPodLaunches.pods[podName] = time.Now().Add(-statusCacheLaunchGracePeriod)
+	recentPodLaunches.mutex.Unlock()
+	workspaceNames, err = listK8sWorkspaceNames(context.Background(), "user1", &PayModel{Local: true})
+	if err != nil || len(workspaceNames) != 0 {
+		t.Errorf("expected the workspaces to be read from the cache after the grace period, got %v, %v", workspaceNames, err)
+	}
+}
+
+func TestStopWorkspaceStatusCache(t *testing.T) {
+	defer SetupAndTeardownTest()()
+	defer currentStatusCache.Store((*workspaceStatusCache)(nil))
+
+	statusCache := newWorkspaceStatusCache(fake.NewSimpleClientset(), "jupyter-pods")
+	stopCh := make(chan struct{})
+	statusCacheMutex.Lock()
+	statusCacheStop = stopCh
+	statusCacheMutex.Unlock()
+	if !statusCache.start(stopCh) {
+		t.Fatal("the cache did not sync")
+	}
+	currentStatusCache.Store(statusCache)
+
+	stopWorkspaceStatusCache()
+	select {
+	case <-stopCh:
+	default:
+		t.Error("expected the informers to be stopped")
+	}
+	if getStatusCache() != nil {
+		t.Error("expected the statuses to be read from the k8s API once the cache is stopped")
+	}
+	// the shutdown hooks can run more than once
+	stopWorkspaceStatusCache()
+}
+
+func TestStatusStream(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_statusStreams := statusStreams
+	defer func() {
+		statusStreams = original_statusStreams
+		currentStatusCache.Store((*workspaceStatusCache)(nil))
+	}()
+	statusStreams = newStatusStreamBroker()
+
+	server := httptest.NewServer(http.HandlerFunc(statusStream))
+	defer server.Close()
+	get := func() *http.Response {
+		req, err := http.NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("REMOTE_USER", "user1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// the statuses are not watched yet
+	resp := get()
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected the stream to be unavailable, got %d", resp.StatusCode)
+	}
+
+	currentStatusCache.Store(newWorkspaceStatusCache(fake.NewSimpleClientset(), "jupyter-pods"))
+	resp = get()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	// the handler subscribes before it sends the headers
+	statusStreams.publish("user2", WorkspaceStatusChange{WorkspaceName: "other", Status: "Running"})
+	statusStreams.publish("user1", WorkspaceStatusChange{WorkspaceName: "rstudio", Status: "Running"})
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unable to read the stream: %v", err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	if lines[0] != "event: status" || !strings.Contains(lines[1], `"workspaceName":"rstudio"`) || !strings.Contains(lines[1], `"status":"Running"`) {
+		t.Errorf("unexpected event: %v", lines)
+	}
+
+	// the stream ends when hatchery shuts down
+	statusStreams.close()
+	if _, err := ioutil.ReadAll(reader); err != nil {
+		t.Errorf("expected the stream to end, got %v", err)
+	}
+}
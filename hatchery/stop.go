@@ -0,0 +1,273 @@
+package hatchery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	k8sv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Status of a workspace that was stopped and can be resumed. Not to be
+// confused with "Stopped", which is a workspace in a failed state.
+const workspaceStoppedStatus = "Stopped (resumable)"
+
+// `app` label of the config maps that record the stopped workspaces
+const stoppedWorkspaceApp = "hatchery-stopped"
+
+// StoppedWorkspace records what is needed to resume a stopped workspace.
+// The user volume (`claim-<user>` PVC or EFS access point) is kept while the
+// workspace is stopped.
+type StoppedWorkspace struct {
+	UserName      string `json:"user"`
+	WorkspaceName string `json:"workspace,omitempty"`
+	ContainerID   string `json:"container_id"`
+	PayModelID    string `json:"pay_model_id,omitempty"`
+	// resource profile the workspace was launched with, if any
+	ResourceProfile string    `json:"resource_profile,omitempty"`
+	StoppedAt       time.Time `json:"stopped_at"`
+}
+
+var errWorkspaceNotRunning = errors.New("Workspace is not running")
+
+// Stopped workspaces are recorded in config maps in the local cluster, for
+// all backends, since the compute they ran on is gone
+var saveStoppedWorkspace = func(ctx context.Context, stopped StoppedWorkspace) error {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	data, err := json.Marshal(stopped)
+	if err != nil {
+		return err
+	}
+	configMap := &k8sv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        workspaceToResourceName(stopped.UserName, stopped.WorkspaceName, "stopped"),
+			Namespace:   getConfig().Config.UserNamespace,
+			Labels:      map[string]string{"app": stoppedWorkspaceApp},
+			Annotations: workspaceAnnotations(stopped.UserName, stopped.WorkspaceName, stopped.ContainerID),
+		},
+		Data: map[string]string{"workspace.json": string(data)},
+	}
+	_, err = podClient.ConfigMaps(getConfig().Config.UserNamespace).Create(ctx, configMap, metav1.CreateOptions{})
+	if k8serrors.IsAlreadyExists(err) {
+		_, err = podClient.ConfigMaps(getConfig().Config.UserNamespace).Update(ctx, configMap, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// getStoppedWorkspace returns the stopped workspace, or nil if the
+// workspace is not stopped
+var getStoppedWorkspace = func(ctx context.Context, userName string, workspaceName string) (*StoppedWorkspace, error) {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return nil, fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	configMap, err := podClient.ConfigMaps(getConfig().Config.UserNamespace).Get(ctx, workspaceToResourceName(userName, workspaceName, "stopped"), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stopped := &StoppedWorkspace{}
+	err = json.Unmarshal([]byte(configMap.Data["workspace.json"]), stopped)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse stopped workspace '%s' of user %s: %v", workspaceName, userName, err)
+	}
+	return stopped, nil
+}
+
+var deleteStoppedWorkspace = func(ctx context.Context, userName string, workspaceName string) error {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	err := podClient.ConfigMaps(getConfig().Config.UserNamespace).Delete(ctx, workspaceToResourceName(userName, workspaceName, "stopped"), metav1.DeleteOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// listStoppedWorkspaceNames returns the names of the user's stopped
+// workspaces, sorted
+var listStoppedWorkspaceNames = func(ctx context.Context, userName string) ([]string, error) {
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return nil, fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	configMaps, err := podClient.ConfigMaps(getConfig().Config.UserNamespace).List(ctx, metav1.ListOptions{LabelSelector: "app=" + stoppedWorkspaceApp})
+	if err != nil {
+		return nil, err
+	}
+	workspaceNames := []string{}
+	for _, configMap := range configMaps.Items {
+		if configMap.Annotations[userNameAnnotation] == userName {
+			workspaceNames = append(workspaceNames, configMap.Annotations[workspaceNameAnnotation])
+		}
+	}
+	sort.Strings(workspaceNames)
+	return workspaceNames, nil
+}
+
+// setStoppedStatus reports a workspace that is not running as resumable if
+// it was stopped
+func setStoppedStatus(ctx context.Context, status *WorkspaceStatus, userName string, workspaceName string) {
+	stopped, err := getStoppedWorkspace(ctx, userName, workspaceName)
+	if err != nil {
+		getConfig().Logger.Printf("Unable to check if workspace '%s' of user %s is stopped: %v", workspaceName, userName, err)
+		return
+	}
+	if stopped != nil {
+		status.Status = workspaceStoppedStatus
+	}
+}
+
+// stopWorkspace deletes the workspace's compute but keeps the user volume,
+// and records the container it was launched with so it can be resumed.
+// Like `terminateWorkspace`, it releases the workspace's licenses. The
+// Nextflow resources and the current pay model are kept for the resumed
+// workspace.
+var stopWorkspace = func(ctx context.Context, userName string, workspaceName string, accessToken string) (err error) {
+	getConfig().Logger.Printf("Stopping workspace '%s' for user %s", workspaceName, userName)
+
+	// like `terminateWorkspace`, use the pay model the workspace was
+	// launched with, even if the user switched pay models since
+	payModel, err := getWorkspacePayModel(userName, getWorkspacePayModelID(ctx, userName, workspaceName))
+	if err != nil {
+		getConfig().Logger.Printf(err.Error())
+	}
+	containerID := getWorkspaceContainerID(ctx, userName, workspaceName)
+	profileName := getWorkspaceResourceProfile(ctx, userName, workspaceName)
+
+	defer func() {
+		record := newAuditRecord(ctx, auditStop, userName, workspaceName, containerID)
+		if payModel != nil {
+			record.PayModelID = payModel.Id
+		}
+		record.setOutcome(err)
+		recordAudit(record)
+	}()
+	if _, backend := getWorkspaceBackend(payModel); workspaceName != "" && !backend.SupportsNamedWorkspaces() {
+		return errNamedWorkspaceOnEcs
+	}
+	if containerID == "" {
+		return errWorkspaceNotRunning
+	}
+
+	stopped := StoppedWorkspace{
+		UserName:        userName,
+		WorkspaceName:   workspaceName,
+		ContainerID:     containerID,
+		ResourceProfile: profileName,
+		StoppedAt:       time.Now().UTC(),
+	}
+	if payModel != nil {
+		stopped.PayModelID = payModel.Id
+	}
+	// record the workspace first, so it is not lost if hatchery stops
+	// while the compute is being deleted
+	err = saveStoppedWorkspace(ctx, stopped)
+	if err != nil {
+		return fmt.Errorf("unable to record the stopped workspace: %v", err)
+	}
+
+	releaseWorkspaceLicenses(userName, workspaceName)
+
+	_, err = deleteWorkspaceCompute(ctx, userName, workspaceName, accessToken, payModel)
+	if err != nil {
+		if derr := deleteStoppedWorkspace(ctx, userName, workspaceName); derr != nil {
+			getConfig().Logger.Printf("Unable to delete the record of stopped workspace '%s' of user %s: %v", workspaceName, userName, derr)
+		}
+		return err
+	}
+	releaseSlot(ctx, containerID, userName, workspaceName)
+	releaseWorkspaceReservation(ctx, userName, workspaceName)
+	getConfig().Logger.Printf("Stopped workspace '%s' for user %s", workspaceName, userName)
+	return nil
+}
+
+// `/stop?workspace=abc` => stop the specified workspace, keeping the user
+// volume so it can be resumed at `/resume`
+func stop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userName := getCurrentUserName(r)
+	if userName == "" {
+		http.Error(w, "No username found. Unable to stop", http.StatusBadRequest)
+		return
+	}
+	workspaceName, err := getWorkspaceName(r, userName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = stopWorkspace(r.Context(), userName, workspaceName, getBearerToken(r))
+	if err != nil {
+		if errors.Is(err, errNamedWorkspaceOnEcs) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else if errors.Is(err, errWorkspaceNotRunning) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	fmt.Fprint(w, "Stopped workspace")
+}
+
+// `/resume?workspace=abc` => launch the stopped workspace again, with the
+// same container, resource profile and user volume
+// `/resume?workspace=abc&profile=large` => resume it with another resource
+// profile
+func resume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userName := getCurrentUserName(r)
+	if userName == "" {
+		http.Error(w, "No username found. Resume forbidden", http.StatusBadRequest)
+		return
+	}
+	workspaceName, err := getWorkspaceName(r, userName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stopped, err := getStoppedWorkspace(r.Context(), userName, workspaceName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if stopped == nil {
+		http.Error(w, "No stopped workspace to resume", http.StatusNotFound)
+		return
+	}
+	// the whole resume reads the same configuration, even if it is
+	// reloaded in the meantime
+	config := getConfig()
+	r = r.WithContext(withConfig(r.Context(), config))
+	hash := resolveContainerID(stopped.ContainerID)
+	if _, ok := config.ContainersMap[hash]; !ok {
+		http.Error(w, "The container of this workspace is no longer available. Terminate the workspace and launch a new one", http.StatusConflict)
+		return
+	}
+	profileName := r.URL.Query().Get("profile")
+	if profileName == "" {
+		profileName = stopped.ResourceProfile
+	}
+	launchWorkspace(w, r, userName, workspaceName, hash, profileName, stopped, false)
+}
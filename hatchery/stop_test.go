@@ -0,0 +1,355 @@
+package hatchery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+)
+
+func TestStopWorkspace(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_getCurrentPayModel := getCurrentPayModel
+	original_getPayModelsForUser := getPayModelsForUser
+	original_getWorkspacePayModelID := getWorkspacePayModelID
+	original_getWorkspaceContainerID := getWorkspaceContainerID
+	original_getWorkspaceResourceProfile := getWorkspaceResourceProfile
+	original_getLicenseUserMapsForUser := getLicenseUserMapsForUser
+	original_saveStoppedWorkspace := saveStoppedWorkspace
+	original_deleteStoppedWorkspace := deleteStoppedWorkspace
+	original_deleteK8sPod := deleteK8sPod
+	defer func() {
+		SetConfig(original_config)
+		getCurrentPayModel = original_getCurrentPayModel
+		getPayModelsForUser = original_getPayModelsForUser
+		getWorkspacePayModelID = original_getWorkspacePayModelID
+		getWorkspaceContainerID = original_getWorkspaceContainerID
+		getWorkspaceResourceProfile = original_getWorkspaceResourceProfile
+		getLicenseUserMapsForUser = original_getLicenseUserMapsForUser
+		saveStoppedWorkspace = original_saveStoppedWorkspace
+		deleteStoppedWorkspace = original_deleteStoppedWorkspace
+		deleteK8sPod = original_deleteK8sPod
+	}()
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags)})
+	getWorkspaceResourceProfile = func(context.Context, string, string) string {
+		return "large"
+	}
+
+	testCases := []struct {
+		name             string
+		workspaceName    string
+		payModel         *PayModel
+		launchPayModel   *PayModel
+		containerID      string
+		deletePodError   error
+		wantError        error
+		wantSaved        bool
+		wantRecordExists bool
+	}{
+		{
+			name:             "Running",
+			workspaceName:    "rstudio",
+			payModel:         &PayModel{Id: "pay-model-id", Local: true},
+			containerID:      "hash",
+			wantSaved:        true,
+			wantRecordExists: true,
+		},
+		{
+			name:             "SwitchedPayModel",
+			workspaceName:    "rstudio",
+			payModel:         &PayModel{Id: "pay-model-id", Local: true},
+			launchPayModel:   &PayModel{Id: "launch-pay-model-id", Local: true},
+			containerID:      "hash",
+			wantSaved:        true,
+			wantRecordExists: true,
+		},
+		{
+			name:          "NotRunning",
+			workspaceName: "rstudio",
+			wantError:     errWorkspaceNotRunning,
+		},
+		{
+			name:          "NamedWorkspaceOnEcs",
+			workspaceName: "rstudio",
+			payModel:      &PayModel{Id: "pay-model-id", Ecs: true},
+			containerID:   "hash",
+			wantError:     errNamedWorkspaceOnEcs,
+		},
+		{
+			name:           "UnableToDeletePod",
+			containerID:    "hash",
+			deletePodError: errors.New("unable to delete pod"),
+			wantSaved:      true,
+		},
+	}
+
+	for _, testcase := range testCases {
+		var saved *StoppedWorkspace
+		recordExists := false
+		getCurrentPayModel = func(string) (*PayModel, error) {
+			return testcase.payModel, nil
+		}
+		wantPayModel := testcase.payModel
+		launchPayModelID := ""
+		if testcase.launchPayModel != nil {
+			wantPayModel = testcase.launchPayModel
+			launchPayModelID = testcase.launchPayModel.Id
+		}
+		getWorkspacePayModelID = func(context.Context, string, string) string {
+			return launchPayModelID
+		}
+		getPayModelsForUser = func(string) (*AllPayModels, error) {
+			allPayModels := &AllPayModels{CurrentPayModel: testcase.payModel}
+			if testcase.launchPayModel != nil {
+				allPayModels.PayModels = []PayModel{*testcase.launchPayModel}
+			}
+			return allPayModels, nil
+		}
+		getWorkspaceContainerID = func(context.Context, string, string) string {
+			return testcase.containerID
+		}
+		getLicenseUserMapsForUser = func(*DbConfig, string) ([]Gen3LicenseUserMap, error) {
+			return []Gen3LicenseUserMap{}, nil
+		}
+		saveStoppedWorkspace = func(ctx context.Context, stopped StoppedWorkspace) error {
+			saved = &stopped
+			recordExists = true
+			return nil
+		}
+		deleteStoppedWorkspace = func(context.Context, string, string) error {
+			recordExists = false
+			return nil
+		}
+		deleteK8sPod = func(ctx context.Context, userName string, workspaceName string, accessToken string, payModel *PayModel) error {
+			return testcase.deletePodError
+		}
+
+		err := stopWorkspace(context.Background(), "testUser", testcase.workspaceName, "")
+		if testcase.wantError != nil && !errors.Is(err, testcase.wantError) {
+			t.Errorf("expected error '%v' when %s, got '%v'", testcase.wantError, testcase.name, err)
+		}
+		if testcase.wantError == nil && testcase.deletePodError == nil && err != nil {
+			t.Errorf("unexpected error when %s: %v", testcase.name, err)
+		}
+		if testcase.wantSaved != (saved != nil) {
+			t.Errorf("unexpected stopped workspace record when %s: %+v", testcase.name, saved)
+		}
+		if testcase.wantRecordExists != recordExists {
+			t.Errorf("the stopped workspace record should exist: %v, but it does: %v, when %s", testcase.wantRecordExists, recordExists, testcase.name)
+		}
+		if saved != nil && testcase.payModel != nil && (saved.ContainerID != testcase.containerID || saved.ResourceProfile != "large" || saved.PayModelID != wantPayModel.Id || saved.WorkspaceName != testcase.workspaceName) {
+			t.Errorf("unexpected stopped workspace record when %s: %+v", testcase.name, saved)
+		}
+	}
+}
+
+func TestStopEndpoint(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_stopWorkspace := stopWorkspace
+	defer func() {
+		SetConfig(original_config)
+		stopWorkspace = original_stopWorkspace
+	}()
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags)})
+
+	testCases := []struct {
+		name       string
+		method     string
+		username   string
+		stopError  error
+		wantStatus int
+	}{
+		{name: "MethodIsNotPost", method: "GET", username: "testUser", wantStatus: http.StatusMethodNotAllowed},
+		{name: "MissingUsername", method: "POST", wantStatus: http.StatusBadRequest},
+		{name: "Stopped", method: "POST", username: "testUser", wantStatus: http.StatusOK},
+		{name: "NotRunning", method: "POST", username: "testUser", stopError: errWorkspaceNotRunning, wantStatus: http.StatusNotFound},
+		{name: "NamedWorkspaceOnEcs", method: "POST", username: "testUser", stopError: errNamedWorkspaceOnEcs, wantStatus: http.StatusBadRequest},
+		{name: "UnableToStop", method: "POST", username: "testUser", stopError: errors.New("unable to delete pod"), wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, testcase := range testCases {
+		stopWorkspace = func(context.Context, string, string, string) error {
+			return testcase.stopError
+		}
+		req, err := http.NewRequest(testcase.method, "/stop?workspace=rstudio", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if testcase.username != "" {
+			req.Header.Set("REMOTE_USER", testcase.username)
+		}
+		w := httptest.NewRecorder()
+		http.HandlerFunc(stop).ServeHTTP(w, req)
+
+		if w.Code != testcase.wantStatus {
+			t.Errorf("handler returned wrong status code when %s:\ngot: '%v'\nwant: '%v'", testcase.name, w.Code, testcase.wantStatus)
+		}
+	}
+}
+
+func TestResumeEndpoint(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_getStoppedWorkspace := getStoppedWorkspace
+	original_deleteStoppedWorkspace := deleteStoppedWorkspace
+	original_isUserAuthorizedForContainer := isUserAuthorizedForContainer
+	original_getPayModelsForUser := getPayModelsForUser
+	original_listK8sWorkspaceNames := listK8sWorkspaceNames
+	original_reserveWorkspace := reserveWorkspace
+	original_createLocalK8sPod := createLocalK8sPod
+	defer func() {
+		SetConfig(original_config)
+		getStoppedWorkspace = original_getStoppedWorkspace
+		deleteStoppedWorkspace = original_deleteStoppedWorkspace
+		isUserAuthorizedForContainer = original_isUserAuthorizedForContainer
+		getPayModelsForUser = original_getPayModelsForUser
+		listK8sWorkspaceNames = original_listK8sWorkspaceNames
+		reserveWorkspace = original_reserveWorkspace
+		createLocalK8sPod = original_createLocalK8sPod
+	}()
+	reserveWorkspace = func(context.Context, string, string, []string) error {
+		return nil
+	}
+
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags)})
+	getConfig().ContainersMap = map[string]Container{
+		"hash": {Name: "Hatchery test container"},
+	}
+	getConfig().Config.MaxWorkspacesPerUser = 2
+	isUserAuthorizedForContainer = func(string, string, Container) (bool, error) {
+		return true, nil
+	}
+	getPayModelsForUser = func(string) (*AllPayModels, error) {
+		return &AllPayModels{CurrentPayModel: &PayModel{Id: "local", Local: true}}, nil
+	}
+	listK8sWorkspaceNames = func(context.Context, string, *PayModel) ([]string, error) {
+		return []string{}, nil
+	}
+
+	testCases := []struct {
+		name        string
+		stopped     *StoppedWorkspace
+		launchErr   error
+		wantStatus  int
+		wantResumed bool
+	}{
+		{
+			name:       "NotStopped",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "ContainerNoLongerAvailable",
+			stopped:    &StoppedWorkspace{UserName: "testUser", WorkspaceName: "rstudio", ContainerID: "removed-hash", PayModelID: "local"},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "OtherPayModel",
+			stopped:    &StoppedWorkspace{UserName: "testUser", WorkspaceName: "rstudio", ContainerID: "hash", PayModelID: "other"},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:        "Resumed",
+			stopped:     &StoppedWorkspace{UserName: "testUser", WorkspaceName: "rstudio", ContainerID: "hash", PayModelID: "local"},
+			wantStatus:  http.StatusOK,
+			wantResumed: true,
+		},
+		{
+			name:       "LaunchFailed",
+			stopped:    &StoppedWorkspace{UserName: "testUser", WorkspaceName: "rstudio", ContainerID: "hash", PayModelID: "local"},
+			launchErr:  errors.New("unable to create the pod"),
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, testcase := range testCases {
+		recordDeleted := false
+		launchedHash := ""
+		getStoppedWorkspace = func(context.Context, string, string) (*StoppedWorkspace, error) {
+			return testcase.stopped, nil
+		}
+		deleteStoppedWorkspace = func(context.Context, string, string) error {
+			recordDeleted = true
+			return nil
+		}
+		createLocalK8sPod = func(ctx context.Context, hash, userName, workspaceName, accessToken string, envVars []k8sv1.EnvVar) error {
+			launchedHash = hash
+			return testcase.launchErr
+		}
+
+		req, err := http.NewRequest("POST", "/resume?workspace=rstudio", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("REMOTE_USER", "testUser")
+		w := httptest.NewRecorder()
+		http.HandlerFunc(resume).ServeHTTP(w, req)
+
+		if w.Code != testcase.wantStatus {
+			t.Errorf("handler returned wrong status code when %s:\ngot: '%v'\nwant: '%v'", testcase.name, w.Code, testcase.wantStatus)
+			continue
+		}
+		if w.Code == http.StatusOK {
+			// the launch runs in the background: wait for the operation to finish
+			var returnedOp Operation
+			if err := json.Unmarshal(w.Body.Bytes(), &returnedOp); err != nil {
+				t.Fatalf("handler did not return an operation: '%v'", w.Body.String())
+			}
+			op, ok := operations.get(returnedOp.ID)
+			if !ok {
+				t.Fatalf("operation '%s' was not registered", returnedOp.ID)
+			}
+			op.wait()
+			if launchedHash != testcase.stopped.ContainerID {
+				t.Errorf("resumed the wrong container: got '%s', want '%s'", launchedHash, testcase.stopped.ContainerID)
+			}
+		}
+		// the record is only deleted once the workspace is running again
+		if testcase.wantResumed != recordDeleted {
+			t.Errorf("the stopped workspace record should be deleted: %v, but it was: %v, when %s", testcase.wantResumed, recordDeleted, testcase.name)
+		}
+	}
+}
+
+func TestSetStoppedStatus(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	original_config := getConfig()
+	original_getStoppedWorkspace := getStoppedWorkspace
+	defer func() {
+		SetConfig(original_config)
+		getStoppedWorkspace = original_getStoppedWorkspace
+	}()
+	SetConfig(&FullHatcheryConfig{Logger: log.New(io.Discard, "", log.LstdFlags)})
+
+	testCases := []struct {
+		name       string
+		stopped    *StoppedWorkspace
+		err        error
+		wantStatus string
+	}{
+		{name: "Stopped", stopped: &StoppedWorkspace{ContainerID: "hash"}, wantStatus: workspaceStoppedStatus},
+		{name: "NotStopped", wantStatus: "Not Found"},
+		{name: "UnableToCheck", err: errors.New("unable to get config map"), wantStatus: "Not Found"},
+	}
+	for _, testcase := range testCases {
+		getStoppedWorkspace = func(context.Context, string, string) (*StoppedWorkspace, error) {
+			return testcase.stopped, testcase.err
+		}
+		status := &WorkspaceStatus{Status: "Not Found"}
+		setStoppedStatus(context.Background(), status, "testUser", "rstudio")
+		if status.Status != testcase.wantStatus {
+			t.Errorf("unexpected status when %s: got '%s', want '%s'", testcase.name, status.Status, testcase.wantStatus)
+		}
+	}
+}
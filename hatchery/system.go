@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/uc-cdis/hatchery/hatchery/version"
 	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
@@ -12,6 +13,9 @@ import (
 type versionSummary struct {
 	Commit  string `json:"commit"`
 	Version string `json:"version"`
+	// the active configuration, which changes when it is reloaded
+	ConfigVersion  string     `json:"config_version,omitempty"`
+	ConfigLoadedAt *time.Time `json:"config_loaded_at,omitempty"`
 }
 
 func RegisterSystem(mux *httptrace.ServeMux) {
@@ -25,6 +29,10 @@ func systemStatus(w http.ResponseWriter, r *http.Request) {
 
 func systemVersion(w http.ResponseWriter, r *http.Request) {
 	ver := versionSummary{Commit: version.GitCommit, Version: version.GitVersion}
+	if config := getConfig(); config != nil && config.Version != "" {
+		ver.ConfigVersion = config.Version
+		ver.ConfigLoadedAt = &config.LoadedAt
+	}
 	out, err := json.Marshal(ver)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -13,11 +13,11 @@ func SetupAndTeardownTest() func() {
 	*/
 
 	/* setup */
-	if Config == nil {
-		Config = &FullHatcheryConfig{
+	if getConfig() == nil {
+		SetConfig(&FullHatcheryConfig{
 			// Logger: log.New(os.Stdout, "", log.LstdFlags), // Print all logs (for dev purposes)
 			Logger: log.New(io.Discard, "", log.LstdFlags), // Discard all logs
-		}
+		})
 	}
 
 	return func() {
@@ -25,7 +25,7 @@ func setupTransitGateway(userName string) error {
 		return err
 	}
 
-	Config.Logger.Printf("Setting up transit gateway in main account")
+	getConfig().Logger.Printf("Setting up transit gateway in main account")
 	tgwid, tgwarn, tgwRouteTableId, err := createTransitGateway(sess, userName)
 	if err != nil {
 		return fmt.Errorf("error creating transit gateway: %s", err.Error())
@@ -50,7 +50,7 @@ func setupTransitGateway(userName string) error {
 		return err
 	}
 
-	Config.Logger.Printf("Setting up remote account ")
+	getConfig().Logger.Printf("Setting up remote account ")
 	err = setupRemoteAccount(userName, false)
 	if err != nil {
 		return fmt.Errorf("failed to setup remote account: %s", err.Error())
@@ -69,18 +69,18 @@ func createLocalTransitGatewayAttachment(userName string, tgwid string, tgwRoute
 	// ec2 session to main AWS account.
 	ec2Local := ec2.New(sess)
 	// Create Transit Gateway Attachment in local VPC
-	// Config.Logger.Printf("Creating tgw attachment in local VPC: %s", vpcid)
+	// getConfig().Logger.Printf("Creating tgw attachment in local VPC: %s", vpcid)
 	tgwAttachment, err := createTransitGatewayAttachments(ec2Local, vpcid, tgwid, true, nil, userName)
 	if err != nil {
 		return err
 	}
-	Config.Logger.Printf("Attachment created: %s", *tgwAttachment)
+	getConfig().Logger.Printf("Attachment created: %s", *tgwAttachment)
 
 	// Create Transit Gateway Route Table
 	err = TGWRoutes(userName, tgwRouteTableId, tgwAttachment, ec2Local, true, false, nil)
 	if err != nil {
 		// Log error
-		Config.Logger.Printf("Failed to create TGW route table: %s", err.Error())
+		getConfig().Logger.Printf("Failed to create TGW route table: %s", err.Error())
 		return err
 	}
 
@@ -88,7 +88,7 @@ func createLocalTransitGatewayAttachment(userName string, tgwid string, tgwRoute
 }
 
 func teardownTransitGateway(userName string) error {
-	Config.Logger.Printf("Terminating remote transit gateway attachment for user %s\n", userName)
+	getConfig().Logger.Printf("Terminating remote transit gateway attachment for user %s\n", userName)
 	err := setupRemoteAccount(userName, true)
 	if err != nil {
 		return err
@@ -191,7 +191,7 @@ func createTransitGateway(sess *session.Session, userName string) (tgwid *string
 
 	// Create Transit Gateway if it doesn't exist
 	if len(exTg.TransitGateways) == 0 {
-		Config.Logger.Printf("No transit gateway found. Creating one...")
+		getConfig().Logger.Printf("No transit gateway found. Creating one...")
 		tgwName := strings.ReplaceAll(os.Getenv("GEN3_ENDPOINT"), ".", "-") + "-tgw"
 		tg, err := ec2Local.CreateTransitGateway(&ec2.CreateTransitGatewayInput{
 			DryRun:      aws.Bool(false),
@@ -219,17 +219,17 @@ func createTransitGateway(sess *session.Session, userName string) (tgwid *string
 		if err != nil {
 			return nil, nil, nil, err
 		}
-		Config.Logger.Printf("Transit gateway created: %s", *tg.TransitGateway.TransitGatewayId)
+		getConfig().Logger.Printf("Transit gateway created: %s", *tg.TransitGateway.TransitGatewayId)
 
 		return tg.TransitGateway.TransitGatewayId, tg.TransitGateway.TransitGatewayArn, tg.TransitGateway.Options.AssociationDefaultRouteTableId, nil
 	} else {
-		Config.Logger.Print("Existing transit gateway found. Skipping creation...")
+		getConfig().Logger.Print("Existing transit gateway found. Skipping creation...")
 		return exTg.TransitGateways[len(exTg.TransitGateways)-1].TransitGatewayId, exTg.TransitGateways[len(exTg.TransitGateways)-1].TransitGatewayArn, exTg.TransitGateways[len(exTg.TransitGateways)-1].Options.AssociationDefaultRouteTableId, nil
 	}
 }
 
 func createTransitGatewayAttachments(svc *ec2.EC2, vpcid string, tgwid string, local bool, sess *CREDS, userName string) (*string, error) {
-	Config.Logger.Printf("Creating transit gateway attachment for VPC: %s", vpcid)
+	getConfig().Logger.Printf("Creating transit gateway attachment for VPC: %s", vpcid)
 	// Check for existing transit gateway
 	tgInput := &ec2.DescribeTransitGatewaysInput{
 		TransitGatewayIds: []*string{aws.String(tgwid)},
@@ -256,7 +256,7 @@ func createTransitGatewayAttachments(svc *ec2.EC2, vpcid string, tgwid string, l
 			switch aerr.Code() {
 			case "InvalidTransitGatewayID.NotFound":
 				// Sleep for the retry interval before trying again
-				Config.Logger.Printf("TransitGateway not found, retrying in %s", retryInterval.String())
+				getConfig().Logger.Printf("TransitGateway not found, retrying in %s", retryInterval.String())
 				time.Sleep(retryInterval)
 			default:
 				return nil, fmt.Errorf("cannot DescribeTransitGateways: %s", err.Error())
@@ -273,7 +273,7 @@ func createTransitGatewayAttachments(svc *ec2.EC2, vpcid string, tgwid string, l
 	}
 
 	for *exTg.TransitGateways[0].State != "available" {
-		Config.Logger.Printf("TransitGateway is in state: %s ...  Waiting for 10 seconds", *exTg.TransitGateways[0].State)
+		getConfig().Logger.Printf("TransitGateway is in state: %s ...  Waiting for 10 seconds", *exTg.TransitGateways[0].State)
 		// sleep for 10 sec
 		time.Sleep(10 * time.Second)
 		exTg, _ = svc.DescribeTransitGateways(tgInput)
@@ -319,7 +319,7 @@ func createTransitGatewayAttachments(svc *ec2.EC2, vpcid string, tgwid string, l
 	}
 	if len(exTgwAttachment.TransitGatewayAttachments) == 0 {
 		// Create the transit gateway attachment
-		Config.Logger.Printf("Local transitgateway attachment not found, creating new one")
+		getConfig().Logger.Printf("Local transitgateway attachment not found, creating new one")
 		tgwAttachmentInput := &ec2.CreateTransitGatewayVpcAttachmentInput{
 			TransitGatewayId: exTg.TransitGateways[0].TransitGatewayId,
 			VpcId:            networkInfo.vpc.Vpcs[len(networkInfo.vpc.Vpcs)-1].VpcId,
@@ -346,10 +346,10 @@ func createTransitGatewayAttachments(svc *ec2.EC2, vpcid string, tgwid string, l
 		if err != nil {
 			return nil, fmt.Errorf("cannot create transitgatewayattachment: %s", err.Error())
 		}
-		Config.Logger.Printf("Created transitgatewayattachment: %s", *tgwAttachment.TransitGatewayVpcAttachment.TransitGatewayAttachmentId)
+		getConfig().Logger.Printf("Created transitgatewayattachment: %s", *tgwAttachment.TransitGatewayVpcAttachment.TransitGatewayAttachmentId)
 		return tgwAttachment.TransitGatewayVpcAttachment.TransitGatewayAttachmentId, nil
 	} else {
-		Config.Logger.Printf("Local transitgateway attachment found, using existing one")
+		getConfig().Logger.Printf("Local transitgateway attachment found, using existing one")
 		return exTgwAttachment.TransitGatewayAttachments[0].TransitGatewayAttachmentId, nil
 	}
 }
@@ -382,7 +382,7 @@ func deleteTransitGatewayAttachment(svc *ec2.EC2, tgwid string, userName string)
 	}
 	exTgwAttachment, err := svc.DescribeTransitGatewayAttachments(exTgwAttachmentInput)
 	if err != nil {
-		Config.Logger.Printf("Error: %s", err.Error())
+		getConfig().Logger.Printf("Error: %s", err.Error())
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
 			case "InvalidTransitGatewayID.NotFound":
@@ -390,13 +390,13 @@ func deleteTransitGatewayAttachment(svc *ec2.EC2, tgwid string, userName string)
 				return nil, nil
 			}
 		} else {
-			Config.Logger.Printf("Error: %s", err.Error())
+			getConfig().Logger.Printf("Error: %s", err.Error())
 			return nil, err
 		}
 	}
 	if len(exTgwAttachment.TransitGatewayAttachments) == 0 {
 		// No transit gateway attachment found, we are happy :)
-		Config.Logger.Printf("No TGW attachment found, we are happy :)")
+		getConfig().Logger.Printf("No TGW attachment found, we are happy :)")
 		return nil, nil
 	}
 
@@ -447,7 +447,7 @@ func setupRemoteAccount(userName string, teardown bool) error {
 		return err
 	}
 	for len(exTg.TransitGateways) == 0 {
-		Config.Logger.Printf("Waiting to find ex_tgw")
+		getConfig().Logger.Printf("Waiting to find ex_tgw")
 		// err := svc.acceptTGWShare()
 		// if err != nil {
 		// 	return err
@@ -479,7 +479,7 @@ func setupRemoteAccount(userName string, teardown bool) error {
 		if err != nil {
 			return fmt.Errorf("Cannot create remote TransitGatewayAttachment: %s", err.Error())
 		}
-		Config.Logger.Printf("tgw_attachment: %s", *tgw_attachment)
+		getConfig().Logger.Printf("tgw_attachment: %s", *tgw_attachment)
 	}
 
 	// setup Transit Gateway Route Table
@@ -521,7 +521,7 @@ func TGWRoutes(userName string, tgwRoutetableId *string, tgwAttachmentId *string
 	if teardown {
 		//  delete routes to all VPC CIDR blocks
 		for _, cidr := range networkInfo.vpcCidrBlocks {
-			Config.Logger.Printf("Deleting route to %s in transit gateway route table %s", *cidr, *tgwRoutetableId)
+			getConfig().Logger.Printf("Deleting route to %s in transit gateway route table %s", *cidr, *tgwRoutetableId)
 			err := deleteTGWRoute(tgwRoutetableId, cidr, svc)
 			if err != nil {
 				return err
@@ -531,7 +531,7 @@ func TGWRoutes(userName string, tgwRoutetableId *string, tgwAttachmentId *string
 		return nil
 	} else {
 		for *tgwAttachment.TransitGatewayAttachments[0].State != "available" {
-			Config.Logger.Printf("Transit Gateway Attachment is not ready. State is: %s", *tgwAttachment.TransitGatewayAttachments[0].State)
+			getConfig().Logger.Printf("Transit Gateway Attachment is not ready. State is: %s", *tgwAttachment.TransitGatewayAttachments[0].State)
 			tgwAttachment, err = svc.DescribeTransitGatewayAttachments(tgwAttachmentInput)
 			if err != nil {
 				return err
@@ -541,7 +541,7 @@ func TGWRoutes(userName string, tgwRoutetableId *string, tgwAttachmentId *string
 
 		//  create routes to all VPC CIDR blocks
 		for _, cidr := range networkInfo.vpcCidrBlocks {
-			Config.Logger.Printf("Creating route to %s in transit gateway route table %s", *cidr, *tgwRoutetableId)
+			getConfig().Logger.Printf("Creating route to %s in transit gateway route table %s", *cidr, *tgwRoutetableId)
 			err := createTGWRoute(tgwRoutetableId, cidr, tgwAttachment.TransitGatewayAttachments[0].TransitGatewayAttachmentId, local, svc)
 			if err != nil {
 				return err
@@ -553,7 +553,7 @@ func TGWRoutes(userName string, tgwRoutetableId *string, tgwAttachmentId *string
 }
 
 func deleteTGWRoute(routeTableId *string, cidrBlock *string, svc *ec2.EC2) error {
-	Config.Logger.Printf("Deleting route to %s from route table %s", *cidrBlock, *routeTableId)
+	getConfig().Logger.Printf("Deleting route to %s from route table %s", *cidrBlock, *routeTableId)
 	delRouteInput := &ec2.DeleteTransitGatewayRouteInput{
 		DestinationCidrBlock:       cidrBlock,
 		TransitGatewayRouteTableId: routeTableId,
@@ -588,7 +588,7 @@ func createTGWRoute(routeTableId *string, cidrBlock *string, attachmentId *strin
 	exRoutes, err := svc.SearchTransitGatewayRoutes(exRoutesInput)
 	if err != nil {
 		// log error
-		Config.Logger.Printf("error SearchTransitGatewayRoutes: %s", err.Error())
+		getConfig().Logger.Printf("error SearchTransitGatewayRoutes: %s", err.Error())
 		return err
 	}
 
@@ -596,7 +596,7 @@ func createTGWRoute(routeTableId *string, cidrBlock *string, attachmentId *strin
 		if local {
 			// Delete route only if it's blackhole route
 			if *exRoutes.Routes[0].State == "blackhole" {
-				Config.Logger.Printf("Route is blackhole, deleting")
+				getConfig().Logger.Printf("Route is blackhole, deleting")
 				delRouteInput := &ec2.DeleteTransitGatewayRouteInput{
 					DestinationCidrBlock:       cidrBlock,
 					TransitGatewayRouteTableId: routeTableId,
@@ -606,7 +606,7 @@ func createTGWRoute(routeTableId *string, cidrBlock *string, attachmentId *strin
 					return err
 				}
 			} else {
-				Config.Logger.Printf("Route to %s already exists in routeTable %s", *cidrBlock, *routeTableId)
+				getConfig().Logger.Printf("Route to %s already exists in routeTable %s", *cidrBlock, *routeTableId)
 				return nil
 			}
 		} else {
@@ -0,0 +1,143 @@
+package hatchery
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ValidateConfigFile checks a configuration file without starting hatchery:
+// it runs `ParseConfig`, checks the settings of the event sink and of the
+// stores without creating them, and also checks what `LoadConfig` accepts
+// but fails at launch, such as missing CPU and memory limits. Unlike
+// `LoadConfig`, it returns all the errors found.
+func ValidateConfigFile(configFilePath string) []error {
+	logger := log.New(io.Discard, "", log.LstdFlags)
+	plan, err := ioutil.ReadFile(configFilePath)
+	if err != nil {
+		return []error{err}
+	}
+	var config HatcheryConfig
+	err = json.Unmarshal(plan, &config)
+	if err != nil {
+		return []error{fmt.Errorf("unable to unmarshal configuration: %v", err)}
+	}
+
+	var errs []error
+	moreConfigsLoaded := true
+	for _, info := range config.MoreConfigs {
+		hatchApp, err := loadMoreConfig(logger, info)
+		if err != nil {
+			errs = append(errs, err)
+			moreConfigsLoaded = false
+		} else if hatchApp != nil {
+			config.Containers = append(config.Containers, *hatchApp)
+		}
+	}
+
+	errs = append(errs, validateSidecarSettings(config.Sidecar)...)
+	errs = append(errs, validateStoreSettings(config)...)
+	names := make(map[string]bool)
+	ids := make(map[string]bool)
+	mountsUserVolume := false
+	for _, container := range config.Containers {
+		if container.Name == "" {
+			errs = append(errs, fmt.Errorf("a container has no 'name'"))
+		} else if names[container.Name] {
+			errs = append(errs, fmt.Errorf("several containers are named '%s'", container.Name))
+		}
+		names[container.Name] = true
+		if container.ID != "" && ids[container.ID] {
+			errs = append(errs, fmt.Errorf("container '%s' has the same 'id' '%s' as another container", container.Name, container.ID))
+		}
+		ids[container.ID] = true
+		mountsUserVolume = mountsUserVolume || container.UserVolumeLocation != ""
+
+		errs = append(errs, validateContainer(logger, config, container)...)
+		errs = append(errs, validateContainerLimits(container)...)
+	}
+	if mountsUserVolume {
+		if err := validateQuantity("user-volume-size", config.UserVolumeSize); err != nil {
+			errs = append(errs, fmt.Errorf("the configuration has %v, which the containers with a 'user-volume-location' need", err))
+		}
+	}
+
+	// also reports the errors of the rest of the configuration, and the
+	// first of the errors found above. ParseConfig fails on the first
+	// `more-configs` app that can not be loaded, which is already reported.
+	if !moreConfigsLoaded {
+		return errs
+	}
+	if _, err := ParseConfig(configFilePath, logger); err != nil {
+		found := false
+		for _, other := range errs {
+			found = found || other.Error() == err.Error()
+		}
+		if !found {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// validateContainerLimits checks that the container sets CPU and memory
+// limits, which `buildPod` fails to parse at launch. The limits of the
+// resource profiles are checked by `validateResourceProfiles`.
+func validateContainerLimits(container Container) []error {
+	var errs []error
+	if err := validateQuantity("cpu-limit", container.CPULimit); err != nil {
+		errs = append(errs, fmt.Errorf("container '%s' has %v", container.Name, err))
+	}
+	if err := validateQuantity("memory-limit", container.MemoryLimit); err != nil {
+		errs = append(errs, fmt.Errorf("container '%s' has %v", container.Name, err))
+	}
+	return errs
+}
+
+// validateStoreSettings checks the settings of the event sink and of the
+// stores without creating them
+func validateStoreSettings(config HatcheryConfig) []error {
+	var errs []error
+	// the secret of the event sink is only set where hatchery runs
+	eventSink := config.EventSink
+	eventSink.SecretEnvVar = ""
+	if _, err := newEventSink(eventSink); err != nil {
+		errs = append(errs, err)
+	}
+	for _, err := range []error{config.AuditLog.validate(), config.PendingOperations.validate(), config.Operations.validate()} {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// validateSidecarSettings checks the settings of the fuse sidecar that every
+// pod runs
+func validateSidecarSettings(sidecar SidecarContainer) []error {
+	var errs []error
+	if sidecar.Image == "" {
+		errs = append(errs, fmt.Errorf("the sidecar has no 'image'"))
+	}
+	if err := validateQuantity("cpu-limit", sidecar.CPULimit); err != nil {
+		errs = append(errs, fmt.Errorf("the sidecar has %v", err))
+	}
+	if err := validateQuantity("memory-limit", sidecar.MemoryLimit); err != nil {
+		errs = append(errs, fmt.Errorf("the sidecar has %v", err))
+	}
+	return errs
+}
+
+func validateQuantity(name string, value string) error {
+	if value == "" {
+		return fmt.Errorf("no '%s'", name)
+	}
+	if _, err := resource.ParseQuantity(value); err != nil {
+		return fmt.Errorf("an invalid '%s' '%s': %v", name, value, err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package hatchery
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestValidateConfigFile(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	errs := ValidateConfigFile("../testData/testConfig.json")
+	if len(errs) != 0 {
+		t.Errorf("expected the test config to be valid, got %v", errs)
+	}
+
+	configPath := filepath.Join(t.TempDir(), "hatchery.json")
+	err := ioutil.WriteFile(configPath, []byte(`{
+		"user-namespace": "jupyter-pods",
+		"sidecar": {"image": "quay.io/cdis/gen3fuse-sidecar", "cpu-limit": "0.1"},
+		"containers": [
+			{"name": "Jupyter", "cpu-limit": "one", "memory-limit": "1Gi", "user-volume-location": "/home/jovyan/pd"},
+			{"name": "Jupyter", "cpu-limit": "1", "memory-limit": "1Gi", "max-concurrent": -1},
+			{"name": "Stata", "cpu-limit": "1", "license": {"enabled": true}}
+		],
+		"overcommit-ratio": 0.5,
+		"event-sink": {"type": "webhook", "url": "https://example.com/events", "secret-env-var": "HATCHERY_TEST_UNSET_SECRET"},
+		"audit-log": {"type": "jsonl"}
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs = ValidateConfigFile(configPath)
+	wantErrs := []string{
+		"the sidecar has no 'memory-limit'",
+		"container 'Jupyter' has an invalid 'cpu-limit' 'one'",
+		"several containers are named 'Jupyter'",
+		"container 'Jupyter' has a negative 'max-concurrent'",
+		"container 'Stata' has no 'memory-limit'",
+		"license is configured for container Stata",
+		"no 'user-volume-size'",
+		// the stores are checked without being created, and the secret of
+		// the event sink is not required
+		"'audit-log' of type 'jsonl' requires a 'file-path'",
+		// only reported by LoadConfig
+		"'overcommit-ratio' must be at least 1",
+	}
+	if len(errs) != len(wantErrs) {
+		t.Errorf("expected %d errors, got %d: %v", len(wantErrs), len(errs), errs)
+	}
+	for _, wantErr := range wantErrs {
+		found := false
+		for _, err := range errs {
+			found = found || strings.Contains(err.Error(), wantErr)
+		}
+		if !found {
+			t.Errorf("expected an error containing \"%s\", got %v", wantErr, errs)
+		}
+	}
+}
+
+func TestRenderPod(t *testing.T) {
+	defer SetupAndTeardownTest()()
+
+	config, err := LoadConfig("../testData/testConfig.json", nil)
+	if err != nil {
+		t.Fatalf("failed to load config, got: %v", err)
+	}
+	original_config := getConfig()
+	defer func() {
+		SetConfig(original_config)
+	}()
+	SetConfig(config)
+
+	out, err := RenderPod("R Studio", "alice")
+	if err != nil {
+		t.Fatalf("unexpected render error: %v", err)
+	}
+	var pod k8sv1.Pod
+	if err := yaml.Unmarshal(out, &pod); err != nil {
+		t.Fatalf("unable to parse the rendered pod: %v", err)
+	}
+	if pod.Kind != "Pod" || pod.Name != userToResourceName("alice", "pod") || len(pod.Spec.Containers) != 2 || pod.Spec.Containers[1].Image != "quay.io/cdis/rstudio:master" {
+		t.Errorf("unexpected rendered pod: %s", out)
+	}
+
+	for id, container := range getConfig().ContainersMap {
+		if container.Name == "R Studio" {
+			byID, err := RenderPod(id, "alice")
+			if err != nil {
+				t.Fatalf("unexpected render error: %v", err)
+			}
+			var podByID k8sv1.Pod
+			if err := yaml.Unmarshal(byID, &podByID); err != nil {
+				t.Fatalf("unable to parse the rendered pod: %v", err)
+			}
+			// the environment variables come from maps, in no particular
+			// order
+			for _, p := range []*k8sv1.Pod{&pod, &podByID} {
+				for i := range p.Spec.Containers {
+					sort.Slice(p.Spec.Containers[i].Env, func(a, b int) bool {
+						return p.Spec.Containers[i].Env[a].Name < p.Spec.Containers[i].Env[b].Name
+					})
+				}
+			}
+			if !reflect.DeepEqual(pod, podByID) {
+				t.Errorf("expected the same pod when rendering by ID, got %s", byID)
+			}
+		}
+	}
+
+	_, err = RenderPod("Unknown", "alice")
+	if err == nil {
+		t.Error("expected an error for an unknown container")
+	}
+}
@@ -0,0 +1,112 @@
+package hatchery
+
+import (
+	"fmt"
+
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// The volumes and containers `buildPod` always adds to the pod. Extra
+// volumes and init containers can not reuse these names.
+var (
+	builtInVolumeNames    = []string{"shared-data", "gen3", "dshm", "user-data"}
+	builtInContainerNames = []string{"fuse-container", "hatchery-container"}
+)
+
+// getAvailableVolumeNames returns the names of the volumes of the
+// container's pods, which init containers and extra volume mounts can refer
+// to
+func getAvailableVolumeNames(container Container) map[string]bool {
+	names := map[string]bool{"shared-data": true, "gen3": true}
+	if container.UseSharedMemory == "true" {
+		names["dshm"] = true
+	}
+	if container.UserVolumeLocation != "" {
+		names["user-data"] = true
+	}
+	for _, volume := range container.ExtraVolumes {
+		names[volume.Name] = true
+	}
+	return names
+}
+
+// validatePodExtensions checks the container's `init-containers`,
+// `extra-volumes` and `extra-volume-mounts`: names must be unique and not
+// collide with the built-in ones, and mounts must refer to volumes of the
+// pod
+func validatePodExtensions(container Container) error {
+	reservedVolumes := make(map[string]bool)
+	for _, name := range builtInVolumeNames {
+		reservedVolumes[name] = true
+	}
+	for _, volume := range container.ExtraVolumes {
+		if errs := validation.IsDNS1123Label(volume.Name); len(errs) > 0 {
+			return fmt.Errorf("invalid 'extra-volumes' name '%s': %v", volume.Name, errs)
+		}
+		if reservedVolumes[volume.Name] {
+			return fmt.Errorf("'extra-volumes' name '%s' is already used by another volume", volume.Name)
+		}
+		reservedVolumes[volume.Name] = true
+	}
+
+	availableVolumes := getAvailableVolumeNames(container)
+	// the paths of the built-in mounts of the workspace container
+	gen3VolumeLocation := container.Gen3VolumeLocation
+	if gen3VolumeLocation == "" {
+		gen3VolumeLocation = "/.gen3"
+	}
+	builtInMountPaths := []string{"/data", gen3VolumeLocation}
+	if container.UserVolumeLocation != "" {
+		builtInMountPaths = append(builtInMountPaths, container.UserVolumeLocation)
+	}
+	if err := validateVolumeMounts(container.ExtraVolumeMounts, availableVolumes, builtInMountPaths); err != nil {
+		return fmt.Errorf("invalid 'extra-volume-mounts': %v", err)
+	}
+
+	reservedContainers := make(map[string]bool)
+	for _, name := range builtInContainerNames {
+		reservedContainers[name] = true
+	}
+	for _, friend := range container.Friends {
+		reservedContainers[friend.Name] = true
+	}
+	for _, initContainer := range container.InitContainers {
+		if errs := validation.IsDNS1123Label(initContainer.Name); len(errs) > 0 {
+			return fmt.Errorf("invalid 'init-containers' name '%s': %v", initContainer.Name, errs)
+		}
+		if reservedContainers[initContainer.Name] {
+			return fmt.Errorf("'init-containers' name '%s' is already used by another container", initContainer.Name)
+		}
+		reservedContainers[initContainer.Name] = true
+		if initContainer.Image == "" {
+			return fmt.Errorf("init container '%s' has no 'image'", initContainer.Name)
+		}
+		if err := validateVolumeMounts(initContainer.VolumeMounts, availableVolumes, nil); err != nil {
+			return fmt.Errorf("init container '%s' has invalid 'volumeMounts': %v", initContainer.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateVolumeMounts checks that the mounts refer to volumes of the pod,
+// at paths that are not already used
+func validateVolumeMounts(volumeMounts []k8sv1.VolumeMount, availableVolumes map[string]bool, usedMountPaths []string) error {
+	mountPaths := make(map[string]bool)
+	for _, mountPath := range usedMountPaths {
+		mountPaths[mountPath] = true
+	}
+	for _, volumeMount := range volumeMounts {
+		if !availableVolumes[volumeMount.Name] {
+			return fmt.Errorf("unknown volume '%s'", volumeMount.Name)
+		}
+		if volumeMount.MountPath == "" {
+			return fmt.Errorf("the mount of volume '%s' has no 'mountPath'", volumeMount.Name)
+		}
+		if mountPaths[volumeMount.MountPath] {
+			return fmt.Errorf("several volumes are mounted at '%s'", volumeMount.MountPath)
+		}
+		mountPaths[volumeMount.MountPath] = true
+	}
+	return nil
+}
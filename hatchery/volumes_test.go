@@ -0,0 +1,47 @@
+package hatchery
+
+import (
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+)
+
+func TestValidatePodExtensions(t *testing.T) {
+	configMapVolume := func(name string) k8sv1.Volume {
+		return k8sv1.Volume{Name: name, VolumeSource: k8sv1.VolumeSource{ConfigMap: &k8sv1.ConfigMapVolumeSource{LocalObjectReference: k8sv1.LocalObjectReference{Name: "settings"}}}}
+	}
+	testCases := []struct {
+		name      string
+		container Container
+		wantError bool
+	}{
+		{name: "NoExtensions", container: Container{}},
+		{
+			name: "Valid",
+			container: Container{
+				UserVolumeLocation: "/home/jovyan/pd",
+				ExtraVolumes:       []k8sv1.Volume{configMapVolume("settings")},
+				ExtraVolumeMounts:  []k8sv1.VolumeMount{{Name: "settings", MountPath: "/etc/settings"}, {Name: "gen3", MountPath: "/gen3-copy"}},
+				InitContainers:     []k8sv1.Container{{Name: "fix-permissions", Image: "busybox", VolumeMounts: []k8sv1.VolumeMount{{Name: "user-data", MountPath: "/pd"}}}},
+			},
+		},
+		{name: "BuiltInVolumeName", container: Container{ExtraVolumes: []k8sv1.Volume{configMapVolume("user-data")}}, wantError: true},
+		{name: "DuplicateVolumeName", container: Container{ExtraVolumes: []k8sv1.Volume{configMapVolume("settings"), configMapVolume("settings")}}, wantError: true},
+		{name: "InvalidVolumeName", container: Container{ExtraVolumes: []k8sv1.Volume{configMapVolume("Settings")}}, wantError: true},
+		{name: "UnknownMountVolume", container: Container{ExtraVolumeMounts: []k8sv1.VolumeMount{{Name: "settings", MountPath: "/etc/settings"}}}, wantError: true},
+		{name: "MissingUserVolume", container: Container{ExtraVolumeMounts: []k8sv1.VolumeMount{{Name: "user-data", MountPath: "/pd"}}}, wantError: true},
+		{name: "BuiltInMountPath", container: Container{ExtraVolumes: []k8sv1.Volume{configMapVolume("settings")}, ExtraVolumeMounts: []k8sv1.VolumeMount{{Name: "settings", MountPath: "/data"}}}, wantError: true},
+		{name: "NoMountPath", container: Container{ExtraVolumes: []k8sv1.Volume{configMapVolume("settings")}, ExtraVolumeMounts: []k8sv1.VolumeMount{{Name: "settings"}}}, wantError: true},
+		{name: "BuiltInContainerName", container: Container{InitContainers: []k8sv1.Container{{Name: "fuse-container", Image: "busybox"}}}, wantError: true},
+		{name: "FriendContainerName", container: Container{Friends: []k8sv1.Container{{Name: "helper"}}, InitContainers: []k8sv1.Container{{Name: "helper", Image: "busybox"}}}, wantError: true},
+		{name: "DuplicateInitContainerName", container: Container{InitContainers: []k8sv1.Container{{Name: "seed", Image: "busybox"}, {Name: "seed", Image: "busybox"}}}, wantError: true},
+		{name: "InitContainerWithoutImage", container: Container{InitContainers: []k8sv1.Container{{Name: "seed"}}}, wantError: true},
+		{name: "InitContainerUnknownVolume", container: Container{InitContainers: []k8sv1.Container{{Name: "seed", Image: "busybox", VolumeMounts: []k8sv1.VolumeMount{{Name: "dshm", MountPath: "/dev/shm"}}}}}, wantError: true},
+	}
+	for _, testcase := range testCases {
+		err := validatePodExtensions(testcase.container)
+		if testcase.wantError != (err != nil) {
+			t.Errorf("unexpected validation result when %s: %v", testcase.name, err)
+		}
+	}
+}
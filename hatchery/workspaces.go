@@ -0,0 +1,243 @@
+package hatchery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Annotations set on workspace pods and services
+const (
+	userNameAnnotation      = "gen3username"
+	workspaceNameAnnotation = "gen3workspace"
+	containerIDAnnotation   = "gen3container"
+	// the resource profile the workspace was launched with, if any
+	resourceProfileAnnotation = "gen3resourceprofile"
+	// the ID of the pay model the workspace was launched with, if any
+	payModelAnnotation = "gen3paymodel"
+)
+
+// Label set on workspace pods and services, which the status cache selects
+const (
+	workspaceLabel      = "gen3hatchery"
+	workspaceLabelValue = "workspace"
+)
+
+// Label set on the workspace pods that mount the user volume, to the name
+// of the user's default workspace pod: see `withUserVolumeAffinity`
+const userVolumeLabel = "gen3hatcheryuser"
+
+// workspaceAnnotations returns the annotations that identify a workspace's
+// pod and services, so that background jobs can find who they belong to
+func workspaceAnnotations(userName string, workspaceName string, hash string) map[string]string {
+	return map[string]string{
+		userNameAnnotation:      userName,
+		workspaceNameAnnotation: workspaceName,
+		containerIDAnnotation:   hash,
+	}
+}
+
+// Workspace names end up in k8s resource names and in the workspace URL,
+// so keep them short and DNS-safe
+var workspaceNameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,18}[a-z0-9])?$`)
+
+// getWorkspaceName returns the value of the `workspace` query parameter.
+// An empty name refers to the user's default workspace.
+func getWorkspaceName(r *http.Request, userName string) (string, error) {
+	workspaceName := r.URL.Query().Get("workspace")
+	if workspaceName == "" {
+		return "", nil
+	}
+	if !workspaceNameRegex.MatchString(workspaceName) {
+		return "", fmt.Errorf("invalid 'workspace' parameter '%s': workspace names must be 1 to 20 lowercase letters, digits or '-', and start and end with a letter or digit", workspaceName)
+	}
+	// the pod name, the longest name built from the user and workspace
+	// names, is also the value of the pod's `app` label
+	if len(workspaceToResourceName(userName, workspaceName, "pod")) > validation.LabelValueMaxLength {
+		maxLength := validation.LabelValueMaxLength - len(workspaceToResourceName(userName, "x", "pod")) + 1
+		if maxLength < 1 {
+			return "", fmt.Errorf("invalid 'workspace' parameter '%s': the user name is too long to use named workspaces", workspaceName)
+		}
+		return "", fmt.Errorf("invalid 'workspace' parameter '%s': workspace names can be at most %d characters long for this user", workspaceName, maxLength)
+	}
+	return workspaceName, nil
+}
+
+// workspaceURLPrefix returns the path, relative to the workspace proxy
+// root, under which the workspace is served
+func workspaceURLPrefix(workspaceName string) string {
+	if workspaceName == "" {
+		return ""
+	}
+	return workspaceName + "/"
+}
+
+// listK8sWorkspaceNames returns the names of all the user's workspace pods
+// in the cluster the pay model points to, sorted. The default workspace
+// is listed as "". The pods of hatchery's cluster are read from the status
+// cache if it is synced.
+var listK8sWorkspaceNames = func(ctx context.Context, userName string, payModelPtr *PayModel) ([]string, error) {
+	pods, err := listWorkspacePods(ctx, userName, payModelPtr)
+	if err != nil {
+		return nil, err
+	}
+	workspaceNames := []string{}
+	for _, pod := range pods {
+		if !strings.HasPrefix(pod.Labels["app"], "hatchery-") || pod.Annotations[userNameAnnotation] != userName {
+			continue
+		}
+		// pods launched before named workspaces existed have no
+		// workspace annotation: they are default workspaces
+		workspaceNames = append(workspaceNames, pod.Annotations[workspaceNameAnnotation])
+	}
+	sort.Strings(workspaceNames)
+	return workspaceNames, nil
+}
+
+// listWorkspacePods returns the pods of the user namespace in the cluster
+// the pay model points to
+func listWorkspacePods(ctx context.Context, userName string, payModelPtr *PayModel) ([]*k8sv1.Pod, error) {
+	statusCache := getStatusCache()
+	if statusCache != nil && (payModelPtr == nil || payModelPtr.Local) {
+		return statusCache.listPods(ctx, getLocalPodClient(), getConfig().Config.UserNamespace)
+	}
+	podClient, _, err := getPodClient(ctx, userName, payModelPtr)
+	if err != nil {
+		return nil, err
+	}
+	if podClient == nil {
+		return nil, fmt.Errorf("unable to get a k8s client to list the workspaces of user '%s'", userName)
+	}
+	podList, err := podClient.Pods(getConfig().Config.UserNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pods := make([]*k8sv1.Pod, 0, len(podList.Items))
+	for i := range podList.Items {
+		pods = append(pods, &podList.Items[i])
+	}
+	return pods, nil
+}
+
+// WorkspaceInfo identifies a running workspace
+type WorkspaceInfo struct {
+	UserName      string    `json:"user"`
+	WorkspaceName string    `json:"workspace,omitempty"`
+	ContainerID   string    `json:"container_id"`
+	PayModelID    string    `json:"pay_model_id,omitempty"`
+	StartTime     time.Time `json:"start_time"`
+}
+
+// listActiveWorkspaces returns all the users' workspaces. Workspaces in the
+// local cluster are found through their pods; workspaces in external EKS
+// clusters and in ECS are found through the service that routes traffic to
+// them from the local cluster. Workspaces launched before the services were
+// annotated with the user name are not listed.
+var listActiveWorkspaces = func(ctx context.Context) ([]WorkspaceInfo, error) {
+	resources, err := listLocalWorkspaceResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	workspaces := []WorkspaceInfo{}
+	seen := make(map[string]bool)
+	for _, meta := range resources {
+		// a pod and its service share the same `app` label
+		app := meta.Labels["app"]
+		if !isWorkspaceResource(meta) || seen[app] {
+			continue
+		}
+		seen[app] = true
+		workspaces = append(workspaces, WorkspaceInfo{
+			UserName:      meta.Annotations[userNameAnnotation],
+			WorkspaceName: meta.Annotations[workspaceNameAnnotation],
+			ContainerID:   resolveContainerID(meta.Annotations[containerIDAnnotation]),
+			PayModelID:    meta.Annotations[payModelAnnotation],
+			StartTime:     meta.CreationTimestamp.Time,
+		})
+	}
+	sort.Slice(workspaces, func(i, j int) bool {
+		if workspaces[i].UserName != workspaces[j].UserName {
+			return workspaces[i].UserName < workspaces[j].UserName
+		}
+		return workspaces[i].WorkspaceName < workspaces[j].WorkspaceName
+	})
+	return workspaces, nil
+}
+
+// isWorkspaceResource returns whether hatchery created the pod or service
+// for a user's workspace
+func isWorkspaceResource(meta metav1.ObjectMeta) bool {
+	return strings.HasPrefix(meta.Labels["app"], "hatchery-") && meta.Annotations[userNameAnnotation] != ""
+}
+
+// listLocalWorkspaceResources returns the metadata of the pods and services
+// of the user namespace, from the status cache if it is synced
+func listLocalWorkspaceResources(ctx context.Context) ([]metav1.ObjectMeta, error) {
+	if statusCache := getStatusCache(); statusCache != nil {
+		return statusCache.listWorkspaceResources(getConfig().Config.UserNamespace)
+	}
+	podClient := getLocalPodClient()
+	if podClient == nil {
+		return nil, fmt.Errorf("unable to get a client for the local k8s cluster")
+	}
+	pods, err := podClient.Pods(getConfig().Config.UserNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	services, err := podClient.Services(getConfig().Config.UserNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	resources := make([]metav1.ObjectMeta, 0, len(pods.Items)+len(services.Items))
+	for _, pod := range pods.Items {
+		resources = append(resources, pod.ObjectMeta)
+	}
+	for _, service := range services.Items {
+		resources = append(resources, service.ObjectMeta)
+	}
+	return resources, nil
+}
+
+// getUserWorkspaceNames returns the names of all the user's workspaces,
+// including the stopped ones. ECS pay models only support the default
+// workspace.
+var getUserWorkspaceNames = func(ctx context.Context, userName string, accessToken string) ([]string, error) {
+	workspaceNames, err := getRunningWorkspaceNames(ctx, userName, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	stoppedNames, err := listStoppedWorkspaceNames(ctx, userName)
+	if err != nil {
+		getConfig().Logger.Printf("Unable to list the stopped workspaces of user %s: %v", userName, err)
+		return workspaceNames, nil
+	}
+	seen := make(map[string]bool)
+	for _, name := range workspaceNames {
+		seen[name] = true
+	}
+	for _, name := range stoppedNames {
+		if !seen[name] {
+			workspaceNames = append(workspaceNames, name)
+		}
+	}
+	sort.Strings(workspaceNames)
+	return workspaceNames, nil
+}
+
+func getRunningWorkspaceNames(ctx context.Context, userName string, accessToken string) ([]string, error) {
+	payModel, err := getCurrentPayModel(userName)
+	if err != nil {
+		return nil, err
+	}
+	_, backend := getWorkspaceBackend(payModel)
+	return backend.List(ctx, userName, accessToken, payModel)
+}
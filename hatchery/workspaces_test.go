@@ -0,0 +1,41 @@
+package hatchery
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetWorkspaceName(t *testing.T) {
+	testCases := []struct {
+		url       string
+		userName  string
+		want      string
+		wantError bool
+	}{
+		{url: "/launch?id=abc", want: ""},
+		{url: "/launch?id=abc&workspace=rstudio", want: "rstudio"},
+		{url: "/launch?id=abc&workspace=my-workspace-2", want: "my-workspace-2"},
+		{url: "/launch?id=abc&workspace=RStudio", wantError: true},
+		{url: "/launch?id=abc&workspace=-rstudio", wantError: true},
+		{url: "/launch?id=abc&workspace=rstudio-", wantError: true},
+		{url: "/launch?id=abc&workspace=my.workspace", wantError: true},
+		{url: "/launch?id=abc&workspace=a-workspace-name-too-long", wantError: true},
+		// the pod name would be longer than 63 characters
+		{url: "/launch?id=abc&workspace=rstudio", userName: "firstname.lastname@some-university.edu", wantError: true},
+		{url: "/launch?id=abc&workspace=rs", userName: "firstname.lastname@some-university.edu", want: "rs"},
+		{url: "/launch?id=abc", userName: "firstname.lastname@some-university.edu", want: ""},
+	}
+	for _, testcase := range testCases {
+		req, err := http.NewRequest("POST", testcase.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := getWorkspaceName(req, testcase.userName)
+		if testcase.wantError != (err != nil) {
+			t.Errorf("unexpected error for '%s': %v", testcase.url, err)
+		}
+		if got != testcase.want {
+			t.Errorf("unexpected workspace name for '%s': got '%s', want '%s'", testcase.url, got, testcase.want)
+		}
+	}
+}
@@ -3,8 +3,8 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -24,16 +24,81 @@ func verifyPath(path string) (string, error) {
 	return r, nil
 }
 
+const usage = `Use: hatchery -config path/to/hatchery.json
+		- also harvests dockstore/bla.yml app definitions where dockstore/
+		  is in the same folder as hatchery.json
+   or: hatchery validate path/to/hatchery.json
+		- checks the configuration and prints all the errors found
+   or: hatchery render path/to/hatchery.json <container> <user>
+		- prints the pod of the user's workspace, <container> being the
+		  ID, an alias or the name of the container
+`
+
+// validateConfig runs `hatchery validate <config>` and returns the exit code
+func validateConfig(args []string) int {
+	if len(args) != 1 {
+		os.Stderr.WriteString(usage)
+		return 2
+	}
+	cleanPath, err := verifyPath(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config - got %v\n", err)
+		return 1
+	}
+	errs := hatchery.ValidateConfigFile(cleanPath)
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "%s has %d error(s):\n", args[0], len(errs))
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "- %v\n", err)
+		}
+		return 1
+	}
+	fmt.Printf("%s is valid\n", args[0])
+	return 0
+}
+
+// renderPod runs `hatchery render <config> <container> <user>` and returns
+// the exit code
+func renderPod(args []string) int {
+	if len(args) != 3 {
+		os.Stderr.WriteString(usage)
+		return 2
+	}
+	cleanPath, err := verifyPath(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config - got %v\n", err)
+		return 1
+	}
+	config, err := hatchery.ParseConfig(cleanPath, log.New(io.Discard, "", log.LstdFlags))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config - got %v\n", err)
+		return 1
+	}
+	hatchery.SetConfig(config)
+	out, err := hatchery.RenderPod(args[1], args[2])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to render pod - got %v\n", err)
+		return 1
+	}
+	os.Stdout.Write(out)
+	return 0
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(validateConfig(os.Args[2:]))
+		case "render":
+			os.Exit(renderPod(os.Args[2:]))
+		}
+	}
+
 	configPath := "/hatchery.json"
 	if len(os.Args) > 2 && strings.HasSuffix(os.Args[1], "-config") {
 		configPath = os.Args[2]
 	} else if len(os.Args) > 1 {
-		os.Stderr.WriteString(
-			`Use: hatchery -config path/to/hatchery.json
-		- also harvests dockstore/bla.yml app definitions where dockstore/
-		  is in the same folder as hatchery.json
-`)
+		os.Stderr.WriteString(usage)
 		return
 	}
 	logger := log.New(os.Stdout, "", log.LstdFlags)
@@ -47,7 +112,7 @@ func main() {
 		config.Logger.Printf(fmt.Sprintf("Failed to load config - got %v", err))
 		return
 	}
-	hatchery.Config = config
+	hatchery.SetConfig(config)
 	ddEnabled := os.Getenv("DD_ENABLED")
 	if strings.ToLower(ddEnabled) == "true" {
 		config.Logger.Printf("Setting up datadog")
@@ -71,11 +136,20 @@ func main() {
 		config.Logger.Printf("Datadog not enabled in manifest, skipping...")
 	}
 
+	hatchery.WatchConfig(cleanPath)
+	hatchery.StartIdleReaper()
+	hatchery.StartSessionSweeper()
+	hatchery.StartPendingOperationResumer()
+	hatchery.StartWorkspaceStatusCache()
+
 	config.Logger.Printf("Setting up routes")
 	mux := httptrace.NewServeMux()
 	hatchery.RegisterSystem(mux)
+	hatchery.RegisterMetrics(mux)
 	hatchery.RegisterHatchery(mux)
 
 	config.Logger.Printf("Running main")
-	log.Fatal(http.ListenAndServe("0.0.0.0:8000", mux))
+	if err := hatchery.Serve(mux); err != nil {
+		log.Fatal(err)
+	}
 }